@@ -0,0 +1,103 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 抽象了获取当前时间与等待，测试中可以使用 Mock 控制时间的流逝
+type Clock interface {
+	Now() time.Time
+	// After 在d之后向返回的channel发送当时的时间，d不大于0时立刻发送
+	After(d time.Duration) <-chan time.Time
+}
+
+// New 返回使用系统时间的 Clock
+func New() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Mock 是手动推进的 Clock，只有调用 Add 或者 Set 时间才会变化
+type Mock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func NewMock(now time.Time) *Mock {
+	return &Mock{now: now}
+}
+
+func (m *Mock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+func (m *Mock) After(d time.Duration) <-chan time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- m.now
+		return ch
+	}
+	m.waiters = append(m.waiters, waiter{deadline: m.now.Add(d), ch: ch})
+	return ch
+}
+
+// Add 将时间向后推进d，并唤醒所有到期的 After
+func (m *Mock) Add(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(m.now.Add(d))
+}
+
+// Set 将时间设置为t，并唤醒所有到期的 After
+func (m *Mock) Set(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(t)
+}
+
+func (m *Mock) set(t time.Time) {
+	m.now = t
+	waiters := m.waiters[:0]
+	for _, w := range m.waiters {
+		if w.deadline.After(t) {
+			waiters = append(waiters, w)
+			continue
+		}
+		w.ch <- t
+	}
+	m.waiters = waiters
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMock(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMock(start)
+	assert.Equal(t, start, m.Now())
+
+	select {
+	case now := <-m.After(0):
+		assert.Equal(t, start, now)
+	default:
+		t.Fatal("d不大于0时应该立刻发送")
+	}
+
+	ch := m.After(time.Minute)
+	m.Add(30 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("还没有到期")
+	default:
+	}
+	m.Add(30 * time.Second)
+	select {
+	case now := <-ch:
+		assert.Equal(t, start.Add(time.Minute), now)
+	default:
+		t.Fatal("已经到期")
+	}
+
+	ch = m.After(time.Hour)
+	m.Set(start.Add(2 * time.Hour))
+	assert.Equal(t, start.Add(2*time.Hour), <-ch)
+}
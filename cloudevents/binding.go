@@ -0,0 +1,247 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+)
+
+const (
+	// headerPrefix 二进制模式下属性对应的header前缀
+	headerPrefix = "ce_"
+	// headerContentType 二进制模式下存放datacontenttype，结构化模式下存放 ContentTypeJSON
+	headerContentType = "content-type"
+	// ContentTypeJSON 是结构化模式的JSON格式
+	ContentTypeJSON = "application/cloudevents+json"
+	// partitionKey 是映射到消息Key的扩展属性
+	partitionKey = "partitionkey"
+)
+
+// Mode 决定事件在消息中的表示方式
+type Mode int
+
+const (
+	// ModeBinary 属性放在 ce_ 开头的header中，Value只包含data
+	ModeBinary Mode = iota
+	// ModeStructured 属性与data一起编码为JSON放在Value中
+	ModeStructured
+)
+
+// ToMessage 校验事件之后转换为消息，扩展属性partitionkey会作为消息的Key
+func ToMessage(e Event, mode Mode) (*mq.Message, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	msg := &mq.Message{}
+	if key, ok := e.Extensions[partitionKey]; ok {
+		msg.Key = []byte(key)
+	}
+	if mode == ModeStructured {
+		value, err := marshalStructured(e)
+		if err != nil {
+			return nil, err
+		}
+		msg.Value = value
+		msg.Header.Set(headerContentType, ContentTypeJSON)
+		return msg, nil
+	}
+	msg.Value = e.Data
+	msg.Header.Set(headerPrefix+"specversion", e.SpecVersion)
+	msg.Header.Set(headerPrefix+"id", e.ID)
+	msg.Header.Set(headerPrefix+"source", e.Source)
+	msg.Header.Set(headerPrefix+"type", e.Type)
+	if e.DataContentType != "" {
+		msg.Header.Set(headerContentType, e.DataContentType)
+	}
+	if e.DataSchema != "" {
+		msg.Header.Set(headerPrefix+"dataschema", e.DataSchema)
+	}
+	if e.Subject != "" {
+		msg.Header.Set(headerPrefix+"subject", e.Subject)
+	}
+	if !e.Time.IsZero() {
+		msg.Header.Set(headerPrefix+"time", e.Time.Format(time.RFC3339Nano))
+	}
+	// 按照名称排序，保证相同的事件得到相同的header
+	for _, f := range mq.HeaderFromMap(e.Extensions) {
+		msg.Header.SetBytes(headerPrefix+f.Key, f.Value)
+	}
+	return msg, nil
+}
+
+// FromMessage 根据content-type判断消息使用的模式并转换为事件，两种模式都不匹配时返回 ErrNotCloudEvent。
+// 消息的Key会作为扩展属性partitionkey
+func FromMessage(msg *mq.Message) (Event, error) {
+	var (
+		e   Event
+		err error
+	)
+	mediaType, _, _ := mime.ParseMediaType(msg.Header.Get(headerContentType))
+	switch {
+	case mediaType == ContentTypeJSON:
+		e, err = unmarshalStructured(msg.Value)
+	case msg.Header.Get(headerPrefix+"specversion") != "":
+		e, err = fromBinary(msg)
+	default:
+		return Event{}, ErrNotCloudEvent
+	}
+	if err != nil {
+		return Event{}, err
+	}
+	if _, ok := e.Extensions[partitionKey]; !ok && len(msg.Key) > 0 {
+		if e.Extensions == nil {
+			e.Extensions = map[string]string{}
+		}
+		e.Extensions[partitionKey] = string(msg.Key)
+	}
+	return e, e.Validate()
+}
+
+func fromBinary(msg *mq.Message) (Event, error) {
+	e := Event{
+		DataContentType: msg.Header.Get(headerContentType),
+		Data:            msg.Value,
+	}
+	for _, f := range msg.Header {
+		name, ok := strings.CutPrefix(f.Key, headerPrefix)
+		if !ok {
+			continue
+		}
+		if name != "time" {
+			e.setAttribute(name, string(f.Value))
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, string(f.Value))
+		if err != nil {
+			return Event{}, fmt.Errorf("属性time非法: %w", err)
+		}
+		e.Time = t
+	}
+	return e, nil
+}
+
+// isJSON datacontenttype为空或者是JSON格式时，结构化模式中的data直接使用JSON表示
+func isJSON(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func marshalStructured(e Event) ([]byte, error) {
+	envelope := make(map[string]any, 9+len(e.Extensions))
+	for name, value := range e.Extensions {
+		envelope[name] = value
+	}
+	envelope["specversion"] = e.SpecVersion
+	envelope["id"] = e.ID
+	envelope["source"] = e.Source
+	envelope["type"] = e.Type
+	if e.DataContentType != "" {
+		envelope["datacontenttype"] = e.DataContentType
+	}
+	if e.DataSchema != "" {
+		envelope["dataschema"] = e.DataSchema
+	}
+	if e.Subject != "" {
+		envelope["subject"] = e.Subject
+	}
+	if !e.Time.IsZero() {
+		envelope["time"] = e.Time.Format(time.RFC3339Nano)
+	}
+	if e.Data != nil {
+		if isJSON(e.DataContentType) && json.Valid(e.Data) {
+			envelope["data"] = json.RawMessage(e.Data)
+		} else {
+			// []byte会被编码为base64
+			envelope["data_base64"] = e.Data
+		}
+	}
+	return json.Marshal(envelope)
+}
+
+func unmarshalStructured(value []byte) (Event, error) {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(value, &envelope); err != nil {
+		return Event{}, fmt.Errorf("结构化事件不是合法的JSON: %w", err)
+	}
+	var e Event
+	for name, raw := range envelope {
+		var err error
+		switch name {
+		case "data":
+			e.Data = raw
+		case "data_base64":
+			err = json.Unmarshal(raw, &e.Data)
+		case "time":
+			var s string
+			if err = json.Unmarshal(raw, &s); err == nil {
+				e.Time, err = time.Parse(time.RFC3339Nano, s)
+			}
+		default:
+			var s string
+			if err = json.Unmarshal(raw, &s); err != nil {
+				// 扩展属性可以是数字或者布尔值，统一保存为字符串
+				s, err = string(raw), nil
+			}
+			e.setAttribute(name, s)
+		}
+		if err != nil {
+			return Event{}, fmt.Errorf("属性%s非法: %w", name, err)
+		}
+	}
+	// data是JSON字符串而datacontenttype不是JSON时，data为字符串的内容
+	if e.Data != nil && !isJSON(e.DataContentType) {
+		var s string
+		if json.Unmarshal(e.Data, &s) == nil {
+			e.Data = []byte(s)
+		}
+	}
+	return e, nil
+}
+
+func (e *Event) setAttribute(name, value string) {
+	switch name {
+	case "specversion":
+		e.SpecVersion = value
+	case "id":
+		e.ID = value
+	case "source":
+		e.Source = value
+	case "type":
+		e.Type = value
+	case "datacontenttype":
+		e.DataContentType = value
+	case "dataschema":
+		e.DataSchema = value
+	case "subject":
+		e.Subject = value
+	default:
+		if e.Extensions == nil {
+			e.Extensions = map[string]string{}
+		}
+		e.Extensions[name] = value
+	}
+}
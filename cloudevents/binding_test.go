@@ -0,0 +1,163 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudevents
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEvent() Event {
+	return Event{
+		ID:              "1",
+		Source:          "/orders",
+		SpecVersion:     SpecVersion,
+		Type:            "order.created",
+		DataContentType: "application/json",
+		Subject:         "order-1",
+		Time:            time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		Extensions:      map[string]string{"traceid": "abc", partitionKey: "user-1"},
+		Data:            []byte(`{"amount":10}`),
+	}
+}
+
+func TestEvent_Validate(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name    string
+		modify  func(e *Event)
+		wantErr error
+	}{
+		{name: "合法", modify: func(e *Event) {}},
+		{name: "缺少id", modify: func(e *Event) { e.ID = "" }, wantErr: ErrMissingAttribute},
+		{name: "缺少source", modify: func(e *Event) { e.Source = "" }, wantErr: ErrMissingAttribute},
+		{name: "缺少type", modify: func(e *Event) { e.Type = "" }, wantErr: ErrMissingAttribute},
+		{name: "缺少specversion", modify: func(e *Event) { e.SpecVersion = "" }, wantErr: ErrMissingAttribute},
+		{name: "版本不支持", modify: func(e *Event) { e.SpecVersion = "0.3" }, wantErr: ErrUnsupportedSpecVersion},
+		{name: "扩展属性大写", modify: func(e *Event) { e.Extensions["traceID"] = "1" }, wantErr: ErrInvalidExtension},
+		{name: "扩展属性与规范属性重名", modify: func(e *Event) { e.Extensions["data"] = "1" }, wantErr: ErrInvalidExtension},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			e := newEvent()
+			tc.modify(&e)
+			assert.ErrorIs(t, e.Validate(), tc.wantErr)
+		})
+	}
+}
+
+func TestBinaryMode(t *testing.T) {
+	t.Parallel()
+	e := newEvent()
+	msg, err := ToMessage(e, ModeBinary)
+	require.NoError(t, err)
+	assert.Equal(t, mq.Header{
+		{Key: "ce_specversion", Value: []byte("1.0")},
+		{Key: "ce_id", Value: []byte("1")},
+		{Key: "ce_source", Value: []byte("/orders")},
+		{Key: "ce_type", Value: []byte("order.created")},
+		{Key: "content-type", Value: []byte("application/json")},
+		{Key: "ce_subject", Value: []byte("order-1")},
+		{Key: "ce_time", Value: []byte("2024-01-02T03:04:05.000000006Z")},
+		{Key: "ce_partitionkey", Value: []byte("user-1")},
+		{Key: "ce_traceid", Value: []byte("abc")},
+	}, msg.Header)
+	assert.Equal(t, []byte("user-1"), msg.Key)
+	assert.Equal(t, e.Data, msg.Value)
+
+	got, err := FromMessage(msg)
+	require.NoError(t, err)
+	assert.Equal(t, e, got)
+
+	msg.Header.Set("ce_time", "yesterday")
+	_, err = FromMessage(msg)
+	assert.Error(t, err)
+}
+
+func TestStructuredMode(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name   string
+		modify func(e *Event)
+		want   string
+	}{
+		{
+			name: "JSON数据",
+			want: `{"data":{"amount":10},"datacontenttype":"application/json","id":"1","partitionkey":"user-1",` +
+				`"source":"/orders","specversion":"1.0","subject":"order-1","time":"2024-01-02T03:04:05.000000006Z",` +
+				`"traceid":"abc","type":"order.created"}`,
+		},
+		{
+			name: "二进制数据",
+			modify: func(e *Event) {
+				e.DataContentType = "application/octet-stream"
+				e.Data = []byte{0xff, 0x00}
+				e.Time = time.Time{}
+				e.Subject = ""
+				e.Extensions = nil
+			},
+			want: `{"data_base64":"/wA=","datacontenttype":"application/octet-stream","id":"1",` +
+				`"source":"/orders","specversion":"1.0","type":"order.created"}`,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			e := newEvent()
+			if tc.modify != nil {
+				tc.modify(&e)
+			}
+			msg, err := ToMessage(e, ModeStructured)
+			require.NoError(t, err)
+			assert.Equal(t, ContentTypeJSON, msg.Header.Get("content-type"))
+			assert.JSONEq(t, tc.want, string(msg.Value))
+			got, err := FromMessage(msg)
+			require.NoError(t, err)
+			assert.Equal(t, e, got)
+		})
+	}
+}
+
+func TestFromMessage(t *testing.T) {
+	t.Parallel()
+	// 其他系统发送的结构化事件，data是字符串且扩展属性是数字
+	msg := &mq.Message{
+		Key: []byte("k"),
+		Header: mq.Header{
+			{Key: "content-type", Value: []byte("application/cloudevents+json; charset=utf-8")},
+		},
+		Value: []byte(`{"specversion":"1.0","id":"2","source":"urn:test","type":"test",` +
+			`"datacontenttype":"text/plain","data":"hello","sequence":3}`),
+	}
+	e, err := FromMessage(msg)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), e.Data)
+	assert.Equal(t, map[string]string{"sequence": "3", partitionKey: "k"}, e.Extensions)
+
+	_, err = FromMessage(&mq.Message{Value: []byte("hello")})
+	assert.ErrorIs(t, err, ErrNotCloudEvent)
+	_, err = FromMessage(&mq.Message{Header: mq.Header{{Key: "ce_specversion", Value: []byte("1.0")}}})
+	assert.ErrorIs(t, err, ErrMissingAttribute)
+	msg.Value = []byte("{")
+	_, err = FromMessage(msg)
+	assert.Error(t, err)
+}
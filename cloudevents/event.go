@@ -0,0 +1,99 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cloudevents 按照CloudEvents的Kafka协议绑定，在 Event 与 mq.Message 之间转换
+package cloudevents
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SpecVersion 是支持的CloudEvents版本
+const SpecVersion = "1.0"
+
+var (
+	ErrMissingAttribute       = errors.New("缺少CloudEvents必需的属性")
+	ErrUnsupportedSpecVersion = errors.New("不支持的CloudEvents版本")
+	ErrInvalidExtension       = errors.New("CloudEvents扩展属性名称非法")
+	ErrNotCloudEvent          = errors.New("消息不是CloudEvents事件")
+)
+
+// Event 是CloudEvents事件，扩展属性统一使用字符串表示
+type Event struct {
+	// 必需属性
+	ID          string
+	Source      string
+	SpecVersion string
+	Type        string
+	// 可选属性
+	DataContentType string
+	DataSchema      string
+	Subject         string
+	Time            time.Time
+	// 扩展属性，名称只能包含小写字母与数字
+	Extensions map[string]string
+	Data       []byte
+}
+
+// Validate 检查必需属性是否齐全以及扩展属性名称是否合法
+func (e Event) Validate() error {
+	required := []struct {
+		name  string
+		value string
+	}{
+		{name: "id", value: e.ID},
+		{name: "source", value: e.Source},
+		{name: "specversion", value: e.SpecVersion},
+		{name: "type", value: e.Type},
+	}
+	for _, attr := range required {
+		if attr.value == "" {
+			return fmt.Errorf("%w: %s", ErrMissingAttribute, attr.name)
+		}
+	}
+	if e.SpecVersion != SpecVersion {
+		return fmt.Errorf("%w: %s", ErrUnsupportedSpecVersion, e.SpecVersion)
+	}
+	for name := range e.Extensions {
+		if !isValidAttributeName(name) || isContextAttribute(name) {
+			return fmt.Errorf("%w: %s", ErrInvalidExtension, name)
+		}
+	}
+	return nil
+}
+
+func isValidAttributeName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// isContextAttribute 扩展属性不能与规范定义的属性以及结构化模式中的data字段重名
+func isContextAttribute(name string) bool {
+	switch name {
+	case "id", "source", "specversion", "type", "datacontenttype", "dataschema", "subject", "time",
+		"data", "data_base64":
+		return true
+	default:
+		return false
+	}
+}
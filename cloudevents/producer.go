@@ -0,0 +1,59 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudevents
+
+import (
+	"context"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+)
+
+// Producer 把事件转换为消息之后发送，缺少必需属性的事件不会被发送
+type Producer struct {
+	producer mq.Producer
+	mode     Mode
+}
+
+// NewProducer 创建 Producer，默认使用 ModeBinary
+func NewProducer(p mq.Producer, opts ...option.Option[Producer]) *Producer {
+	res := &Producer{producer: p, mode: ModeBinary}
+	option.Apply(res, opts...)
+	return res
+}
+
+// WithMode 指定事件在消息中的表示方式
+func WithMode(mode Mode) option.Option[Producer] {
+	return func(p *Producer) {
+		p.mode = mode
+	}
+}
+
+// Send 校验事件的属性并发送，SpecVersion为空时使用 SpecVersion
+func (p *Producer) Send(ctx context.Context, e Event) (*mq.ProducerResult, error) {
+	if e.SpecVersion == "" {
+		e.SpecVersion = SpecVersion
+	}
+	msg, err := ToMessage(e, p.mode)
+	if err != nil {
+		return nil, err
+	}
+	return p.producer.Produce(ctx, msg)
+}
+
+// Close 关闭底层的生产者
+func (p *Producer) Close() error {
+	return p.producer.Close()
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudevents
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProducer(t *testing.T) {
+	t.Parallel()
+	testmq := memory.NewMQ()
+	defer func() {
+		_ = testmq.Close()
+	}()
+	const topic = "cloudevents"
+	require.NoError(t, testmq.CreateTopic(context.Background(), topic, 1))
+	c, err := testmq.Consumer(topic, "g1")
+	require.NoError(t, err)
+	p, err := testmq.Producer(topic)
+	require.NoError(t, err)
+
+	e := newEvent()
+	e.SpecVersion = ""
+	_, err = NewProducer(p).Send(context.Background(), e)
+	require.NoError(t, err)
+	_, err = NewProducer(p, WithMode(ModeStructured)).Send(context.Background(), e)
+	require.NoError(t, err)
+	e.Source = ""
+	_, err = NewProducer(p).Send(context.Background(), e)
+	assert.ErrorIs(t, err, ErrMissingAttribute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	want := newEvent()
+	for _, header := range []string{"ce_id", "content-type"} {
+		msg, err := c.Consume(ctx)
+		require.NoError(t, err)
+		assert.NotEmpty(t, msg.Header.Get(header))
+		got, err := FromMessage(msg)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+}
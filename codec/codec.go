@@ -0,0 +1,112 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package codec 提供 mq.Codec 的常用实现
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	_ mq.Codec[any] = JSON[any]{}
+	_ mq.Codec[any] = Gob[any]{}
+	_ mq.Codec[any] = MessagePack[any]{}
+)
+
+// ErrProtobufInterface Protobuf 的类型参数是接口时无法知道需要创建的消息类型
+var ErrProtobufInterface = errors.New("codec: Protobuf的类型参数需要是具体的消息指针类型，例如 *pb.User")
+
+// JSON 使用encoding/json编解码
+type JSON[T any] struct{}
+
+func (JSON[T]) ContentType() string {
+	return "application/json"
+}
+
+func (JSON[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSON[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// Gob 使用encoding/gob编解码，每条消息都会携带完整的类型信息，只适合Go服务之间使用
+type Gob[T any] struct{}
+
+func (Gob[T]) ContentType() string {
+	return "application/x-gob"
+}
+
+func (Gob[T]) Encode(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (Gob[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// MessagePack 使用MessagePack编解码
+type MessagePack[T any] struct{}
+
+func (MessagePack[T]) ContentType() string {
+	return "application/msgpack"
+}
+
+func (MessagePack[T]) Encode(v T) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (MessagePack[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := msgpack.Unmarshal(data, &v)
+	return v, err
+}
+
+// Protobuf 使用Protocol Buffers编解码，T为生成的消息的指针类型，例如 *pb.User。
+// T为 proto.Message 等接口类型时 Decode 返回 ErrProtobufInterface
+type Protobuf[T proto.Message] struct{}
+
+func (Protobuf[T]) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (Protobuf[T]) Encode(v T) ([]byte, error) {
+	return proto.Marshal(v)
+}
+
+func (Protobuf[T]) Decode(data []byte) (T, error) {
+	var zero T
+	if any(zero) == nil {
+		return zero, ErrProtobufInterface
+	}
+	// 生成的消息类型在nil指针上也可以获取类型信息
+	v, _ := zero.ProtoReflect().Type().New().Interface().(T)
+	err := proto.Unmarshal(data, v)
+	return v, err
+}
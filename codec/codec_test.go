@@ -0,0 +1,127 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type user struct {
+	Name string
+	Age  int
+}
+
+func TestCodec(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name        string
+		codec       mq.Codec[user]
+		contentType string
+	}{
+		{name: "json", codec: JSON[user]{}, contentType: "application/json"},
+		{name: "gob", codec: Gob[user]{}, contentType: "application/x-gob"},
+		{name: "msgpack", codec: MessagePack[user]{}, contentType: "application/msgpack"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.contentType, tc.codec.ContentType())
+			data, err := tc.codec.Encode(user{Name: "Tom", Age: 18})
+			require.NoError(t, err)
+			v, err := tc.codec.Decode(data)
+			require.NoError(t, err)
+			assert.Equal(t, user{Name: "Tom", Age: 18}, v)
+			_, err = tc.codec.Decode([]byte{0xc1})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestProtobuf(t *testing.T) {
+	t.Parallel()
+	c := Protobuf[*wrapperspb.StringValue]{}
+	assert.Equal(t, "application/x-protobuf", c.ContentType())
+	data, err := c.Encode(wrapperspb.String("hello"))
+	require.NoError(t, err)
+	v, err := c.Decode(data)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(wrapperspb.String("hello"), v))
+	_, err = c.Decode([]byte{0xff})
+	assert.Error(t, err)
+
+	// 类型参数为接口时返回错误而不是panic
+	_, err = Protobuf[proto.Message]{}.Decode(data)
+	assert.ErrorIs(t, err, ErrProtobufInterface)
+}
+
+func TestTyped(t *testing.T) {
+	t.Parallel()
+	testmq := memory.NewMQ()
+	defer func() {
+		_ = testmq.Close()
+	}()
+	const topic = "typed"
+	require.NoError(t, testmq.CreateTopic(context.Background(), topic, 1))
+	c, err := testmq.Consumer(topic, "g1")
+	require.NoError(t, err)
+	consumer := mq.NewTypedConsumer[user](c, JSON[user]{})
+	p, err := testmq.Producer(topic)
+	require.NoError(t, err)
+	producer := mq.NewTypedProducer[user](p, JSON[user]{})
+
+	_, err = producer.Produce(context.Background(), &mq.TypedMessage[user]{
+		Value:  user{Name: "Tom", Age: 18},
+		Key:    []byte("k"),
+		Header: mq.Header{{Key: "trace", Value: []byte("1")}},
+	})
+	require.NoError(t, err)
+	// 其他格式与无法解析的消息
+	_, err = mq.NewTypedProducer[user](p, Gob[user]{}).Produce(context.Background(), &mq.TypedMessage[user]{})
+	require.NoError(t, err)
+	_, err = p.Produce(context.Background(), &mq.Message{Value: []byte("{")})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	msg, err := consumer.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Tom", Age: 18}, msg.Value)
+	assert.Equal(t, []byte("k"), msg.Key)
+	assert.Equal(t, "1", msg.Header.Get("trace"))
+	assert.Equal(t, "application/json", msg.Header.Get(mq.HeaderContentType))
+	assert.Equal(t, int64(0), msg.Raw.Offset)
+
+	_, err = consumer.Consume(ctx)
+	var decodeErr *mq.DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	assert.ErrorIs(t, err, mq.ErrContentTypeMismatch)
+	assert.Equal(t, "application/x-gob", decodeErr.ContentType)
+	assert.Equal(t, int64(1), decodeErr.Msg.Offset)
+
+	_, err = consumer.Consume(ctx)
+	require.True(t, errors.As(err, &decodeErr))
+	assert.Equal(t, []byte("{"), decodeErr.Msg.Value)
+}
@@ -0,0 +1,172 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package compress 在发送时压缩较大的 mq.Message.Value，并在消费时透明地解压
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec 是压缩算法，实现需要可以被并发调用
+type Codec interface {
+	// Name 写入 HeaderCodec，消费时根据它选择解压使用的 Codec
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// DefaultMaxDecompressedSize 是内置压缩算法解压之后的默认最大字节数
+const DefaultMaxDecompressedSize = 32 << 20
+
+// ErrDecompressedTooLarge 表示解压之后的数据超过了最大字节数，用于防止压缩炸弹耗尽内存
+var ErrDecompressedTooLarge = errors.New("解压之后的数据过大")
+
+var (
+	Gzip   = NewGzip(DefaultMaxDecompressedSize)
+	Zstd   = NewZstd(DefaultMaxDecompressedSize)
+	Snappy = NewSnappy(DefaultMaxDecompressedSize)
+)
+
+// NewGzip 创建解压之后最多 maxSize 字节的gzip压缩算法
+func NewGzip(maxSize int) Codec {
+	return gzipCodec{maxSize: maxSize}
+}
+
+// NewZstd 创建解压之后最多 maxSize 字节的zstd压缩算法
+func NewZstd(maxSize int) Codec {
+	return &zstdCodec{maxSize: maxSize}
+}
+
+// NewSnappy 创建解压之后最多 maxSize 字节的snappy压缩算法
+func NewSnappy(maxSize int) Codec {
+	return snappyCodec{maxSize: maxSize}
+}
+
+type gzipCodec struct {
+	maxSize int
+}
+
+func (gzipCodec) Name() string {
+	return "gzip"
+}
+
+func (gzipCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c gzipCodec) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	// 多读一个字节才能区分恰好等于上限与超过上限
+	res, err := io.ReadAll(io.LimitReader(r, int64(c.maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(res) > c.maxSize {
+		return nil, ErrDecompressedTooLarge
+	}
+	return res, nil
+}
+
+// zstdCodec 的编码器与解码器第一次使用时创建，EncodeAll 与 DecodeAll 可以并发调用
+type zstdCodec struct {
+	maxSize int
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+}
+
+func (*zstdCodec) Name() string {
+	return "zstd"
+}
+
+func (c *zstdCodec) init() error {
+	c.once.Do(func() {
+		c.encoder, c.err = zstd.NewWriter(nil)
+		if c.err != nil {
+			return
+		}
+		// DecodeAll 解压之后超过 WithDecoderMaxMemory 时返回 zstd.ErrDecoderSizeExceeded
+		c.decoder, c.err = zstd.NewReader(nil,
+			zstd.WithDecoderMaxMemory(uint64(c.maxSize)),
+			zstd.WithDecoderMaxWindow(uint64(min(max(c.maxSize, zstd.MinWindowSize), zstd.MaxWindowSize))))
+	})
+	return c.err
+}
+
+func (c *zstdCodec) Compress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+func (c *zstdCodec) Decompress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	res, err := c.decoder.DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return nil, fmt.Errorf("%w: %w", ErrDecompressedTooLarge, err)
+	}
+	return res, err
+}
+
+// snappyCodec 使用snappy的块格式，与kafka的snappy压缩不同，不带xerial的分帧
+type snappyCodec struct {
+	maxSize int
+}
+
+func (snappyCodec) Name() string {
+	return "snappy"
+}
+
+func (snappyCodec) Compress(data []byte) ([]byte, error) {
+	return s2.EncodeSnappy(nil, data), nil
+}
+
+func (c snappyCodec) Decompress(data []byte) ([]byte, error) {
+	// 块格式的头部记录了解压之后的长度，超过上限时不分配内存
+	n, err := s2.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if n > c.maxSize {
+		return nil, ErrDecompressedTooLarge
+	}
+	return s2.Decode(nil, data)
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compress
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodec(t *testing.T) {
+	t.Parallel()
+	data := bytes.Repeat([]byte("mq-api"), 1000)
+	for _, codec := range []Codec{Gzip, Zstd, Snappy} {
+		codec := codec
+		t.Run(codec.Name(), func(t *testing.T) {
+			t.Parallel()
+			compressed, err := codec.Compress(data)
+			require.NoError(t, err)
+			assert.Less(t, len(compressed), len(data))
+			decompressed, err := codec.Decompress(compressed)
+			require.NoError(t, err)
+			assert.Equal(t, data, decompressed)
+			_, err = codec.Decompress([]byte("not compressed"))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCodec_MaxDecompressedSize(t *testing.T) {
+	t.Parallel()
+	const maxSize = 4096
+	testCases := []struct {
+		name  string
+		codec Codec
+	}{
+		{name: "gzip", codec: NewGzip(maxSize)},
+		{name: "zstd", codec: NewZstd(maxSize)},
+		{name: "snappy", codec: NewSnappy(maxSize)},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			data := bytes.Repeat([]byte{'a'}, maxSize)
+			compressed, err := tc.codec.Compress(data)
+			require.NoError(t, err)
+			decompressed, err := tc.codec.Decompress(compressed)
+			require.NoError(t, err)
+			assert.Equal(t, data, decompressed)
+
+			compressed, err = tc.codec.Compress(bytes.Repeat([]byte{'a'}, 1<<20))
+			require.NoError(t, err)
+			_, err = tc.codec.Decompress(compressed)
+			assert.ErrorIs(t, err, ErrDecompressedTooLarge)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+)
+
+// HeaderCodec 记录 Value 使用的压缩算法，没有这个header表示没有压缩
+const HeaderCodec = "x-mq-compression"
+
+// 默认只压缩不小于1KB的消息
+const defaultThreshold = 1024
+
+var ErrUnknownCodec = errors.New("未知的压缩算法")
+
+var (
+	_ mq.ProducerInterceptor = &Interceptor{}
+	_ mq.ConsumerInterceptor = &Interceptor{}
+)
+
+// Interceptor 同时实现了 mq.ProducerInterceptor 与 mq.ConsumerInterceptor，
+// 可以通过MQ实现的拦截器选项使用，也可以使用 mq.InterceptProducer 与 mq.InterceptConsumer 包装已有的生产者和消费者。
+// 发送时压缩 Value 不小于阈值的消息，压缩之后没有变小的消息按照原样发送；
+// 消费时按照 HeaderCodec 解压，解压之后删除该header
+type Interceptor struct {
+	codec     Codec
+	threshold int
+	// 消费时可以识别的压缩算法，键为 Codec.Name
+	codecs map[string]Codec
+}
+
+// NewInterceptor 创建使用codec压缩的 Interceptor，消费时可以识别内置的所有压缩算法
+func NewInterceptor(codec Codec, opts ...option.Option[Interceptor]) *Interceptor {
+	i := &Interceptor{
+		codec:     codec,
+		threshold: defaultThreshold,
+		codecs:    map[string]Codec{},
+	}
+	for _, c := range []Codec{Gzip, Zstd, Snappy, codec} {
+		i.codecs[c.Name()] = c
+	}
+	option.Apply(i, opts...)
+	return i
+}
+
+// WithThreshold 指定需要压缩的 Value 的最小长度，默认为1024字节
+func WithThreshold(threshold int) option.Option[Interceptor] {
+	return func(i *Interceptor) {
+		i.threshold = threshold
+	}
+}
+
+// WithMaxDecompressedSize 指定消费时内置压缩算法解压之后的最大字节数，默认为 DefaultMaxDecompressedSize，
+// 超过时返回 ErrDecompressedTooLarge。通过 WithCodecs 注册的压缩算法需要自行限制
+func WithMaxDecompressedSize(maxSize int) option.Option[Interceptor] {
+	return func(i *Interceptor) {
+		for _, c := range []Codec{NewGzip(maxSize), NewZstd(maxSize), NewSnappy(maxSize)} {
+			i.codecs[c.Name()] = c
+		}
+	}
+}
+
+// WithCodecs 注册消费时可以识别的其他压缩算法
+func WithCodecs(codecs ...Codec) option.Option[Interceptor] {
+	return func(i *Interceptor) {
+		for _, c := range codecs {
+			i.codecs[c.Name()] = c
+		}
+	}
+}
+
+// InterceptProduce 压缩之后发送的是消息的副本，不会修改调用者传入的消息
+func (i *Interceptor) InterceptProduce(_ mq.ProducerInfo, next mq.ProduceFunc) mq.ProduceFunc {
+	return func(ctx context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+		if len(m.Value) < i.threshold || m.Header.Get(HeaderCodec) != "" {
+			return next(ctx, m)
+		}
+		compressed, err := i.codec.Compress(m.Value)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) >= len(m.Value) {
+			return next(ctx, m)
+		}
+		msg := *m
+		msg.Value = compressed
+		msg.Header = m.Header.Clone()
+		msg.Header.Set(HeaderCodec, i.codec.Name())
+		return next(ctx, &msg)
+	}
+}
+
+// InterceptConsume 返回解压之后的副本，不会修改next返回的消息
+func (i *Interceptor) InterceptConsume(_ mq.ConsumerInfo, next mq.ConsumeFunc) mq.ConsumeFunc {
+	return func(ctx context.Context) (*mq.Message, error) {
+		m, err := next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		name := m.Header.Get(HeaderCodec)
+		if name == "" {
+			return m, nil
+		}
+		codec, ok := i.codecs[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, name)
+		}
+		value, err := codec.Decompress(m.Value)
+		if err != nil {
+			return nil, fmt.Errorf("解压消息失败，topic: %s, partition: %d, offset: %d: %w",
+				m.Topic, m.Partition, m.Offset, err)
+		}
+		// next返回的消息可能与其他消费者共享，返回解压之后的副本
+		msg := *m
+		msg.Value = value
+		msg.Header = m.Header.Clone()
+		msg.Header.Del(HeaderCodec)
+		return &msg, nil
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compress
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterceptor(t *testing.T) {
+	t.Parallel()
+	interceptor := NewInterceptor(Zstd, WithThreshold(100))
+	// 记录实际写入的消息
+	var raw []*mq.Message
+	recorder := mq.ProducerInterceptorFunc(func(_ mq.ProducerInfo, next mq.ProduceFunc) mq.ProduceFunc {
+		return func(ctx context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+			raw = append(raw, &mq.Message{Value: m.Value, Header: m.Header.Clone()})
+			return next(ctx, m)
+		}
+	})
+	testmq := memory.NewMQ(
+		memory.WithProducerInterceptors(interceptor, recorder),
+		memory.WithConsumerInterceptors(interceptor))
+	defer func() {
+		_ = testmq.Close()
+	}()
+	const topic = "compress"
+	require.NoError(t, testmq.CreateTopic(context.Background(), topic, 1))
+	c, err := testmq.Consumer(topic, "g1")
+	require.NoError(t, err)
+	p, err := testmq.Producer(topic)
+	require.NoError(t, err)
+
+	large := bytes.Repeat([]byte("a"), 1000)
+	random := make([]byte, 1000)
+	_, err = rand.Read(random)
+	require.NoError(t, err)
+	values := [][]byte{large, []byte("small"), random}
+	for _, value := range values {
+		msg := &mq.Message{Value: value, Header: mq.Header{{Key: "k", Value: []byte("v")}}}
+		_, err = p.Produce(context.Background(), msg)
+		require.NoError(t, err)
+		// 不修改调用者的消息
+		assert.Equal(t, value, msg.Value)
+		assert.Equal(t, "", msg.Header.Get(HeaderCodec))
+	}
+
+	require.Len(t, raw, 3)
+	assert.Equal(t, "zstd", raw[0].Header.Get(HeaderCodec))
+	assert.Less(t, len(raw[0].Value), len(large))
+	assert.Equal(t, "", raw[1].Header.Get(HeaderCodec))
+	// 随机数据压缩之后不会变小
+	assert.Equal(t, "", raw[2].Header.Get(HeaderCodec))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, value := range values {
+		msg, err := c.Consume(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, value, msg.Value)
+		assert.Equal(t, mq.Header{{Key: "k", Value: []byte("v")}}, msg.Header)
+	}
+}
+
+func TestInterceptor_Consume(t *testing.T) {
+	t.Parallel()
+	interceptor := NewInterceptor(Gzip)
+	compressed, err := Snappy.Compress([]byte("hello"))
+	require.NoError(t, err)
+	testCases := []struct {
+		name      string
+		msg       *mq.Message
+		wantValue []byte
+		wantErr   error
+	}{
+		{
+			name:      "其他内置算法",
+			msg:       &mq.Message{Value: compressed, Header: mq.Header{{Key: HeaderCodec, Value: []byte("snappy")}}},
+			wantValue: []byte("hello"),
+		},
+		{
+			name:      "没有压缩",
+			msg:       &mq.Message{Value: []byte("hello")},
+			wantValue: []byte("hello"),
+		},
+		{
+			name:    "未知算法",
+			msg:     &mq.Message{Value: compressed, Header: mq.Header{{Key: HeaderCodec, Value: []byte("lz4")}}},
+			wantErr: ErrUnknownCodec,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			consume := interceptor.InterceptConsume(mq.ConsumerInfo{}, func(ctx context.Context) (*mq.Message, error) {
+				return tc.msg, nil
+			})
+			msg, err := consume(context.Background())
+			assert.ErrorIs(t, err, tc.wantErr)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantValue, msg.Value)
+		})
+	}
+}
+
+func TestInterceptor_MaxDecompressedSize(t *testing.T) {
+	t.Parallel()
+	interceptor := NewInterceptor(Gzip, WithMaxDecompressedSize(1024))
+	compressed, err := Zstd.Compress(bytes.Repeat([]byte{'a'}, 1025))
+	require.NoError(t, err)
+	consume := interceptor.InterceptConsume(mq.ConsumerInfo{}, func(ctx context.Context) (*mq.Message, error) {
+		return &mq.Message{Value: compressed, Header: mq.Header{{Key: HeaderCodec, Value: []byte("zstd")}}}, nil
+	})
+	_, err = consume(context.Background())
+	assert.ErrorIs(t, err, ErrDecompressedTooLarge)
+}
+
+func TestInterceptor_ConsumeShared(t *testing.T) {
+	t.Parallel()
+	interceptor := NewInterceptor(Gzip)
+	compressed, err := Gzip.Compress([]byte("hello"))
+	require.NoError(t, err)
+	// 模拟多个消费组拿到同一条消息
+	shared := &mq.Message{Value: compressed, Header: mq.Header{{Key: HeaderCodec, Value: []byte("gzip")}}}
+	var wg sync.WaitGroup
+	for _, group := range []string{"g1", "g2"} {
+		consume := interceptor.InterceptConsume(mq.ConsumerInfo{GroupID: group}, func(ctx context.Context) (*mq.Message, error) {
+			return shared, nil
+		})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg, err := consume(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("hello"), msg.Value)
+			assert.Empty(t, msg.Header.Get(HeaderCodec))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, compressed, shared.Value)
+	assert.Equal(t, "gzip", shared.Header.Get(HeaderCodec))
+}
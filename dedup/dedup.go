@@ -0,0 +1,177 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dedup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strconv"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+)
+
+// HeaderMessageID 消息的唯一ID，由 IDInterceptor 在发送时生成
+const HeaderMessageID = "x-mq-message-id"
+
+var ErrMissingMessageID = errors.New("消息没有ID")
+
+// Store 记录已经处理过的消息，可以被多个协程并发访问
+type Store interface {
+	// Process 在key没有被处理过时调用fn，fn返回nil之后把key标记为已处理。
+	// key已经被处理过时不调用fn并返回false；同一个key并发调用时，只有一个fn会被执行，其他调用等待它的结果。
+	// fn返回error时key不会被标记，返回该error
+	Process(ctx context.Context, key string, fn func(ctx context.Context) error) (bool, error)
+	// Seen 判断key是否已经被处理过
+	Seen(ctx context.Context, key string) (bool, error)
+}
+
+// KeyFunc 返回用于去重的键，同一条消息重复投递时需要返回相同的键
+type KeyFunc func(msg *mq.Message) (string, error)
+
+// ByMessageID 使用 HeaderMessageID 去重，能够识别生产者重试造成的重复消息，需要生产者使用 IDInterceptor
+func ByMessageID(msg *mq.Message) (string, error) {
+	id := msg.Header.Get(HeaderMessageID)
+	if id == "" {
+		return "", ErrMissingMessageID
+	}
+	return id, nil
+}
+
+// ByOffset 使用消息的topic、分区与偏移量去重，只能识别消费者重复拉取造成的重复消息
+func ByOffset(msg *mq.Message) (string, error) {
+	return msg.Topic + "/" + strconv.FormatInt(msg.Partition, 10) + "/" + strconv.FormatInt(msg.Offset, 10), nil
+}
+
+var _ mq.ConsumerInterceptor = &Deduplicator{}
+
+// Deduplicator 保证同一个消费组对同一条消息只成功处理一次。
+// 它实现了 mq.ConsumerInterceptor，在消费时跳过已经处理过的消息，但是这只能减少重复，
+// 处理消息时需要使用 Process 或者 Wrap，才能保证处理与标记是原子的
+type Deduplicator struct {
+	store   Store
+	groupID string
+	keyFunc KeyFunc
+}
+
+// New 创建groupID消费组使用的 Deduplicator，不同消费组的处理记录互不影响。默认使用 ByMessageID 去重
+func New(store Store, groupID string, opts ...option.Option[Deduplicator]) *Deduplicator {
+	d := &Deduplicator{
+		store:   store,
+		groupID: groupID,
+		keyFunc: ByMessageID,
+	}
+	option.Apply(d, opts...)
+	return d
+}
+
+// WithKeyFunc 指定去重使用的键，默认为 ByMessageID
+func WithKeyFunc(fn KeyFunc) option.Option[Deduplicator] {
+	return func(d *Deduplicator) {
+		d.keyFunc = fn
+	}
+}
+
+func (d *Deduplicator) key(msg *mq.Message) (string, error) {
+	key, err := d.keyFunc(msg)
+	if err != nil {
+		return "", err
+	}
+	return d.groupID + "/" + key, nil
+}
+
+// Process 在msg没有被处理过时调用fn，fn返回nil之后把msg标记为已处理。msg已经被处理过时返回false
+func (d *Deduplicator) Process(ctx context.Context, msg *mq.Message,
+	fn func(ctx context.Context, msg *mq.Message) error) (bool, error) {
+	key, err := d.key(msg)
+	if err != nil {
+		return false, err
+	}
+	return d.store.Process(ctx, key, func(ctx context.Context) error {
+		return fn(ctx, msg)
+	})
+}
+
+// Wrap 返回跳过重复消息的处理函数，可以直接注册到 processor.Processor
+func (d *Deduplicator) Wrap(fn func(ctx context.Context, msg *mq.Message) error) func(ctx context.Context, msg *mq.Message) error {
+	return func(ctx context.Context, msg *mq.Message) error {
+		_, err := d.Process(ctx, msg, fn)
+		return err
+	}
+}
+
+// InterceptConsume 跳过已经处理过的消息。无法计算键或者查询失败的消息会原样返回，由 Process 处理
+func (d *Deduplicator) InterceptConsume(_ mq.ConsumerInfo, next mq.ConsumeFunc) mq.ConsumeFunc {
+	return func(ctx context.Context) (*mq.Message, error) {
+		for {
+			msg, err := next(ctx)
+			if err != nil {
+				return nil, err
+			}
+			key, err := d.key(msg)
+			if err != nil {
+				return msg, nil
+			}
+			seen, err := d.store.Seen(ctx, key)
+			if err != nil {
+				mq.Logger().Warn("查询消息是否处理过失败", slog.String("key", key), slog.String("error", err.Error()))
+				return msg, nil
+			}
+			if !seen {
+				return msg, nil
+			}
+		}
+	}
+}
+
+// NewConsumer 为c套上d，跳过已经处理过的消息，info为c订阅的topic与消费组
+func NewConsumer(c mq.Consumer, info mq.ConsumerInfo, d *Deduplicator) mq.Consumer {
+	return mq.InterceptConsumer(c, info, d)
+}
+
+var _ mq.ProducerInterceptor = IDInterceptor{}
+
+// IDInterceptor 为没有 HeaderMessageID 的消息生成随机ID。
+// 每次调用 Produce 都会生成新的ID，调用者自己重新发送同一条消息时需要预先设置 HeaderMessageID
+type IDInterceptor struct{}
+
+// InterceptProduce 发送的是消息的副本，不会修改调用者传入的消息
+func (IDInterceptor) InterceptProduce(_ mq.ProducerInfo, next mq.ProduceFunc) mq.ProduceFunc {
+	return func(ctx context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+		if m.Header.Get(HeaderMessageID) != "" {
+			return next(ctx, m)
+		}
+		id, err := newID()
+		if err != nil {
+			return nil, err
+		}
+		msg := *m
+		msg.Header = m.Header.Clone()
+		msg.Header.Set(HeaderMessageID, id)
+		return next(ctx, &msg)
+	}
+}
+
+// newID 生成随机的十六进制ID
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dedup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeduplicator(t *testing.T) {
+	t.Parallel()
+	testmq := memory.NewMQ(memory.WithProducerInterceptors(IDInterceptor{}))
+	defer func() {
+		_ = testmq.Close()
+	}()
+	const topic = "dedup"
+	require.NoError(t, testmq.CreateTopic(context.Background(), topic, 1))
+	c, err := testmq.Consumer(topic, "g1")
+	require.NoError(t, err)
+	p, err := testmq.Producer(topic)
+	require.NoError(t, err)
+
+	msg := &mq.Message{Value: []byte("pay")}
+	_, err = p.Produce(context.Background(), msg)
+	require.NoError(t, err)
+	// 不修改调用者的消息
+	assert.Empty(t, msg.Header)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	first, err := c.Consume(ctx)
+	require.NoError(t, err)
+	id := first.Header.Get(HeaderMessageID)
+	assert.Len(t, id, 32)
+	// 调用者重新发送同一条消息，模拟重复投递
+	_, err = p.Produce(context.Background(), first)
+	require.NoError(t, err)
+	_, err = p.Produce(context.Background(), &mq.Message{Value: []byte("other")})
+	require.NoError(t, err)
+
+	d := New(NewMemoryStore(100, time.Hour), "g1")
+	var handled []string
+	handler := d.Wrap(func(_ context.Context, msg *mq.Message) error {
+		handled = append(handled, string(msg.Value))
+		return nil
+	})
+	require.NoError(t, handler(ctx, first))
+	dedupConsumer := NewConsumer(c, mq.ConsumerInfo{Topic: topic, GroupID: "g1"}, d)
+	// 重复的消息在消费时被跳过
+	second, err := dedupConsumer.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("other"), second.Value)
+	require.NoError(t, handler(ctx, second))
+	// 绕过消费者的过滤，处理时依旧会跳过
+	require.NoError(t, handler(ctx, first))
+	assert.Equal(t, []string{"pay", "other"}, handled)
+
+	// 不同的消费组互不影响
+	processed, err := New(d.store, "g2").Process(ctx, first, func(context.Context, *mq.Message) error {
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, processed)
+}
+
+func TestDeduplicator_Process(t *testing.T) {
+	t.Parallel()
+	errHandle := errors.New("处理失败")
+	testCases := []struct {
+		name          string
+		keyFunc       KeyFunc
+		msg           *mq.Message
+		handleErr     error
+		wantProcessed bool
+		wantErr       error
+	}{
+		{
+			name:          "使用消息ID",
+			keyFunc:       ByMessageID,
+			msg:           &mq.Message{Header: mq.Header{{Key: HeaderMessageID, Value: []byte("id")}}},
+			wantProcessed: true,
+		},
+		{
+			name:    "没有消息ID",
+			keyFunc: ByMessageID,
+			msg:     &mq.Message{},
+			wantErr: ErrMissingMessageID,
+		},
+		{
+			name:          "使用偏移量",
+			keyFunc:       ByOffset,
+			msg:           &mq.Message{Topic: "t", Partition: 1, Offset: 2},
+			wantProcessed: true,
+		},
+		{
+			name:      "处理失败",
+			keyFunc:   ByOffset,
+			msg:       &mq.Message{Topic: "t", Partition: 1, Offset: 2},
+			handleErr: errHandle,
+			wantErr:   errHandle,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			store := NewMemoryStore(100, 0)
+			d := New(store, "g1", WithKeyFunc(tc.keyFunc))
+			processed, err := d.Process(context.Background(), tc.msg, func(context.Context, *mq.Message) error {
+				return tc.handleErr
+			})
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantProcessed, processed)
+			if tc.wantErr != nil {
+				return
+			}
+			key, err := tc.keyFunc(tc.msg)
+			require.NoError(t, err)
+			seen, err := store.Seen(context.Background(), "g1/"+key)
+			require.NoError(t, err)
+			assert.True(t, seen)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api/clock"
+)
+
+var _ Store = &MemoryStore{}
+
+// MemoryStore 在内存中记录处理过的键，超过容量时淘汰最久没有访问的键，超过ttl的键视为没有处理过。
+// 记录不会在进程之间共享，重启之后也会丢失，适合单实例的消费者或者测试
+type MemoryStore struct {
+	capacity int
+	ttl      time.Duration
+	clock    clock.Clock
+
+	locker sync.Mutex
+	// 最近访问的键在最前面
+	lru     *list.List
+	entries map[string]*list.Element
+	// 正在处理的键，处理完成时关闭channel
+	inflight map[string]chan struct{}
+}
+
+type memoryEntry struct {
+	key      string
+	expireAt time.Time
+}
+
+// NewMemoryStore 创建最多记录capacity个键的 MemoryStore，ttl为0时键不会过期
+func NewMemoryStore(capacity int, ttl time.Duration, opts ...option.Option[MemoryStore]) *MemoryStore {
+	s := &MemoryStore{
+		capacity: capacity,
+		ttl:      ttl,
+		clock:    clock.New(),
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+		inflight: map[string]chan struct{}{},
+	}
+	option.Apply(s, opts...)
+	return s
+}
+
+// WithClock 指定判断键是否过期使用的时钟，测试中可以使用 clock.Mock
+func WithClock(clk clock.Clock) option.Option[MemoryStore] {
+	return func(s *MemoryStore) {
+		s.clock = clk
+	}
+}
+
+func (s *MemoryStore) Process(ctx context.Context, key string, fn func(ctx context.Context) error) (bool, error) {
+	for {
+		s.locker.Lock()
+		if s.seen(key) {
+			s.locker.Unlock()
+			return false, nil
+		}
+		done, ok := s.inflight[key]
+		if !ok {
+			break
+		}
+		s.locker.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	done := make(chan struct{})
+	s.inflight[key] = done
+	s.locker.Unlock()
+
+	err := fn(ctx)
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	delete(s.inflight, key)
+	close(done)
+	if err != nil {
+		return false, err
+	}
+	s.add(key)
+	return true, nil
+}
+
+func (s *MemoryStore) Seen(_ context.Context, key string) (bool, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.seen(key), nil
+}
+
+// seen 调用者需要持有锁
+func (s *MemoryStore) seen(key string) bool {
+	elem, ok := s.entries[key]
+	if !ok {
+		return false
+	}
+	entry := elem.Value.(*memoryEntry)
+	if s.ttl > 0 && !s.clock.Now().Before(entry.expireAt) {
+		s.lru.Remove(elem)
+		delete(s.entries, key)
+		return false
+	}
+	s.lru.MoveToFront(elem)
+	return true
+}
+
+// add 调用者需要持有锁
+func (s *MemoryStore) add(key string) {
+	entry := &memoryEntry{key: key, expireAt: s.clock.Now().Add(s.ttl)}
+	if elem, ok := s.entries[key]; ok {
+		elem.Value = entry
+		s.lru.MoveToFront(elem)
+		return
+	}
+	s.entries[key] = s.lru.PushFront(entry)
+	for s.capacity > 0 && s.lru.Len() > s.capacity {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryEntry).key)
+	}
+}
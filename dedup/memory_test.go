@@ -0,0 +1,124 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dedup
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStore 测试 Store 实现的通用行为
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	errHandle := errors.New("处理失败")
+	processed, err := store.Process(ctx, "k1", func(context.Context) error {
+		return errHandle
+	})
+	assert.ErrorIs(t, err, errHandle)
+	assert.False(t, processed)
+	seen, err := store.Seen(ctx, "k1")
+	require.NoError(t, err)
+	assert.False(t, seen)
+
+	// 并发处理同一个键时只执行一次
+	var calls atomic.Int64
+	var wg sync.WaitGroup
+	var processedCount atomic.Int64
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			processed, err := store.Process(ctx, "k1", func(context.Context) error {
+				calls.Add(1)
+				time.Sleep(50 * time.Millisecond)
+				return nil
+			})
+			assert.NoError(t, err)
+			if processed {
+				processedCount.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1), calls.Load())
+	assert.Equal(t, int64(1), processedCount.Load())
+	seen, err = store.Seen(ctx, "k1")
+	require.NoError(t, err)
+	assert.True(t, seen)
+	seen, err = store.Seen(ctx, "k2")
+	require.NoError(t, err)
+	assert.False(t, seen)
+}
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+	testStore(t, NewMemoryStore(100, time.Hour))
+}
+
+func TestMemoryStore_Expire(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	clk := clock.NewMock(time.Now())
+	store := NewMemoryStore(2, time.Minute, WithClock(clk))
+	nop := func(context.Context) error { return nil }
+	for _, key := range []string{"k1", "k2"} {
+		_, err := store.Process(ctx, key, nop)
+		require.NoError(t, err)
+	}
+	// 访问k1之后k2是最久没有访问的键
+	seen, _ := store.Seen(ctx, "k1")
+	assert.True(t, seen)
+	_, err := store.Process(ctx, "k3", nop)
+	require.NoError(t, err)
+	seen, _ = store.Seen(ctx, "k2")
+	assert.False(t, seen)
+	seen, _ = store.Seen(ctx, "k1")
+	assert.True(t, seen)
+
+	clk.Add(time.Minute)
+	seen, _ = store.Seen(ctx, "k3")
+	assert.False(t, seen)
+	processed, err := store.Process(ctx, "k1", nop)
+	require.NoError(t, err)
+	assert.True(t, processed)
+}
+
+func TestMemoryStore_ContextCanceled(t *testing.T) {
+	t.Parallel()
+	store := NewMemoryStore(100, 0)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		_, _ = store.Process(context.Background(), "k1", func(context.Context) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := store.Process(ctx, "k1", func(context.Context) error { return nil })
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	close(release)
+}
@@ -0,0 +1,164 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dedup
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisDone       = "done"
+	redisProcessing = "processing:"
+
+	defaultRedisPrefix       = "mq:dedup:"
+	defaultRedisLease        = 30 * time.Second
+	defaultRedisPollInterval = 100 * time.Millisecond
+)
+
+var (
+	// 键的值依旧是本次处理设置的值时标记为已处理
+	redisMarkScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	if tonumber(ARGV[3]) > 0 then
+		redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+	else
+		redis.call("SET", KEYS[1], ARGV[2])
+	end
+	return 1
+end
+return 0`)
+	// 键的值依旧是本次处理设置的值时删除
+	redisReleaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+var _ Store = &RedisStore{}
+
+// RedisStore 使用redis记录处理过的键，可以在多个消费者实例之间共享。
+// 处理之前以 SET NX 占用键并设置租约，处理成功之后改为已处理并设置ttl，失败时释放。
+// 处理时间超过租约时其他实例可能会重复处理，因此租约需要长于处理消息的最长时间。
+// 标记与业务数据不在同一个事务中，标记之前进程崩溃时消息会被重新处理
+type RedisStore struct {
+	client redis.Cmdable
+	ttl    time.Duration
+	prefix string
+	lease  time.Duration
+	// 其他实例正在处理时，检查是否处理完成的间隔
+	pollInterval time.Duration
+}
+
+// NewRedisStore 创建使用client的 RedisStore，处理过的键保留ttl，ttl为0时键不会过期
+func NewRedisStore(client redis.Cmdable, ttl time.Duration, opts ...option.Option[RedisStore]) *RedisStore {
+	s := &RedisStore{
+		client:       client,
+		ttl:          ttl,
+		prefix:       defaultRedisPrefix,
+		lease:        defaultRedisLease,
+		pollInterval: defaultRedisPollInterval,
+	}
+	option.Apply(s, opts...)
+	return s
+}
+
+// WithKeyPrefix 指定redis中键的前缀，默认为 mq:dedup:
+func WithKeyPrefix(prefix string) option.Option[RedisStore] {
+	return func(s *RedisStore) {
+		s.prefix = prefix
+	}
+}
+
+// WithLease 指定处理消息时占用键的时长，默认为30秒
+func WithLease(lease time.Duration) option.Option[RedisStore] {
+	return func(s *RedisStore) {
+		s.lease = lease
+	}
+}
+
+// WithPollInterval 指定其他实例正在处理同一个键时检查结果的间隔，默认为100毫秒
+func WithPollInterval(interval time.Duration) option.Option[RedisStore] {
+	return func(s *RedisStore) {
+		s.pollInterval = interval
+	}
+}
+
+func (s *RedisStore) Process(ctx context.Context, key string, fn func(ctx context.Context) error) (bool, error) {
+	id, err := newID()
+	if err != nil {
+		return false, err
+	}
+	key = s.prefix + key
+	token := redisProcessing + id
+	for {
+		ok, err := s.client.SetNX(ctx, key, token, s.lease).Result()
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			break
+		}
+		val, err := s.client.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if val == redisDone {
+			return false, nil
+		}
+		select {
+		case <-time.After(s.pollInterval):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+
+	if err = fn(ctx); err != nil {
+		if releaseErr := redisReleaseScript.Run(ctx, s.client, []string{key}, token).Err(); releaseErr != nil {
+			mq.Logger().Warn("释放去重的键失败", slog.String("key", key), slog.String("error", releaseErr.Error()))
+		}
+		return false, err
+	}
+	marked, err := redisMarkScript.Run(ctx, s.client, []string{key}, token, redisDone, s.ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	if marked == 0 {
+		mq.Logger().Warn("处理消息的时间超过了租约，消息可能被重复处理", slog.String("key", key))
+	}
+	return true, nil
+}
+
+func (s *RedisStore) Seen(ctx context.Context, key string) (bool, error) {
+	val, err := s.client.Get(ctx, s.prefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !strings.HasPrefix(val, redisProcessing), nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRedisClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return mr, client
+}
+
+func TestRedisStore(t *testing.T) {
+	t.Parallel()
+	_, client := newRedisClient(t)
+	testStore(t, NewRedisStore(client, time.Hour, WithPollInterval(10*time.Millisecond)))
+}
+
+func TestRedisStore_Expire(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	mr, client := newRedisClient(t)
+	store := NewRedisStore(client, time.Minute, WithKeyPrefix("test:"), WithLease(time.Second))
+	processed, err := store.Process(ctx, "k1", func(context.Context) error {
+		// 处理时间超过租约，标记失败
+		mr.FastForward(2 * time.Second)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, processed)
+	assert.False(t, mr.Exists("test:k1"))
+
+	_, err = store.Process(ctx, "k1", func(context.Context) error { return nil })
+	require.NoError(t, err)
+	val, err := mr.Get("test:k1")
+	require.NoError(t, err)
+	assert.Equal(t, redisDone, val)
+	assert.Equal(t, time.Minute, mr.TTL("test:k1"))
+	mr.FastForward(time.Minute)
+	seen, err := store.Seen(ctx, "k1")
+	require.NoError(t, err)
+	assert.False(t, seen)
+
+	// ttl为0时不过期
+	_, err = NewRedisStore(client, 0, WithKeyPrefix("test:")).Process(ctx, "k2", func(context.Context) error { return nil })
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), mr.TTL("test:k2"))
+	assert.True(t, mr.Exists("test:k2"))
+}
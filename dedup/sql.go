@@ -0,0 +1,130 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dedup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+)
+
+const defaultTable = "mq_dedup"
+
+var _ Store = &SQLStore{}
+
+// SQLStore 使用数据库记录处理过的键，表结构为：
+//
+//	CREATE TABLE mq_dedup (
+//	    dedup_key VARCHAR(255) PRIMARY KEY,
+//	    created_at TIMESTAMP NOT NULL
+//	)
+//
+// Process 在同一个事务中插入键并调用fn，fn通过 TxFromContext 获取事务写入业务数据，
+// 因此处理与标记是原子的：fn返回error或者提交失败时两者都会回滚。
+// 并发处理同一个键时，后插入的事务会等待主键冲突的结果
+type SQLStore struct {
+	db    *sql.DB
+	table string
+	// 生成第i个参数的占位符，从1开始
+	placeholder func(i int) string
+}
+
+// NewSQLStore 创建使用db的 SQLStore，默认使用表mq_dedup与?占位符
+func NewSQLStore(db *sql.DB, opts ...option.Option[SQLStore]) *SQLStore {
+	s := &SQLStore{
+		db:    db,
+		table: defaultTable,
+		placeholder: func(_ int) string {
+			return "?"
+		},
+	}
+	option.Apply(s, opts...)
+	return s
+}
+
+// WithTable 指定记录键的表名，默认为mq_dedup
+func WithTable(table string) option.Option[SQLStore] {
+	return func(s *SQLStore) {
+		s.table = table
+	}
+}
+
+// WithDollarPlaceholder 使用$1形式的占位符，用于PostgreSQL
+func WithDollarPlaceholder() option.Option[SQLStore] {
+	return func(s *SQLStore) {
+		s.placeholder = func(i int) string {
+			return fmt.Sprintf("$%d", i)
+		}
+	}
+}
+
+type txKey struct{}
+
+// TxFromContext 返回 SQLStore.Process 传给fn的事务，在fn中使用它写入业务数据
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(*sql.Tx)
+	return tx, ok
+}
+
+func (s *SQLStore) Process(ctx context.Context, key string, fn func(ctx context.Context) error) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (dedup_key, created_at) VALUES (%s, %s)", s.table, s.placeholder(1), s.placeholder(2)),
+		key, time.Now())
+	if err != nil {
+		_ = tx.Rollback()
+		// 不同的数据库主键冲突的错误不同，因此插入失败之后查询键是否已经存在
+		seen, seenErr := s.Seen(ctx, key)
+		if seenErr == nil && seen {
+			return false, nil
+		}
+		return false, err
+	}
+	if err = fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *SQLStore) Seen(ctx context.Context, key string) (bool, error) {
+	var one int
+	err := s.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT 1 FROM %s WHERE dedup_key = %s", s.table, s.placeholder(1)), key).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeleteBefore 删除早于t处理的键，返回删除的数量，需要定期调用清理过期的记录
+func (s *SQLStore) DeleteBefore(ctx context.Context, t time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		fmt.Sprintf("DELETE FROM %s WHERE created_at < %s", s.table, s.placeholder(1)), t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dedup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLStore_Process(t *testing.T) {
+	t.Parallel()
+	errHandle := errors.New("处理失败")
+	errInsert := errors.New("连接断开")
+	testCases := []struct {
+		name          string
+		mock          func(mock sqlmock.Sqlmock)
+		handleErr     error
+		wantProcessed bool
+		wantErr       error
+	}{
+		{
+			name: "处理成功",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO mq_dedup \\(dedup_key, created_at\\) VALUES \\(\\?, \\?\\)").
+					WithArgs("k1", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("UPDATE account").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			wantProcessed: true,
+		},
+		{
+			name: "处理失败",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO mq_dedup").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("UPDATE account").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectRollback()
+			},
+			handleErr: errHandle,
+			wantErr:   errHandle,
+		},
+		{
+			name: "已经处理过",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO mq_dedup").WillReturnError(errors.New("Duplicate entry"))
+				mock.ExpectRollback()
+				mock.ExpectQuery("SELECT 1 FROM mq_dedup WHERE dedup_key = \\?").
+					WithArgs("k1").WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+			},
+		},
+		{
+			name: "插入失败",
+			mock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO mq_dedup").WillReturnError(errInsert)
+				mock.ExpectRollback()
+				mock.ExpectQuery("SELECT 1 FROM mq_dedup").WillReturnError(sql.ErrNoRows)
+			},
+			wantErr: errInsert,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer func() {
+				_ = db.Close()
+			}()
+			tc.mock(mock)
+			processed, err := NewSQLStore(db).Process(context.Background(), "k1", func(ctx context.Context) error {
+				tx, ok := TxFromContext(ctx)
+				require.True(t, ok)
+				_, err := tx.ExecContext(ctx, "UPDATE account SET balance = balance - 1")
+				require.NoError(t, err)
+				return tc.handleErr
+			})
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantProcessed, processed)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSQLStore(t *testing.T) {
+	t.Parallel()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		_ = db.Close()
+	}()
+	store := NewSQLStore(db, WithTable("payment_dedup"), WithDollarPlaceholder())
+	mock.ExpectQuery("SELECT 1 FROM payment_dedup WHERE dedup_key = \\$1").
+		WithArgs("k1").WillReturnRows(sqlmock.NewRows([]string{"1"}))
+	seen, err := store.Seen(context.Background(), "k1")
+	require.NoError(t, err)
+	assert.False(t, seen)
+
+	before := time.Now().Add(-time.Hour)
+	mock.ExpectExec("DELETE FROM payment_dedup WHERE created_at < \\$1").
+		WithArgs(before).WillReturnResult(sqlmock.NewResult(0, 3))
+	n, err := store.DeleteBefore(context.Background(), before)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,238 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dlq
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/ekit/retry"
+	"github.com/ecodeclub/mq-api"
+	"go.uber.org/multierr"
+)
+
+const (
+	// HeaderAttempts 记录消息已经被处理的次数
+	HeaderAttempts = "x-mq-attempts"
+	// HeaderError 记录最后一次处理失败的错误信息
+	HeaderError = "x-mq-error"
+	// HeaderOriginTopic 记录消息最初所在的topic
+	HeaderOriginTopic = "x-mq-origin-topic"
+	// HeaderOriginPartition 记录消息最初所在的分区
+	HeaderOriginPartition = "x-mq-origin-partition"
+	// HeaderOriginOffset 记录消息最初的偏移量
+	HeaderOriginOffset = "x-mq-origin-offset"
+
+	topicSuffix = ".dlq"
+
+	defaultMaxAttempts     = 3
+	defaultInitialInterval = 100 * time.Millisecond
+	defaultMaxInterval     = 10 * time.Second
+)
+
+// HandleFunc 是业务处理消息的方法，返回error表示处理失败
+type HandleFunc func(ctx context.Context, msg *mq.Message) error
+
+// Topic 返回topic对应的死信topic
+func Topic(topic string) string {
+	return topic + topicSuffix
+}
+
+// Attempts 返回消息已经被处理的次数
+func Attempts(msg *mq.Message) int {
+	attempts, err := strconv.Atoi(msg.Header.Get(HeaderAttempts))
+	if err != nil {
+		return 0
+	}
+	return attempts
+}
+
+// OriginTopic 返回消息最初所在的topic，消息没有经过转发时就是 msg.Topic
+func OriginTopic(msg *mq.Message) string {
+	if topic := msg.Header.Get(HeaderOriginTopic); topic != "" {
+		return topic
+	}
+	return msg.Topic
+}
+
+// DeadLetterQueue 在 mq.MQ 的基础上提供死信队列。
+// 处理失败的消息会按照重试策略重试，次数耗尽后投递到 <topic>.dlq
+type DeadLetterQueue struct {
+	mq          mq.MQ
+	maxAttempts int
+	newStrategy func() retry.Strategy
+
+	locker    sync.Mutex
+	producers map[string]mq.Producer
+}
+
+func NewDeadLetterQueue(m mq.MQ, opts ...option.Option[DeadLetterQueue]) *DeadLetterQueue {
+	d := &DeadLetterQueue{
+		mq:          m,
+		maxAttempts: defaultMaxAttempts,
+		producers:   make(map[string]mq.Producer),
+	}
+	option.Apply(d, opts...)
+	if d.newStrategy == nil {
+		d.newStrategy = func() retry.Strategy {
+			strategy, _ := retry.NewExponentialBackoffRetryStrategy(defaultInitialInterval, defaultMaxInterval, int32(d.maxAttempts))
+			return strategy
+		}
+	}
+	return d
+}
+
+// WithMaxAttempts 指定消息最多被处理的次数，包括第一次处理，默认为3
+func WithMaxAttempts(maxAttempts int) option.Option[DeadLetterQueue] {
+	return func(d *DeadLetterQueue) {
+		d.maxAttempts = maxAttempts
+	}
+}
+
+// WithRetryStrategy 指定两次处理之间的退避策略，每条消息都会调用newStrategy创建新的策略，默认为指数退避。
+// 策略返回false时即使没有达到最大次数也会投递到死信topic
+func WithRetryStrategy(newStrategy func() retry.Strategy) option.Option[DeadLetterQueue] {
+	return func(d *DeadLetterQueue) {
+		d.newStrategy = newStrategy
+	}
+}
+
+// Handle 调用handler处理消息，失败时按照重试策略重试，重试次数耗尽后投递到死信topic。
+// 返回nil表示消息已经处理成功或者已经投递到死信topic，调用者可以提交偏移量
+func (d *DeadLetterQueue) Handle(ctx context.Context, msg *mq.Message, handler HandleFunc) error {
+	attempts := Attempts(msg)
+	strategy := d.newStrategy()
+	for {
+		err := handler(ctx, msg)
+		if err == nil {
+			return nil
+		}
+		attempts++
+		if attempts >= d.maxAttempts {
+			return d.send(ctx, msg, attempts, err)
+		}
+		interval, ok := strategy.Next()
+		if !ok {
+			return d.send(ctx, msg, attempts, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Send 直接将消息投递到死信topic，适用于不需要重试的错误，例如消息无法解析
+func (d *DeadLetterQueue) Send(ctx context.Context, msg *mq.Message, cause error) error {
+	return d.send(ctx, msg, Attempts(msg)+1, cause)
+}
+
+func (d *DeadLetterQueue) send(ctx context.Context, msg *mq.Message, attempts int, cause error) error {
+	origin := OriginTopic(msg)
+	header := msg.Header.Clone()
+	header.Set(HeaderAttempts, strconv.Itoa(attempts))
+	header.Set(HeaderError, cause.Error())
+	if header.Get(HeaderOriginTopic) == "" {
+		header.Set(HeaderOriginTopic, msg.Topic)
+		header.Set(HeaderOriginPartition, strconv.FormatInt(msg.Partition, 10))
+		header.Set(HeaderOriginOffset, strconv.FormatInt(msg.Offset, 10))
+	}
+	p, err := d.producer(ctx, Topic(origin))
+	if err != nil {
+		return err
+	}
+	_, err = p.Produce(ctx, &mq.Message{
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Header:    header,
+		Timestamp: msg.Timestamp,
+	})
+	return err
+}
+
+// Replay 从topic的死信topic中消费最多limit条消息，去掉死信相关的header后重新投递回原topic，返回重新投递的消息数量。
+// 死信topic中的消息不足limit条时会一直等待到ctx超时，此时返回已经投递的数量和ctx的错误。
+// 每条消息在重新投递成功之后才会提交消费进度，投递失败或者超出limit的消息会留在死信topic中
+func (d *DeadLetterQueue) Replay(ctx context.Context, topic, groupID string, limit int) (int, error) {
+	c, err := d.mq.Consumer(Topic(topic), groupID, mq.WithManualCommit())
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	n := 0
+	for n < limit {
+		msg, err := c.Consume(ctx)
+		if err != nil {
+			return n, err
+		}
+		header := msg.Header.Clone()
+		for _, key := range []string{HeaderAttempts, HeaderError, HeaderOriginTopic, HeaderOriginPartition, HeaderOriginOffset} {
+			header.Del(key)
+		}
+		p, err := d.producer(ctx, OriginTopic(msg))
+		if err != nil {
+			return n, err
+		}
+		_, err = p.Produce(ctx, &mq.Message{
+			Key:    msg.Key,
+			Value:  msg.Value,
+			Header: header,
+		})
+		if err != nil {
+			return n, err
+		}
+		if err = c.Commit(ctx, msg); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (d *DeadLetterQueue) producer(ctx context.Context, topic string) (mq.Producer, error) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	if p, ok := d.producers[topic]; ok {
+		return p, nil
+	}
+	// topic已经存在时两种实现的CreateTopic都不会返回错误
+	if err := d.mq.CreateTopic(ctx, topic, 1); err != nil {
+		return nil, err
+	}
+	p, err := d.mq.Producer(topic)
+	if err != nil {
+		return nil, err
+	}
+	d.producers[topic] = p
+	return p, nil
+}
+
+// Close 关闭 DeadLetterQueue 创建的生产者，不会关闭 mq.MQ
+func (d *DeadLetterQueue) Close() error {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	var err error
+	for topic, p := range d.producers {
+		err = multierr.Append(err, p.Close())
+		delete(d.producers, topic)
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dlq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/ekit/retry"
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetterQueue_Handle(t *testing.T) {
+	t.Parallel()
+	errHandle := errors.New("处理失败")
+	testCases := []struct {
+		name        string
+		topic       string
+		failures    int
+		wantCalls   int
+		wantDLQ     bool
+		wantHeaders map[string]string
+	}{
+		{
+			name:      "重试后成功",
+			topic:     "dlq_handle_success",
+			failures:  2,
+			wantCalls: 3,
+		},
+		{
+			name:      "重试次数耗尽",
+			topic:     "dlq_handle_exhausted",
+			failures:  5,
+			wantCalls: 3,
+			wantDLQ:   true,
+			wantHeaders: map[string]string{
+				HeaderAttempts:        "3",
+				HeaderError:           errHandle.Error(),
+				HeaderOriginTopic:     "dlq_handle_exhausted",
+				HeaderOriginPartition: "0",
+				HeaderOriginOffset:    "0",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			m := memory.NewMQ()
+			d := NewDeadLetterQueue(m, WithMaxAttempts(3), WithRetryStrategy(func() retry.Strategy {
+				strategy, _ := retry.NewFixedIntervalRetryStrategy(time.Millisecond, 10)
+				return strategy
+			}))
+			defer func() {
+				_ = d.Close()
+			}()
+
+			calls := 0
+			err := d.Handle(context.Background(), &mq.Message{Topic: tc.topic, Value: []byte("msg")}, func(ctx context.Context, msg *mq.Message) error {
+				calls++
+				if calls <= tc.failures {
+					return errHandle
+				}
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantCalls, calls)
+
+			c, err := m.Consumer(Topic(tc.topic), "c1")
+			require.NoError(t, err)
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			msg, err := c.Consume(ctx)
+			if !tc.wantDLQ {
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []byte("msg"), msg.Value)
+			assert.Equal(t, tc.wantHeaders, msg.Header.Map())
+		})
+	}
+}
+
+func TestDeadLetterQueue_SendAndReplay(t *testing.T) {
+	t.Parallel()
+	m := memory.NewMQ()
+	d := NewDeadLetterQueue(m)
+	defer func() {
+		_ = d.Close()
+	}()
+
+	var header mq.Header
+	header.Add("trace", "a")
+	err := d.Send(context.Background(), &mq.Message{Topic: "dlq_replay", Value: []byte("msg"), Header: header}, errors.New("无法解析"))
+	require.NoError(t, err)
+	err = d.Send(context.Background(), &mq.Message{Topic: "dlq_replay", Value: []byte("msg2")}, errors.New("无法解析"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	n, err := d.Replay(ctx, "dlq_replay", "replay", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	c, err := m.Consumer("dlq_replay", "c1")
+	require.NoError(t, err)
+	msg, err := c.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("msg"), msg.Value)
+	assert.Equal(t, header, msg.Header)
+
+	// 第一次重放时一起拉取到的消息没有提交，依旧留在死信topic中
+	n, err = d.Replay(ctx, "dlq_replay", "replay", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	msg, err = c.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("msg2"), msg.Value)
+
+	// 死信topic中已经没有消息
+	replayCtx, replayCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer replayCancel()
+	n, err = d.Replay(replayCtx, "dlq_replay", "replay", 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, n)
+}
+
+func TestAttempts(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, 0, Attempts(&mq.Message{}))
+	assert.Equal(t, 0, Attempts(&mq.Message{Header: mq.Header{{Key: HeaderAttempts, Value: []byte("x")}}}))
+	assert.Equal(t, 2, Attempts(&mq.Message{Header: mq.Header{{Key: HeaderAttempts, Value: []byte("2")}}}))
+}
@@ -0,0 +1,269 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+)
+
+const (
+	// HeaderKeyID 加密数据密钥使用的主密钥ID
+	HeaderKeyID = "x-mq-key-id"
+	// HeaderWrappedKey 被主密钥加密之后的数据密钥
+	HeaderWrappedKey = "x-mq-wrapped-key"
+	// HeaderNonce 加密 Value 使用的nonce
+	HeaderNonce = "x-mq-nonce"
+	// HeaderSignature 消息的签名
+	HeaderSignature = "x-mq-signature"
+
+	// AES-256
+	dataKeySize = 32
+	// 消费者缓存的数据密钥数量上限，超过之后清空
+	maxCachedKeys = 1024
+)
+
+var (
+	ErrNotEncrypted     = errors.New("消息没有加密")
+	ErrDecryptFailed    = errors.New("解密消息失败")
+	ErrMissingSignature = errors.New("消息没有签名")
+)
+
+var (
+	_ mq.ProducerInterceptor = &Interceptor{}
+	_ mq.ConsumerInterceptor = &Interceptor{}
+)
+
+// Interceptor 同时实现了 mq.ProducerInterceptor 与 mq.ConsumerInterceptor。
+// 发送时使用随机生成的数据密钥以AES-GCM加密 Value，数据密钥由 KeyProvider 的主密钥加密之后与nonce一起放在header中；
+// 配置了 Signer 时再对Key、Value与header签名。消费时先校验签名，再解密 Value 并删除这些header。
+//
+// 签名覆盖了所有header，因此它需要是生产者最内层、消费者最内层的拦截器，例如压缩需要放在它之前
+type Interceptor struct {
+	provider KeyProvider
+	signer   Signer
+	// 是否接受没有加密的消息，用于逐步迁移
+	allowPlaintext bool
+	// 数据密钥的复用时长，为0时每条消息使用新的数据密钥
+	dataKeyLifetime time.Duration
+
+	dataKeyLocker sync.Mutex
+	dataKey       *dataKey
+
+	unwrappedLocker sync.RWMutex
+	// 键为主密钥ID与加密之后的数据密钥
+	unwrapped map[string]cipher.AEAD
+}
+
+type dataKey struct {
+	keyID   string
+	wrapped []byte
+	aead    cipher.AEAD
+	created time.Time
+}
+
+func NewInterceptor(provider KeyProvider, opts ...option.Option[Interceptor]) *Interceptor {
+	i := &Interceptor{
+		provider:  provider,
+		unwrapped: map[string]cipher.AEAD{},
+	}
+	option.Apply(i, opts...)
+	return i
+}
+
+// WithSigner 指定签名方式，消费时拒绝没有签名或者签名不匹配的消息
+func WithSigner(signer Signer) option.Option[Interceptor] {
+	return func(i *Interceptor) {
+		i.signer = signer
+	}
+}
+
+// WithAllowPlaintext 消费时直接返回没有加密的消息，默认返回 ErrNotEncrypted。签名依旧会被校验
+func WithAllowPlaintext() option.Option[Interceptor] {
+	return func(i *Interceptor) {
+		i.allowPlaintext = true
+	}
+}
+
+// WithDataKeyLifetime 在lifetime内复用同一个数据密钥，减少访问 KeyProvider 的次数。
+// 主密钥轮换之后会立刻使用新的数据密钥
+func WithDataKeyLifetime(lifetime time.Duration) option.Option[Interceptor] {
+	return func(i *Interceptor) {
+		i.dataKeyLifetime = lifetime
+	}
+}
+
+// InterceptProduce 加密之后发送的是消息的副本，不会修改调用者传入的消息
+func (i *Interceptor) InterceptProduce(_ mq.ProducerInfo, next mq.ProduceFunc) mq.ProduceFunc {
+	return func(ctx context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+		key, err := i.currentDataKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, key.aead.NonceSize())
+		if _, err = rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		msg := *m
+		msg.Value = key.aead.Seal(nil, nonce, m.Value, []byte(key.keyID))
+		msg.Header = m.Header.Clone()
+		msg.Header.Set(HeaderKeyID, key.keyID)
+		msg.Header.SetBytes(HeaderWrappedKey, key.wrapped)
+		msg.Header.SetBytes(HeaderNonce, nonce)
+		if i.signer != nil {
+			msg.Header.Del(HeaderSignature)
+			signature, err := i.signer.Sign(signingPayload(&msg))
+			if err != nil {
+				return nil, err
+			}
+			msg.Header.SetBytes(HeaderSignature, signature)
+		}
+		return next(ctx, &msg)
+	}
+}
+
+// InterceptConsume 在副本上校验与解密，不会修改next返回的消息，因此多个消费组可以共享同一条消息
+func (i *Interceptor) InterceptConsume(_ mq.ConsumerInfo, next mq.ConsumeFunc) mq.ConsumeFunc {
+	return func(ctx context.Context) (*mq.Message, error) {
+		m, err := next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		msg := *m
+		msg.Header = m.Header.Clone()
+		if err = i.verify(&msg); err != nil {
+			return nil, fmt.Errorf("%w, topic: %s, partition: %d, offset: %d", err, m.Topic, m.Partition, m.Offset)
+		}
+		keyID := msg.Header.Get(HeaderKeyID)
+		if keyID == "" {
+			if i.allowPlaintext {
+				return &msg, nil
+			}
+			return nil, fmt.Errorf("%w, topic: %s, partition: %d, offset: %d", ErrNotEncrypted, m.Topic, m.Partition, m.Offset)
+		}
+		aead, err := i.unwrap(ctx, keyID, msg.Header.GetBytes(HeaderWrappedKey))
+		if err != nil {
+			return nil, err
+		}
+		nonce := msg.Header.GetBytes(HeaderNonce)
+		if len(nonce) != aead.NonceSize() {
+			return nil, fmt.Errorf("%w: nonce长度非法", ErrDecryptFailed)
+		}
+		value, err := aead.Open(nil, nonce, msg.Value, []byte(keyID))
+		if err != nil {
+			return nil, fmt.Errorf("%w, topic: %s, partition: %d, offset: %d", ErrDecryptFailed, m.Topic, m.Partition, m.Offset)
+		}
+		msg.Value = value
+		for _, key := range []string{HeaderKeyID, HeaderWrappedKey, HeaderNonce} {
+			msg.Header.Del(key)
+		}
+		return &msg, nil
+	}
+}
+
+// verify 校验签名并删除签名header，没有配置 Signer 时不做任何事情
+func (i *Interceptor) verify(m *mq.Message) error {
+	if i.signer == nil {
+		return nil
+	}
+	signature := m.Header.GetBytes(HeaderSignature)
+	if signature == nil {
+		return ErrMissingSignature
+	}
+	m.Header.Del(HeaderSignature)
+	return i.signer.Verify(signingPayload(m), signature)
+}
+
+// currentDataKey 返回可以复用的数据密钥，过期或者主密钥轮换之后生成新的数据密钥
+func (i *Interceptor) currentDataKey(ctx context.Context) (*dataKey, error) {
+	keyID, err := i.provider.CurrentKeyID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	i.dataKeyLocker.Lock()
+	defer i.dataKeyLocker.Unlock()
+	if k := i.dataKey; k != nil && k.keyID == keyID && time.Since(k.created) < i.dataKeyLifetime {
+		return k, nil
+	}
+	raw := make([]byte, dataKeySize)
+	if _, err = rand.Read(raw); err != nil {
+		return nil, err
+	}
+	wrapped, err := i.provider.WrapKey(ctx, keyID, raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	i.dataKey = &dataKey{keyID: keyID, wrapped: wrapped, aead: aead, created: time.Now()}
+	return i.dataKey, nil
+}
+
+// unwrap 解密数据密钥，复用数据密钥时可以命中缓存
+func (i *Interceptor) unwrap(ctx context.Context, keyID string, wrapped []byte) (cipher.AEAD, error) {
+	cacheKey := keyID + "\x00" + string(wrapped)
+	i.unwrappedLocker.RLock()
+	aead, ok := i.unwrapped[cacheKey]
+	i.unwrappedLocker.RUnlock()
+	if ok {
+		return aead, nil
+	}
+	raw, err := i.provider.UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err = newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	i.unwrappedLocker.Lock()
+	defer i.unwrappedLocker.Unlock()
+	if len(i.unwrapped) >= maxCachedKeys {
+		i.unwrapped = map[string]cipher.AEAD{}
+	}
+	i.unwrapped[cacheKey] = aead
+	return aead, nil
+}
+
+// signingPayload 按照顺序拼接Key、Value与所有header，每一部分之前加上4字节的长度，避免不同的消息得到相同的内容
+func signingPayload(m *mq.Message) []byte {
+	size := 8 + len(m.Key) + len(m.Value)
+	for _, f := range m.Header {
+		size += 8 + len(f.Key) + len(f.Value)
+	}
+	buf := make([]byte, 0, size)
+	appendField := func(data []byte) {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+		buf = append(buf, data...)
+	}
+	appendField(m.Key)
+	appendField(m.Value)
+	for _, f := range m.Header {
+		appendField([]byte(f.Key))
+		appendField(f.Value)
+	}
+	return buf
+}
@@ -0,0 +1,265 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingProvider 记录加密数据密钥的次数
+type countingProvider struct {
+	*LocalKeyProvider
+	wraps atomic.Int64
+}
+
+func (p *countingProvider) WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	p.wraps.Add(1)
+	return p.LocalKeyProvider.WrapKey(ctx, keyID, dataKey)
+}
+
+func newProvider(t *testing.T) *countingProvider {
+	p, err := NewLocalKeyProvider("k1", bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	require.NoError(t, p.AddKey("k2", bytes.Repeat([]byte{2}, 32)))
+	return &countingProvider{LocalKeyProvider: p}
+}
+
+// roundTrip 加密msg之后经过tamper修改，再交给消费者一侧解密
+func roundTrip(producer, consumer *Interceptor, msg *mq.Message, tamper func(m *mq.Message)) (*mq.Message, error) {
+	var sent *mq.Message
+	produce := producer.InterceptProduce(mq.ProducerInfo{}, func(_ context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+		sent = m
+		return &mq.ProducerResult{}, nil
+	})
+	if _, err := produce(context.Background(), msg); err != nil {
+		return nil, err
+	}
+	if tamper != nil {
+		tamper(sent)
+	}
+	consume := consumer.InterceptConsume(mq.ConsumerInfo{}, func(_ context.Context) (*mq.Message, error) {
+		return sent, nil
+	})
+	return consume(context.Background())
+}
+
+func TestInterceptor(t *testing.T) {
+	t.Parallel()
+	provider := newProvider(t)
+	signer := NewHMACSigner([]byte("secret"))
+	testCases := []struct {
+		name      string
+		producer  *Interceptor
+		consumer  *Interceptor
+		tamper    func(m *mq.Message)
+		wantValue []byte
+		wantErr   error
+	}{
+		{
+			name:      "加密",
+			producer:  NewInterceptor(provider),
+			consumer:  NewInterceptor(provider),
+			wantValue: []byte("secret data"),
+		},
+		{
+			name:      "加密并签名",
+			producer:  NewInterceptor(provider, WithSigner(signer)),
+			consumer:  NewInterceptor(provider, WithSigner(signer)),
+			wantValue: []byte("secret data"),
+		},
+		{
+			name:     "没有签名修改Value",
+			producer: NewInterceptor(provider),
+			consumer: NewInterceptor(provider),
+			tamper:   func(m *mq.Message) { m.Value[0] ^= 1 },
+			wantErr:  ErrDecryptFailed,
+		},
+		{
+			name:     "修改Value",
+			producer: NewInterceptor(provider, WithSigner(signer)),
+			consumer: NewInterceptor(provider, WithSigner(signer)),
+			tamper:   func(m *mq.Message) { m.Value[0] ^= 1 },
+			wantErr:  ErrInvalidSignature,
+		},
+		{
+			name:     "修改Key",
+			producer: NewInterceptor(provider, WithSigner(signer)),
+			consumer: NewInterceptor(provider, WithSigner(signer)),
+			tamper:   func(m *mq.Message) { m.Key = []byte("other") },
+			wantErr:  ErrInvalidSignature,
+		},
+		{
+			name:     "修改header",
+			producer: NewInterceptor(provider, WithSigner(signer)),
+			consumer: NewInterceptor(provider, WithSigner(signer)),
+			tamper:   func(m *mq.Message) { m.Header.Set("tenant", "other") },
+			wantErr:  ErrInvalidSignature,
+		},
+		{
+			name:     "缺少签名",
+			producer: NewInterceptor(provider),
+			consumer: NewInterceptor(provider, WithSigner(signer)),
+			wantErr:  ErrMissingSignature,
+		},
+		{
+			name:     "签名密钥不同",
+			producer: NewInterceptor(provider, WithSigner(NewHMACSigner([]byte("other")))),
+			consumer: NewInterceptor(provider, WithSigner(signer)),
+			wantErr:  ErrInvalidSignature,
+		},
+		{
+			name:     "没有加密",
+			producer: NewInterceptor(provider),
+			consumer: NewInterceptor(provider),
+			tamper: func(m *mq.Message) {
+				m.Value = []byte("secret data")
+				m.Header.Del(HeaderKeyID)
+			},
+			wantErr: ErrNotEncrypted,
+		},
+		{
+			name:     "允许没有加密",
+			producer: NewInterceptor(provider),
+			consumer: NewInterceptor(provider, WithAllowPlaintext()),
+			tamper: func(m *mq.Message) {
+				m.Value = []byte("secret data")
+				m.Header = mq.Header{{Key: "tenant", Value: []byte("a")}}
+			},
+			wantValue: []byte("secret data"),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			msg := &mq.Message{
+				Key:    []byte("k"),
+				Value:  []byte("secret data"),
+				Header: mq.Header{{Key: "tenant", Value: []byte("a")}},
+			}
+			got, err := roundTrip(tc.producer, tc.consumer, msg, tc.tamper)
+			// 不修改调用者的消息
+			assert.Equal(t, []byte("secret data"), msg.Value)
+			assert.Equal(t, mq.Header{{Key: "tenant", Value: []byte("a")}}, msg.Header)
+			assert.ErrorIs(t, err, tc.wantErr)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tc.wantValue, got.Value)
+			assert.Equal(t, mq.Header{{Key: "tenant", Value: []byte("a")}}, got.Header)
+		})
+	}
+}
+
+func TestInterceptor_DataKey(t *testing.T) {
+	t.Parallel()
+	provider := newProvider(t)
+	var sent []*mq.Message
+	produce := NewInterceptor(provider, WithDataKeyLifetime(time.Hour)).InterceptProduce(mq.ProducerInfo{},
+		func(_ context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+			sent = append(sent, m)
+			return &mq.ProducerResult{}, nil
+		})
+	for i := 0; i < 3; i++ {
+		_, err := produce(context.Background(), &mq.Message{Value: []byte("data")})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(1), provider.wraps.Load())
+	assert.Equal(t, sent[0].Header.GetBytes(HeaderWrappedKey), sent[2].Header.GetBytes(HeaderWrappedKey))
+	assert.NotEqual(t, sent[0].Header.GetBytes(HeaderNonce), sent[1].Header.GetBytes(HeaderNonce))
+
+	// 轮换主密钥之后立刻使用新的数据密钥
+	require.NoError(t, provider.Rotate("k2"))
+	_, err := produce(context.Background(), &mq.Message{Value: []byte("data")})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), provider.wraps.Load())
+	assert.Equal(t, "k2", sent[3].Header.Get(HeaderKeyID))
+
+	// 轮换之前发送的消息依旧可以解密
+	consumer := NewInterceptor(provider)
+	for _, m := range sent {
+		m := m
+		got, err := consumer.InterceptConsume(mq.ConsumerInfo{}, func(_ context.Context) (*mq.Message, error) {
+			return m, nil
+		})(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []byte("data"), got.Value)
+	}
+}
+
+func TestInterceptor_Memory(t *testing.T) {
+	t.Parallel()
+	provider := newProvider(t)
+	interceptor := NewInterceptor(provider, WithSigner(NewHMACSigner([]byte("secret"))))
+	// 不使用拦截器的消费者只能看到密文
+	testmq := memory.NewMQ(
+		memory.WithProducerInterceptors(interceptor))
+	defer func() {
+		_ = testmq.Close()
+	}()
+	const topic = "encryption"
+	require.NoError(t, testmq.CreateTopic(context.Background(), topic, 1))
+	c, err := testmq.Consumer(topic, "g1")
+	require.NoError(t, err)
+	p, err := testmq.Producer(topic)
+	require.NoError(t, err)
+	_, err = p.Produce(context.Background(), &mq.Message{Value: []byte("secret data")})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	decrypted := mq.InterceptConsumer(c, mq.ConsumerInfo{Topic: topic, GroupID: "g1"}, interceptor)
+	msg, err := decrypted.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret data"), msg.Value)
+	assert.Empty(t, msg.Header)
+}
+
+func TestInterceptor_ConsumerGroups(t *testing.T) {
+	t.Parallel()
+	provider := newProvider(t)
+	interceptor := NewInterceptor(provider, WithSigner(NewHMACSigner([]byte("secret"))))
+	var sent *mq.Message
+	produce := interceptor.InterceptProduce(mq.ProducerInfo{Topic: "t"}, func(_ context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+		sent = m
+		return &mq.ProducerResult{}, nil
+	})
+	_, err := produce(context.Background(), &mq.Message{Value: []byte("secret-pii")})
+	require.NoError(t, err)
+	ciphertext := bytes.Clone(sent.Value)
+	header := sent.Header.Clone()
+
+	// 多个消费组拿到同一条消息时，每个消费组都可以校验并解密
+	for _, group := range []string{"g1", "g2"} {
+		msg, err := interceptor.InterceptConsume(mq.ConsumerInfo{Topic: "t", GroupID: group}, func(_ context.Context) (*mq.Message, error) {
+			return sent, nil
+		})(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []byte("secret-pii"), msg.Value)
+		assert.Empty(t, msg.Header)
+	}
+	// 原消息依旧是密文
+	assert.Equal(t, ciphertext, sent.Value)
+	assert.Equal(t, header, sent.Header)
+}
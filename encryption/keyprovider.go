@@ -0,0 +1,150 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package encryption 使用信封加密保护 mq.Message.Value，并可以对消息签名，
+// 消费时拒绝被篡改的消息
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrUnknownKey    = errors.New("主密钥不存在")
+	ErrInvalidKeyLen = errors.New("密钥长度必须为16、24或者32字节")
+)
+
+// KeyProvider 管理加密数据密钥的主密钥，可以对接KMS等外部服务，实现需要可以被并发调用。
+// 轮换密钥之后 CurrentKeyID 返回新的ID，旧的主密钥需要继续保留，用于解密轮换之前发送的消息
+type KeyProvider interface {
+	// CurrentKeyID 返回加密新消息时使用的主密钥ID
+	CurrentKeyID(ctx context.Context) (string, error)
+	// WrapKey 使用keyID对应的主密钥加密数据密钥
+	WrapKey(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	// UnwrapKey 使用keyID对应的主密钥解密数据密钥
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+var _ KeyProvider = &LocalKeyProvider{}
+
+// LocalKeyProvider 把主密钥保存在内存中，使用AES-GCM加密数据密钥
+type LocalKeyProvider struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewLocalKeyProvider 创建使用keyID对应的key作为当前主密钥的 LocalKeyProvider
+func NewLocalKeyProvider(keyID string, key []byte) (*LocalKeyProvider, error) {
+	p := &LocalKeyProvider{keys: map[string]cipher.AEAD{}}
+	if err := p.AddKey(keyID, key); err != nil {
+		return nil, err
+	}
+	p.current = keyID
+	return p, nil
+}
+
+// AddKey 添加一个主密钥，不改变当前使用的主密钥
+func (p *LocalKeyProvider) AddKey(keyID string, key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[keyID] = aead
+	return nil
+}
+
+// Rotate 把当前主密钥切换为已经添加的keyID，之前的主密钥仍然可以解密
+func (p *LocalKeyProvider) Rotate(keyID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.keys[keyID]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	p.current = keyID
+	return nil
+}
+
+func (p *LocalKeyProvider) CurrentKeyID(_ context.Context) (string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.current, nil
+}
+
+func (p *LocalKeyProvider) WrapKey(_ context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	aead, err := p.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return seal(aead, dataKey, []byte(keyID))
+}
+
+func (p *LocalKeyProvider) UnwrapKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, err := p.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, wrapped, []byte(keyID))
+}
+
+func (p *LocalKeyProvider) key(keyID string) (cipher.AEAD, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	return aead, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, ErrInvalidKeyLen
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal 加密data，随机生成的nonce放在密文之前
+func seal(aead cipher.AEAD, data, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, additional), nil
+}
+
+func open(aead cipher.AEAD, data, additional []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, ErrDecryptFailed
+	}
+	res, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return res, nil
+}
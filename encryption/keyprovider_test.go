@@ -0,0 +1,59 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalKeyProvider(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	_, err := NewLocalKeyProvider("k1", []byte("short"))
+	assert.ErrorIs(t, err, ErrInvalidKeyLen)
+
+	p, err := NewLocalKeyProvider("k1", bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	dataKey := bytes.Repeat([]byte{9}, 32)
+	wrapped, err := p.WrapKey(ctx, "k1", dataKey)
+	require.NoError(t, err)
+	assert.NotContains(t, string(wrapped), string(dataKey))
+
+	require.NoError(t, p.AddKey("k2", bytes.Repeat([]byte{2}, 16)))
+	id, err := p.CurrentKeyID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "k1", id)
+	assert.ErrorIs(t, p.Rotate("k3"), ErrUnknownKey)
+	require.NoError(t, p.Rotate("k2"))
+	id, err = p.CurrentKeyID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "k2", id)
+
+	// 轮换之后旧的主密钥依旧可以解密
+	unwrapped, err := p.UnwrapKey(ctx, "k1", wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+	_, err = p.UnwrapKey(ctx, "k2", wrapped)
+	assert.ErrorIs(t, err, ErrDecryptFailed)
+	_, err = p.UnwrapKey(ctx, "k3", wrapped)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	_, err = p.UnwrapKey(ctx, "k1", wrapped[:4])
+	assert.ErrorIs(t, err, ErrDecryptFailed)
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+var (
+	ErrInvalidSignature = errors.New("消息签名校验失败")
+	ErrCannotSign       = errors.New("只有公钥，无法签名")
+)
+
+// Signer 对消息签名并校验签名，实现需要可以被并发调用
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+	// Verify 签名不匹配时返回 ErrInvalidSignature
+	Verify(data, signature []byte) error
+}
+
+var (
+	_ Signer = hmacSigner{}
+	_ Signer = ed25519Signer{}
+)
+
+// NewHMACSigner 使用HMAC-SHA256签名，生产者与消费者共享同一个key
+func NewHMACSigner(key []byte) Signer {
+	return hmacSigner{key: key}
+}
+
+type hmacSigner struct {
+	key []byte
+}
+
+func (s hmacSigner) Sign(data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+func (s hmacSigner) Verify(data, signature []byte) error {
+	expected, _ := s.Sign(data)
+	if !hmac.Equal(expected, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// NewEd25519Signer 使用私钥签名，生产者使用
+func NewEd25519Signer(privateKey ed25519.PrivateKey) Signer {
+	return ed25519Signer{privateKey: privateKey, publicKey: privateKey.Public().(ed25519.PublicKey)}
+}
+
+// NewEd25519Verifier 只使用公钥校验签名，消费者使用，调用 Sign 会返回 ErrCannotSign
+func NewEd25519Verifier(publicKey ed25519.PublicKey) Signer {
+	return ed25519Signer{publicKey: publicKey}
+}
+
+type ed25519Signer struct {
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+func (s ed25519Signer) Sign(data []byte) ([]byte, error) {
+	if s.privateKey == nil {
+		return nil, ErrCannotSign
+	}
+	return ed25519.Sign(s.privateKey, data), nil
+}
+
+func (s ed25519Signer) Verify(data, signature []byte) error {
+	if !ed25519.Verify(s.publicKey, data, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
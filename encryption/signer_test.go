@@ -0,0 +1,56 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigner(t *testing.T) {
+	t.Parallel()
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	testCases := []struct {
+		name     string
+		signer   Signer
+		verifier Signer
+	}{
+		{name: "hmac", signer: NewHMACSigner([]byte("secret")), verifier: NewHMACSigner([]byte("secret"))},
+		{name: "ed25519", signer: NewEd25519Signer(privateKey), verifier: NewEd25519Verifier(publicKey)},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			signature, err := tc.signer.Sign([]byte("data"))
+			require.NoError(t, err)
+			assert.NoError(t, tc.verifier.Verify([]byte("data"), signature))
+			assert.NoError(t, tc.signer.Verify([]byte("data"), signature))
+			assert.ErrorIs(t, tc.verifier.Verify([]byte("date"), signature), ErrInvalidSignature)
+			signature[0] ^= 1
+			assert.ErrorIs(t, tc.verifier.Verify([]byte("data"), signature), ErrInvalidSignature)
+		})
+	}
+
+	_, err = NewEd25519Verifier(publicKey).Sign([]byte("data"))
+	assert.ErrorIs(t, err, ErrCannotSign)
+	signature, err := NewHMACSigner([]byte("other")).Sign([]byte("data"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, NewHMACSigner([]byte("secret")).Verify([]byte("data"), signature), ErrInvalidSignature)
+}
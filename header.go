@@ -0,0 +1,143 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import "sort"
+
+// HeaderField 是 Header 中的一个键值对，Value 为原始字节，可以存放二进制数据
+type HeaderField struct {
+	Key   string
+	Value []byte
+}
+
+// Header 对标kafka的header，按照写入顺序保存键值对，同一个键允许出现多次
+type Header []HeaderField
+
+// HeaderFromMap 将旧的map形式的header转换为 Header，键按照字典序排列
+func HeaderFromMap(m map[string]string) Header {
+	if m == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := make(Header, 0, len(keys))
+	for _, k := range keys {
+		h = append(h, HeaderField{Key: k, Value: []byte(m[k])})
+	}
+	return h
+}
+
+// Get 返回key对应的第一个值，不存在时返回空字符串
+func (h Header) Get(key string) string {
+	return string(h.GetBytes(key))
+}
+
+// GetBytes 返回key对应的第一个值的原始字节，不存在时返回nil
+func (h Header) GetBytes(key string) []byte {
+	for _, f := range h {
+		if f.Key == key {
+			return f.Value
+		}
+	}
+	return nil
+}
+
+// Values 按照写入顺序返回key对应的所有值
+func (h Header) Values(key string) []string {
+	var res []string
+	for _, f := range h {
+		if f.Key == key {
+			res = append(res, string(f.Value))
+		}
+	}
+	return res
+}
+
+// Add 在末尾追加一个键值对，不会覆盖已有的同名键
+func (h *Header) Add(key, value string) {
+	h.AddBytes(key, []byte(value))
+}
+
+// AddBytes 同 Add，value为原始字节
+func (h *Header) AddBytes(key string, value []byte) {
+	*h = append(*h, HeaderField{Key: key, Value: value})
+}
+
+// Set 将key的值设置为value，保留第一次出现的位置并删除其余同名键，key不存在时追加到末尾
+func (h *Header) Set(key, value string) {
+	h.SetBytes(key, []byte(value))
+}
+
+// SetBytes 同 Set，value为原始字节。
+// 结果写入新的切片，不会修改与h共享底层数组的浅拷贝，例如 m := *msg 得到的 m.Header
+func (h *Header) SetBytes(key string, value []byte) {
+	res := make(Header, 0, len(*h)+1)
+	found := false
+	for _, f := range *h {
+		if f.Key != key {
+			res = append(res, f)
+			continue
+		}
+		if !found {
+			res = append(res, HeaderField{Key: key, Value: value})
+			found = true
+		}
+	}
+	if !found {
+		res = append(res, HeaderField{Key: key, Value: value})
+	}
+	*h = res
+}
+
+// Del 删除key对应的所有值，与 SetBytes 相同不会修改浅拷贝
+func (h *Header) Del(key string) {
+	if len(*h) == 0 {
+		return
+	}
+	res := make(Header, 0, len(*h))
+	for _, f := range *h {
+		if f.Key != key {
+			res = append(res, f)
+		}
+	}
+	*h = res
+}
+
+// Map 将 Header 转换为旧的map形式，同名键以最后一个值为准
+func (h Header) Map() map[string]string {
+	if h == nil {
+		return nil
+	}
+	res := make(map[string]string, len(h))
+	for _, f := range h {
+		res[f.Key] = string(f.Value)
+	}
+	return res
+}
+
+// Clone 返回 Header 的深拷贝
+func (h Header) Clone() Header {
+	if h == nil {
+		return nil
+	}
+	res := make(Header, 0, len(h))
+	for _, f := range h {
+		res = append(res, HeaderField{Key: f.Key, Value: append([]byte(nil), f.Value...)})
+	}
+	return res
+}
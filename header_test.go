@@ -0,0 +1,90 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeader(t *testing.T) {
+	t.Parallel()
+	var h Header
+	h.Add("trace", "a")
+	h.Add("span", "b")
+	h.AddBytes("trace", []byte{0x00, 0xff})
+	assert.Equal(t, "a", h.Get("trace"))
+	assert.Equal(t, []string{"a", string([]byte{0x00, 0xff})}, h.Values("trace"))
+	assert.Equal(t, []byte{0x00, 0xff}, h[2].Value)
+	assert.Equal(t, "", h.Get("unknown"))
+	assert.Nil(t, h.GetBytes("unknown"))
+	assert.Nil(t, h.Values("unknown"))
+
+	h.Set("trace", "c")
+	assert.Equal(t, Header{
+		{Key: "trace", Value: []byte("c")},
+		{Key: "span", Value: []byte("b")},
+	}, h)
+	// key不存在时追加到末尾
+	h.Set("new", "d")
+	assert.Equal(t, HeaderField{Key: "new", Value: []byte("d")}, h[2])
+	h.Del("span")
+	assert.Equal(t, Header{
+		{Key: "trace", Value: []byte("c")},
+		{Key: "new", Value: []byte("d")},
+	}, h)
+}
+
+func TestHeader_Map(t *testing.T) {
+	t.Parallel()
+	assert.Nil(t, HeaderFromMap(nil))
+	assert.Nil(t, Header(nil).Map())
+
+	h := HeaderFromMap(map[string]string{"b": "2", "a": "1"})
+	assert.Equal(t, Header{
+		{Key: "a", Value: []byte("1")},
+		{Key: "b", Value: []byte("2")},
+	}, h)
+	h.Add("a", "3")
+	assert.Equal(t, map[string]string{"a": "3", "b": "2"}, h.Map())
+}
+
+func TestHeader_Clone(t *testing.T) {
+	t.Parallel()
+	assert.Nil(t, Header(nil).Clone())
+	h := Header{{Key: "a", Value: []byte("1")}}
+	c := h.Clone()
+	c[0].Value[0] = '2'
+	c.Add("b", "3")
+	assert.Equal(t, Header{{Key: "a", Value: []byte("1")}}, h)
+}
+
+func TestHeader_ShallowCopy(t *testing.T) {
+	t.Parallel()
+	msg := &Message{Header: Header{{Key: "a", Value: []byte("1")}, {Key: "b", Value: []byte("2")}, {Key: "a", Value: []byte("3")}}}
+	want := msg.Header.Clone()
+
+	cp := *msg
+	cp.Header.Del("a")
+	assert.Equal(t, Header{{Key: "b", Value: []byte("2")}}, cp.Header)
+	assert.Equal(t, want, msg.Header)
+
+	cp = *msg
+	cp.Header.Set("b", "4")
+	cp.Header.Set("a", "5")
+	assert.Equal(t, Header{{Key: "a", Value: []byte("5")}, {Key: "b", Value: []byte("4")}}, cp.Header)
+	assert.Equal(t, want, msg.Header)
+}
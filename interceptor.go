@@ -0,0 +1,232 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/mq-api/clock"
+)
+
+// ProduceFunc 发送一条消息。Produce、ProduceWithPartition 与 ProduceAt 都会被包装成 ProduceFunc 交给拦截器
+type ProduceFunc func(ctx context.Context, m *Message) (*ProducerResult, error)
+
+// ConsumeFunc 获取一条消息。Consume 与 ConsumeChan 都会被包装成 ConsumeFunc 交给拦截器
+type ConsumeFunc func(ctx context.Context) (*Message, error)
+
+// ProducerInfo 描述被拦截的生产者
+type ProducerInfo struct {
+	// 生产者发送消息的topic
+	Topic string
+}
+
+// ConsumerInfo 描述被拦截的消费者
+type ConsumerInfo struct {
+	// 消费者订阅的topic
+	Topic string
+	// 消费者所属的消费组
+	GroupID string
+}
+
+// ProducerInterceptor 包装发送消息的过程，可以在调用next之前修改消息、注入header或者返回error阻止发送，
+// 也可以在next返回之后记录结果
+type ProducerInterceptor interface {
+	InterceptProduce(info ProducerInfo, next ProduceFunc) ProduceFunc
+}
+
+// ConsumerInterceptor 包装获取消息的过程，可以在next返回之后替换消息或者返回error。
+// next返回的消息应当视为只读，实现不保证它不与其他消费者共享，需要修改时先复制消息与 Header，再返回修改后的副本
+type ConsumerInterceptor interface {
+	InterceptConsume(info ConsumerInfo, next ConsumeFunc) ConsumeFunc
+}
+
+// ProducerInterceptorFunc 让普通的函数实现 ProducerInterceptor
+type ProducerInterceptorFunc func(info ProducerInfo, next ProduceFunc) ProduceFunc
+
+func (f ProducerInterceptorFunc) InterceptProduce(info ProducerInfo, next ProduceFunc) ProduceFunc {
+	return f(info, next)
+}
+
+// ConsumerInterceptorFunc 让普通的函数实现 ConsumerInterceptor
+type ConsumerInterceptorFunc func(info ConsumerInfo, next ConsumeFunc) ConsumeFunc
+
+func (f ConsumerInterceptorFunc) InterceptConsume(info ConsumerInfo, next ConsumeFunc) ConsumeFunc {
+	return f(info, next)
+}
+
+// InterceptProducer 供MQ的实现使用，按照顺序为p套上拦截器，第一个拦截器在最外层。没有拦截器时直接返回p
+func InterceptProducer(p Producer, info ProducerInfo, interceptors ...ProducerInterceptor) Producer {
+	if len(interceptors) == 0 {
+		return p
+	}
+	return &interceptedProducer{Producer: p, info: info, interceptors: interceptors}
+}
+
+type interceptedProducer struct {
+	Producer
+	info         ProducerInfo
+	interceptors []ProducerInterceptor
+}
+
+func (p *interceptedProducer) chain(base ProduceFunc) ProduceFunc {
+	for i := len(p.interceptors) - 1; i >= 0; i-- {
+		base = p.interceptors[i].InterceptProduce(p.info, base)
+	}
+	return base
+}
+
+func (p *interceptedProducer) Produce(ctx context.Context, m *Message) (*ProducerResult, error) {
+	return p.chain(p.Producer.Produce)(ctx, m)
+}
+
+func (p *interceptedProducer) ProduceWithPartition(ctx context.Context, m *Message, partition int) (*ProducerResult, error) {
+	return p.chain(func(ctx context.Context, m *Message) (*ProducerResult, error) {
+		return p.Producer.ProduceWithPartition(ctx, m, partition)
+	})(ctx, m)
+}
+
+func (p *interceptedProducer) ProduceAt(ctx context.Context, m *Message, at time.Time) (*ProducerResult, error) {
+	return p.chain(func(ctx context.Context, m *Message) (*ProducerResult, error) {
+		return p.Producer.ProduceAt(ctx, m, at)
+	})(ctx, m)
+}
+
+func (p *interceptedProducer) Clock() clock.Clock {
+	return producerClock(p.Producer)
+}
+
+// InterceptTxnProducer 与 InterceptProducer 相同，事务相关的方法直接调用p
+func InterceptTxnProducer(p TxnProducer, info ProducerInfo, interceptors ...ProducerInterceptor) TxnProducer {
+	if len(interceptors) == 0 {
+		return p
+	}
+	return &interceptedTxnProducer{
+		TxnProducer: p,
+		producer:    &interceptedProducer{Producer: p, info: info, interceptors: interceptors},
+	}
+}
+
+type interceptedTxnProducer struct {
+	TxnProducer
+	producer Producer
+}
+
+func (p *interceptedTxnProducer) Produce(ctx context.Context, m *Message) (*ProducerResult, error) {
+	return p.producer.Produce(ctx, m)
+}
+
+func (p *interceptedTxnProducer) ProduceWithPartition(ctx context.Context, m *Message, partition int) (*ProducerResult, error) {
+	return p.producer.ProduceWithPartition(ctx, m, partition)
+}
+
+func (p *interceptedTxnProducer) ProduceAt(ctx context.Context, m *Message, at time.Time) (*ProducerResult, error) {
+	return p.producer.ProduceAt(ctx, m, at)
+}
+
+func (p *interceptedTxnProducer) Clock() clock.Clock {
+	return producerClock(p.TxnProducer)
+}
+
+// InterceptConsumer 供MQ的实现使用，按照顺序为c套上拦截器，第一个拦截器在最外层。没有拦截器时直接返回c。
+// 通过 ConsumeChan 获取消息时，拦截器返回error的消息会被丢弃并记录日志，
+// 第一次调用 ConsumeChan 传入的ctx结束或者消费者关闭之后，返回的channel会被关闭
+func InterceptConsumer(c Consumer, info ConsumerInfo, interceptors ...ConsumerInterceptor) Consumer {
+	if len(interceptors) == 0 {
+		return c
+	}
+	return &interceptedConsumer{Consumer: c, info: info, interceptors: interceptors, done: make(chan struct{})}
+}
+
+type interceptedConsumer struct {
+	Consumer
+	info         ConsumerInfo
+	interceptors []ConsumerInterceptor
+
+	chanOnce sync.Once
+	msgCh    chan *Message
+	// Close 时关闭，通知转发 ConsumeChan 的协程退出
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (c *interceptedConsumer) chain(base ConsumeFunc) ConsumeFunc {
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		base = c.interceptors[i].InterceptConsume(c.info, base)
+	}
+	return base
+}
+
+func (c *interceptedConsumer) Consume(ctx context.Context) (*Message, error) {
+	return c.chain(c.Consumer.Consume)(ctx)
+}
+
+func (c *interceptedConsumer) ConsumeChan(ctx context.Context) (<-chan *Message, error) {
+	src, err := c.Consumer.ConsumeChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.chanOnce.Do(func() {
+		c.msgCh = make(chan *Message, cap(src))
+		go c.forward(ctx, src)
+	})
+	return c.msgCh, nil
+}
+
+func (c *interceptedConsumer) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	return c.Consumer.Close()
+}
+
+// forward 把src中的消息经过拦截器之后转发到msgCh，src关闭、ctx结束或者消费者关闭时关闭msgCh
+func (c *interceptedConsumer) forward(ctx context.Context, src <-chan *Message) {
+	defer close(c.msgCh)
+	errClosed := errors.New("channel已经关闭")
+	consume := c.chain(func(ctx context.Context) (*Message, error) {
+		select {
+		case m, ok := <-src:
+			if !ok {
+				return nil, errClosed
+			}
+			return m, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.done:
+			return nil, errClosed
+		}
+	})
+	for {
+		m, err := consume(ctx)
+		if errors.Is(err, errClosed) || ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			Logger().Error("拦截器处理消息失败，消息被丢弃", slog.String("error", err.Error()))
+			continue
+		}
+		select {
+		case c.msgCh <- m:
+		case <-ctx.Done():
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
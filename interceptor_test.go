@@ -0,0 +1,268 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProducer struct {
+	Producer
+	sent []string
+}
+
+func (p *fakeProducer) Produce(_ context.Context, m *Message) (*ProducerResult, error) {
+	p.sent = append(p.sent, "produce:"+m.Header.Get("trace"))
+	return &ProducerResult{}, nil
+}
+
+func (p *fakeProducer) ProduceWithPartition(_ context.Context, m *Message, partition int) (*ProducerResult, error) {
+	p.sent = append(p.sent, "partition:"+m.Header.Get("trace"))
+	return &ProducerResult{}, nil
+}
+
+func (p *fakeProducer) ProduceAt(_ context.Context, m *Message, _ time.Time) (*ProducerResult, error) {
+	p.sent = append(p.sent, "at:"+m.Header.Get("trace"))
+	return &ProducerResult{}, nil
+}
+
+type fakeConsumer struct {
+	Consumer
+	msgCh chan *Message
+}
+
+func (c *fakeConsumer) Consume(ctx context.Context) (*Message, error) {
+	return <-c.msgCh, nil
+}
+
+func (c *fakeConsumer) ConsumeChan(ctx context.Context) (<-chan *Message, error) {
+	return c.msgCh, nil
+}
+
+func (c *fakeConsumer) Close() error {
+	return nil
+}
+
+func TestInterceptProducer(t *testing.T) {
+	t.Parallel()
+	var order []string
+	newInterceptor := func(name string) ProducerInterceptor {
+		return ProducerInterceptorFunc(func(_ ProducerInfo, next ProduceFunc) ProduceFunc {
+			return func(ctx context.Context, m *Message) (*ProducerResult, error) {
+				order = append(order, name)
+				m.Header.Add("trace", name)
+				return next(ctx, m)
+			}
+		})
+	}
+	errInvalid := errors.New("非法消息")
+	validator := ProducerInterceptorFunc(func(_ ProducerInfo, next ProduceFunc) ProduceFunc {
+		return func(ctx context.Context, m *Message) (*ProducerResult, error) {
+			if len(m.Value) == 0 {
+				return nil, errInvalid
+			}
+			return next(ctx, m)
+		}
+	})
+
+	fake := &fakeProducer{}
+	assert.Equal(t, Producer(fake), InterceptProducer(fake, ProducerInfo{}))
+	p := InterceptProducer(fake, ProducerInfo{Topic: "t"}, newInterceptor("a"), newInterceptor("b"), validator)
+
+	_, err := p.Produce(context.Background(), &Message{Value: []byte("1")})
+	require.NoError(t, err)
+	_, err = p.ProduceWithPartition(context.Background(), &Message{Value: []byte("1")}, 1)
+	require.NoError(t, err)
+	_, err = p.ProduceAt(context.Background(), &Message{Value: []byte("1")}, time.Now())
+	require.NoError(t, err)
+	_, err = p.Produce(context.Background(), &Message{})
+	assert.ErrorIs(t, err, errInvalid)
+
+	assert.Equal(t, []string{"a", "b", "a", "b", "a", "b", "a", "b"}, order)
+	assert.Equal(t, []string{"produce:a", "partition:a", "at:a"}, fake.sent)
+}
+
+type fakeClockProducer struct {
+	*fakeProducer
+	clk clock.Clock
+	at  time.Time
+}
+
+func (p *fakeClockProducer) ProduceAt(_ context.Context, _ *Message, at time.Time) (*ProducerResult, error) {
+	p.at = at
+	return &ProducerResult{}, nil
+}
+
+func (p *fakeClockProducer) Clock() clock.Clock {
+	return p.clk
+}
+
+func TestProduceWithDelay(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := &fakeClockProducer{fakeProducer: &fakeProducer{}, clk: clock.NewMock(start)}
+	noop := ProducerInterceptorFunc(func(_ ProducerInfo, next ProduceFunc) ProduceFunc {
+		return next
+	})
+	// 拦截器包装之后依旧使用被包装的生产者的时钟
+	p := InterceptProducer(fake, ProducerInfo{}, noop)
+	_, err := ProduceWithDelay(context.Background(), p, &Message{}, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(time.Minute), fake.at)
+}
+
+func TestInterceptConsumer(t *testing.T) {
+	t.Parallel()
+	errDrop := errors.New("丢弃")
+	upper := ConsumerInterceptorFunc(func(_ ConsumerInfo, next ConsumeFunc) ConsumeFunc {
+		return func(ctx context.Context) (*Message, error) {
+			m, err := next(ctx)
+			if err != nil {
+				return nil, err
+			}
+			m.Value = append(m.Value, '!')
+			return m, nil
+		}
+	})
+	dropEmpty := ConsumerInterceptorFunc(func(_ ConsumerInfo, next ConsumeFunc) ConsumeFunc {
+		return func(ctx context.Context) (*Message, error) {
+			m, err := next(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if len(m.Value) == 0 {
+				return nil, errDrop
+			}
+			return m, nil
+		}
+	})
+
+	fake := &fakeConsumer{msgCh: make(chan *Message, 10)}
+	assert.Equal(t, Consumer(fake), InterceptConsumer(fake, ConsumerInfo{}))
+	c := InterceptConsumer(fake, ConsumerInfo{Topic: "t", GroupID: "g"}, upper, dropEmpty)
+
+	fake.msgCh <- &Message{Value: []byte("a")}
+	m, err := c.Consume(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "a!", string(m.Value))
+	fake.msgCh <- &Message{}
+	_, err = c.Consume(context.Background())
+	assert.ErrorIs(t, err, errDrop)
+
+	ch, err := c.ConsumeChan(context.Background())
+	require.NoError(t, err)
+	fake.msgCh <- &Message{}
+	fake.msgCh <- &Message{Value: []byte("b")}
+	close(fake.msgCh)
+	var values []string
+	for m := range ch {
+		values = append(values, string(m.Value))
+	}
+	// 被拦截器拒绝的消息不会出现在channel中
+	assert.Equal(t, []string{"b!"}, values)
+}
+
+func TestInterceptConsumer_ConsumeChanStop(t *testing.T) {
+	t.Parallel()
+	noop := ConsumerInterceptorFunc(func(_ ConsumerInfo, next ConsumeFunc) ConsumeFunc {
+		return next
+	})
+	// drain 读取ch直到它被关闭
+	drain := func(ch <-chan *Message) bool {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case _, ok := <-ch:
+				if !ok {
+					return true
+				}
+			case <-timeout:
+				return false
+			}
+		}
+	}
+
+	// 使用者不再读取channel之后关闭消费者，转发协程不会阻塞在发送上
+	fake := &fakeConsumer{msgCh: make(chan *Message)}
+	c := InterceptConsumer(fake, ConsumerInfo{Topic: "t"}, noop)
+	ch, err := c.ConsumeChan(context.Background())
+	require.NoError(t, err)
+	fake.msgCh <- &Message{Value: []byte("a")}
+	require.NoError(t, c.Close())
+	assert.True(t, drain(ch))
+
+	// ctx结束之后channel被关闭
+	fake = &fakeConsumer{msgCh: make(chan *Message)}
+	c = InterceptConsumer(fake, ConsumerInfo{Topic: "t"}, noop)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err = c.ConsumeChan(ctx)
+	require.NoError(t, err)
+	fake.msgCh <- &Message{Value: []byte("a")}
+	cancel()
+	assert.True(t, drain(ch))
+}
+
+type fakeTxnProducer struct {
+	TxnProducer
+	*fakeProducer
+	began bool
+}
+
+func (p *fakeTxnProducer) Produce(ctx context.Context, m *Message) (*ProducerResult, error) {
+	return p.fakeProducer.Produce(ctx, m)
+}
+
+func (p *fakeTxnProducer) ProduceWithPartition(ctx context.Context, m *Message, partition int) (*ProducerResult, error) {
+	return p.fakeProducer.ProduceWithPartition(ctx, m, partition)
+}
+
+func (p *fakeTxnProducer) ProduceAt(ctx context.Context, m *Message, at time.Time) (*ProducerResult, error) {
+	return p.fakeProducer.ProduceAt(ctx, m, at)
+}
+
+func (p *fakeTxnProducer) BeginTxn(_ context.Context) error {
+	p.began = true
+	return nil
+}
+
+func TestInterceptTxnProducer(t *testing.T) {
+	t.Parallel()
+	trace := ProducerInterceptorFunc(func(_ ProducerInfo, next ProduceFunc) ProduceFunc {
+		return func(ctx context.Context, m *Message) (*ProducerResult, error) {
+			m.Header.Add("trace", "a")
+			return next(ctx, m)
+		}
+	})
+	fake := &fakeTxnProducer{fakeProducer: &fakeProducer{}}
+	assert.Equal(t, TxnProducer(fake), InterceptTxnProducer(fake, ProducerInfo{}))
+	p := InterceptTxnProducer(fake, ProducerInfo{Topic: "t"}, trace)
+
+	require.NoError(t, p.BeginTxn(context.Background()))
+	assert.True(t, fake.began)
+	_, err := p.Produce(context.Background(), &Message{})
+	require.NoError(t, err)
+	_, err = p.ProduceWithPartition(context.Background(), &Message{}, 1)
+	require.NoError(t, err)
+	_, err = p.ProduceAt(context.Background(), &Message{}, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"produce:a", "partition:a", "at:a"}, fake.sent)
+}
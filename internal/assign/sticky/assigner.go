@@ -0,0 +1,102 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sticky
+
+import "sort"
+
+// Assigner 粘性分配器，在保证各消费者分区数尽量均衡的前提下，尽可能保留消费者上一轮持有的分区，
+// 从而减少重平衡时分区在消费者之间的移动
+type Assigner struct{}
+
+// AssignPartition 没有上一轮分配结果时，等价于按照消费者顺序连续分配
+func (b *Assigner) AssignPartition(consumers []string, partitions int) map[string][]int {
+	return b.AssignPartitionWithPrevious(consumers, partitions, nil)
+}
+
+// AssignPartitionWithPrevious previous为上一轮的分配结果，键为消费者名称
+func (b *Assigner) AssignPartitionWithPrevious(consumers []string, partitions int, previous map[string][]int) map[string][]int {
+	result := make(map[string][]int, len(consumers))
+	for _, consumer := range consumers {
+		result[consumer] = make([]int, 0)
+	}
+	consumerCount := len(consumers)
+	if consumerCount == 0 {
+		return result
+	}
+	// 每个消费者至少分到base个分区，其中extra个消费者可以多分一个
+	base := partitions / consumerCount
+	extra := partitions % consumerCount
+	owned := make(map[int]bool, partitions)
+	keep := func(consumer string, partition int) bool {
+		if partition < 0 || partition >= partitions || owned[partition] {
+			return false
+		}
+		owned[partition] = true
+		result[consumer] = append(result[consumer], partition)
+		return true
+	}
+	// 第一轮：每个消费者最多保留base个原有分区
+	for _, consumer := range consumers {
+		for _, p := range previous[consumer] {
+			if len(result[consumer]) >= base {
+				break
+			}
+			keep(consumer, p)
+		}
+	}
+	// 第二轮：原本持有更多分区的消费者优先占用多出来的名额
+	for _, consumer := range consumers {
+		if extra == 0 {
+			break
+		}
+		if len(result[consumer]) != base {
+			continue
+		}
+		for _, p := range previous[consumer] {
+			if keep(consumer, p) {
+				extra--
+				break
+			}
+		}
+	}
+	// 第三轮：把没有归属的分区分配给还没有达到配额的消费者
+	remaining := make([]int, 0, partitions-len(owned))
+	for p := 0; p < partitions; p++ {
+		if !owned[p] {
+			remaining = append(remaining, p)
+		}
+	}
+	for _, consumer := range consumers {
+		quota := base
+		if len(result[consumer]) > base {
+			quota = base + 1
+		} else if extra > 0 {
+			quota = base + 1
+			extra--
+		}
+		for len(result[consumer]) < quota && len(remaining) > 0 {
+			keep(consumer, remaining[0])
+			remaining = remaining[1:]
+		}
+	}
+	for _, consumer := range consumers {
+		sort.Ints(result[consumer])
+	}
+	return result
+}
+
+func NewAssigner() *Assigner {
+	return &Assigner{}
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sticky
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBalancer_AssignPartitionWithPrevious(t *testing.T) {
+	t.Parallel()
+	balancer := NewAssigner()
+	testcases := []struct {
+		name       string
+		consumers  []string
+		partition  int
+		previous   map[string][]int
+		wantAnswer map[string][]int
+	}{
+		{
+			name:      "没有上一轮分配结果",
+			consumers: []string{"c1", "c2"},
+			partition: 5,
+			wantAnswer: map[string][]int{
+				"c1": {0, 1, 2},
+				"c2": {3, 4},
+			},
+		},
+		{
+			name:      "新消费者加入只拿走多余的分区",
+			consumers: []string{"c1", "c2", "c3"},
+			partition: 6,
+			previous: map[string][]int{
+				"c1": {0, 1, 2},
+				"c2": {3, 4, 5},
+			},
+			wantAnswer: map[string][]int{
+				"c1": {0, 1},
+				"c2": {3, 4},
+				"c3": {2, 5},
+			},
+		},
+		{
+			name:      "消费者离开只移动它持有的分区",
+			consumers: []string{"c1", "c3"},
+			partition: 6,
+			previous: map[string][]int{
+				"c1": {0, 1},
+				"c2": {3, 4},
+				"c3": {2, 5},
+			},
+			wantAnswer: map[string][]int{
+				"c1": {0, 1, 3},
+				"c3": {2, 4, 5},
+			},
+		},
+		{
+			name:      "多出来的名额优先给原本持有更多分区的消费者",
+			consumers: []string{"c1", "c2"},
+			partition: 3,
+			previous: map[string][]int{
+				"c1": {0},
+				"c2": {1, 2},
+			},
+			wantAnswer: map[string][]int{
+				"c1": {0},
+				"c2": {1, 2},
+			},
+		},
+		{
+			name:      "忽略已经不存在的分区",
+			consumers: []string{"c1", "c2"},
+			partition: 2,
+			previous: map[string][]int{
+				"c1": {0, 3},
+				"c2": {1, 2},
+			},
+			wantAnswer: map[string][]int{
+				"c1": {0},
+				"c2": {1},
+			},
+		},
+		{
+			name:       "没有consumer",
+			consumers:  []string{},
+			partition:  3,
+			wantAnswer: map[string][]int{},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			actualVal := balancer.AssignPartitionWithPrevious(tc.consumers, tc.partition, tc.previous)
+			assert.Equal(t, tc.wantAnswer, actualVal)
+		})
+	}
+}
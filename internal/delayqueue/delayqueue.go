@@ -0,0 +1,155 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package delayqueue
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
+)
+
+// DelayQueue 按照到期时间保存消息，到期后在后台协程中调用release。
+// 到期时间相同的消息按照加入的顺序释放
+type DelayQueue struct {
+	clock   clock.Clock
+	release func(msg *mq.Message)
+
+	mu    sync.Mutex
+	items items
+	seq   int64
+
+	wakeCh    chan struct{}
+	closeCh   chan struct{}
+	doneCh    chan struct{}
+	closeOnce sync.Once
+}
+
+func New(clk clock.Clock, release func(msg *mq.Message)) *DelayQueue {
+	q := &DelayQueue{
+		clock:   clk,
+		release: release,
+		wakeCh:  make(chan struct{}, 1),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	go q.loop()
+	return q
+}
+
+// Push 加入一条在due时刻到期的消息
+func (q *DelayQueue) Push(msg *mq.Message, due time.Time) {
+	q.mu.Lock()
+	q.seq++
+	heap.Push(&q.items, item{msg: msg, due: due, seq: q.seq})
+	q.mu.Unlock()
+	select {
+	case q.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// Len 返回还没有到期的消息数量
+func (q *DelayQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.items.Len()
+}
+
+// Close 停止后台协程，没有到期的消息会被丢弃
+func (q *DelayQueue) Close() {
+	q.closeOnce.Do(func() {
+		close(q.closeCh)
+	})
+	<-q.doneCh
+}
+
+func (q *DelayQueue) loop() {
+	defer close(q.doneCh)
+	for {
+		msgs, wait := q.popDue()
+		for _, msg := range msgs {
+			q.release(msg)
+		}
+		if len(msgs) > 0 {
+			continue
+		}
+		select {
+		case <-wait:
+		case <-q.wakeCh:
+		case <-q.closeCh:
+			return
+		}
+	}
+}
+
+// popDue 取出所有已经到期的消息，并返回等待下一条消息到期的channel，没有消息时返回nil
+func (q *DelayQueue) popDue() ([]*mq.Message, <-chan time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var msgs []*mq.Message
+	now := q.clock.Now()
+	for q.items.Len() > 0 && !q.items[0].due.After(now) {
+		msgs = append(msgs, heap.Pop(&q.items).(item).msg)
+	}
+	if len(msgs) > 0 || q.items.Len() == 0 {
+		return msgs, nil
+	}
+	wait := q.clock.After(q.items[0].due.Sub(now))
+	// 获取当前时间与注册等待之间时间可能已经推进，此时直接进入下一轮
+	if !q.items[0].due.After(q.clock.Now()) {
+		ch := make(chan time.Time, 1)
+		ch <- now
+		return nil, ch
+	}
+	return nil, wait
+}
+
+type item struct {
+	msg *mq.Message
+	due time.Time
+	seq int64
+}
+
+type items []item
+
+func (h items) Len() int {
+	return len(h)
+}
+
+func (h items) Less(i, j int) bool {
+	if h[i].due.Equal(h[j].due) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].due.Before(h[j].due)
+}
+
+func (h items) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *items) Push(x any) {
+	*h = append(*h, x.(item))
+}
+
+func (h *items) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
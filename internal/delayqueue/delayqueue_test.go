@@ -0,0 +1,66 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package delayqueue
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelayQueue(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewMock(start)
+	var mu sync.Mutex
+	var released []string
+	q := New(clk, func(msg *mq.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		released = append(released, string(msg.Value))
+	})
+	defer q.Close()
+	releasedValues := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), released...)
+	}
+
+	q.Push(&mq.Message{Value: []byte("c")}, start.Add(3*time.Minute))
+	q.Push(&mq.Message{Value: []byte("a")}, start.Add(time.Minute))
+	q.Push(&mq.Message{Value: []byte("b")}, start.Add(time.Minute))
+	q.Push(&mq.Message{Value: []byte("now")}, start)
+	require.Eventually(t, func() bool {
+		return len(releasedValues()) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 3, q.Len())
+
+	clk.Add(time.Minute)
+	require.Eventually(t, func() bool {
+		return len(releasedValues()) == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"now", "a", "b"}, releasedValues())
+
+	clk.Add(2 * time.Minute)
+	require.Eventually(t, func() bool {
+		return len(releasedValues()) == 4
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 0, q.Len())
+}
@@ -18,6 +18,7 @@ package e2e
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"sync"
 	"testing"
@@ -824,6 +825,295 @@ func (b *TestSuite) TestConsumer_Consume() {
 	})
 }
 
+func (b *TestSuite) TestConsumer_RebalanceCallbacks() {
+	t := b.T()
+	t.Parallel()
+
+	topic26, partitions := "topic26", 2
+	err := b.messageQueue.CreateTopic(context.Background(), topic26, partitions)
+	require.NoError(t, err)
+
+	assignedCh := make(chan []int, 1)
+	revokedCh := make(chan []int, 1)
+	c, err := b.messageQueue.Consumer(topic26, "c1",
+		mq.WithOnPartitionsAssigned(func(partitions []int) {
+			assignedCh <- partitions
+		}),
+		mq.WithOnPartitionsRevoked(func(partitions []int) {
+			revokedCh <- partitions
+		}))
+	require.NoError(t, err)
+
+	select {
+	case assigned := <-assignedCh:
+		require.ElementsMatch(t, []int{0, 1}, assigned)
+	case <-time.After(time.Minute):
+		t.Fatal("没有收到分区分配的回调")
+	}
+
+	// 关闭消费者会交出全部分区
+	require.NoError(t, c.Close())
+	select {
+	case revoked := <-revokedCh:
+		require.ElementsMatch(t, []int{0, 1}, revoked)
+	case <-time.After(time.Minute):
+		t.Fatal("没有收到分区收回的回调")
+	}
+}
+
+func (b *TestSuite) TestConsumer_PauseAndResume() {
+	t := b.T()
+	t.Parallel()
+
+	topic27, partitions := "topic27", 2
+	err := b.messageQueue.CreateTopic(context.Background(), topic27, partitions)
+	require.NoError(t, err)
+
+	assignedCh := make(chan []int, 1)
+	c, err := b.messageQueue.Consumer(topic27, "c1", mq.WithOnPartitionsAssigned(func(partitions []int) {
+		assignedCh <- partitions
+	}))
+	require.NoError(t, err)
+	defer func() {
+		_ = c.Close()
+	}()
+	select {
+	case <-assignedCh:
+	case <-time.After(time.Minute):
+		t.Fatal("没有收到分区分配的回调")
+	}
+	require.NoError(t, c.Pause(0))
+
+	p, err := b.messageQueue.Producer(topic27)
+	require.NoError(t, err)
+	for i := 0; i < partitions; i++ {
+		_, err = p.ProduceWithPartition(context.Background(), &mq.Message{Value: []byte("msg")}, i)
+		require.NoError(t, err)
+	}
+
+	// 暂停期间只能收到分区1的消息
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	msg, err := c.Consume(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), msg.Partition)
+	pausedCtx, pausedCancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer pausedCancel()
+	_, err = c.Consume(pausedCtx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.NoError(t, c.Resume(0))
+	msg, err = c.Consume(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), msg.Partition)
+}
+
+func (b *TestSuite) TestMQ_OffsetsForTime() {
+	t := b.T()
+	t.Parallel()
+
+	topic28, partitions := "topic28", 2
+	err := b.messageQueue.CreateTopic(context.Background(), topic28, partitions)
+	require.NoError(t, err)
+
+	p, err := b.messageQueue.Producer(topic28)
+	require.NoError(t, err)
+	now := time.Now().Truncate(time.Millisecond)
+	for i := 0; i < 3; i++ {
+		_, err = p.ProduceWithPartition(context.Background(), &mq.Message{
+			Value:     []byte("msg"),
+			Timestamp: now.Add(time.Duration(i) * time.Minute),
+		}, 0)
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	res, err := b.messageQueue.OffsetsForTime(ctx, topic28, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, map[int]int64{0: 1, 1: 0}, res)
+	res, err = b.messageQueue.OffsetsForTime(ctx, topic28, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, map[int]int64{0: 3, 1: 0}, res)
+
+	c, err := b.messageQueue.Consumer(topic28, "c1")
+	require.NoError(t, err)
+	defer func() {
+		_ = c.Close()
+	}()
+	msg, err := c.Consume(ctx)
+	require.NoError(t, err)
+	assert.True(t, msg.Timestamp.Equal(now), "消息的时间戳应该是生产者设置的时间")
+}
+
+func (b *TestSuite) TestMessage_Header() {
+	t := b.T()
+	t.Parallel()
+
+	topic29, partitions := "topic29", 1
+	err := b.messageQueue.CreateTopic(context.Background(), topic29, partitions)
+	require.NoError(t, err)
+
+	var header mq.Header
+	header.Add("trace", "a")
+	header.Add("span", "b")
+	header.AddBytes("trace", []byte{0x00, 0xff})
+	p, err := b.messageQueue.Producer(topic29)
+	require.NoError(t, err)
+	_, err = p.Produce(context.Background(), &mq.Message{Value: []byte("msg"), Header: header})
+	require.NoError(t, err)
+
+	c, err := b.messageQueue.Consumer(topic29, "c1")
+	require.NoError(t, err)
+	defer func() {
+		_ = c.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	msg, err := c.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, header, msg.Header)
+	assert.Equal(t, []string{"a", string([]byte{0x00, 0xff})}, msg.Header.Values("trace"))
+}
+
+func (b *TestSuite) TestProducer_ProduceAt() {
+	t := b.T()
+	t.Parallel()
+
+	topic30, partitions := "topic30", 1
+	err := b.messageQueue.CreateTopic(context.Background(), topic30, partitions)
+	require.NoError(t, err)
+
+	c, err := b.messageQueue.Consumer(topic30, "c1")
+	require.NoError(t, err)
+	defer func() {
+		_ = c.Close()
+	}()
+	p, err := b.messageQueue.Producer(topic30)
+	require.NoError(t, err)
+	due := time.Now().Add(3 * time.Second)
+	_, err = p.ProduceAt(context.Background(), &mq.Message{Value: []byte("delayed")}, due)
+	require.NoError(t, err)
+	_, err = mq.ProduceWithDelay(context.Background(), p, &mq.Message{Value: []byte("now")}, 0)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	msg, err := c.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "now", string(msg.Value))
+	msg, err = c.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "delayed", string(msg.Value))
+	assert.False(t, time.Now().Before(due), "延迟消息不能在到期之前被消费")
+}
+
+func (b *TestSuite) TestConsumer_ManualCommit() {
+	t := b.T()
+	t.Parallel()
+
+	topic31, partitions := "topic31", 1
+	err := b.messageQueue.CreateTopic(context.Background(), topic31, partitions)
+	require.NoError(t, err)
+	p, err := b.messageQueue.Producer(topic31)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = p.Produce(context.Background(), &mq.Message{Value: []byte(fmt.Sprintf("%d", i))})
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	c, err := b.messageQueue.Consumer(topic31, "c1", mq.WithManualCommit())
+	require.NoError(t, err)
+	first, err := c.Consume(ctx)
+	require.NoError(t, err)
+	_, err = c.Consume(ctx)
+	require.NoError(t, err)
+	// 只提交第一条消息
+	require.NoError(t, c.Commit(ctx, first))
+	require.NoError(t, c.Close())
+
+	c, err = b.messageQueue.Consumer(topic31, "c1")
+	require.NoError(t, err)
+	defer func() {
+		_ = c.Close()
+	}()
+	msg, err := c.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(msg.Value))
+}
+
+func (b *TestSuite) TestTxnProducer() {
+	t := b.T()
+	t.Parallel()
+
+	txnMQ, ok := b.messageQueue.(mq.TxnMQ)
+	require.True(t, ok)
+	src, dst, partitions := "topic32", "topic33", 1
+	require.NoError(t, b.messageQueue.CreateTopic(context.Background(), src, partitions))
+	require.NoError(t, b.messageQueue.CreateTopic(context.Background(), dst, partitions))
+	p, err := b.messageQueue.Producer(src)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = p.Produce(context.Background(), &mq.Message{Value: []byte(fmt.Sprintf("%d", i))})
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	c, err := b.messageQueue.Consumer(src, "c1", mq.WithManualCommit())
+	require.NoError(t, err)
+	txnProducer, err := txnMQ.TxnProducer(dst, "txn1")
+	require.NoError(t, err)
+	msg, err := c.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "0", string(msg.Value))
+
+	// 回滚的事务中的消息与消费进度都不会生效
+	require.NoError(t, txnProducer.BeginTxn(ctx))
+	_, err = txnProducer.Produce(ctx, &mq.Message{Value: []byte("aborted")})
+	require.NoError(t, err)
+	require.NoError(t, txnProducer.SendOffsetsToTxn(ctx, "c1", msg))
+	require.NoError(t, txnProducer.AbortTxn(ctx))
+
+	require.NoError(t, txnProducer.BeginTxn(ctx))
+	_, err = txnProducer.Produce(ctx, &mq.Message{Value: []byte("committed")})
+	require.NoError(t, err)
+	require.NoError(t, txnProducer.SendOffsetsToTxn(ctx, "c1", msg))
+	require.NoError(t, txnProducer.CommitTxn(ctx))
+	require.NoError(t, c.Close())
+
+	reader, err := b.messageQueue.Consumer(dst, "c1", mq.WithIsolationLevel(mq.ReadCommitted))
+	require.NoError(t, err)
+	defer func() {
+		_ = reader.Close()
+	}()
+	msg, err = reader.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "committed", string(msg.Value))
+
+	// 消费进度随事务一起提交
+	c, err = b.messageQueue.Consumer(src, "c1")
+	require.NoError(t, err)
+	defer func() {
+		_ = c.Close()
+	}()
+	msg, err = c.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(msg.Value))
+
+	// 使用相同transactionalID的新生产者会取代旧生产者
+	newProducer, err := txnMQ.TxnProducer(dst, "txn1")
+	require.NoError(t, err)
+	defer func() {
+		_ = newProducer.Close()
+	}()
+	assert.ErrorIs(t, txnProducer.BeginTxn(ctx), mq.ErrProducerFenced)
+	require.NoError(t, newProducer.BeginTxn(ctx))
+	require.NoError(t, newProducer.AbortTxn(ctx))
+}
+
 func newExpectedMessages(messages ...string) []mq.Message {
 	res := make([]mq.Message, 0, len(messages))
 	for _, message := range messages {
@@ -840,6 +1130,7 @@ func assertMessageEqual(t *testing.T, actualMessages []mq.Message, expectedMessa
 		actualMessages[i].Topic = ""
 		actualMessages[i].Offset = 0
 		actualMessages[i].Header = nil
+		actualMessages[i].Timestamp = time.Time{}
 		if !withSpecifiedPartition {
 			actualMessages[i].Partition = 0
 		}
@@ -19,10 +19,13 @@ package e2e
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/ecodeclub/mq-api"
 	"github.com/ecodeclub/mq-api/kafka"
 	kafkago "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -31,6 +34,38 @@ func TestKafka(t *testing.T) {
 	suite.Run(t, NewTestSuite(KafkaCreator{address: address}))
 }
 
+// TestKafka_DelayForwarderRestart 发送延迟消息的MQ在消息到期之前关闭，
+// 只消费topic的新MQ也要转发延迟topic中剩下的消息
+func TestKafka_DelayForwarderRestart(t *testing.T) {
+	creator := KafkaCreator{address: []string{"127.0.0.1:9094"}}
+	for creator.Ping(context.Background()) != nil {
+		time.Sleep(time.Second * 3)
+	}
+
+	topic := "delay_restart"
+	first := creator.Create()
+	require.NoError(t, first.CreateTopic(context.Background(), topic, 1))
+	p, err := first.Producer(topic)
+	require.NoError(t, err)
+	due := time.Now().Add(5 * time.Second)
+	_, err = p.ProduceAt(context.Background(), &mq.Message{Value: []byte("delayed")}, due)
+	require.NoError(t, err)
+	require.NoError(t, first.Close())
+
+	second := creator.Create()
+	defer func() {
+		_ = second.Close()
+	}()
+	c, err := second.Consumer(topic, "c1")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	msg, err := c.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "delayed", string(msg.Value))
+	assert.False(t, time.Now().Before(due), "延迟消息不能在到期之前被消费")
+}
+
 type KafkaCreator struct {
 	address []string
 }
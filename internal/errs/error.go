@@ -22,4 +22,5 @@ var (
 	ErrMQIsClosed       = errors.New("mq已经关闭")
 	ErrInvalidTopic     = errors.New("topic非法")
 	ErrInvalidPartition = errors.New("partition非法")
+	ErrUnknownTopic     = errors.New("topic不存在")
 )
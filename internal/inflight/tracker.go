@@ -0,0 +1,90 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inflight
+
+import (
+	"sync"
+
+	"github.com/ecodeclub/mq-api"
+)
+
+// Tracker 记录每个分区正在处理的消息。消息可以乱序完成，
+// 但是只有从队头开始连续完成的那一段才可以提交，否则重启之后中间未完成的消息会丢失
+type Tracker struct {
+	mu         sync.Mutex
+	partitions map[int64]*partition
+}
+
+type partition struct {
+	// 按照开始处理的顺序排列，也就是偏移量递增的顺序
+	queue []*Entry
+}
+
+// Entry 是一条正在处理的消息
+type Entry struct {
+	Message *mq.Message
+	done    bool
+	// 所属的分区状态，分区被重置之后完成的消息不再影响新的状态
+	p *partition
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{partitions: make(map[int64]*partition)}
+}
+
+// Start 记录msg开始处理。偏移量不大于该分区最后一条消息时，说明重平衡之后消息被重新投递，
+// 此时丢弃该分区之前的记录
+func (t *Tracker) Start(msg *mq.Message) *Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.partitions[msg.Partition]
+	if ok && len(p.queue) > 0 && p.queue[len(p.queue)-1].Message.Offset >= msg.Offset {
+		ok = false
+	}
+	if !ok {
+		p = &partition{}
+		t.partitions[msg.Partition] = p
+	}
+	e := &Entry{Message: msg, p: p}
+	p.queue = append(p.queue, e)
+	return e
+}
+
+// Done 标记e处理完成，返回可以提交的最后一条消息，队头没有新完成的消息时返回nil
+func (t *Tracker) Done(e *Entry) *mq.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e.done = true
+	if t.partitions[e.Message.Partition] != e.p {
+		return nil
+	}
+	var last *mq.Message
+	for len(e.p.queue) > 0 && e.p.queue[0].done {
+		last = e.p.queue[0].Message
+		e.p.queue = e.p.queue[1:]
+	}
+	return last
+}
+
+// Len 返回所有分区中还没有提交的消息数量，包括已经完成但是前面还有未完成消息的
+func (t *Tracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, p := range t.partitions {
+		n += len(p.queue)
+	}
+	return n
+}
@@ -0,0 +1,50 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inflight
+
+import (
+	"testing"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker(t *testing.T) {
+	t.Parallel()
+	tracker := NewTracker()
+	msg := func(partition, offset int64) *mq.Message {
+		return &mq.Message{Partition: partition, Offset: offset}
+	}
+	e0 := tracker.Start(msg(0, 0))
+	e1 := tracker.Start(msg(0, 1))
+	e2 := tracker.Start(msg(0, 2))
+	other := tracker.Start(msg(1, 0))
+	assert.Equal(t, 4, tracker.Len())
+
+	// 前面的消息没有完成，不能提交
+	assert.Nil(t, tracker.Done(e1))
+	assert.Equal(t, msg(1, 0), tracker.Done(other))
+	// 队头完成之后连续完成的一段一起提交
+	assert.Equal(t, msg(0, 1), tracker.Done(e0))
+	assert.Equal(t, msg(0, 2), tracker.Done(e2))
+	assert.Equal(t, 0, tracker.Len())
+
+	// 重新投递之后旧的记录被丢弃，旧记录完成不影响新的状态
+	stale := tracker.Start(msg(0, 3))
+	redelivered := tracker.Start(msg(0, 3))
+	assert.Nil(t, tracker.Done(stale))
+	assert.Equal(t, 1, tracker.Len())
+	assert.Equal(t, msg(0, 3), tracker.Done(redelivered))
+}
@@ -20,9 +20,12 @@ import (
 )
 
 func ConvertToMQMessage(kafkaMsg kafkago.Message) *mq.Message {
-	header := mq.Header{}
+	var header mq.Header
+	if len(kafkaMsg.Headers) > 0 {
+		header = make(mq.Header, 0, len(kafkaMsg.Headers))
+	}
 	for _, h := range kafkaMsg.Headers {
-		header[h.Key] = string(h.Value)
+		header = append(header, mq.HeaderField{Key: h.Key, Value: h.Value})
 	}
 	return &mq.Message{
 		Key:       kafkaMsg.Key,
@@ -31,15 +34,16 @@ func ConvertToMQMessage(kafkaMsg kafkago.Message) *mq.Message {
 		Header:    header,
 		Partition: int64(kafkaMsg.Partition),
 		Offset:    kafkaMsg.Offset,
+		Timestamp: kafkaMsg.Time,
 	}
 }
 
 func ConvertToKafkaHeader(header mq.Header) []kafkago.Header {
 	h := make([]kafkago.Header, 0, len(header))
-	for key, val := range header {
+	for _, f := range header {
 		h = append(h, kafkago.Header{
-			Key:   key,
-			Value: []byte(val),
+			Key:   f.Key,
+			Value: f.Value,
 		})
 	}
 	return h
@@ -19,25 +19,54 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ecodeclub/mq-api/internal/errs"
 
+	"github.com/ecodeclub/ekit/bean/option"
 	"github.com/ecodeclub/mq-api"
 	"github.com/ecodeclub/mq-api/kafka/common"
 	kafkago "github.com/segmentio/kafka-go"
 )
 
-// consumerChannel先默认1000
-const msgChannelSize = 1000
+const (
+	// consumerChannel先默认1000
+	msgChannelSize = 1000
+	// 提交消费进度的间隔
+	commitInterval = time.Second
+)
 
+// Consumer 基于kafka-go的ConsumerGroup实现，每一代(Generation)为分配到的每个分区启动一个Reader，
+// 从而能够感知分区的分配与收回
 type Consumer struct {
-	topic   string
-	groupID string
+	topic          string
+	groupID        string
+	address        []string
+	groupBalancers []kafkago.GroupBalancer
+	// 当前消费者使用的 StickyGroupBalancer，每一代开始时记录分配到的分区
+	stickyBalancers []*StickyGroupBalancer
+	cfg             mq.ConsumerConfig
+
+	group *kafkago.ConsumerGroup
+	msgCh chan *mq.Message
+
+	// 被暂停的分区，恢复时关闭对应的channel唤醒等待的协程
+	pausedLocker sync.Mutex
+	paused       map[int]chan struct{}
+
+	// 当前这一代的消费进度与分配到的分区，手动提交时 Commit 需要用到
+	generationLocker sync.Mutex
+	offsets          *offsetTracker
+	assigned         map[int]struct{}
 
-	reader *kafkago.Reader
-	msgCh  chan *mq.Message
+	metrics mq.Metrics
+	// 上一次记录的msgCh中的消息数量
+	buffered atomic.Int64
+	// 上一代结束的时间，单位纳秒，用于计算重平衡的耗时
+	revokedAt atomic.Int64
 
 	closeCtx           context.Context
 	closeCtxCancelFunc context.CancelFunc
@@ -45,22 +74,83 @@ type Consumer struct {
 	closeOnce          *sync.Once
 }
 
-func NewConsumer(address []string, topic, groupID string) *Consumer {
+func NewConsumer(address []string, topic, groupID string, opts ...option.Option[Consumer]) (*Consumer, error) {
 	ctx, cancelFunc := context.WithCancel(context.Background())
-	return &Consumer{
-		topic:   topic,
-		groupID: groupID,
-		reader: kafkago.NewReader(kafkago.ReaderConfig{
-			Brokers: address,
-			Topic:   topic,
-			GroupID: groupID,
-		}),
+	c := &Consumer{
+		topic:              topic,
+		groupID:            groupID,
+		address:            address,
+		cfg:                mq.NewConsumerConfig(),
 		msgCh:              make(chan *mq.Message, msgChannelSize),
+		paused:             map[int]chan struct{}{},
+		metrics:            mq.NopMetrics{},
 		closeCtx:           ctx,
 		closeCtxCancelFunc: cancelFunc,
 		closeErr:           nil,
 		closeOnce:          &sync.Once{},
 	}
+	option.Apply(c, opts...)
+	balancers, err := c.balancers()
+	if err != nil {
+		cancelFunc()
+		return nil, err
+	}
+	group, err := kafkago.NewConsumerGroup(kafkago.ConsumerGroupConfig{
+		ID:             groupID,
+		Brokers:        address,
+		Topics:         []string{topic},
+		GroupBalancers: balancers,
+	})
+	if err != nil {
+		cancelFunc()
+		return nil, err
+	}
+	c.group = group
+	return c, nil
+}
+
+// balancers 为当前消费者创建 StickyGroupBalancer 的副本，用来在UserData中携带InstanceID与持有的分区。
+// 其他分配策略无法携带InstanceID，因此设置了InstanceID时只能使用 StickyGroupBalancer
+func (c *Consumer) balancers() ([]kafkago.GroupBalancer, error) {
+	if c.cfg.InstanceID != "" && len(c.groupBalancers) == 0 {
+		return nil, ErrInstanceIDRequiresSticky
+	}
+	res := make([]kafkago.GroupBalancer, 0, len(c.groupBalancers))
+	for _, b := range c.groupBalancers {
+		sb, ok := b.(*StickyGroupBalancer)
+		if !ok {
+			if c.cfg.InstanceID != "" {
+				return nil, fmt.Errorf("%w: %s", ErrInstanceIDRequiresSticky, b.ProtocolName())
+			}
+			res = append(res, b)
+			continue
+		}
+		member := sb.forMember(c.cfg.InstanceID)
+		c.stickyBalancers = append(c.stickyBalancers, member)
+		res = append(res, member)
+	}
+	return res, nil
+}
+
+// WithConsumerGroupBalancers 指定分区分配策略，为空时使用kafka-go默认的分配策略
+func WithConsumerGroupBalancers(balancers ...kafkago.GroupBalancer) option.Option[Consumer] {
+	return func(c *Consumer) {
+		c.groupBalancers = balancers
+	}
+}
+
+// WithConsumerMetrics 指定记录消费次数、缓冲区深度、重平衡与积压的 mq.Metrics
+func WithConsumerMetrics(metrics mq.Metrics) option.Option[Consumer] {
+	return func(c *Consumer) {
+		c.metrics = metrics
+	}
+}
+
+// WithConsumerConfig 指定通用的消费者配置
+func WithConsumerConfig(cfg mq.ConsumerConfig) option.Option[Consumer] {
+	return func(c *Consumer) {
+		c.cfg = cfg
+	}
 }
 
 func (c *Consumer) Consume(ctx context.Context) (*mq.Message, error) {
@@ -85,10 +175,80 @@ func (c *Consumer) ConsumeChan(ctx context.Context) (<-chan *mq.Message, error)
 	return c.msgCh, nil
 }
 
+// Pause kafka-go的Reader不支持暂停，暂停的分区会停止向msgCh投递消息，
+// Reader在预取队列填满之后也会停止从broker拉取
+func (c *Consumer) Pause(partitions ...int) error {
+	if c.closeCtx.Err() != nil {
+		return fmt.Errorf("kafka: %w", errs.ErrConsumerIsClosed)
+	}
+	c.pausedLocker.Lock()
+	defer c.pausedLocker.Unlock()
+	for _, p := range partitions {
+		if _, ok := c.paused[p]; !ok {
+			c.paused[p] = make(chan struct{})
+		}
+	}
+	return nil
+}
+
+func (c *Consumer) Resume(partitions ...int) error {
+	if c.closeCtx.Err() != nil {
+		return fmt.Errorf("kafka: %w", errs.ErrConsumerIsClosed)
+	}
+	c.pausedLocker.Lock()
+	defer c.pausedLocker.Unlock()
+	for _, p := range partitions {
+		if ch, ok := c.paused[p]; ok {
+			close(ch)
+			delete(c.paused, p)
+		}
+	}
+	return nil
+}
+
+// waitIfPaused 分区被暂停时阻塞直到恢复或者ctx结束，返回ctx是否仍然有效
+func (c *Consumer) waitIfPaused(ctx context.Context, partition int) bool {
+	c.pausedLocker.Lock()
+	ch, ok := c.paused[partition]
+	c.pausedLocker.Unlock()
+	if !ok {
+		return true
+	}
+	select {
+	case <-ch:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Commit 手动提交时记录消费进度，由这一代的提交协程定期提交，这一代结束时还会再提交一次
+func (c *Consumer) Commit(ctx context.Context, msgs ...*mq.Message) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if c.closeCtx.Err() != nil {
+		return fmt.Errorf("kafka: %w", errs.ErrConsumerIsClosed)
+	}
+	if !c.cfg.ManualCommit {
+		return nil
+	}
+	c.generationLocker.Lock()
+	defer c.generationLocker.Unlock()
+	for _, msg := range msgs {
+		if _, ok := c.assigned[int(msg.Partition)]; !ok {
+			continue
+		}
+		c.offsets.advance(int(msg.Partition), msg.Offset+1)
+	}
+	return nil
+}
+
 func (c *Consumer) Close() error {
 	c.closeOnce.Do(func() {
 		c.closeCtxCancelFunc()
-		c.closeErr = c.reader.Close()
+		// 离开消费组，并等待当前这一代的所有协程退出
+		c.closeErr = c.group.Close()
 	})
 	return c.closeErr
 }
@@ -96,23 +256,188 @@ func (c *Consumer) Close() error {
 // getMsgFromKafka 完成持续从kafka内获取数据
 func (c *Consumer) getMsgFromKafka() {
 	defer func() {
+		// 确保所有往msgCh写数据的协程都已经退出
+		_ = c.group.Close()
 		close(c.msgCh)
+		c.metrics.AddBuffered(c.topic, c.groupID, -int(c.buffered.Swap(0)))
 	}()
 
+	c.revokedAt.Store(time.Now().UnixNano())
 	for {
-		m, err := c.reader.ReadMessage(c.closeCtx)
+		gen, err := c.group.Next(c.closeCtx)
 		if err != nil {
-			if errors.Is(err, io.ErrClosedPipe) || errors.Is(err, io.EOF) || errors.Is(err, context.Canceled) {
+			if errors.Is(err, kafkago.ErrGroupClosed) || errors.Is(err, context.Canceled) {
 				return
 			}
-			log.Printf("读取消息失败: %s", err.Error())
+			mq.Logger().Error("加入消费组失败", slog.String("group", c.groupID), slog.String("error", err.Error()))
 			continue
 		}
+		c.metrics.ObserveRebalance(c.topic, c.groupID, time.Since(time.Unix(0, c.revokedAt.Load())))
+		c.startGeneration(gen)
+	}
+}
+
+// startGeneration 在新的一代中消费分配到的分区，这一代结束时回调 OnPartitionsRevoked 并提交最终的消费进度
+func (c *Consumer) startGeneration(gen *kafkago.Generation) {
+	assignments := gen.Assignments[c.topic]
+	partitions := make([]int, 0, len(assignments))
+	for _, a := range assignments {
+		partitions = append(partitions, a.ID)
+	}
+	for _, b := range c.stickyBalancers {
+		b.setOwned(int(gen.ID), map[string][]int{c.topic: partitions})
+	}
+	if len(partitions) > 0 {
+		c.cfg.OnPartitionsAssigned(partitions)
+	}
+	offsets := newOffsetTracker()
+	assigned := make(map[int]struct{}, len(partitions))
+	for _, p := range partitions {
+		assigned[p] = struct{}{}
+	}
+	c.generationLocker.Lock()
+	c.offsets, c.assigned = offsets, assigned
+	c.generationLocker.Unlock()
+	var wg sync.WaitGroup
+	for _, a := range assignments {
+		wg.Add(1)
+		assignment := a
+		gen.Start(func(ctx context.Context) {
+			defer wg.Done()
+			c.consumePartition(ctx, assignment, offsets)
+		})
+	}
+	gen.Start(func(ctx context.Context) {
+		ticker := time.NewTicker(commitInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.commit(gen, offsets)
+				c.observeBuffered()
+			case <-ctx.Done():
+				c.revokedAt.Store(time.Now().UnixNano())
+				// 等待所有分区停止投递消息之后再交出分区
+				wg.Wait()
+				if len(partitions) > 0 {
+					c.cfg.OnPartitionsRevoked(partitions)
+				}
+				c.commit(gen, offsets)
+				return
+			}
+		}
+	})
+}
+
+func (c *Consumer) consumePartition(ctx context.Context, assignment kafkago.PartitionAssignment, offsets *offsetTracker) {
+	reader := kafkago.NewReader(kafkago.ReaderConfig{
+		Brokers:        c.address,
+		Topic:          c.topic,
+		Partition:      assignment.ID,
+		IsolationLevel: isolationLevel(c.cfg.IsolationLevel),
+	})
+	defer func() {
+		_ = reader.Close()
+	}()
+	if err := reader.SetOffset(assignment.Offset); err != nil {
+		mq.Logger().Error("设置分区的消费进度失败", slog.Int("partition", assignment.ID), slog.String("error", err.Error()))
+		return
+	}
+	for {
+		if !c.waitIfPaused(ctx, assignment.ID) {
+			return
+		}
+		m, err := reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, io.EOF) {
+				return
+			}
+			mq.Logger().Error("读取消息失败", slog.String("topic", c.topic), slog.String("error", err.Error()))
+			continue
+		}
+		// 读取期间分区可能被暂停
+		if !c.waitIfPaused(ctx, assignment.ID) {
+			return
+		}
 		msg := common.ConvertToMQMessage(m)
 		select {
 		case c.msgCh <- msg:
-		case <-c.closeCtx.Done():
+			c.metrics.ObserveConsume(c.topic, c.groupID, m.Partition)
+			c.metrics.SetLag(c.topic, c.groupID, m.Partition, m.HighWaterMark-m.Offset-1)
+			if !c.cfg.ManualCommit {
+				offsets.store(m.Partition, m.Offset+1)
+			}
+		case <-ctx.Done():
 			return
 		}
 	}
 }
+
+// observeBuffered 记录msgCh中消息数量相对上一次记录的变化
+func (c *Consumer) observeBuffered() {
+	buffered := int64(len(c.msgCh))
+	c.metrics.AddBuffered(c.topic, c.groupID, int(buffered-c.buffered.Swap(buffered)))
+}
+
+func (c *Consumer) commit(gen *kafkago.Generation, offsets *offsetTracker) {
+	pending := offsets.pending()
+	if len(pending) == 0 {
+		return
+	}
+	err := gen.CommitOffsets(map[string]map[int]int64{c.topic: pending})
+	if err != nil {
+		mq.Logger().Error("提交消费进度失败", slog.String("group", c.groupID), slog.String("error", err.Error()))
+		return
+	}
+	offsets.committed(pending)
+}
+
+// offsetTracker 记录每个分区下一条待消费消息的偏移量
+type offsetTracker struct {
+	mu      sync.Mutex
+	offsets map[int]int64
+	// 已经提交过的偏移量，避免重复提交
+	commits map[int]int64
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		offsets: map[int]int64{},
+		commits: map[int]int64{},
+	}
+}
+
+func (o *offsetTracker) store(partition int, offset int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.offsets[partition] = offset
+}
+
+// advance 与 store 相同，但是偏移量只能前进
+func (o *offsetTracker) advance(partition int, offset int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if offset > o.offsets[partition] {
+		o.offsets[partition] = offset
+	}
+}
+
+func (o *offsetTracker) pending() map[int]int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	res := make(map[int]int64, len(o.offsets))
+	for p, offset := range o.offsets {
+		if o.commits[p] != offset {
+			res[p] = offset
+		}
+	}
+	return res
+}
+
+func (o *offsetTracker) committed(offsets map[int]int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for p, offset := range offsets {
+		o.commits[p] = offset
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
+	"github.com/ecodeclub/mq-api/internal/delayqueue"
+	kafkago "github.com/segmentio/kafka-go"
+	"go.uber.org/multierr"
+)
+
+const (
+	delayTopicSuffix = ".delay"
+	// 重试转发失败的消息之前等待的时间
+	forwardRetryInterval = time.Second
+	// 记录延迟消息的到期时间，为毫秒级的unix时间戳
+	delayDueHeader = "x-mq-delay-due"
+)
+
+func delayTopic(topic string) string {
+	return topic + delayTopicSuffix
+}
+
+// withDelayDue 在消息中记录到期时间，写入延迟topic之前调用
+func withDelayDue(message kafkago.Message, at time.Time) kafkago.Message {
+	message.Headers = append(message.Headers, kafkago.Header{
+		Key:   delayDueHeader,
+		Value: []byte(strconv.FormatInt(at.UnixMilli(), 10)),
+	})
+	return message
+}
+
+// delayForwarderGroupID 每个topic的转发者使用单独的消费组，新增topic时不会触发其他topic的重平衡。
+// 延迟topic只有一个分区，同一时刻只有一个MQ实例的转发者生效
+func delayForwarderGroupID(topic string) string {
+	return "mq-api-delay-forwarder-" + topic
+}
+
+// delayForwarder 消费延迟topic中的消息，到期后转发回原topic。
+// 消息转发成功之后才会提交偏移量，并且只提交连续转发成功的部分，
+// 因此转发者重启时没有到期的消息会被重新读取，已经转发但是还没有提交的消息可能会被重复转发
+type delayForwarder struct {
+	consumer *Consumer
+	producer *Producer
+	queue    *delayqueue.DelayQueue
+	clock    clock.Clock
+	tracker  *forwardTracker
+	doneCh   chan struct{}
+}
+
+func newDelayForwarder(address []string, topic string, clk clock.Clock) (*delayForwarder, error) {
+	// 事务中的延迟消息在事务提交之后才转发
+	c, err := NewConsumer(address, delayTopic(topic), delayForwarderGroupID(topic),
+		WithConsumerConfig(mq.NewConsumerConfig(mq.WithManualCommit(), mq.WithIsolationLevel(mq.ReadCommitted))))
+	if err != nil {
+		return nil, err
+	}
+	go c.getMsgFromKafka()
+	balancer, _ := NewSpecifiedPartitionBalancer(&kafkago.Hash{})
+	f := &delayForwarder{
+		consumer: c,
+		producer: NewProducer(address, topic, balancer, WithProducerClock(clk)),
+		clock:    clk,
+		tracker:  newForwardTracker(),
+		doneCh:   make(chan struct{}),
+	}
+	f.queue = delayqueue.New(clk, f.forward)
+	go f.run()
+	return f, nil
+}
+
+func (f *delayForwarder) run() {
+	defer close(f.doneCh)
+	for {
+		msg, err := f.consumer.Consume(context.Background())
+		if err != nil {
+			return
+		}
+		// 重平衡之后会从提交的位置重新读取，已经在等待到期的消息不需要再加入队列
+		if !f.tracker.track(msg) {
+			continue
+		}
+		due, err := strconv.ParseInt(msg.Header.Get(delayDueHeader), 10, 64)
+		if err != nil {
+			mq.Logger().Warn("延迟消息缺少到期时间，立刻转发", slog.String("error", err.Error()))
+		}
+		f.queue.Push(msg, time.UnixMilli(due))
+	}
+}
+
+// forward 转发到期的消息，失败时稍后重试
+func (f *delayForwarder) forward(msg *mq.Message) {
+	out := *msg
+	out.Header = msg.Header.Clone()
+	out.Header.Del(delayDueHeader)
+	if _, err := f.producer.Produce(context.Background(), &out); err != nil {
+		mq.Logger().Warn("转发延迟消息失败，稍后重试", slog.String("topic", f.producer.topic), slog.String("error", err.Error()))
+		f.queue.Push(msg, f.clock.Now().Add(forwardRetryInterval))
+		return
+	}
+	if committed := f.tracker.forwarded(msg); committed != nil {
+		if err := f.consumer.Commit(context.Background(), committed); err != nil {
+			mq.Logger().Error("提交延迟消息的消费进度失败", slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (f *delayForwarder) Close() error {
+	err := f.consumer.Close()
+	<-f.doneCh
+	f.queue.Close()
+	return multierr.Append(err, f.producer.Close())
+}
+
+// forwardTracker 记录已经读取但是还没有提交的延迟消息。
+// 延迟topic中的消息不是按照到期时间排列的，只有偏移量更小的消息都转发之后才能提交
+type forwardTracker struct {
+	mu sync.Mutex
+	// 按照偏移量排列的未提交的消息
+	offsets []int64
+	// 偏移量 => 已经转发的消息，为nil表示还在等待到期
+	msgs map[int64]*mq.Message
+}
+
+func newForwardTracker() *forwardTracker {
+	return &forwardTracker{msgs: map[int64]*mq.Message{}}
+}
+
+// track 记录读取到的消息，消息已经在等待转发时返回false
+func (t *forwardTracker) track(msg *mq.Message) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.msgs[msg.Offset]; ok {
+		return false
+	}
+	t.msgs[msg.Offset] = nil
+	idx, _ := slices.BinarySearch(t.offsets, msg.Offset)
+	t.offsets = slices.Insert(t.offsets, idx, msg.Offset)
+	return true
+}
+
+// forwarded 标记msg已经转发，返回可以提交的最后一条消息，没有可以提交的消息时返回nil
+func (t *forwardTracker) forwarded(msg *mq.Message) *mq.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.msgs[msg.Offset]; !ok {
+		return nil
+	}
+	t.msgs[msg.Offset] = msg
+	var res *mq.Message
+	for len(t.offsets) > 0 && t.msgs[t.offsets[0]] != nil {
+		res = t.msgs[t.offsets[0]]
+		delete(t.msgs, t.offsets[0])
+		t.offsets = t.offsets[1:]
+	}
+	return res
+}
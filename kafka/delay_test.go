@@ -0,0 +1,51 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"testing"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelayForwarderGroupID(t *testing.T) {
+	t.Parallel()
+	assert.NotEqual(t, delayForwarderGroupID("t1"), delayForwarderGroupID("t2"))
+}
+
+func TestForwardTracker(t *testing.T) {
+	t.Parallel()
+	tracker := newForwardTracker()
+	msgs := make([]*mq.Message, 4)
+	for i := range msgs {
+		msgs[i] = &mq.Message{Offset: int64(i)}
+		assert.True(t, tracker.track(msgs[i]))
+	}
+	// 重平衡之后重新读取到还在等待的消息
+	assert.False(t, tracker.track(&mq.Message{Offset: 1}))
+
+	// 偏移量更小的消息还没有转发，不能提交
+	assert.Nil(t, tracker.forwarded(msgs[2]))
+	assert.Nil(t, tracker.forwarded(msgs[1]))
+	assert.Equal(t, msgs[2], tracker.forwarded(msgs[0]))
+	assert.Equal(t, msgs[3], tracker.forwarded(msgs[3]))
+	// 没有记录过的消息
+	assert.Nil(t, tracker.forwarded(&mq.Message{Offset: 10}))
+
+	// 提交之后重新读取的消息会再次转发
+	assert.True(t, tracker.track(msgs[0]))
+	assert.Equal(t, msgs[0], tracker.forwarded(msgs[0]))
+}
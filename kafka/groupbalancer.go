@@ -0,0 +1,208 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/ecodeclub/mq-api/internal/assign/sticky"
+	kafkago "github.com/segmentio/kafka-go"
+)
+
+const stickyProtocolName = "mq-api-sticky"
+
+// ErrInstanceIDRequiresSticky 设置了 mq.ConsumerConfig.InstanceID 却使用了 StickyGroupBalancer 以外的分配策略
+var ErrInstanceIDRequiresSticky = errors.New("kafka: 静态成员只能使用 StickyGroupBalancer")
+
+var _ kafkago.GroupBalancer = &StickyGroupBalancer{}
+
+// StickyGroupBalancer 粘性分区分配策略，重平衡时尽量保留成员上一轮持有的分区。
+// 与kafka的cooperative-sticky一样，每个成员加入消费组时通过UserData携带自己当前持有的分区，
+// 组长根据所有成员的UserData重建上一轮的分配结果，因此不依赖组长的内存，也不会在消费组之间互相影响。
+//
+// kafka-go 的JoinGroup请求不支持 group.instance.id，设置了 mq.ConsumerConfig.InstanceID 的消费者
+// 会通过UserData携带InstanceID，以相同InstanceID重新加入的消费者可以拿回原来的分区，但是依旧会触发一次重平衡
+type StickyGroupBalancer struct {
+	assigner *sticky.Assigner
+	// 消费者自己的状态，通过 forMember 创建，NewStickyGroupBalancer 返回的对象没有
+	member *stickyMember
+}
+
+// stickyMember 一个消费者当前持有的分区
+type stickyMember struct {
+	instanceID string
+
+	mu         sync.Mutex
+	generation int
+	owned      map[string][]int
+}
+
+// stickyUserData 成员加入消费组时携带的UserData
+type stickyUserData struct {
+	InstanceID string `json:"instanceId,omitempty"`
+	// 持有Owned时所在的代，同一个分区被多个成员声明时以代更新的为准
+	Generation int              `json:"generation,omitempty"`
+	Owned      map[string][]int `json:"owned,omitempty"`
+}
+
+func NewStickyGroupBalancer() *StickyGroupBalancer {
+	return &StickyGroupBalancer{assigner: sticky.NewAssigner()}
+}
+
+// forMember 返回某个消费者使用的副本，副本在UserData中携带instanceID与这个消费者持有的分区
+func (b *StickyGroupBalancer) forMember(instanceID string) *StickyGroupBalancer {
+	return &StickyGroupBalancer{
+		assigner: b.assigner,
+		member:   &stickyMember{instanceID: instanceID},
+	}
+}
+
+// setOwned 记录消费者在新的一代中分配到的分区，下一次加入消费组时携带
+func (b *StickyGroupBalancer) setOwned(generation int, owned map[string][]int) {
+	b.member.mu.Lock()
+	defer b.member.mu.Unlock()
+	b.member.generation = generation
+	b.member.owned = owned
+}
+
+func (b *StickyGroupBalancer) ProtocolName() string {
+	return stickyProtocolName
+}
+
+func (b *StickyGroupBalancer) UserData() ([]byte, error) {
+	if b.member == nil {
+		return nil, nil
+	}
+	b.member.mu.Lock()
+	defer b.member.mu.Unlock()
+	return json.Marshal(stickyUserData{
+		InstanceID: b.member.instanceID,
+		Generation: b.member.generation,
+		Owned:      b.member.owned,
+	})
+}
+
+func (b *StickyGroupBalancer) AssignGroups(members []kafkago.GroupMember, partitions []kafkago.Partition) kafkago.GroupMemberAssignments {
+	userData := decodeUserData(members)
+	// 成员ID => 分配时使用的名称
+	names := memberNames(members, userData)
+	assignments := kafkago.GroupMemberAssignments{}
+	for topic, memberIDs := range membersByTopic(members) {
+		// 分区ID与分配器使用的下标相互转换，分区ID不一定连续
+		ids := partitionIDs(topic, partitions)
+		indexes := make(map[int]int, len(ids))
+		for idx, id := range ids {
+			indexes[id] = idx
+		}
+		previous := make(map[string][]int, len(memberIDs))
+		for id, memberID := range previousOwners(topic, memberIDs, userData) {
+			if idx, ok := indexes[id]; ok {
+				previous[names[memberID]] = append(previous[names[memberID]], idx)
+			}
+		}
+		for _, owned := range previous {
+			sort.Ints(owned)
+		}
+		consumers := make([]string, 0, len(memberIDs))
+		for _, memberID := range memberIDs {
+			consumers = append(consumers, names[memberID])
+		}
+		result := b.assigner.AssignPartitionWithPrevious(consumers, len(ids), previous)
+		for _, memberID := range memberIDs {
+			idxs := result[names[memberID]]
+			owned := make([]int, 0, len(idxs))
+			for _, idx := range idxs {
+				owned = append(owned, ids[idx])
+			}
+			if _, ok := assignments[memberID]; !ok {
+				assignments[memberID] = map[string][]int{}
+			}
+			assignments[memberID][topic] = owned
+		}
+	}
+	return assignments
+}
+
+// decodeUserData 解析成员的UserData，无法解析的成员视为没有持有分区的新成员
+func decodeUserData(members []kafkago.GroupMember) map[string]stickyUserData {
+	res := make(map[string]stickyUserData, len(members))
+	for _, member := range members {
+		var data stickyUserData
+		if len(member.UserData) > 0 && json.Unmarshal(member.UserData, &data) != nil {
+			data = stickyUserData{}
+		}
+		res[member.ID] = data
+	}
+	return res
+}
+
+// previousOwners 返回 分区ID => 上一轮持有它的成员ID。
+// 重平衡过程中成员的信息可能已经过期，同一个分区被多个成员声明时以代更新的为准
+func previousOwners(topic string, memberIDs []string, userData map[string]stickyUserData) map[int]string {
+	owners := map[int]string{}
+	for _, memberID := range memberIDs {
+		data := userData[memberID]
+		for _, id := range data.Owned[topic] {
+			owner, ok := owners[id]
+			if !ok || userData[owner].Generation < data.Generation {
+				owners[id] = memberID
+			}
+		}
+	}
+	return owners
+}
+
+// memberNames 成员携带了InstanceID时使用InstanceID，否则使用成员ID。InstanceID重复时后来者使用成员ID
+func memberNames(members []kafkago.GroupMember, userData map[string]stickyUserData) map[string]string {
+	res := make(map[string]string, len(members))
+	used := make(map[string]bool, len(members))
+	for _, member := range members {
+		name := member.ID
+		if instanceID := userData[member.ID].InstanceID; instanceID != "" && !used[instanceID] {
+			name = instanceID
+		}
+		used[name] = true
+		res[member.ID] = name
+	}
+	return res
+}
+
+// membersByTopic 返回 topic => 订阅该topic的成员ID，成员ID有序以保证分配结果稳定
+func membersByTopic(members []kafkago.GroupMember) map[string][]string {
+	res := map[string][]string{}
+	for _, member := range members {
+		for _, topic := range member.Topics {
+			res[topic] = append(res[topic], member.ID)
+		}
+	}
+	for _, ids := range res {
+		sort.Strings(ids)
+	}
+	return res
+}
+
+func partitionIDs(topic string, partitions []kafkago.Partition) []int {
+	ids := make([]int, 0, len(partitions))
+	for _, p := range partitions {
+		if p.Topic == topic {
+			ids = append(ids, p.ID)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
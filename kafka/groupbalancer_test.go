@@ -0,0 +1,197 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"testing"
+
+	"github.com/ecodeclub/mq-api"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// member 构造携带UserData的成员，模拟消费者在上一代中持有owned
+func member(t *testing.T, id, instanceID string, generation int, owned map[string][]int, topics ...string) kafkago.GroupMember {
+	t.Helper()
+	b := NewStickyGroupBalancer().forMember(instanceID)
+	b.setOwned(generation, owned)
+	data, err := b.UserData()
+	require.NoError(t, err)
+	return kafkago.GroupMember{ID: id, Topics: topics, UserData: data}
+}
+
+func TestStickyGroupBalancer_AssignGroups(t *testing.T) {
+	t.Parallel()
+	partitions := []kafkago.Partition{
+		{Topic: "t1", ID: 0},
+		{Topic: "t1", ID: 1},
+		{Topic: "t1", ID: 2},
+		{Topic: "t1", ID: 3},
+		{Topic: "t2", ID: 0},
+	}
+	balancer := NewStickyGroupBalancer()
+
+	got := balancer.AssignGroups([]kafkago.GroupMember{
+		member(t, "m1", "", 0, nil, "t1", "t2"),
+		member(t, "m2", "", 0, nil, "t1"),
+	}, partitions)
+	assert.Equal(t, kafkago.GroupMemberAssignments{
+		"m1": {"t1": {0, 1}, "t2": {0}},
+		"m2": {"t1": {2, 3}},
+	}, got)
+
+	// m0加入，原有成员尽量保留自己的分区。执行分配的可以是另一个组长
+	got = NewStickyGroupBalancer().AssignGroups([]kafkago.GroupMember{
+		member(t, "m0", "", 0, nil, "t1"),
+		member(t, "m1", "", 1, map[string][]int{"t1": {0, 1}, "t2": {0}}, "t1", "t2"),
+		member(t, "m2", "", 1, map[string][]int{"t1": {2, 3}}, "t1"),
+	}, partitions)
+	assert.Equal(t, kafkago.GroupMemberAssignments{
+		"m0": {"t1": {3}},
+		"m1": {"t1": {0, 1}, "t2": {0}},
+		"m2": {"t1": {2}},
+	}, got)
+
+	// m1离开，只有它的分区发生移动
+	got = balancer.AssignGroups([]kafkago.GroupMember{
+		member(t, "m0", "", 2, map[string][]int{"t1": {3}}, "t1", "t2"),
+		member(t, "m2", "", 2, map[string][]int{"t1": {2}}, "t1"),
+	}, partitions)
+	assert.Equal(t, kafkago.GroupMemberAssignments{
+		"m0": {"t1": {0, 3}, "t2": {0}},
+		"m2": {"t1": {1, 2}},
+	}, got)
+
+	// 同一个分区被多个成员声明时，以代更新的为准
+	got = balancer.AssignGroups([]kafkago.GroupMember{
+		member(t, "m1", "", 1, map[string][]int{"t1": {0, 1}}, "t1"),
+		member(t, "m2", "", 2, map[string][]int{"t1": {0, 3}}, "t1"),
+	}, partitions)
+	assert.Equal(t, kafkago.GroupMemberAssignments{
+		"m1": {"t1": {1, 2}},
+		"m2": {"t1": {0, 3}},
+	}, got)
+}
+
+func TestStickyGroupBalancer_InstanceID(t *testing.T) {
+	t.Parallel()
+	partitions := []kafkago.Partition{
+		{Topic: "t1", ID: 0},
+		{Topic: "t1", ID: 1},
+		{Topic: "t1", ID: 2},
+		{Topic: "t1", ID: 3},
+	}
+	balancer := NewStickyGroupBalancer()
+	userData, err := balancer.UserData()
+	require.NoError(t, err)
+	assert.Nil(t, userData)
+
+	got := balancer.AssignGroups([]kafkago.GroupMember{
+		member(t, "m1", "", 0, nil, "t1"),
+		member(t, "m2", "i2", 0, nil, "t1"),
+	}, partitions)
+	assert.Equal(t, kafkago.GroupMemberAssignments{
+		"m1": {"t1": {0, 1}},
+		"m2": {"t1": {2, 3}},
+	}, got)
+
+	// 静态成员以新的成员ID重新加入，依旧拿回原来的分区
+	got = balancer.AssignGroups([]kafkago.GroupMember{
+		member(t, "m0", "i2", 1, map[string][]int{"t1": {2, 3}}, "t1"),
+		member(t, "m1", "", 1, map[string][]int{"t1": {0, 1}}, "t1"),
+	}, partitions)
+	assert.Equal(t, kafkago.GroupMemberAssignments{
+		"m0": {"t1": {2, 3}},
+		"m1": {"t1": {0, 1}},
+	}, got)
+
+	// 无法解析的UserData视为新成员
+	got = balancer.AssignGroups([]kafkago.GroupMember{
+		{ID: "m1", Topics: []string{"t1"}, UserData: []byte("i1")},
+		member(t, "m2", "", 1, map[string][]int{"t1": {0, 1}}, "t1"),
+	}, partitions)
+	assert.Equal(t, kafkago.GroupMemberAssignments{
+		"m1": {"t1": {2, 3}},
+		"m2": {"t1": {0, 1}},
+	}, got)
+}
+
+func TestConsumer_balancers(t *testing.T) {
+	t.Parallel()
+	sticky := NewStickyGroupBalancer()
+	testCases := []struct {
+		name       string
+		instanceID string
+		balancers  []kafkago.GroupBalancer
+		wantErr    error
+		wantData   []byte
+	}{
+		{
+			name:      "动态成员",
+			balancers: []kafkago.GroupBalancer{kafkago.RangeGroupBalancer{}},
+		},
+		{
+			name:       "静态成员",
+			instanceID: "i1",
+			balancers:  []kafkago.GroupBalancer{sticky},
+			wantData:   []byte(`{"instanceId":"i1"}`),
+		},
+		{
+			name:       "静态成员使用默认的分配策略",
+			instanceID: "i1",
+			wantErr:    ErrInstanceIDRequiresSticky,
+		},
+		{
+			name:       "静态成员使用其他分配策略",
+			instanceID: "i1",
+			balancers:  []kafkago.GroupBalancer{sticky, kafkago.RangeGroupBalancer{}},
+			wantErr:    ErrInstanceIDRequiresSticky,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			c := &Consumer{groupBalancers: tc.balancers, cfg: mq.NewConsumerConfig(mq.WithInstanceID(tc.instanceID))}
+			got, err := c.balancers()
+			assert.ErrorIs(t, err, tc.wantErr)
+			if err != nil {
+				return
+			}
+			assert.Len(t, got, len(tc.balancers))
+			if tc.wantData != nil {
+				data, err := got[0].UserData()
+				assert.NoError(t, err)
+				assert.Equal(t, tc.wantData, data)
+			}
+		})
+	}
+	// 共享同一个 StickyGroupBalancer 的消费者各自记录持有的分区
+	c1 := &Consumer{topic: "t1", groupBalancers: []kafkago.GroupBalancer{sticky}, cfg: mq.NewConsumerConfig()}
+	c2 := &Consumer{topic: "t1", groupBalancers: []kafkago.GroupBalancer{sticky}, cfg: mq.NewConsumerConfig()}
+	_, err := c1.balancers()
+	require.NoError(t, err)
+	_, err = c2.balancers()
+	require.NoError(t, err)
+	c1.stickyBalancers[0].setOwned(1, map[string][]int{"t1": {0}})
+	data, err := c2.stickyBalancers[0].UserData()
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(data))
+
+	_, err = NewConsumer([]string{"localhost:9092"}, "t1", "g1",
+		WithConsumerConfig(mq.NewConsumerConfig(mq.WithInstanceID("i1"))))
+	assert.ErrorIs(t, err, ErrInstanceIDRequiresSticky)
+}
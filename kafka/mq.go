@@ -20,10 +20,13 @@ import (
 	"net"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/ecodeclub/mq-api/internal/errs"
 
+	"github.com/ecodeclub/ekit/bean/option"
 	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
 	"github.com/ecodeclub/mq-api/internal/pkg/validator"
 	"github.com/pkg/errors"
 	kafkago "github.com/segmentio/kafka-go"
@@ -37,6 +40,15 @@ type MQ struct {
 	address           []string
 	controllerConn    *kafkago.Conn
 	replicationFactor int
+	// 消费者加入消费组时支持的分区分配策略，按照优先级排列
+	groupBalancers []kafkago.GroupBalancer
+	// 新建topic时使用的时间戳类型
+	timestampType mq.TimestampType
+	clock         clock.Clock
+	metrics       mq.Metrics
+	// 按照顺序套在生产者与消费者上的拦截器
+	producerInterceptors []mq.ProducerInterceptor
+	consumerInterceptors []mq.ConsumerInterceptor
 
 	locker   sync.RWMutex
 	closed   bool
@@ -44,9 +56,13 @@ type MQ struct {
 
 	producers []mq.Producer
 	consumers []mq.Consumer
+	// 每个发送过延迟消息的topic对应一个转发者
+	forwarders map[string]*delayForwarder
+	// 每个transactionalID最新的事务生产者
+	txnProducers map[string]*TxnProducer
 }
 
-func NewMQ(network string, address []string) (mq.MQ, error) {
+func NewMQ(network string, address []string, opts ...option.Option[MQ]) (mq.MQ, error) {
 	conn, err := kafkago.Dial(network, address[0])
 	if err != nil {
 		return nil, err
@@ -62,11 +78,60 @@ func NewMQ(network string, address []string) (mq.MQ, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &MQ{
+	m := &MQ{
 		address:           address,
 		controllerConn:    controllerConn,
 		replicationFactor: defaultReplicationFactor,
-	}, nil
+		clock:             clock.New(),
+		metrics:           mq.NopMetrics{},
+		forwarders:        map[string]*delayForwarder{},
+		txnProducers:      map[string]*TxnProducer{},
+	}
+	option.Apply(m, opts...)
+	return m, nil
+}
+
+// WithGroupBalancers 指定消费者使用的分区分配策略，对应 ConsumerGroupConfig.GroupBalancers，
+// 例如 kafkago.RoundRobinGroupBalancer 与 StickyGroupBalancer
+func WithGroupBalancers(balancers ...kafkago.GroupBalancer) option.Option[MQ] {
+	return func(m *MQ) {
+		m.groupBalancers = balancers
+	}
+}
+
+// WithTimestampType 指定新建topic的时间戳类型，对应topic配置message.timestamp.type，默认为 mq.TimestampCreateTime
+func WithTimestampType(timestampType mq.TimestampType) option.Option[MQ] {
+	return func(m *MQ) {
+		m.timestampType = timestampType
+	}
+}
+
+// WithClock 指定判断延迟消息是否到期使用的时钟，测试中可以使用 clock.Mock
+func WithClock(clk clock.Clock) option.Option[MQ] {
+	return func(m *MQ) {
+		m.clock = clk
+	}
+}
+
+// WithProducerInterceptors 为 Producer 创建的所有生产者按照顺序套上拦截器，第一个拦截器在最外层
+func WithProducerInterceptors(interceptors ...mq.ProducerInterceptor) option.Option[MQ] {
+	return func(m *MQ) {
+		m.producerInterceptors = interceptors
+	}
+}
+
+// WithConsumerInterceptors 为 Consumer 创建的所有消费者按照顺序套上拦截器，第一个拦截器在最外层
+func WithConsumerInterceptors(interceptors ...mq.ConsumerInterceptor) option.Option[MQ] {
+	return func(m *MQ) {
+		m.consumerInterceptors = interceptors
+	}
+}
+
+// WithMetrics 指定记录生产、消费与重平衡情况的 mq.Metrics
+func WithMetrics(metrics mq.Metrics) option.Option[MQ] {
+	return func(m *MQ) {
+		m.metrics = metrics
+	}
 }
 
 func (m *MQ) CreateTopic(ctx context.Context, name string, partitions int) error {
@@ -90,7 +155,20 @@ func (m *MQ) CreateTopic(ctx context.Context, name string, partitions int) error
 	}
 
 	cfg := kafkago.TopicConfig{Topic: name, NumPartitions: partitions, ReplicationFactor: m.replicationFactor}
-	return m.controllerConn.CreateTopics(cfg)
+	if m.timestampType == mq.TimestampLogAppendTime {
+		cfg.ConfigEntries = append(cfg.ConfigEntries, kafkago.ConfigEntry{
+			ConfigName:  "message.timestamp.type",
+			ConfigValue: "LogAppendTime",
+		})
+	}
+	createErr := m.controllerConn.CreateTopics(cfg)
+	if createErr != nil && !errors.Is(createErr, kafkago.TopicAlreadyExists) {
+		return createErr
+	}
+	if err := m.resumeDelayForwarder(ctx, name); err != nil {
+		return err
+	}
+	return createErr
 }
 
 // DeleteTopics 删除topic
@@ -122,13 +200,87 @@ func (m *MQ) Producer(topic string) (mq.Producer, error) {
 		return nil, fmt.Errorf("kafka: %w", errs.ErrMQIsClosed)
 	}
 
+	if err := m.resumeDelayForwarder(context.Background(), topic); err != nil {
+		return nil, err
+	}
+
 	balancer, _ := NewSpecifiedPartitionBalancer(&kafkago.Hash{})
-	p := NewProducer(m.address, topic, balancer)
+	p := NewProducer(m.address, topic, balancer,
+		WithProducerClock(m.clock),
+		WithProducerMetrics(m.metrics),
+		withPrepareDelay(func(ctx context.Context) error {
+			return m.startDelayForwarder(ctx, topic)
+		}))
 	m.producers = append(m.producers, p)
-	return p, nil
+	return mq.InterceptProducer(p, mq.ProducerInfo{Topic: topic}, m.producerInterceptors...), nil
 }
 
-func (m *MQ) Consumer(topic, groupID string) (mq.Consumer, error) {
+// startDelayForwarder 创建topic的延迟topic并启动转发者，已经启动过时直接返回
+func (m *MQ) startDelayForwarder(ctx context.Context, topic string) error {
+	m.locker.RLock()
+	_, ok := m.forwarders[topic]
+	closed := m.closed
+	m.locker.RUnlock()
+	if ok && !closed {
+		return nil
+	}
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	if m.closed {
+		return fmt.Errorf("kafka: %w", errs.ErrMQIsClosed)
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if _, ok := m.forwarders[topic]; ok {
+		return nil
+	}
+	err := m.controllerConn.CreateTopics(kafkago.TopicConfig{
+		Topic:             delayTopic(topic),
+		NumPartitions:     1,
+		ReplicationFactor: m.replicationFactor,
+	})
+	if err != nil {
+		return err
+	}
+	return m.addDelayForwarder(topic)
+}
+
+// resumeDelayForwarder 延迟topic已经存在时启动转发者，进程重启或者只消费topic时，之前写入的延迟消息也会到期转发。
+// 调用者需要持有锁
+func (m *MQ) resumeDelayForwarder(ctx context.Context, topic string) error {
+	if _, ok := m.forwarders[topic]; ok {
+		return nil
+	}
+	// 使用Client查询，避免broker开启自动创建topic时创建延迟topic
+	client := &kafkago.Client{Addr: kafkago.TCP(m.address...)}
+	resp, err := client.Metadata(ctx, &kafkago.MetadataRequest{Topics: []string{delayTopic(topic)}})
+	if err != nil {
+		return err
+	}
+	for _, t := range resp.Topics {
+		if t.Name == delayTopic(topic) && t.Error == nil && len(t.Partitions) > 0 {
+			return m.addDelayForwarder(topic)
+		}
+	}
+	return nil
+}
+
+// addDelayForwarder 启动topic的转发者，调用者需要持有锁
+func (m *MQ) addDelayForwarder(topic string) error {
+	f, err := newDelayForwarder(m.address, topic, m.clock)
+	if err != nil {
+		return err
+	}
+	m.forwarders[topic] = f
+	return nil
+}
+
+func (m *MQ) Consumer(topic, groupID string, opts ...option.Option[mq.ConsumerConfig]) (mq.Consumer, error) {
 	m.locker.Lock()
 	defer m.locker.Unlock()
 
@@ -136,11 +288,82 @@ func (m *MQ) Consumer(topic, groupID string) (mq.Consumer, error) {
 		return nil, fmt.Errorf("kafka: %w", errs.ErrMQIsClosed)
 	}
 
-	c := NewConsumer(m.address, topic, groupID)
+	if err := m.resumeDelayForwarder(context.Background(), topic); err != nil {
+		return nil, err
+	}
+
+	c, err := NewConsumer(m.address, topic, groupID,
+		WithConsumerGroupBalancers(m.groupBalancers...),
+		WithConsumerMetrics(m.metrics),
+		WithConsumerConfig(mq.NewConsumerConfig(opts...)))
+	if err != nil {
+		return nil, err
+	}
 	m.consumers = append(m.consumers, c)
 
 	go c.getMsgFromKafka()
-	return c, nil
+	return mq.InterceptConsumer(c, mq.ConsumerInfo{Topic: topic, GroupID: groupID}, m.consumerInterceptors...), nil
+}
+
+func (m *MQ) OffsetsForTime(ctx context.Context, topic string, t time.Time) (map[int]int64, error) {
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+
+	if m.closed {
+		return nil, fmt.Errorf("kafka: %w", errs.ErrMQIsClosed)
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	partitions, err := m.controllerConn.ReadPartitions(topic)
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]kafkago.OffsetRequest, 0, len(partitions))
+	for _, p := range partitions {
+		requests = append(requests, kafkago.TimeOffsetOf(p.ID, t))
+	}
+	client := &kafkago.Client{Addr: kafkago.TCP(m.address...)}
+	resp, err := client.ListOffsets(ctx, &kafkago.ListOffsetsRequest{
+		Topics: map[string][]kafkago.OffsetRequest{topic: requests},
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[int]int64, len(partitions))
+	// 分区中没有满足条件的消息时，broker不会返回偏移量，需要再查询分区的末尾
+	latest := make([]kafkago.OffsetRequest, 0, len(partitions))
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return nil, p.Error
+		}
+		found := false
+		for offset := range p.Offsets {
+			res[p.Partition] = offset
+			found = true
+		}
+		if !found {
+			latest = append(latest, kafkago.LastOffsetOf(p.Partition))
+		}
+	}
+	if len(latest) == 0 {
+		return res, nil
+	}
+	resp, err = client.ListOffsets(ctx, &kafkago.ListOffsetsRequest{
+		Topics: map[string][]kafkago.OffsetRequest{topic: latest},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return nil, p.Error
+		}
+		res[p.Partition] = p.LastOffset
+	}
+	return res, nil
 }
 
 func (m *MQ) Close() error {
@@ -155,6 +378,9 @@ func (m *MQ) Close() error {
 		for _, c := range m.consumers {
 			errorList = append(errorList, c.Close())
 		}
+		for _, f := range m.forwarders {
+			errorList = append(errorList, f.Close())
+		}
 		errorList = append(errorList, m.controllerConn.Close())
 		m.closeErr = multierr.Combine(errorList...)
 
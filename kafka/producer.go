@@ -23,11 +23,14 @@ import (
 
 	"github.com/ecodeclub/mq-api/internal/errs"
 
+	"github.com/ecodeclub/ekit/bean/option"
 	"github.com/ecodeclub/ekit/retry"
 	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
 	"github.com/ecodeclub/mq-api/kafka/common"
 	"github.com/pkg/errors"
 	kafkago "github.com/segmentio/kafka-go"
+	"go.uber.org/multierr"
 )
 
 const (
@@ -38,25 +41,60 @@ type Producer struct {
 	topic  string
 	writer *kafkago.Writer
 	locker *sync.RWMutex
+	clock  clock.Clock
+	// 延迟消息先写入延迟topic，由 delayForwarder 到期后转发回topic
+	delayWriter *kafkago.Writer
+	// 发送延迟消息之前调用，用于创建延迟topic并启动转发
+	prepareDelay func(ctx context.Context) error
+	metrics      mq.Metrics
 
 	closeOnce *sync.Once
 	closed    bool
 	closeErr  error
 }
 
-func NewProducer(address []string, topic string, balancer kafkago.Balancer) *Producer {
-	return &Producer{
-		topic:  topic,
-		locker: &sync.RWMutex{},
+func NewProducer(address []string, topic string, balancer kafkago.Balancer, opts ...option.Option[Producer]) *Producer {
+	p := &Producer{
+		topic:   topic,
+		locker:  &sync.RWMutex{},
+		clock:   clock.New(),
+		metrics: mq.NopMetrics{},
 		writer: &kafkago.Writer{
 			Addr:      kafkago.TCP(address...),
 			Topic:     topic,
 			Balancer:  balancer,
 			BatchSize: defaultBatchSize,
 		},
+		delayWriter: &kafkago.Writer{
+			Addr:      kafkago.TCP(address...),
+			Topic:     delayTopic(topic),
+			BatchSize: defaultBatchSize,
+		},
 		closed:    false,
 		closeOnce: &sync.Once{},
 	}
+	option.Apply(p, opts...)
+	return p
+}
+
+// WithProducerClock 指定判断延迟消息是否到期以及生成消息时间戳使用的时钟
+func WithProducerClock(clk clock.Clock) option.Option[Producer] {
+	return func(p *Producer) {
+		p.clock = clk
+	}
+}
+
+// WithProducerMetrics 指定记录发送次数、耗时与重试次数的 mq.Metrics
+func WithProducerMetrics(metrics mq.Metrics) option.Option[Producer] {
+	return func(p *Producer) {
+		p.metrics = metrics
+	}
+}
+
+func withPrepareDelay(prepare func(ctx context.Context) error) option.Option[Producer] {
+	return func(p *Producer) {
+		p.prepareDelay = prepare
+	}
 }
 
 func (p *Producer) Produce(ctx context.Context, m *mq.Message) (*mq.ProducerResult, error) {
@@ -68,9 +106,24 @@ func (p *Producer) ProduceWithPartition(ctx context.Context, m *mq.Message, part
 	return p.produce(ctx, m, metaMessage{SpecifiedPartitionKey: partition})
 }
 
+// ProduceAt 未到期的消息先写入延迟topic <topic>.delay，由 delayForwarder 到期后转发回topic
+func (p *Producer) ProduceAt(ctx context.Context, m *mq.Message, at time.Time) (*mq.ProducerResult, error) {
+	if !at.After(p.clock.Now()) {
+		return p.produce(ctx, m, nil)
+	}
+	if p.prepareDelay != nil {
+		if err := p.prepareDelay(ctx); err != nil {
+			return &mq.ProducerResult{}, err
+		}
+	}
+	return p.write(ctx, p.delayWriter, withDelayDue(newKafkaMessage(p.clock, m, nil), at))
+}
+
 func (p *Producer) produce(ctx context.Context, m *mq.Message, meta metaMessage) (*mq.ProducerResult, error) {
-	message := p.newKafkaMessage(m, meta)
+	return p.write(ctx, p.writer, newKafkaMessage(p.clock, m, meta))
+}
 
+func (p *Producer) write(ctx context.Context, writer *kafkago.Writer, message kafkago.Message) (*mq.ProducerResult, error) {
 	const (
 		initialInterval = 100 * time.Millisecond
 		maxInterval     = 10 * time.Second
@@ -79,32 +132,45 @@ func (p *Producer) produce(ctx context.Context, m *mq.Message, meta metaMessage)
 
 	strategy, _ := retry.NewExponentialBackoffRetryStrategy(initialInterval, maxInterval, maxRetries)
 
+	start := time.Now()
 	for {
-		err := p.writer.WriteMessages(ctx, message)
+		err := writer.WriteMessages(ctx, message)
 		if err == nil {
+			p.metrics.ObserveProduce(writer.Topic, time.Since(start), nil)
 			return &mq.ProducerResult{}, nil
 		}
 		if errors.Is(err, io.ErrClosedPipe) {
-			return &mq.ProducerResult{}, fmt.Errorf("kafka: %w", errs.ErrProducerIsClosed)
+			err = fmt.Errorf("kafka: %w", errs.ErrProducerIsClosed)
+			p.metrics.ObserveProduce(writer.Topic, time.Since(start), err)
+			return &mq.ProducerResult{}, err
 		}
 		// 控制流走到这Topic和Partition已经验证合法
 		// 要么选主阶段、要么分区在broker间移动,因此这两种情况需要重试
 		if errors.Is(err, kafkago.LeaderNotAvailable) || errors.Is(err, kafkago.UnknownTopicOrPartition) {
 			duration, ok := strategy.Next()
 			if ok {
+				p.metrics.ObserveProduceRetry(writer.Topic)
 				time.Sleep(duration)
 				continue
 			}
 		}
+		p.metrics.ObserveProduce(writer.Topic, time.Since(start), err)
 		return &mq.ProducerResult{}, err
 	}
 }
 
-func (p *Producer) newKafkaMessage(m *mq.Message, meta metaMessage) kafkago.Message {
+// newKafkaMessage 不会修改m，没有设置时间戳时只在返回的消息中使用当前时间
+func newKafkaMessage(clk clock.Clock, m *mq.Message, meta metaMessage) kafkago.Message {
+	ts := m.Timestamp
+	if ts.IsZero() {
+		ts = clk.Now()
+	}
 	message := kafkago.Message{
 		Value:   m.Value,
 		Key:     m.Key,
 		Headers: common.ConvertToKafkaHeader(m.Header),
+		// topic的时间戳类型为LogAppendTime时，broker会用写入时间覆盖它
+		Time: ts,
 	}
 	if meta != nil {
 		message.WriterData = meta
@@ -112,9 +178,14 @@ func (p *Producer) newKafkaMessage(m *mq.Message, meta metaMessage) kafkago.Mess
 	return message
 }
 
+// Clock 返回 WithProducerClock 指定的时钟，mq.ProduceWithDelay 使用它计算到期时间
+func (p *Producer) Clock() clock.Clock {
+	return p.clock
+}
+
 func (p *Producer) Close() error {
 	p.closeOnce.Do(func() {
-		p.closeErr = p.writer.Close()
+		p.closeErr = multierr.Combine(p.writer.Close(), p.delayWriter.Close())
 	})
 	return p.closeErr
 }
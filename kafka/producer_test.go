@@ -0,0 +1,42 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewKafkaMessage(t *testing.T) {
+	t.Parallel()
+	now := time.UnixMilli(1700000000000)
+	clk := clock.NewMock(now)
+
+	m := &mq.Message{Key: []byte("k"), Value: []byte("v"), Header: mq.Header{{Key: "h", Value: []byte("1")}}}
+	message := newKafkaMessage(clk, m, nil)
+	assert.Equal(t, now, message.Time)
+	assert.Equal(t, []byte("v"), message.Value)
+	assert.Equal(t, "h", message.Headers[0].Key)
+	// 不会修改调用者的消息
+	assert.True(t, m.Timestamp.IsZero())
+
+	ts := now.Add(-time.Hour)
+	m.Timestamp = ts
+	assert.Equal(t, ts, newKafkaMessage(clk, m, nil).Time)
+}
@@ -0,0 +1,630 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/ekit/retry"
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
+	"github.com/ecodeclub/mq-api/internal/errs"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"go.uber.org/multierr"
+)
+
+const (
+	// 事务超过这个时间没有结束时会被协调者回滚，对应transaction.timeout.ms
+	defaultTxnTimeout = time.Minute
+	// 创建事务生产者时等待协调者分配producer id的时间
+	txnInitTimeout = 30 * time.Second
+)
+
+// ErrTxnFailed 事务中有消息发送失败时，broker上的序列号已经无法确定，只能回滚
+var ErrTxnFailed = errors.New("kafka: 事务中有发送失败的消息，只能回滚")
+
+var _ mq.TxnMQ = &MQ{}
+
+// TxnProducer 创建事务生产者，创建时会向协调者申请producer id，使用相同transactionalID的旧生产者进行中的事务会被回滚。
+// 消费事务消息时需要使用 mq.WithIsolationLevel(mq.ReadCommitted) 创建消费者
+func (m *MQ) TxnProducer(topic, transactionalID string) (mq.TxnProducer, error) {
+	m.locker.RLock()
+	closed := m.closed
+	m.locker.RUnlock()
+	if closed {
+		return nil, fmt.Errorf("kafka: %w", errs.ErrMQIsClosed)
+	}
+
+	balancer, _ := NewSpecifiedPartitionBalancer(&kafkago.Hash{})
+	ctx, cancel := context.WithTimeout(context.Background(), txnInitTimeout)
+	defer cancel()
+	// 申请producer id需要访问网络，不持有锁
+	p, err := NewTxnProducer(ctx, m.address, topic, transactionalID, balancer,
+		WithTxnProducerClock(m.clock),
+		WithTxnProducerMetrics(m.metrics),
+		withTxnPrepareDelay(func(ctx context.Context) error {
+			return m.startDelayForwarder(ctx, topic)
+		}))
+	if err != nil {
+		return nil, err
+	}
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if m.closed {
+		return nil, multierr.Append(fmt.Errorf("kafka: %w", errs.ErrMQIsClosed), p.Close())
+	}
+	if err = m.resumeDelayForwarder(ctx, topic); err != nil {
+		return nil, multierr.Append(err, p.Close())
+	}
+	if old, ok := m.txnProducers[transactionalID]; ok {
+		old.fence()
+	}
+	m.txnProducers[transactionalID] = p
+	m.producers = append(m.producers, p)
+	return mq.InterceptTxnProducer(p, mq.ProducerInfo{Topic: topic}, m.producerInterceptors...), nil
+}
+
+// isolationLevel 转换为kafka-go的isolation.level
+func isolationLevel(level mq.IsolationLevel) kafkago.IsolationLevel {
+	if level == mq.ReadCommitted {
+		return kafkago.ReadCommitted
+	}
+	return kafkago.ReadUncommitted
+}
+
+// topicPartition 事务中写入的分区
+type topicPartition struct {
+	topic     string
+	partition int
+}
+
+// transaction 是 TxnProducer 进行中的事务
+type transaction struct {
+	// 已经加入事务的分区与消费组，提交或者回滚时都需要通知协调者
+	partitions map[topicPartition]struct{}
+	groups     map[string]struct{}
+	// 有消息发送失败
+	failed bool
+}
+
+func newTransaction() *transaction {
+	return &transaction{
+		partitions: map[topicPartition]struct{}{},
+		groups:     map[string]struct{}{},
+	}
+}
+
+// empty 事务中没有写入消息也没有提交消费进度时，协调者不知道这个事务，结束时不需要通知
+func (t *transaction) empty() bool {
+	return len(t.partitions) == 0 && len(t.groups) == 0
+}
+
+// TxnProducer 是kafka的事务生产者。kafka-go的Writer编码消息时固定使用-1作为producer id与epoch，
+// 因此事务中的消息由 TxnProducer 自己编码成v2格式的record batch，再通过 kafkago.Client.RawProduce 发送。
+// 每条消息单独发送，发送成功之后才会返回
+type TxnProducer struct {
+	topic           string
+	transactionalID string
+	client          *kafkago.Client
+	balancer        kafkago.Balancer
+	clock           clock.Clock
+	metrics         mq.Metrics
+	txnTimeout      time.Duration
+	// 发送延迟消息之前调用，用于创建延迟topic并启动转发
+	prepareDelay func(ctx context.Context) error
+
+	locker        sync.Mutex
+	producerID    int
+	producerEpoch int
+	// 每个分区下一条消息的序列号，broker用它来去掉重试时重复写入的消息
+	sequences map[topicPartition]int32
+	// topic的分区，第一次发送消息时获取
+	partitions []int
+	txn        *transaction
+	closed     bool
+	fenced     bool
+}
+
+// NewTxnProducer 创建事务生产者，并向协调者申请producer id
+func NewTxnProducer(ctx context.Context, address []string, topic, transactionalID string, balancer kafkago.Balancer,
+	opts ...option.Option[TxnProducer]) (*TxnProducer, error) {
+	p := &TxnProducer{
+		topic:           topic,
+		transactionalID: transactionalID,
+		client:          &kafkago.Client{Addr: kafkago.TCP(address...)},
+		balancer:        balancer,
+		clock:           clock.New(),
+		metrics:         mq.NopMetrics{},
+		txnTimeout:      defaultTxnTimeout,
+	}
+	option.Apply(p, opts...)
+	if err := p.initProducerID(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// WithTxnProducerClock 指定判断延迟消息是否到期以及生成消息时间戳使用的时钟
+func WithTxnProducerClock(clk clock.Clock) option.Option[TxnProducer] {
+	return func(p *TxnProducer) {
+		p.clock = clk
+	}
+}
+
+// WithTxnProducerMetrics 指定记录发送次数与耗时的 mq.Metrics
+func WithTxnProducerMetrics(metrics mq.Metrics) option.Option[TxnProducer] {
+	return func(p *TxnProducer) {
+		p.metrics = metrics
+	}
+}
+
+// WithTxnTimeout 指定事务的超时时间，超过这个时间没有结束的事务会被协调者回滚，默认为一分钟
+func WithTxnTimeout(timeout time.Duration) option.Option[TxnProducer] {
+	return func(p *TxnProducer) {
+		p.txnTimeout = timeout
+	}
+}
+
+func withTxnPrepareDelay(prepare func(ctx context.Context) error) option.Option[TxnProducer] {
+	return func(p *TxnProducer) {
+		p.prepareDelay = prepare
+	}
+}
+
+// initProducerID 申请新的producer id与epoch，协调者会回滚同一个transactionalID进行中的事务，
+// 并拒绝旧epoch之后的请求。序列号随之从0开始
+func (p *TxnProducer) initProducerID(ctx context.Context) error {
+	return p.retry(ctx, func() error {
+		resp, err := p.client.InitProducerID(ctx, &kafkago.InitProducerIDRequest{
+			TransactionalID:      p.transactionalID,
+			TransactionTimeoutMs: int(p.txnTimeout.Milliseconds()),
+			ProducerID:           -1,
+			ProducerEpoch:        -1,
+		})
+		if err != nil {
+			return err
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		p.producerID, p.producerEpoch = resp.Producer.ProducerID, resp.Producer.ProducerEpoch
+		p.sequences = map[topicPartition]int32{}
+		return nil
+	})
+}
+
+// checkProducer 检查生产者是否可用，调用者需要持有锁
+func (p *TxnProducer) checkProducer(ctx context.Context) error {
+	if p.closed {
+		return fmt.Errorf("kafka: %w", errs.ErrProducerIsClosed)
+	}
+	if p.fenced {
+		return fmt.Errorf("kafka: %w", mq.ErrProducerFenced)
+	}
+	return ctx.Err()
+}
+
+// check 检查是否可以在事务中操作，调用者需要持有锁
+func (p *TxnProducer) check(ctx context.Context) error {
+	if err := p.checkProducer(ctx); err != nil {
+		return err
+	}
+	if p.txn == nil {
+		return mq.ErrNoTxn
+	}
+	return nil
+}
+
+func (p *TxnProducer) BeginTxn(ctx context.Context) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if err := p.checkProducer(ctx); err != nil {
+		return err
+	}
+	if p.txn != nil {
+		return mq.ErrTxnInProgress
+	}
+	p.txn = newTransaction()
+	return nil
+}
+
+func (p *TxnProducer) Produce(ctx context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+	return p.produce(ctx, m, nil)
+}
+
+// ProduceWithPartition 并没有校验 partition 的正确性。
+func (p *TxnProducer) ProduceWithPartition(ctx context.Context, m *mq.Message, partition int) (*mq.ProducerResult, error) {
+	return p.produce(ctx, m, metaMessage{SpecifiedPartitionKey: partition})
+}
+
+// ProduceAt 未到期的消息在事务中写入延迟topic，事务提交之后才会被转发者读到，回滚时直接丢弃
+func (p *TxnProducer) ProduceAt(ctx context.Context, m *mq.Message, at time.Time) (*mq.ProducerResult, error) {
+	if !at.After(p.clock.Now()) {
+		return p.produce(ctx, m, nil)
+	}
+	if p.prepareDelay != nil {
+		if err := p.prepareDelay(ctx); err != nil {
+			return &mq.ProducerResult{}, err
+		}
+	}
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if err := p.check(ctx); err != nil {
+		return &mq.ProducerResult{}, err
+	}
+	// 延迟topic只有一个分区
+	message := withDelayDue(newKafkaMessage(p.clock, m, nil), at)
+	return &mq.ProducerResult{}, p.write(ctx, topicPartition{topic: delayTopic(p.topic)}, message)
+}
+
+func (p *TxnProducer) produce(ctx context.Context, m *mq.Message, meta metaMessage) (*mq.ProducerResult, error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if err := p.check(ctx); err != nil {
+		return &mq.ProducerResult{}, err
+	}
+	partitions, err := p.loadPartitions(ctx)
+	if err != nil {
+		return &mq.ProducerResult{}, err
+	}
+	message := newKafkaMessage(p.clock, m, meta)
+	tp := topicPartition{topic: p.topic, partition: p.balancer.Balance(message, partitions...)}
+	return &mq.ProducerResult{}, p.write(ctx, tp, message)
+}
+
+// loadPartitions 获取topic的分区，调用者需要持有锁
+func (p *TxnProducer) loadPartitions(ctx context.Context) ([]int, error) {
+	if p.partitions != nil {
+		return p.partitions, nil
+	}
+	resp, err := p.client.Metadata(ctx, &kafkago.MetadataRequest{Topics: []string{p.topic}})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range resp.Topics {
+		if t.Name != p.topic {
+			continue
+		}
+		if t.Error != nil {
+			return nil, t.Error
+		}
+		partitions := make([]int, 0, len(t.Partitions))
+		for _, partition := range t.Partitions {
+			partitions = append(partitions, partition.ID)
+		}
+		p.partitions = partitions
+		return partitions, nil
+	}
+	return nil, fmt.Errorf("kafka: %w", kafkago.UnknownTopicOrPartition)
+}
+
+// write 把分区加入事务之后发送消息，调用者需要持有锁
+func (p *TxnProducer) write(ctx context.Context, tp topicPartition, message kafkago.Message) error {
+	start := time.Now()
+	err := p.addPartition(ctx, tp)
+	if err == nil {
+		err = p.send(ctx, tp, message)
+	}
+	err = p.txnError(err)
+	p.metrics.ObserveProduce(tp.topic, time.Since(start), err)
+	// 被取代之后事务已经不存在
+	if err != nil && p.txn != nil {
+		p.txn.failed = true
+	}
+	return err
+}
+
+func (p *TxnProducer) addPartition(ctx context.Context, tp topicPartition) error {
+	if _, ok := p.txn.partitions[tp]; ok {
+		return nil
+	}
+	err := p.retry(ctx, func() error {
+		resp, err := p.client.AddPartitionsToTxn(ctx, &kafkago.AddPartitionsToTxnRequest{
+			TransactionalID: p.transactionalID,
+			ProducerID:      p.producerID,
+			ProducerEpoch:   p.producerEpoch,
+			Topics:          map[string][]kafkago.AddPartitionToTxn{tp.topic: {{Partition: tp.partition}}},
+		})
+		if err != nil {
+			return err
+		}
+		for _, partition := range resp.Topics[tp.topic] {
+			if partition.Error != nil {
+				return partition.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p.txn.partitions[tp] = struct{}{}
+	return nil
+}
+
+func (p *TxnProducer) send(ctx context.Context, tp topicPartition, message kafkago.Message) error {
+	sequence := p.sequences[tp]
+	batch, err := encodeTxnBatch(p.producerID, p.producerEpoch, sequence, message)
+	if err != nil {
+		return err
+	}
+	err = p.retry(ctx, func() error {
+		resp, err := p.client.RawProduce(ctx, &kafkago.RawProduceRequest{
+			Topic:           tp.topic,
+			Partition:       tp.partition,
+			RequiredAcks:    kafkago.RequireAll,
+			TransactionalID: p.transactionalID,
+			RawRecords:      protocol.RawRecordSet{Reader: bytes.NewReader(batch)},
+		})
+		if err != nil {
+			return err
+		}
+		// 重试之前的请求已经写入成功
+		if errors.Is(resp.Error, kafkago.DuplicateSequenceNumber) {
+			return nil
+		}
+		return resp.Error
+	})
+	if err != nil {
+		return err
+	}
+	p.sequences[tp] = sequence + 1
+	return nil
+}
+
+// encodeTxnBatch 把消息编码成只有一条消息的事务record batch，结果可以直接作为 protocol.RawRecordSet 的内容。
+// protocol.RecordSet 编码时固定使用-1作为producer id、epoch与序列号，这里按照v2格式的偏移量回填之后重新计算crc
+func encodeTxnBatch(producerID, producerEpoch int, sequence int32, message kafkago.Message) ([]byte, error) {
+	const (
+		// RecordSet.WriteTo 在record batch之前写入的长度
+		sizeLength       = 4
+		crcOffset        = 17
+		attributesOffset = 21
+		producerIDOffset = 43
+		epochOffset      = 51
+		sequenceOffset   = 53
+	)
+	rs := protocol.RecordSet{
+		Version:    2,
+		Attributes: protocol.Transactional,
+		Records: protocol.NewRecordReader(protocol.Record{
+			Time:    message.Time,
+			Key:     protocol.NewBytes(message.Key),
+			Value:   protocol.NewBytes(message.Value),
+			Headers: message.Headers,
+		}),
+	}
+	buf := &bytes.Buffer{}
+	if _, err := rs.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	batch := data[sizeLength:]
+	binary.BigEndian.PutUint64(batch[producerIDOffset:], uint64(producerID))
+	binary.BigEndian.PutUint16(batch[epochOffset:], uint16(producerEpoch))
+	binary.BigEndian.PutUint32(batch[sequenceOffset:], uint32(sequence))
+	binary.BigEndian.PutUint32(batch[crcOffset:],
+		crc32.Checksum(batch[attributesOffset:], crc32.MakeTable(crc32.Castagnoli)))
+	return data, nil
+}
+
+func (p *TxnProducer) SendOffsetsToTxn(ctx context.Context, groupID string, msgs ...*mq.Message) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if err := p.check(ctx); err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	if _, ok := p.txn.groups[groupID]; !ok {
+		err := p.retry(ctx, func() error {
+			resp, err := p.client.AddOffsetsToTxn(ctx, &kafkago.AddOffsetsToTxnRequest{
+				TransactionalID: p.transactionalID,
+				ProducerID:      p.producerID,
+				ProducerEpoch:   p.producerEpoch,
+				GroupID:         groupID,
+			})
+			if err != nil {
+				return err
+			}
+			return resp.Error
+		})
+		if err != nil {
+			return p.txnError(err)
+		}
+		p.txn.groups[groupID] = struct{}{}
+	}
+	err := p.retry(ctx, func() error {
+		resp, err := p.client.TxnOffsetCommit(ctx, &kafkago.TxnOffsetCommitRequest{
+			TransactionalID: p.transactionalID,
+			GroupID:         groupID,
+			ProducerID:      p.producerID,
+			ProducerEpoch:   p.producerEpoch,
+			// 生产者不是消费组的成员，协调者不检查代与成员
+			GenerationID: -1,
+			Topics:       txnOffsets(msgs),
+		})
+		if err != nil {
+			return err
+		}
+		for _, partitions := range resp.Topics {
+			for _, partition := range partitions {
+				if partition.Error != nil {
+					return partition.Error
+				}
+			}
+		}
+		return nil
+	})
+	return p.txnError(err)
+}
+
+// txnOffsets 与 Consumer.Commit 相同，提交的是每个分区最后一条消息的下一个偏移量
+func txnOffsets(msgs []*mq.Message) map[string][]kafkago.TxnOffsetCommit {
+	next := map[topicPartition]int64{}
+	for _, msg := range msgs {
+		tp := topicPartition{topic: msg.Topic, partition: int(msg.Partition)}
+		if offset, ok := next[tp]; !ok || msg.Offset+1 > offset {
+			next[tp] = msg.Offset + 1
+		}
+	}
+	res := make(map[string][]kafkago.TxnOffsetCommit, len(next))
+	for tp, offset := range next {
+		res[tp.topic] = append(res[tp.topic], kafkago.TxnOffsetCommit{Partition: tp.partition, Offset: offset})
+	}
+	return res
+}
+
+func (p *TxnProducer) CommitTxn(ctx context.Context) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if err := p.check(ctx); err != nil {
+		return err
+	}
+	if p.txn.failed {
+		return ErrTxnFailed
+	}
+	if err := p.endTxn(ctx, true); err != nil {
+		return err
+	}
+	p.txn = nil
+	return nil
+}
+
+func (p *TxnProducer) AbortTxn(ctx context.Context) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if err := p.check(ctx); err != nil {
+		return err
+	}
+	failed := p.txn.failed
+	if err := p.abort(ctx); err != nil {
+		return err
+	}
+	if failed {
+		// 发送失败的消息可能已经写入，重新申请producer id之后序列号从0开始
+		return p.txnError(p.initProducerID(ctx))
+	}
+	return nil
+}
+
+// abort 回滚进行中的事务，调用者需要持有锁
+func (p *TxnProducer) abort(ctx context.Context) error {
+	if p.txn == nil {
+		return nil
+	}
+	if err := p.endTxn(ctx, false); err != nil {
+		return err
+	}
+	p.txn = nil
+	return nil
+}
+
+// endTxn 通知协调者结束事务，调用者需要持有锁
+func (p *TxnProducer) endTxn(ctx context.Context, committed bool) error {
+	if p.txn.empty() {
+		return nil
+	}
+	err := p.retry(ctx, func() error {
+		resp, err := p.client.EndTxn(ctx, &kafkago.EndTxnRequest{
+			TransactionalID: p.transactionalID,
+			ProducerID:      p.producerID,
+			ProducerEpoch:   p.producerEpoch,
+			Committed:       committed,
+		})
+		if err != nil {
+			return err
+		}
+		return resp.Error
+	})
+	return p.txnError(err)
+}
+
+// txnError 把协调者拒绝旧epoch的错误转换为 mq.ErrProducerFenced，之后的调用都会返回这个错误。调用者需要持有锁
+func (p *TxnProducer) txnError(err error) error {
+	if errors.Is(err, kafkago.ProducerFenced) || errors.Is(err, kafkago.InvalidProducerEpoch) {
+		p.fenced = true
+		p.txn = nil
+		return fmt.Errorf("kafka: %w", mq.ErrProducerFenced)
+	}
+	return err
+}
+
+// retry 重试临时错误。上一个事务还没有完全结束时协调者返回 kafkago.ConcurrentTransactions，也需要重试
+func (p *TxnProducer) retry(ctx context.Context, fn func() error) error {
+	const (
+		initialInterval = 100 * time.Millisecond
+		maxInterval     = 10 * time.Second
+		maxRetries      = 50
+	)
+	strategy, _ := retry.NewExponentialBackoffRetryStrategy(initialInterval, maxInterval, maxRetries)
+	for {
+		err := fn()
+		var kafkaErr kafkago.Error
+		if err == nil || !errors.As(err, &kafkaErr) ||
+			(!kafkaErr.Temporary() && kafkaErr != kafkago.ConcurrentTransactions) {
+			return err
+		}
+		duration, ok := strategy.Next()
+		if !ok {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(duration):
+		}
+	}
+}
+
+// fence 在同一个MQ中使用相同transactionalID的新生产者创建时调用，协调者已经回滚了进行中的事务
+func (p *TxnProducer) fence() {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.fenced = true
+	p.txn = nil
+}
+
+// Clock 返回 WithTxnProducerClock 指定的时钟，mq.ProduceWithDelay 使用它计算到期时间
+func (p *TxnProducer) Clock() clock.Clock {
+	return p.clock
+}
+
+// Close 回滚进行中的事务
+func (p *TxnProducer) Close() error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	if p.fenced {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), txnInitTimeout)
+	defer cancel()
+	return p.abort(ctx)
+}
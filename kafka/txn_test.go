@@ -0,0 +1,157 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/internal/errs"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMQ_TxnProducer(t *testing.T) {
+	t.Parallel()
+	_, err := (&MQ{closed: true}).TxnProducer("topic", "tx")
+	assert.ErrorIs(t, err, errs.ErrMQIsClosed)
+	assert.Equal(t, kafkago.ReadCommitted, isolationLevel(mq.ReadCommitted))
+	assert.Equal(t, kafkago.ReadUncommitted, isolationLevel(mq.ReadUncommitted))
+}
+
+func TestEncodeTxnBatch(t *testing.T) {
+	t.Parallel()
+	now := time.UnixMilli(1700000000000)
+	data, err := encodeTxnBatch(1234, 5, 42, kafkago.Message{
+		Key:     []byte("k"),
+		Value:   []byte("v"),
+		Headers: []kafkago.Header{{Key: "h", Value: []byte("1")}},
+		Time:    now,
+	})
+	require.NoError(t, err)
+
+	// 解码时会校验crc
+	rs := &protocol.RecordSet{}
+	_, err = rs.ReadFrom(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.True(t, rs.Attributes.Transactional())
+	stream, ok := rs.Records.(*protocol.RecordStream)
+	require.True(t, ok)
+	require.Len(t, stream.Records, 1)
+	batch, ok := stream.Records[0].(*protocol.RecordBatch)
+	require.True(t, ok)
+	assert.Equal(t, int64(1234), batch.ProducerID)
+	assert.Equal(t, int16(5), batch.ProducerEpoch)
+	assert.Equal(t, int32(42), batch.BaseSequence)
+
+	r, err := batch.ReadRecord()
+	require.NoError(t, err)
+	key, err := protocol.ReadAll(r.Key)
+	require.NoError(t, err)
+	value, err := protocol.ReadAll(r.Value)
+	require.NoError(t, err)
+	assert.Equal(t, "k", string(key))
+	assert.Equal(t, "v", string(value))
+	assert.Equal(t, []kafkago.Header{{Key: "h", Value: []byte("1")}}, r.Headers)
+	assert.Equal(t, now, r.Time)
+}
+
+func TestTxnOffsets(t *testing.T) {
+	t.Parallel()
+	offsets := txnOffsets([]*mq.Message{
+		{Topic: "a", Partition: 0, Offset: 3},
+		{Topic: "a", Partition: 0, Offset: 1},
+		{Topic: "a", Partition: 1, Offset: 7},
+		{Topic: "b", Partition: 0, Offset: 0},
+	})
+	assert.ElementsMatch(t, []kafkago.TxnOffsetCommit{{Partition: 0, Offset: 4}, {Partition: 1, Offset: 8}}, offsets["a"])
+	assert.Equal(t, []kafkago.TxnOffsetCommit{{Partition: 0, Offset: 1}}, offsets["b"])
+}
+
+func TestTxnProducer_txnError(t *testing.T) {
+	t.Parallel()
+	p := &TxnProducer{txn: newTransaction()}
+	err := errors.New("mock error")
+	assert.Equal(t, err, p.txnError(err))
+	assert.False(t, p.fenced)
+
+	assert.ErrorIs(t, p.txnError(kafkago.ProducerFenced), mq.ErrProducerFenced)
+	assert.True(t, p.fenced)
+	assert.Nil(t, p.txn)
+	_, err = p.Produce(context.Background(), &mq.Message{})
+	assert.ErrorIs(t, err, mq.ErrProducerFenced)
+	assert.ErrorIs(t, p.BeginTxn(context.Background()), mq.ErrProducerFenced)
+}
+
+func TestTxnProducer_retry(t *testing.T) {
+	t.Parallel()
+	p := &TxnProducer{}
+	calls := 0
+	err := p.retry(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return kafkago.ConcurrentTransactions
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = p.retry(context.Background(), func() error {
+		calls++
+		return kafkago.InvalidTransactionState
+	})
+	assert.ErrorIs(t, err, kafkago.InvalidTransactionState)
+	assert.Equal(t, 1, calls)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = p.retry(ctx, func() error {
+		return kafkago.ConcurrentTransactions
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestTxnProducer_NoTxn(t *testing.T) {
+	t.Parallel()
+	p := &TxnProducer{}
+	ctx := context.Background()
+	_, err := p.Produce(ctx, &mq.Message{})
+	assert.ErrorIs(t, err, mq.ErrNoTxn)
+	assert.ErrorIs(t, p.SendOffsetsToTxn(ctx, "g"), mq.ErrNoTxn)
+	assert.ErrorIs(t, p.CommitTxn(ctx), mq.ErrNoTxn)
+	assert.ErrorIs(t, p.AbortTxn(ctx), mq.ErrNoTxn)
+
+	// 没有写入消息的事务结束时不需要访问协调者
+	require.NoError(t, p.BeginTxn(ctx))
+	assert.ErrorIs(t, p.BeginTxn(ctx), mq.ErrTxnInProgress)
+	require.NoError(t, p.CommitTxn(ctx))
+	require.NoError(t, p.BeginTxn(ctx))
+	require.NoError(t, p.AbortTxn(ctx))
+
+	require.NoError(t, p.BeginTxn(ctx))
+	p.txn.failed = true
+	assert.ErrorIs(t, p.CommitTxn(ctx), ErrTxnFailed)
+
+	require.NoError(t, p.Close())
+	assert.ErrorIs(t, p.BeginTxn(ctx), errs.ErrProducerIsClosed)
+}
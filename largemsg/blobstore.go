@@ -0,0 +1,131 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package largemsg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrBlobNotFound = errors.New("消息内容不存在")
+	ErrInvalidRef   = errors.New("非法的消息内容引用")
+)
+
+// BlobStore 保存 ClaimCheckInterceptor 发送的消息内容，可以被多个协程并发访问
+type BlobStore interface {
+	// Put 保存data并返回引用，引用会放在消息的header中发送给消费者
+	Put(ctx context.Context, data []byte) (string, error)
+	// Get 按照 Put 返回的引用读取内容，不存在时返回 ErrBlobNotFound
+	Get(ctx context.Context, ref string) ([]byte, error)
+	// Delete 删除引用对应的内容，不存在时不返回error
+	Delete(ctx context.Context, ref string) error
+}
+
+var _ BlobStore = &FileStore{}
+
+// FileStore 把消息内容保存为本地目录中的文件，主要用于测试与单机部署
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore 创建保存在dir中的 FileStore，dir不存在时会被创建
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) Put(ctx context.Context, data []byte) (string, error) {
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	ref, err := newID()
+	if err != nil {
+		return "", err
+	}
+	// 先写入临时文件再重命名，避免消费者读到写了一半的内容
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(s.dir, ref))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return ref, nil
+}
+
+func (s *FileStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	path, err := s.path(ref)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, ref)
+	}
+	return data, err
+}
+
+func (s *FileStore) Delete(ctx context.Context, ref string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	path, err := s.path(ref)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// path 引用来自消息的header，只接受 Put 生成的文件名，防止访问目录之外的文件
+func (s *FileStore) path(ref string) (string, error) {
+	if _, err := hex.DecodeString(ref); err != nil || len(ref) != 2*idLen {
+		return "", fmt.Errorf("%w: %q", ErrInvalidRef, ref)
+	}
+	return filepath.Join(s.dir, ref), nil
+}
+
+const idLen = 16
+
+// newID 生成随机的十六进制ID
+func newID() (string, error) {
+	b := make([]byte, idLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
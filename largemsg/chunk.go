@@ -0,0 +1,323 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package largemsg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+)
+
+const (
+	// HeaderChunkID 同一条消息的所有分片使用相同的ID
+	HeaderChunkID = "x-mq-chunk-id"
+	// HeaderChunkIndex 分片的序号，从0开始
+	HeaderChunkIndex = "x-mq-chunk-index"
+	// HeaderChunkCount 消息的分片总数
+	HeaderChunkCount = "x-mq-chunk-count"
+	// HeaderChunkSize 消息 Value 的总长度
+	HeaderChunkSize = "x-mq-chunk-size"
+
+	// 默认按照512KB拆分消息，留出header的空间，不超过kafka默认的message.max.bytes
+	defaultThreshold = 512 * 1024
+	// 默认最多重组64MB的消息
+	defaultMaxMessageSize = 64 * 1024 * 1024
+	// 默认丢弃10分钟内没有收齐的消息
+	defaultPendingTimeout = 10 * time.Minute
+	// 默认最多缓存256MB没有收齐的分片
+	defaultMaxPendingSize = 4 * defaultMaxMessageSize
+)
+
+var ErrInvalidChunk = errors.New("非法的消息分片")
+
+var (
+	_ mq.ProducerInterceptor = &ChunkInterceptor{}
+	_ mq.ConsumerInterceptor = &ChunkInterceptor{}
+)
+
+// ChunkInterceptor 同时实现了 mq.ProducerInterceptor 与 mq.ConsumerInterceptor。
+// 发送时把 Value 超过分片大小的消息拆分成多条按顺序发送的分片，分片使用相同的Key，因此会落在同一个分区；
+// 没有Key的消息使用分片ID作为Key，重组之后恢复为空。消费时缓存收到的分片，收齐之后返回重组的消息，
+// 它的 Partition 与 Offset 为最后一个分片的位置，Header 与 Timestamp 来自第一个分片。
+//
+// 消费者自动提交时，分片在投递之后就会被提交，重启之后无法重组，因此需要使用 mq.WithManualCommit 并提交重组之后的消息。
+// 同一个分区中交错发送的多条大消息，提交其中一条时也会提交另一条已经收到的分片
+type ChunkInterceptor struct {
+	chunkSize      int
+	maxMessageSize int
+	pendingTimeout time.Duration
+	maxPendingSize int
+
+	locker sync.Mutex
+	// 键为消费者、分区与分片ID，ConsumeFunc在每次消费时都可能重新创建，因此状态保存在拦截器中
+	pending map[pendingKey]*pendingMessage
+	// pending 中所有分片的总长度
+	pendingSize int
+	// 递增的序号，用于找出最早开始接收的消息
+	seq uint64
+}
+
+type pendingKey struct {
+	info      mq.ConsumerInfo
+	partition int64
+	id        string
+}
+
+type pendingMessage struct {
+	chunks   [][]byte
+	received int
+	size     int
+	first    *mq.Message
+	// 收到第一个分片的时间
+	createdAt time.Time
+	seq       uint64
+}
+
+// NewChunkInterceptor 创建按照512KB拆分消息的 ChunkInterceptor
+func NewChunkInterceptor(opts ...option.Option[ChunkInterceptor]) *ChunkInterceptor {
+	i := &ChunkInterceptor{
+		chunkSize:      defaultThreshold,
+		maxMessageSize: defaultMaxMessageSize,
+		pendingTimeout: defaultPendingTimeout,
+		maxPendingSize: defaultMaxPendingSize,
+		pending:        map[pendingKey]*pendingMessage{},
+	}
+	option.Apply(i, opts...)
+	return i
+}
+
+// WithChunkSize 指定分片中 Value 的最大长度，默认为512KB
+func WithChunkSize(size int) option.Option[ChunkInterceptor] {
+	return func(i *ChunkInterceptor) {
+		i.chunkSize = size
+	}
+}
+
+// WithMaxMessageSize 指定消费时可以重组的消息的最大长度，超过时返回 ErrInvalidChunk，默认为64MB
+func WithMaxMessageSize(size int) option.Option[ChunkInterceptor] {
+	return func(i *ChunkInterceptor) {
+		i.maxMessageSize = size
+	}
+}
+
+// WithPendingTimeout 指定等待分片收齐的最长时间，超过之后丢弃已经收到的分片，默认为10分钟
+func WithPendingTimeout(timeout time.Duration) option.Option[ChunkInterceptor] {
+	return func(i *ChunkInterceptor) {
+		i.pendingTimeout = timeout
+	}
+}
+
+// WithMaxPendingSize 指定所有没有收齐的消息缓存的分片的最大总长度，默认为256MB。
+// 超过时从最早开始接收的消息开始丢弃，避免大量发送了部分分片的消息耗尽内存
+func WithMaxPendingSize(size int) option.Option[ChunkInterceptor] {
+	return func(i *ChunkInterceptor) {
+		i.maxPendingSize = size
+	}
+}
+
+// InterceptProduce 发送的是消息的副本，不会修改调用者传入的消息。
+// 某个分片发送失败时直接返回error，已经发送的分片会在消费者一侧超时之后被丢弃
+func (i *ChunkInterceptor) InterceptProduce(_ mq.ProducerInfo, next mq.ProduceFunc) mq.ProduceFunc {
+	return func(ctx context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+		if len(m.Value) <= i.chunkSize || m.Header.Get(HeaderChunkID) != "" {
+			return next(ctx, m)
+		}
+		id, err := newID()
+		if err != nil {
+			return nil, err
+		}
+		key := m.Key
+		if len(key) == 0 {
+			key = []byte(id)
+		}
+		count := (len(m.Value) + i.chunkSize - 1) / i.chunkSize
+		var res *mq.ProducerResult
+		for idx := 0; idx < count; idx++ {
+			msg := *m
+			msg.Key = key
+			msg.Value = m.Value[idx*i.chunkSize : min((idx+1)*i.chunkSize, len(m.Value))]
+			msg.Header = m.Header.Clone()
+			msg.Header.Set(HeaderChunkID, id)
+			msg.Header.Set(HeaderChunkIndex, strconv.Itoa(idx))
+			msg.Header.Set(HeaderChunkCount, strconv.Itoa(count))
+			msg.Header.Set(HeaderChunkSize, strconv.Itoa(len(m.Value)))
+			res, err = next(ctx, &msg)
+			if err != nil {
+				return nil, fmt.Errorf("发送第%d个分片失败: %w", idx, err)
+			}
+		}
+		return res, nil
+	}
+}
+
+// InterceptConsume 收到分片时继续获取下一条消息，直到某条消息的分片收齐
+func (i *ChunkInterceptor) InterceptConsume(info mq.ConsumerInfo, next mq.ConsumeFunc) mq.ConsumeFunc {
+	return func(ctx context.Context) (*mq.Message, error) {
+		for {
+			m, err := next(ctx)
+			if err != nil {
+				return nil, err
+			}
+			id := m.Header.Get(HeaderChunkID)
+			if id == "" {
+				return m, nil
+			}
+			msg, err := i.add(info, id, m)
+			if err != nil {
+				return nil, fmt.Errorf("%w，topic: %s, partition: %d, offset: %d: %s",
+					ErrInvalidChunk, m.Topic, m.Partition, m.Offset, err.Error())
+			}
+			if msg != nil {
+				return msg, nil
+			}
+		}
+	}
+}
+
+// add 缓存分片，收齐之后返回重组的消息
+func (i *ChunkInterceptor) add(info mq.ConsumerInfo, id string, m *mq.Message) (*mq.Message, error) {
+	index, err := strconv.Atoi(m.Header.Get(HeaderChunkIndex))
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(m.Header.Get(HeaderChunkCount))
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.Atoi(m.Header.Get(HeaderChunkSize))
+	if err != nil {
+		return nil, err
+	}
+	if count <= 0 || index < 0 || index >= count || size < 0 || count > size {
+		return nil, fmt.Errorf("分片序号%d，分片总数%d，消息长度%d", index, count, size)
+	}
+	if size > i.maxMessageSize {
+		return nil, fmt.Errorf("消息长度%d超过了上限%d", size, i.maxMessageSize)
+	}
+
+	i.locker.Lock()
+	defer i.locker.Unlock()
+	i.evict()
+	key := pendingKey{info: info, partition: m.Partition, id: id}
+	p, ok := i.pending[key]
+	if !ok {
+		i.seq++
+		p = &pendingMessage{chunks: make([][]byte, count), size: size, createdAt: time.Now(), seq: i.seq}
+		i.pending[key] = p
+	}
+	if len(p.chunks) != count || p.size != size {
+		return nil, fmt.Errorf("分片总数%d或消息长度%d与之前的分片不一致", count, size)
+	}
+	// 重复投递的分片直接覆盖
+	if p.chunks[index] == nil {
+		p.received++
+	}
+	i.pendingSize += len(m.Value) - len(p.chunks[index])
+	p.chunks[index] = m.Value
+	if index == 0 {
+		p.first = m
+	}
+	if p.received < count {
+		if !i.shrink(key) {
+			return nil, fmt.Errorf("缓存的分片超过了上限%d", i.maxPendingSize)
+		}
+		return nil, nil
+	}
+	i.remove(key, p)
+
+	value := make([]byte, 0, size)
+	for _, chunk := range p.chunks {
+		value = append(value, chunk...)
+	}
+	if len(value) != size {
+		return nil, fmt.Errorf("重组之后的长度%d与消息长度%d不一致", len(value), size)
+	}
+	msg := *m
+	msg.Value = value
+	msg.Key = p.first.Key
+	if string(msg.Key) == id {
+		msg.Key = nil
+	}
+	msg.Timestamp = p.first.Timestamp
+	msg.Header = p.first.Header.Clone()
+	for _, h := range []string{HeaderChunkID, HeaderChunkIndex, HeaderChunkCount, HeaderChunkSize} {
+		msg.Header.Del(h)
+	}
+	return &msg, nil
+}
+
+// evict 丢弃超时没有收齐的消息，调用者需要持有锁
+func (i *ChunkInterceptor) evict() {
+	if i.pendingTimeout <= 0 {
+		return
+	}
+	for key, p := range i.pending {
+		if time.Since(p.createdAt) < i.pendingTimeout {
+			continue
+		}
+		i.remove(key, p)
+		mq.Logger().Warn("分片没有在超时时间内收齐，已经收到的分片被丢弃",
+			slog.String("topic", key.info.Topic),
+			slog.String("group", key.info.GroupID),
+			slog.Int64("partition", key.partition),
+			slog.String("id", key.id),
+			slog.Int("received", p.received),
+			slog.Int("count", len(p.chunks)))
+	}
+}
+
+// shrink 缓存的分片超过上限时，从最早开始接收的消息开始丢弃，直到不超过上限。
+// 只剩下current依旧超过上限时丢弃current并返回false，调用者需要持有锁
+func (i *ChunkInterceptor) shrink(current pendingKey) bool {
+	for i.maxPendingSize > 0 && i.pendingSize > i.maxPendingSize {
+		var (
+			oldestKey pendingKey
+			oldest    *pendingMessage
+		)
+		for key, p := range i.pending {
+			if key != current && (oldest == nil || p.seq < oldest.seq) {
+				oldestKey, oldest = key, p
+			}
+		}
+		if oldest == nil {
+			i.remove(current, i.pending[current])
+			return false
+		}
+		i.remove(oldestKey, oldest)
+		mq.Logger().Warn("缓存的分片超过上限，最早开始接收的消息已经收到的分片被丢弃",
+			slog.String("topic", oldestKey.info.Topic),
+			slog.String("group", oldestKey.info.GroupID),
+			slog.Int64("partition", oldestKey.partition),
+			slog.String("id", oldestKey.id),
+			slog.Int("received", oldest.received),
+			slog.Int("count", len(oldest.chunks)))
+	}
+	return true
+}
+
+// remove 删除缓存的消息并扣减分片的总长度，调用者需要持有锁
+func (i *ChunkInterceptor) remove(key pendingKey, p *pendingMessage) {
+	delete(i.pending, key)
+	for _, chunk := range p.chunks {
+		i.pendingSize -= len(chunk)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package largemsg
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkInterceptor(t *testing.T) {
+	t.Parallel()
+	interceptor := NewChunkInterceptor(WithChunkSize(100))
+	// 记录实际写入的消息
+	var raw []*mq.Message
+	recorder := mq.ProducerInterceptorFunc(func(_ mq.ProducerInfo, next mq.ProduceFunc) mq.ProduceFunc {
+		return func(ctx context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+			raw = append(raw, &mq.Message{Key: m.Key, Value: m.Value, Header: m.Header.Clone()})
+			return next(ctx, m)
+		}
+	})
+	testmq := memory.NewMQ(
+		memory.WithProducerInterceptors(interceptor, recorder),
+		memory.WithConsumerInterceptors(interceptor))
+	defer func() {
+		_ = testmq.Close()
+	}()
+	const topic = "chunk"
+	require.NoError(t, testmq.CreateTopic(context.Background(), topic, 4))
+	c, err := testmq.Consumer(topic, "g1")
+	require.NoError(t, err)
+	p, err := testmq.Producer(topic)
+	require.NoError(t, err)
+
+	large := make([]byte, 250)
+	_, err = rand.Read(large)
+	require.NoError(t, err)
+	msgs := []*mq.Message{
+		{Key: []byte("k1"), Value: large, Header: mq.Header{{Key: "k", Value: []byte("v")}}},
+		{Value: large},
+		{Key: []byte("k2"), Value: []byte("small")},
+	}
+	for _, msg := range msgs {
+		_, err = p.Produce(context.Background(), msg)
+		require.NoError(t, err)
+	}
+	// 不修改调用者的消息
+	assert.Equal(t, mq.Header{{Key: "k", Value: []byte("v")}}, msgs[0].Header)
+	assert.Nil(t, msgs[1].Key)
+
+	require.Len(t, raw, 7)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, []byte("k1"), raw[i].Key)
+		assert.Equal(t, "3", raw[i].Header.Get(HeaderChunkCount))
+		assert.Equal(t, "250", raw[i].Header.Get(HeaderChunkSize))
+	}
+	assert.Len(t, raw[2].Value, 50)
+	// 没有Key的消息使用分片ID作为Key
+	assert.Equal(t, raw[3].Header.Get(HeaderChunkID), string(raw[3].Key))
+	assert.Equal(t, raw[3].Key, raw[5].Key)
+	assert.Equal(t, "", raw[6].Header.Get(HeaderChunkID))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	got := map[string]*mq.Message{}
+	for range msgs {
+		msg, err := c.Consume(ctx)
+		require.NoError(t, err)
+		got[string(msg.Key)] = msg
+	}
+	assert.Equal(t, large, got["k1"].Value)
+	assert.Equal(t, mq.Header{{Key: "k", Value: []byte("v")}}, got["k1"].Header)
+	assert.Equal(t, large, got[""].Value)
+	assert.Empty(t, got[""].Header)
+	assert.Equal(t, []byte("small"), got["k2"].Value)
+}
+
+func chunk(id string, index, count, size int, value string) *mq.Message {
+	return &mq.Message{
+		Key:   []byte("key"),
+		Value: []byte(value),
+		Header: mq.HeaderFromMap(map[string]string{
+			HeaderChunkID:    id,
+			HeaderChunkIndex: strconv.Itoa(index),
+			HeaderChunkCount: strconv.Itoa(count),
+			HeaderChunkSize:  strconv.Itoa(size),
+		}),
+		Offset: int64(index),
+	}
+}
+
+func TestChunkInterceptor_Consume(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name       string
+		opts       []option.Option[ChunkInterceptor]
+		msgs       []*mq.Message
+		wantValues []string
+		wantErr    error
+	}{
+		{
+			name: "乱序与重复",
+			msgs: []*mq.Message{
+				chunk("a", 1, 3, 6, "cd"),
+				chunk("b", 0, 2, 4, "12"),
+				chunk("a", 0, 3, 6, "ab"),
+				chunk("a", 1, 3, 6, "cd"),
+				chunk("b", 1, 2, 4, "34"),
+				chunk("a", 2, 3, 6, "ef"),
+			},
+			wantValues: []string{"1234", "abcdef"},
+		},
+		{
+			name:    "序号超出范围",
+			msgs:    []*mq.Message{chunk("a", 3, 3, 6, "ab")},
+			wantErr: ErrInvalidChunk,
+		},
+		{
+			name:    "分片总数不一致",
+			msgs:    []*mq.Message{chunk("a", 0, 3, 6, "ab"), chunk("a", 1, 2, 6, "cd")},
+			wantErr: ErrInvalidChunk,
+		},
+		{
+			name:    "长度不一致",
+			msgs:    []*mq.Message{chunk("a", 0, 2, 6, "ab"), chunk("a", 1, 2, 6, "cd")},
+			wantErr: ErrInvalidChunk,
+		},
+		{
+			name:    "超过最大长度",
+			opts:    []option.Option[ChunkInterceptor]{WithMaxMessageSize(5)},
+			msgs:    []*mq.Message{chunk("a", 0, 3, 6, "ab")},
+			wantErr: ErrInvalidChunk,
+		},
+		{
+			name: "超过缓存上限时丢弃最早的消息",
+			opts: []option.Option[ChunkInterceptor]{WithMaxPendingSize(4)},
+			msgs: []*mq.Message{
+				chunk("a", 0, 2, 4, "ab"),
+				chunk("b", 0, 2, 4, "12"),
+				chunk("c", 0, 2, 4, "xy"),
+				chunk("b", 1, 2, 4, "34"),
+				chunk("c", 1, 2, 4, "zw"),
+				chunk("a", 1, 2, 4, "cd"),
+			},
+			wantValues: []string{"1234", "xyzw"},
+		},
+		{
+			name:    "单条消息超过缓存上限",
+			opts:    []option.Option[ChunkInterceptor]{WithMaxPendingSize(1)},
+			msgs:    []*mq.Message{chunk("a", 0, 2, 4, "ab")},
+			wantErr: ErrInvalidChunk,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			msgs := tc.msgs
+			consume := NewChunkInterceptor(tc.opts...).InterceptConsume(mq.ConsumerInfo{},
+				func(ctx context.Context) (*mq.Message, error) {
+					if len(msgs) == 0 {
+						return nil, context.DeadlineExceeded
+					}
+					m := msgs[0]
+					msgs = msgs[1:]
+					return m, nil
+				})
+			var values []string
+			for {
+				msg, err := consume(context.Background())
+				if errors.Is(err, context.DeadlineExceeded) {
+					break
+				}
+				assert.ErrorIs(t, err, tc.wantErr)
+				if err != nil {
+					return
+				}
+				assert.Equal(t, []byte("key"), msg.Key)
+				assert.Empty(t, msg.Header)
+				values = append(values, string(msg.Value))
+			}
+			assert.Nil(t, tc.wantErr)
+			assert.Equal(t, tc.wantValues, values)
+		})
+	}
+}
+
+func TestChunkInterceptor_PendingTimeout(t *testing.T) {
+	t.Parallel()
+	interceptor := NewChunkInterceptor(WithPendingTimeout(time.Millisecond))
+	msgs := []*mq.Message{chunk("a", 0, 2, 4, "ab"), chunk("b", 0, 1, 2, "12"), chunk("a", 1, 2, 4, "cd")}
+	consume := interceptor.InterceptConsume(mq.ConsumerInfo{}, func(ctx context.Context) (*mq.Message, error) {
+		if len(msgs) == 0 {
+			return nil, context.DeadlineExceeded
+		}
+		m := msgs[0]
+		msgs = msgs[1:]
+		// 让之前的分片超时
+		time.Sleep(10 * time.Millisecond)
+		return m, nil
+	})
+	msg, err := consume(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []byte("12"), msg.Value)
+	// a的第一个分片已经被丢弃
+	_, err = consume(context.Background())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Len(t, interceptor.pending, 1)
+	assert.Equal(t, 2, interceptor.pendingSize)
+}
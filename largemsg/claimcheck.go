@@ -0,0 +1,101 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package largemsg
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+)
+
+// HeaderClaimCheck 记录消息内容在 BlobStore 中的引用，没有这个header表示 Value 就是消息内容
+const HeaderClaimCheck = "x-mq-claim-check"
+
+var (
+	_ mq.ProducerInterceptor = &ClaimCheckInterceptor{}
+	_ mq.ConsumerInterceptor = &ClaimCheckInterceptor{}
+)
+
+// ClaimCheckInterceptor 同时实现了 mq.ProducerInterceptor 与 mq.ConsumerInterceptor。
+// 发送时把 Value 不小于阈值的消息内容保存到 BlobStore，消息中只携带 HeaderClaimCheck 引用；
+// 消费时按照引用读取内容放回 Value 并删除该header。
+//
+// 同一条消息可能被多个消费组消费，因此 ClaimCheckInterceptor 不会删除 BlobStore 中的内容，
+// 需要按照topic的保留时间由 BlobStore 自行清理
+type ClaimCheckInterceptor struct {
+	store     BlobStore
+	threshold int
+}
+
+// NewClaimCheckInterceptor 创建把消息内容保存在store中的 ClaimCheckInterceptor
+func NewClaimCheckInterceptor(store BlobStore, opts ...option.Option[ClaimCheckInterceptor]) *ClaimCheckInterceptor {
+	i := &ClaimCheckInterceptor{
+		store:     store,
+		threshold: defaultThreshold,
+	}
+	option.Apply(i, opts...)
+	return i
+}
+
+// WithClaimCheckThreshold 指定需要保存到 BlobStore 的 Value 的最小长度，默认为512KB
+func WithClaimCheckThreshold(threshold int) option.Option[ClaimCheckInterceptor] {
+	return func(i *ClaimCheckInterceptor) {
+		i.threshold = threshold
+	}
+}
+
+// InterceptProduce 发送的是消息的副本，不会修改调用者传入的消息
+func (i *ClaimCheckInterceptor) InterceptProduce(_ mq.ProducerInfo, next mq.ProduceFunc) mq.ProduceFunc {
+	return func(ctx context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+		if len(m.Value) < i.threshold || m.Header.Get(HeaderClaimCheck) != "" {
+			return next(ctx, m)
+		}
+		ref, err := i.store.Put(ctx, m.Value)
+		if err != nil {
+			return nil, err
+		}
+		msg := *m
+		msg.Value = nil
+		msg.Header = m.Header.Clone()
+		msg.Header.Set(HeaderClaimCheck, ref)
+		return next(ctx, &msg)
+	}
+}
+
+// InterceptConsume 返回取回内容之后的副本，next返回的消息依旧携带引用，其他消费组可以继续取回内容
+func (i *ClaimCheckInterceptor) InterceptConsume(_ mq.ConsumerInfo, next mq.ConsumeFunc) mq.ConsumeFunc {
+	return func(ctx context.Context) (*mq.Message, error) {
+		m, err := next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ref := m.Header.Get(HeaderClaimCheck)
+		if ref == "" {
+			return m, nil
+		}
+		value, err := i.store.Get(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("读取消息内容失败，topic: %s, partition: %d, offset: %d: %w",
+				m.Topic, m.Partition, m.Offset, err)
+		}
+		msg := *m
+		msg.Value = value
+		msg.Header = m.Header.Clone()
+		msg.Header.Del(HeaderClaimCheck)
+		return &msg, nil
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package largemsg
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	ref, err := store.Put(ctx, []byte("data"))
+	require.NoError(t, err)
+	data, err := store.Get(ctx, ref)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	require.NoError(t, store.Delete(ctx, ref))
+	require.NoError(t, store.Delete(ctx, ref))
+	_, err = store.Get(ctx, ref)
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+	for _, ref := range []string{"", "../etc/passwd", "abc"} {
+		_, err = store.Get(ctx, ref)
+		assert.ErrorIs(t, err, ErrInvalidRef)
+	}
+}
+
+func TestClaimCheckInterceptor(t *testing.T) {
+	t.Parallel()
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	interceptor := NewClaimCheckInterceptor(store, WithClaimCheckThreshold(100))
+	// 记录实际写入的消息
+	var raw []*mq.Message
+	recorder := mq.ProducerInterceptorFunc(func(_ mq.ProducerInfo, next mq.ProduceFunc) mq.ProduceFunc {
+		return func(ctx context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+			raw = append(raw, &mq.Message{Value: m.Value, Header: m.Header.Clone()})
+			return next(ctx, m)
+		}
+	})
+	testmq := memory.NewMQ(
+		memory.WithProducerInterceptors(interceptor, recorder),
+		memory.WithConsumerInterceptors(interceptor))
+	defer func() {
+		_ = testmq.Close()
+	}()
+	const topic = "claimcheck"
+	require.NoError(t, testmq.CreateTopic(context.Background(), topic, 1))
+	c, err := testmq.Consumer(topic, "g1")
+	require.NoError(t, err)
+	p, err := testmq.Producer(topic)
+	require.NoError(t, err)
+
+	large := bytes.Repeat([]byte("a"), 1000)
+	values := [][]byte{large, []byte("small")}
+	for _, value := range values {
+		msg := &mq.Message{Value: value, Header: mq.Header{{Key: "k", Value: []byte("v")}}}
+		_, err = p.Produce(context.Background(), msg)
+		require.NoError(t, err)
+		// 不修改调用者的消息
+		assert.Equal(t, value, msg.Value)
+		assert.Equal(t, "", msg.Header.Get(HeaderClaimCheck))
+	}
+	require.Len(t, raw, 2)
+	assert.Empty(t, raw[0].Value)
+	data, err := store.Get(context.Background(), raw[0].Header.Get(HeaderClaimCheck))
+	require.NoError(t, err)
+	assert.Equal(t, large, data)
+	assert.Equal(t, "", raw[1].Header.Get(HeaderClaimCheck))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, value := range values {
+		msg, err := c.Consume(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, value, msg.Value)
+		assert.Equal(t, mq.Header{{Key: "k", Value: []byte("v")}}, msg.Header)
+	}
+
+	// 内容已经被删除
+	require.NoError(t, store.Delete(context.Background(), raw[0].Header.Get(HeaderClaimCheck)))
+	consume := interceptor.InterceptConsume(mq.ConsumerInfo{}, func(ctx context.Context) (*mq.Message, error) {
+		return &mq.Message{Header: raw[0].Header.Clone()}, nil
+	})
+	_, err = consume(context.Background())
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+}
+
+func TestClaimCheckInterceptor_ConsumerGroups(t *testing.T) {
+	t.Parallel()
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	interceptor := NewClaimCheckInterceptor(store, WithClaimCheckThreshold(4))
+	var sent *mq.Message
+	produce := interceptor.InterceptProduce(mq.ProducerInfo{Topic: "t"}, func(_ context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+		sent = m
+		return &mq.ProducerResult{}, nil
+	})
+	_, err = produce(context.Background(), &mq.Message{Value: []byte("large payload")})
+	require.NoError(t, err)
+	ref := sent.Header.Get(HeaderClaimCheck)
+	require.NotEmpty(t, ref)
+
+	// 多个消费组拿到同一条消息时，每个消费组都可以取回内容
+	for _, group := range []string{"g1", "g2"} {
+		msg, err := interceptor.InterceptConsume(mq.ConsumerInfo{Topic: "t", GroupID: group}, func(_ context.Context) (*mq.Message, error) {
+			return sent, nil
+		})(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []byte("large payload"), msg.Value)
+		assert.Empty(t, msg.Header.Get(HeaderClaimCheck))
+	}
+	assert.Nil(t, sent.Value)
+	assert.Equal(t, ref, sent.Header.Get(HeaderClaimCheck))
+}
@@ -0,0 +1,37 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 修改了全局的logger，因此不并行执行
+func TestSetLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, nil))
+	SetLogger(l)
+	defer SetLogger(nil)
+	assert.Equal(t, l, Logger())
+	Logger().Warn("测试", slog.String("topic", "t"))
+	assert.Contains(t, buf.String(), "topic=t")
+
+	SetLogger(nil)
+	assert.Equal(t, slog.Default(), Logger())
+}
@@ -16,7 +16,10 @@ package memory
 
 import (
 	"context"
+	"errors"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ecodeclub/mq-api"
@@ -31,9 +34,11 @@ const (
 )
 
 type Consumer struct {
-	locker sync.RWMutex
-	name   string
-	closed bool
+	locker  sync.RWMutex
+	name    string
+	topic   string
+	groupID string
+	closed  bool
 	// 用于存放分区号，每个元素就是一个分区号
 	partitions       []*Partition
 	partitionRecords []PartitionRecord
@@ -42,12 +47,50 @@ type Consumer struct {
 	once             sync.Once
 	reportCh         chan *Event
 	receiveCh        chan *Event
+	// 分区分配与收回时的回调，在eventLoop中执行
+	onAssigned func(partitions []int)
+	onRevoked  func(partitions []int)
+	// Close 通过它请求eventLoop交出全部分区，eventLoop处理完成之后关闭收到的channel
+	revokeCh chan chan struct{}
+	// 关闭时已经交出全部分区，之后不再回调onAssigned与onRevoked
+	revokedOnClose atomic.Bool
+	// eventLoop退出时关闭
+	loopDone chan struct{}
+	// 心跳间隔，为0时不发送心跳
+	heartbeatInterval time.Duration
+	// 超过maxPollInterval没有拉取已投递的消息则停止心跳，为0时不检测
+	maxPollInterval time.Duration
+	// 最近一次拉取消息的时间，单位纳秒
+	lastPoll atomic.Int64
+	// 是否已经被移出消费组，只在eventLoop中读写
+	evicted bool
+	// 是否正在退出消费组，退出之后reportCh会被关闭，eventLoop不能再主动上报事件
+	exiting atomic.Bool
+	// 保证eventLoop不会在设置exiting之后向reportCh发送事件
+	reportLocker sync.RWMutex
+	// 开始关闭时关闭，用于唤醒阻塞在msgCh上的eventLoop
+	closing     chan struct{}
+	closingOnce sync.Once
+	// 静态成员ID，为空表示动态成员
+	instanceID string
+	// 被暂停的分区，暂停状态不随重平衡清除
+	pausedLocker sync.RWMutex
+	paused       map[int]struct{}
+	// 手动提交时partitionRecords只记录拉取进度，上报给消费组的是使用者提交的committed
+	manualCommit    bool
+	committedLocker sync.Mutex
+	committed       map[int]int
+	isolationLevel  mq.IsolationLevel
+	metrics         mq.Metrics
+	// 上一次记录的msgCh中的消息数量，只在eventLoop中读写
+	buffered int
 }
 
 func (c *Consumer) Consume(ctx context.Context) (*mq.Message, error) {
 	if c.isClosed() {
 		return nil, errs.ErrConsumerIsClosed
 	}
+	c.lastPoll.Store(time.Now().UnixNano())
 	select {
 	case val, ok := <-c.msgCh:
 		if !ok {
@@ -63,36 +106,70 @@ func (c *Consumer) Consume(ctx context.Context) (*mq.Message, error) {
 func (c *Consumer) eventLoop() {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
+	defer close(c.loopDone)
+	// eventLoop是msgCh唯一的发送者，由它负责关闭msgCh
+	defer close(c.msgCh)
+	defer func() {
+		c.metrics.AddBuffered(c.topic, c.groupID, -c.buffered)
+	}()
+	var heartbeatCh <-chan time.Time
+	if c.heartbeatInterval > 0 {
+		heartbeatTicker := time.NewTicker(c.heartbeatInterval)
+		defer heartbeatTicker.Stop()
+		heartbeatCh = heartbeatTicker.C
+	}
 	for {
 		select {
+		case <-heartbeatCh:
+			c.heartbeat()
 		case <-ticker.C:
 			c.consumeAndReport()
+			c.observeBuffered()
 		case event, ok := <-c.receiveCh:
 			if !ok {
 				return
 			}
 			// 处理各种事件
 			c.handle(event)
+		case done := <-c.revokeCh:
+			c.revokeAll()
+			close(done)
 		}
 	}
 }
 
 func (c *Consumer) consumeAndReport() {
 	for idx, record := range c.partitionRecords {
-		msgs := c.partitions[record.Index].getBatch(record.Offset, limit)
+		if c.isPaused(record.Index) {
+			continue
+		}
+		msgs, next := c.partitions[record.Index].getBatch(record.Offset, limit, c.isolationLevel)
 		for _, msg := range msgs {
-			c.msgCh <- msg
+			select {
+			// 每个消费者拿到的都是副本，修改消息不会影响其他消费组
+			case c.msgCh <- cloneMessage(msg):
+				c.metrics.ObserveConsume(c.topic, c.groupID, record.Index)
+			case <-c.closing:
+				return
+			}
 		}
-		record.Offset += len(msgs)
+		record.Offset = next
 		errCh := make(chan error, 1)
-		c.reportCh <- &Event{
+		ok := c.report(&Event{
 			Type: ReportOffsetEvent,
 			Data: ReportData{
-				Records: []PartitionRecord{record},
+				Records: c.committedRecords([]PartitionRecord{record}),
 				ErrChan: errCh,
 			},
+		})
+		if !ok {
+			return
 		}
 		err := <-errCh
+		if errors.Is(err, ErrConsumerEvicted) {
+			c.handle(&Event{Type: EvictEvent})
+			return
+		}
 		if err != nil {
 			return
 		}
@@ -101,25 +178,75 @@ func (c *Consumer) consumeAndReport() {
 	}
 }
 
+// observeBuffered 记录msgCh中消息数量相对上一次记录的变化
+func (c *Consumer) observeBuffered() {
+	buffered := len(c.msgCh)
+	c.metrics.AddBuffered(c.topic, c.groupID, buffered-c.buffered)
+	c.buffered = buffered
+}
+
 func (c *Consumer) handle(event *Event) {
 	switch event.Type {
 	// 服务端发起的重新加入事件
 	case RejoinEvent:
+		c.revoked(c.ownedPartitions())
 		// 消费者上报消费进度
 		c.reportCh <- &Event{
 			Type: RejoinAckEvent,
-			Data: c.partitionRecords,
+			Data: c.committedRecords(c.partitionRecords),
 		}
 		// 设置消费进度
 		partitionInfo := <-c.receiveCh
 		c.partitionRecords, _ = partitionInfo.Data.([]PartitionRecord)
+		c.resetCommitted()
+		c.assigned(c.ownedPartitions())
 		// 返回设置完成的信号
 		c.reportCh <- &Event{
 			Type: PartitionNotifyAckEvent,
 		}
+	// 协作式重平衡中服务端收回部分分区
+	case RevokeEvent:
+		revoked, _ := event.Data.([]int)
+		records := make([]PartitionRecord, 0, len(c.partitionRecords))
+		revokedRecords := make([]PartitionRecord, 0, len(revoked))
+		for _, record := range c.partitionRecords {
+			if slices.Contains(revoked, record.Index) {
+				revokedRecords = append(revokedRecords, record)
+				continue
+			}
+			records = append(records, record)
+		}
+		c.revoked(recordIndexes(revokedRecords))
+		revokedRecords = c.committedRecords(revokedRecords)
+		c.partitionRecords = records
+		c.resetCommitted()
+		c.reportCh <- &Event{
+			Type: RevokeAckEvent,
+			Data: revokedRecords,
+		}
+	// 协作式重平衡中服务端追加分区
+	case AssignEvent:
+		records, _ := event.Data.([]PartitionRecord)
+		c.assigned(recordIndexes(records))
+		c.partitionRecords = append(c.partitionRecords, records...)
+		c.resetCommitted()
+		c.reportCh <- &Event{
+			Type: PartitionNotifyAckEvent,
+		}
+	// 心跳超时被移出消费组，交出全部分区，恢复后在下一次心跳时请求重新加入
+	case EvictEvent:
+		if c.evicted {
+			return
+		}
+		c.revoked(c.ownedPartitions())
+		c.partitionRecords = []PartitionRecord{}
+		c.resetCommitted()
+		c.evicted = true
 	case CloseEvent:
-		// 未返回错误不做处理
-		_ = c.Close()
+		// 已经在eventLoop中，直接交出分区，不能再通过revokeCh请求自己
+		c.startClosing()
+		c.revokeAll()
+		c.close()
 		ch, ok := event.Data.(chan struct{})
 		if !ok {
 			return
@@ -139,23 +266,238 @@ func (c *Consumer) ConsumeChan(ctx context.Context) (<-chan *mq.Message, error)
 	return c.msgCh, nil
 }
 
+func (c *Consumer) Commit(ctx context.Context, msgs ...*mq.Message) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if c.isClosed() {
+		return errs.ErrConsumerIsClosed
+	}
+	c.commit(msgs)
+	return nil
+}
+
+// commit 提交消费进度，返回属于该消费者的分区。自动提交时不做任何处理
+func (c *Consumer) commit(msgs []*mq.Message) []int {
+	if !c.manualCommit {
+		return nil
+	}
+	c.committedLocker.Lock()
+	defer c.committedLocker.Unlock()
+	owned := make([]int, 0, len(msgs))
+	for _, msg := range msgs {
+		p, offset := int(msg.Partition), int(msg.Offset)+1
+		// 只接受仍然属于该消费者的分区，并且进度只能前进
+		cur, ok := c.committed[p]
+		if !ok {
+			continue
+		}
+		owned = append(owned, p)
+		if offset > cur {
+			c.committed[p] = offset
+		}
+	}
+	return owned
+}
+
+// committedRecords 手动提交时把records的偏移量替换为已经提交的进度，records为nil时返回所有分区已经提交的进度。
+// 自动提交时直接返回records
+func (c *Consumer) committedRecords(records []PartitionRecord) []PartitionRecord {
+	if !c.manualCommit {
+		return records
+	}
+	c.committedLocker.Lock()
+	defer c.committedLocker.Unlock()
+	if records == nil {
+		res := make([]PartitionRecord, 0, len(c.committed))
+		for p, offset := range c.committed {
+			res = append(res, PartitionRecord{Index: p, Offset: offset})
+		}
+		return res
+	}
+	res := make([]PartitionRecord, 0, len(records))
+	for _, record := range records {
+		if offset, ok := c.committed[record.Index]; ok {
+			record.Offset = offset
+		}
+		res = append(res, record)
+	}
+	return res
+}
+
+// resetCommitted 在持有的分区变化之后调用，新分配的分区从消费组下发的进度开始，被收回的分区不再接受提交
+func (c *Consumer) resetCommitted() {
+	if !c.manualCommit {
+		return
+	}
+	c.committedLocker.Lock()
+	defer c.committedLocker.Unlock()
+	committed := make(map[int]int, len(c.partitionRecords))
+	for _, record := range c.partitionRecords {
+		if offset, ok := c.committed[record.Index]; ok {
+			committed[record.Index] = offset
+			continue
+		}
+		committed[record.Index] = record.Offset
+	}
+	c.committed = committed
+}
+
+func (c *Consumer) Pause(partitions ...int) error {
+	if c.isClosed() {
+		return errs.ErrConsumerIsClosed
+	}
+	c.pausedLocker.Lock()
+	defer c.pausedLocker.Unlock()
+	if c.paused == nil {
+		c.paused = make(map[int]struct{}, len(partitions))
+	}
+	for _, p := range partitions {
+		c.paused[p] = struct{}{}
+	}
+	return nil
+}
+
+func (c *Consumer) Resume(partitions ...int) error {
+	if c.isClosed() {
+		return errs.ErrConsumerIsClosed
+	}
+	c.pausedLocker.Lock()
+	defer c.pausedLocker.Unlock()
+	for _, p := range partitions {
+		delete(c.paused, p)
+	}
+	return nil
+}
+
+func (c *Consumer) isPaused(partition int) bool {
+	c.pausedLocker.RLock()
+	defer c.pausedLocker.RUnlock()
+	_, ok := c.paused[partition]
+	return ok
+}
+
+// Close 退出消费组之前由eventLoop回调onRevoked交出全部分区，因此不会与重平衡的回调并发执行
 func (c *Consumer) Close() error {
+	c.startClosing()
+	c.revokeOnClose()
+	c.close()
+	return nil
+}
+
+// startClosing 唤醒阻塞在msgCh上的eventLoop，使它可以处理交出分区的请求
+func (c *Consumer) startClosing() {
+	c.closingOnce.Do(func() {
+		close(c.closing)
+	})
+}
+
+// close 上报最后的消费进度并退出消费组，交出分区由调用者负责
+func (c *Consumer) close() {
 	c.locker.Lock()
 	defer c.locker.Unlock()
 	c.once.Do(func() {
 		c.closed = true
+		// 退出之前上报最后一次提交的进度，eventLoop可能要到下一次拉取时才会上报
+		if records := c.committedRecords(nil); len(records) > 0 {
+			errCh := make(chan error, 1)
+			if c.report(&Event{
+				Type: ReportOffsetEvent,
+				Data: ReportData{Records: records, ErrChan: errCh},
+			}) {
+				<-errCh
+			}
+		}
+		c.reportLocker.Lock()
+		c.exiting.Store(true)
+		c.reportLocker.Unlock()
 		c.reportCh <- &Event{
 			Type: ExitGroupEvent,
 			Data: c.closeCh,
 		}
 		// 等待服务端退出完成
 		<-c.closeCh
-		// 关闭资源
+		// 关闭资源，eventLoop退出时会关闭msgCh
 		close(c.receiveCh)
-		close(c.msgCh)
 	})
+}
 
-	return nil
+// heartbeat 向消费组发送心跳。msgCh中的消息超过maxPollInterval没有被取走，
+// 说明使用者已经卡住，此时停止心跳，由消费组将其移出，对应kafka的max.poll.interval.ms
+func (c *Consumer) heartbeat() {
+	if c.exiting.Load() {
+		return
+	}
+	now := time.Now()
+	if len(c.msgCh) == 0 {
+		// 没有积压的消息，使用者只是在等待新消息
+		c.lastPoll.Store(now.UnixNano())
+	}
+	if c.maxPollInterval > 0 && now.Sub(time.Unix(0, c.lastPoll.Load())) > c.maxPollInterval {
+		return
+	}
+	if c.evicted {
+		c.evicted = false
+		c.report(&Event{Type: RejoinRequestEvent})
+		return
+	}
+	c.report(&Event{Type: HeartbeatEvent})
+}
+
+// report 在没有退出消费组时向消费组上报事件，返回false表示已经退出
+func (c *Consumer) report(event *Event) bool {
+	c.reportLocker.RLock()
+	defer c.reportLocker.RUnlock()
+	if c.exiting.Load() {
+		return false
+	}
+	c.reportCh <- event
+	return true
+}
+
+// ownedPartitions 只能在eventLoop中调用
+func (c *Consumer) ownedPartitions() []int {
+	return recordIndexes(c.partitionRecords)
+}
+
+// revokeOnClose 请求eventLoop交出全部分区并等待完成，eventLoop已经退出时直接返回
+func (c *Consumer) revokeOnClose() {
+	done := make(chan struct{})
+	select {
+	case c.revokeCh <- done:
+		<-done
+	case <-c.loopDone:
+	}
+}
+
+// revokeAll 只能在eventLoop中调用，关闭时交出全部分区，多次调用只会回调一次
+func (c *Consumer) revokeAll() {
+	owned := c.ownedPartitions()
+	if c.revokedOnClose.CompareAndSwap(false, true) && len(owned) > 0 {
+		c.onRevoked(owned)
+	}
+}
+
+// assigned 只能在eventLoop中调用，关闭时交出全部分区之后不再回调
+func (c *Consumer) assigned(partitions []int) {
+	if len(partitions) > 0 && !c.revokedOnClose.Load() {
+		c.onAssigned(partitions)
+	}
+}
+
+// revoked 只能在eventLoop中调用，关闭时交出全部分区之后不再回调
+func (c *Consumer) revoked(partitions []int) {
+	if len(partitions) > 0 && !c.revokedOnClose.Load() {
+		c.onRevoked(partitions)
+	}
+}
+
+func recordIndexes(records []PartitionRecord) []int {
+	res := make([]int, 0, len(records))
+	for _, record := range records {
+		res = append(res, record.Index)
+	}
+	return res
 }
 
 func (c *Consumer) isClosed() bool {
@@ -0,0 +1,152 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/internal/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumer_PauseAndResume(t *testing.T) {
+	t.Parallel()
+	m := NewMQ()
+	defer func() {
+		_ = m.Close()
+	}()
+	topic := "pause_topic"
+	require.NoError(t, m.CreateTopic(context.Background(), topic, 2))
+	c, err := m.Consumer(topic, "pause_group")
+	require.NoError(t, err)
+	p, err := m.Producer(topic)
+	require.NoError(t, err)
+
+	require.NoError(t, c.Pause(0))
+	for i := 0; i < 2; i++ {
+		_, err = p.ProduceWithPartition(context.Background(), &mq.Message{Value: []byte("msg")}, i)
+		require.NoError(t, err)
+	}
+	// 只能收到分区1的消息
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	msg, err := c.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), msg.Partition)
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer timeoutCancel()
+	_, err = c.Consume(timeoutCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.NoError(t, c.Resume(0))
+	msg, err = c.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), msg.Partition)
+
+	require.NoError(t, c.Close())
+	assert.ErrorIs(t, c.Pause(0), errs.ErrConsumerIsClosed)
+	assert.ErrorIs(t, c.Resume(0), errs.ErrConsumerIsClosed)
+}
+
+func TestConsumer_MessageCopy(t *testing.T) {
+	t.Parallel()
+	m := NewMQ()
+	defer func() {
+		_ = m.Close()
+	}()
+	topic := "copy_topic"
+	require.NoError(t, m.CreateTopic(context.Background(), topic, 1))
+	c1, err := m.Consumer(topic, "g1")
+	require.NoError(t, err)
+	c2, err := m.Consumer(topic, "g2")
+	require.NoError(t, err)
+	p, err := m.Producer(topic)
+	require.NoError(t, err)
+
+	msg := &mq.Message{Value: []byte("msg"), Header: mq.Header{{Key: "k", Value: []byte("v")}}}
+	_, err = p.Produce(context.Background(), msg)
+	require.NoError(t, err)
+	// 发送之后修改消息不影响分区中的数据
+	msg.Value[0] = 'x'
+	msg.Header.Del("k")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	got1, err := c1.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "msg", string(got1.Value))
+	// 一个消费组修改消息不影响其他消费组
+	got1.Value[0] = 'y'
+	got1.Header.Del("k")
+	got2, err := c2.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "msg", string(got2.Value))
+	assert.Equal(t, "v", got2.Header.Get("k"))
+}
+
+// 测试场景：eventLoop阻塞在已满的msgCh上时关闭消费者，onRevoked在eventLoop中执行，不会与分配回调并发
+func TestConsumer_CloseRevoke(t *testing.T) {
+	t.Parallel()
+	m := NewMQ()
+	defer func() {
+		_ = m.Close()
+	}()
+	topic := "close_revoke_topic"
+	require.NoError(t, m.CreateTopic(context.Background(), topic, 1))
+	var running, overlapped atomic.Int32
+	callback := func(ch chan []int) func([]int) {
+		return func(partitions []int) {
+			if running.Add(1) > 1 {
+				overlapped.Add(1)
+			}
+			defer running.Add(-1)
+			ch <- partitions
+		}
+	}
+	assignedCh, revokedCh := make(chan []int, 10), make(chan []int, 10)
+	c, err := m.Consumer(topic, "close_revoke_group",
+		mq.WithOnPartitionsAssigned(callback(assignedCh)),
+		mq.WithOnPartitionsRevoked(callback(revokedCh)))
+	require.NoError(t, err)
+	assert.Equal(t, []int{0}, <-assignedCh)
+
+	p, err := m.Producer(topic)
+	require.NoError(t, err)
+	for i := 0; i < msgChannelLength+limit; i++ {
+		_, err = p.Produce(context.Background(), &mq.Message{Value: []byte("msg")})
+		require.NoError(t, err)
+	}
+	// 等待eventLoop填满msgCh
+	time.Sleep(2 * interval)
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- c.Close()
+	}()
+	select {
+	case err = <-closed:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("关闭消费者超时")
+	}
+	assert.Equal(t, []int{0}, <-revokedCh)
+	assert.Empty(t, revokedCh)
+	assert.Zero(t, overlapped.Load())
+}
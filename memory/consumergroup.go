@@ -16,12 +16,14 @@ package memory
 
 import (
 	"fmt"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/ecodeclub/mq-api"
 
+	"github.com/ecodeclub/ekit/bean/option"
 	"github.com/ecodeclub/ekit/syncx"
 	"github.com/pkg/errors"
 )
@@ -29,6 +31,8 @@ import (
 var (
 	ErrReportOffsetFail    = errors.New("非平衡状态，无法上报偏移量")
 	ErrConsumerGroupClosed = errors.New("消费组已经关闭")
+	ErrConsumerEvicted     = errors.New("消费者心跳超时，已被移出消费组")
+	ErrInstanceIDInUse     = errors.New("消费组内已经存在相同InstanceID的消费者")
 )
 
 const (
@@ -51,6 +55,18 @@ const (
 	PartitionNotifyEvent = "partition_notify"
 	// PartitionNotifyAckEvent consumer=>consumer_group  表示消费者对消费组下发分区情况事件的确认
 	PartitionNotifyAckEvent = "partition_notify_ack"
+	// RevokeEvent consumer_group=>consumer 协作式重平衡中，表示消费组收回消费者的部分分区
+	RevokeEvent = "revoke"
+	// RevokeAckEvent consumer=>consumer_group 表示消费者交出被收回的分区并上报这些分区的消费进度
+	RevokeAckEvent = "revoke_ack"
+	// AssignEvent consumer_group=>consumer 协作式重平衡中，表示消费组向消费者追加分区，消费者以 PartitionNotifyAckEvent 确认
+	AssignEvent = "assign"
+	// HeartbeatEvent consumer=>consumer_group 表示消费者的心跳
+	HeartbeatEvent = "heartbeat"
+	// EvictEvent consumer_group=>consumer 表示消费者心跳超时，已经被移出消费组
+	EvictEvent = "evict"
+	// RejoinRequestEvent consumer=>consumer_group 表示被移出的消费者恢复之后请求重新加入消费组
+	RejoinRequestEvent = "rejoin_request"
 
 	StatusStable    = 1 // 稳定状态，可以正常的进行消费数据
 	StatusBalancing = 2
@@ -62,7 +78,8 @@ const (
 
 // ConsumerGroup 表示消费组是并发安全的
 type ConsumerGroup struct {
-	name string
+	name  string
+	topic string
 	// 存储消费者元数据，键为消费者的名称
 	consumers syncx.Map[string, *Consumer]
 	// 消费者平衡器
@@ -74,6 +91,25 @@ type ConsumerGroup struct {
 	status     int32
 	balanceCh  chan struct{}
 	once       sync.Once
+	// 重平衡协议
+	rebalanceProtocol RebalanceProtocol
+	// 当前的分区分配结果，键为消费者名称，只在重平衡过程中读写
+	assignments map[string][]int
+	// 超过sessionTimeout没有收到心跳的消费者会被移出消费组，为0时不检测
+	sessionTimeout time.Duration
+	// 消费者发送心跳的间隔，为0时不发送心跳
+	heartbeatInterval time.Duration
+	// 消费者超过maxPollInterval没有拉取已经投递的消息就停止发送心跳，为0时不检测
+	maxPollInterval time.Duration
+	// 消费者最近一次心跳的时间
+	heartbeats syncx.Map[string, time.Time]
+	// 被移出消费组、等待重新加入的消费者
+	evicted syncx.Map[string, *Consumer]
+	// 已经退出但是仍然保留分区的静态成员，会话超时之后才会被移除
+	departed syncx.Map[string, struct{}]
+	// 用于生成消费者名称的序号，保证名称在消费组内唯一
+	memberSeq atomic.Int64
+	metrics   mq.Metrics
 }
 
 type PartitionRecord struct {
@@ -102,14 +138,29 @@ func (c *ConsumerGroup) eventHandler(name string, event *Event) {
 	case ReportOffsetEvent:
 		data, _ := event.Data.(ReportData)
 		var err error
-		err = c.reportOffset(data.Records)
+		// 已经被移出消费组的消费者不再持有分区
+		if _, ok := c.consumers.Load(name); ok {
+			err = c.reportOffset(data.Records)
+		} else {
+			err = ErrConsumerEvicted
+		}
 		data.ErrChan <- err
+	case HeartbeatEvent:
+		c.heartbeats.Store(name, time.Now())
+	case RejoinRequestEvent:
+		// 重新加入需要和该消费者交互，不能阻塞处理它上报事件的协程
+		go c.rejoinGroup(name)
 	case RejoinAckEvent:
 		// consumer响应重平衡信号返回的数据，返回的是当前所有分区的偏移量
 		records, _ := event.Data.([]PartitionRecord)
 		// 不管上报成不成功
 		_ = c.reportOffset(records)
 		c.balanceCh <- struct{}{}
+	case RevokeAckEvent:
+		// 被收回分区的最终消费进度
+		records, _ := event.Data.([]PartitionRecord)
+		_ = c.reportOffset(records)
+		c.balanceCh <- struct{}{}
 	case PartitionNotifyAckEvent:
 		c.balanceCh <- struct{}{}
 	}
@@ -124,8 +175,17 @@ func (c *ConsumerGroup) exitGroup(name string, closeCh chan struct{}) {
 			time.Sleep(defaultSleepTime)
 			continue
 		}
-		c.consumers.Delete(name)
-		c.reBalance()
+		consumer, ok := c.consumers.LoadAndDelete(name)
+		c.evicted.Delete(name)
+		if ok && consumer.instanceID != "" && c.sessionTimeout > 0 &&
+			atomic.LoadInt32(&c.status) == StatusBalancing {
+			// 静态成员退出时保留它的分区，直到会话超时，期间重新加入不需要重平衡
+			c.departed.Store(name, struct{}{})
+			c.heartbeats.Store(name, time.Now())
+		} else {
+			c.heartbeats.Delete(name)
+			c.reBalance()
+		}
 		close(closeCh)
 		if !atomic.CompareAndSwapInt32(&c.status, StatusBalancing, StatusStable) {
 			atomic.CompareAndSwapInt32(&c.status, StatusStopping, StatusStop)
@@ -136,16 +196,50 @@ func (c *ConsumerGroup) exitGroup(name string, closeCh chan struct{}) {
 
 // ReportOffsetEvent 上报偏移量
 func (c *ConsumerGroup) reportOffset(records []PartitionRecord) error {
-	status := atomic.LoadInt32(&c.status)
-	if status != StatusStable && status != StatusStop {
+	if !c.canReportOffset() {
 		return ErrReportOffsetFail
 	}
 	for _, record := range records {
 		c.partitionRecords.Store(record.Index, record)
+		lag := c.partitions[record.Index].len() - record.Offset
+		c.metrics.SetLag(c.topic, c.name, record.Index, int64(lag))
 	}
 	return nil
 }
 
+// commitTxnOffsets 提交事务中的消费进度。持有分区的消费者在下一次上报时把进度交给消费组，
+// 没有消费者持有的分区直接更新消费组的进度
+func (c *ConsumerGroup) commitTxnOffsets(msgs []*mq.Message) {
+	owned := make(map[int]struct{}, len(msgs))
+	c.consumers.Range(func(_ string, consumer *Consumer) bool {
+		for _, p := range consumer.commit(msgs) {
+			owned[p] = struct{}{}
+		}
+		return true
+	})
+	for _, msg := range msgs {
+		p, offset := int(msg.Partition), int(msg.Offset)+1
+		if _, ok := owned[p]; ok {
+			continue
+		}
+		if record, ok := c.partitionRecords.Load(p); ok && offset > record.Offset {
+			c.partitionRecords.Store(p, PartitionRecord{Index: p, Offset: offset})
+		}
+	}
+}
+
+func (c *ConsumerGroup) canReportOffset() bool {
+	switch atomic.LoadInt32(&c.status) {
+	case StatusStable, StatusStop:
+		return true
+	case StatusBalancing:
+		// 协作式重平衡只会收回部分分区，消费者在交出分区之前上报的进度依旧有效
+		return c.rebalanceProtocol == RebalanceCooperative
+	default:
+		return false
+	}
+}
+
 func (c *ConsumerGroup) Close() {
 	c.once.Do(func() {
 		for {
@@ -160,7 +254,7 @@ func (c *ConsumerGroup) Close() {
 }
 
 func (c *ConsumerGroup) close() {
-	c.consumers.Range(func(_ string, value *Consumer) bool {
+	closeConsumer := func(_ string, value *Consumer) bool {
 		ch := make(chan struct{})
 		value.receiveCh <- &Event{
 			Type: CloseEvent,
@@ -168,11 +262,26 @@ func (c *ConsumerGroup) close() {
 		}
 		<-ch
 		return true
-	})
+	}
+	c.consumers.Range(closeConsumer)
+	c.evicted.Range(closeConsumer)
 }
 
 // reBalance 单独使用该方法是并发不安全的
 func (c *ConsumerGroup) reBalance() {
+	start := time.Now()
+	defer func() {
+		c.metrics.ObserveRebalance(c.topic, c.name, time.Since(start))
+	}()
+	if c.rebalanceProtocol == RebalanceCooperative {
+		c.cooperativeReBalance()
+		return
+	}
+	c.eagerReBalance()
+}
+
+// eagerReBalance 所有消费者先上报进度并交出分区，再统一重新分配
+func (c *ConsumerGroup) eagerReBalance() {
 	// 通知每一个消费者进行偏移量的上报
 	length := 0
 	consumers := make([]string, 0, consumerCap)
@@ -184,6 +293,7 @@ func (c *ConsumerGroup) reBalance() {
 		length++
 		return true
 	})
+	consumers = c.appendDeparted(consumers)
 	number := 0
 	// 等待所有消费者都接收到信号，并上报自己offset
 	for length > 0 {
@@ -193,35 +303,118 @@ func (c *ConsumerGroup) reBalance() {
 			continue
 		}
 		// 接收到所有信号
-		consumerMap := c.consumerPartitionAssigner.AssignPartition(consumers, len(c.partitions))
+		consumerMap := c.assign(consumers)
 		// 通知所有消费者分配
 		for consumerName, partitions := range consumerMap {
 			// 查找消费者所属的channel
 			consumer, ok := c.consumers.Load(consumerName)
 			if ok {
 				// 往每个消费者的receive_channel发送partition的信息
-				records := make([]PartitionRecord, 0, len(partitions))
-				for _, p := range partitions {
-					record, ok := c.partitionRecords.Load(p)
-					if ok {
-						records = append(records, record)
-					}
-				}
 				consumer.receiveCh <- &Event{
 					Type: PartitionNotifyEvent,
-					Data: records,
+					Data: c.loadRecords(partitions),
 				}
 				// 等待消费者接收到并保存
 				<-c.balanceCh
 
 			}
 		}
+		c.assignments = consumerMap
 		return
 	}
+	// 消费组内已经没有在线的消费者，分区只分配给尚未超时的静态成员
+	c.assignments = c.assign(consumers)
+}
+
+// cooperativeReBalance 只收回需要移动的分区，收回完成后再把它们分配给新的消费者，
+// 分区没有变化的消费者不会被打断
+func (c *ConsumerGroup) cooperativeReBalance() {
+	consumers := make([]string, 0, consumerCap)
+	c.consumers.Range(func(key string, _ *Consumer) bool {
+		consumers = append(consumers, key)
+		return true
+	})
+	consumers = c.appendDeparted(consumers)
+	target := c.assign(consumers)
+	// 先收回分区，已经退出的消费者在退出前就交出了全部分区
+	for name, owned := range c.assignments {
+		consumer, ok := c.consumers.Load(name)
+		if !ok {
+			continue
+		}
+		revoked := subtractPartitions(owned, target[name])
+		if len(revoked) == 0 {
+			continue
+		}
+		consumer.receiveCh <- &Event{
+			Type: RevokeEvent,
+			Data: revoked,
+		}
+		<-c.balanceCh
+	}
+	// 再分配新增的分区，此时分区的消费进度已经由原持有者上报
+	for name, partitions := range target {
+		consumer, ok := c.consumers.Load(name)
+		if !ok {
+			continue
+		}
+		added := subtractPartitions(partitions, c.assignments[name])
+		if len(added) == 0 {
+			continue
+		}
+		consumer.receiveCh <- &Event{
+			Type: AssignEvent,
+			Data: c.loadRecords(added),
+		}
+		<-c.balanceCh
+	}
+	c.assignments = target
+}
+
+func (c *ConsumerGroup) assign(consumers []string) map[string][]int {
+	if len(consumers) == 0 {
+		return map[string][]int{}
+	}
+	if assigner, ok := c.consumerPartitionAssigner.(StickyConsumerPartitionAssigner); ok {
+		return assigner.AssignPartitionWithPrevious(consumers, len(c.partitions), c.assignments)
+	}
+	return c.consumerPartitionAssigner.AssignPartition(consumers, len(c.partitions))
+}
+
+// appendDeparted 已经退出的静态成员仍然参与分配，它们的分区会一直保留到会话超时
+func (c *ConsumerGroup) appendDeparted(consumers []string) []string {
+	c.departed.Range(func(name string, _ struct{}) bool {
+		consumers = append(consumers, name)
+		return true
+	})
+	return consumers
+}
+
+func (c *ConsumerGroup) loadRecords(partitions []int) []PartitionRecord {
+	records := make([]PartitionRecord, 0, len(partitions))
+	for _, p := range partitions {
+		record, ok := c.partitionRecords.Load(p)
+		if ok {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// subtractPartitions 返回在src中但不在dst中的分区
+func subtractPartitions(src, dst []int) []int {
+	res := make([]int, 0, len(src))
+	for _, p := range src {
+		if !slices.Contains(dst, p) {
+			res = append(res, p)
+		}
+	}
+	return res
 }
 
 // JoinGroup 加入消费组
-func (c *ConsumerGroup) JoinGroup() (*Consumer, error) {
+func (c *ConsumerGroup) JoinGroup(opts ...option.Option[mq.ConsumerConfig]) (*Consumer, error) {
+	cfg := mq.NewConsumerConfig(opts...)
 	for {
 
 		if atomic.LoadInt32(&c.status) > StatusBalancing {
@@ -232,33 +425,148 @@ func (c *ConsumerGroup) JoinGroup() (*Consumer, error) {
 			continue
 		}
 
-		var length int
-		c.consumers.Range(func(_ string, _ *Consumer) bool {
-			length++
-			return true
-		})
-		name := fmt.Sprintf("%s_%d", c.name, length)
+		name := c.memberName(cfg.InstanceID)
+		if c.isMember(name) {
+			atomic.CompareAndSwapInt32(&c.status, StatusBalancing, StatusStable)
+			return nil, fmt.Errorf("%w: %s", ErrInstanceIDInUse, cfg.InstanceID)
+		}
 		reportCh := make(chan *Event, defaultEventCap)
 		receiveCh := make(chan *Event, defaultEventCap)
 		consumer := &Consumer{
-			partitions:       c.partitions,
-			receiveCh:        receiveCh,
-			reportCh:         reportCh,
-			name:             name,
-			msgCh:            make(chan *mq.Message, msgChannelLength),
-			partitionRecords: []PartitionRecord{},
-			closeCh:          make(chan struct{}),
+			partitions:        c.partitions,
+			receiveCh:         receiveCh,
+			reportCh:          reportCh,
+			name:              name,
+			topic:             c.topic,
+			groupID:           c.name,
+			metrics:           c.metrics,
+			msgCh:             make(chan *mq.Message, msgChannelLength),
+			partitionRecords:  []PartitionRecord{},
+			closeCh:           make(chan struct{}),
+			closing:           make(chan struct{}),
+			revokeCh:          make(chan chan struct{}),
+			loopDone:          make(chan struct{}),
+			onAssigned:        cfg.OnPartitionsAssigned,
+			onRevoked:         cfg.OnPartitionsRevoked,
+			heartbeatInterval: c.heartbeatInterval,
+			maxPollInterval:   c.maxPollInterval,
+			instanceID:        cfg.InstanceID,
+			manualCommit:      cfg.ManualCommit,
+			isolationLevel:    cfg.IsolationLevel,
 		}
+		consumer.lastPoll.Store(time.Now().UnixNano())
 		c.consumers.Store(name, consumer)
+		c.heartbeats.Store(name, time.Now())
 		go c.consumerEventsHandler(name, reportCh)
 		go consumer.eventLoop()
-		// 重平衡分配分区
-		c.reBalance()
+		if _, ok := c.departed.LoadAndDelete(name); ok {
+			// 静态成员在会话超时之前重新加入，直接拿回保留的分区
+			c.restoreAssignment(name, consumer)
+		} else {
+			// 重平衡分配分区
+			c.reBalance()
+		}
 		atomic.CompareAndSwapInt32(&c.status, StatusBalancing, StatusStable)
 		return consumer, nil
 	}
 }
 
+// evictLoop 定期检查消费者的心跳，将超过sessionTimeout没有心跳的消费者移出消费组，消费组关闭后退出
+func (c *ConsumerGroup) evictLoop() {
+	if c.sessionTimeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.sessionTimeout / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		if atomic.LoadInt32(&c.status) > StatusBalancing {
+			return
+		}
+		now := time.Now()
+		c.heartbeats.Range(func(name string, last time.Time) bool {
+			if now.Sub(last) > c.sessionTimeout {
+				c.evict(name)
+			}
+			return true
+		})
+	}
+}
+
+// evict 将消费者移出消费组并重平衡，消费组正在重平衡时放弃，等待下一次检查
+func (c *ConsumerGroup) evict(name string) {
+	if !atomic.CompareAndSwapInt32(&c.status, StatusStable, StatusBalancing) {
+		return
+	}
+	defer atomic.CompareAndSwapInt32(&c.status, StatusBalancing, StatusStable)
+	consumer, ok := c.consumers.LoadAndDelete(name)
+	if !ok {
+		// 静态成员会话超时，释放为它保留的分区
+		if _, ok = c.departed.LoadAndDelete(name); ok {
+			c.heartbeats.Delete(name)
+			c.reBalance()
+		}
+		return
+	}
+	c.heartbeats.Delete(name)
+	c.evicted.Store(name, consumer)
+	// 消费者可能已经卡住，不能阻塞在这里。没有通知到的消费者会在上报进度失败时发现自己被移出
+	select {
+	case consumer.receiveCh <- &Event{Type: EvictEvent}:
+	default:
+	}
+	c.reBalance()
+}
+
+// rejoinGroup 被移出的消费者恢复之后重新加入消费组
+func (c *ConsumerGroup) rejoinGroup(name string) {
+	for {
+		if atomic.LoadInt32(&c.status) > StatusBalancing {
+			return
+		}
+		if !atomic.CompareAndSwapInt32(&c.status, StatusStable, StatusBalancing) {
+			time.Sleep(defaultSleepTime)
+			continue
+		}
+		// 消费者可能在等待期间已经退出
+		if consumer, ok := c.evicted.LoadAndDelete(name); ok {
+			c.consumers.Store(name, consumer)
+			c.heartbeats.Store(name, time.Now())
+			c.reBalance()
+		}
+		atomic.CompareAndSwapInt32(&c.status, StatusBalancing, StatusStable)
+		return
+	}
+}
+
+// memberName 静态成员的名称由InstanceID决定，其余消费者使用递增的序号，保证在消费组内唯一
+func (c *ConsumerGroup) memberName(instanceID string) string {
+	if instanceID != "" {
+		return fmt.Sprintf("%s_instance_%s", c.name, instanceID)
+	}
+	return fmt.Sprintf("%s_%d", c.name, c.memberSeq.Add(1)-1)
+}
+
+func (c *ConsumerGroup) isMember(name string) bool {
+	if _, ok := c.consumers.Load(name); ok {
+		return true
+	}
+	_, ok := c.evicted.Load(name)
+	return ok
+}
+
+// restoreAssignment 把保留的分区重新交给静态成员，其余消费者不受影响
+func (c *ConsumerGroup) restoreAssignment(name string, consumer *Consumer) {
+	partitions := c.assignments[name]
+	if len(partitions) == 0 {
+		return
+	}
+	consumer.receiveCh <- &Event{
+		Type: AssignEvent,
+		Data: c.loadRecords(partitions),
+	}
+	<-c.balanceCh
+}
+
 // consumerEventsHandler 处理消费者上报的事件
 func (c *ConsumerGroup) consumerEventsHandler(name string, reportCh chan *Event) {
 	for event := range reportCh {
@@ -15,14 +15,18 @@
 package memory
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/ecodeclub/ekit/syncx"
+	"github.com/ecodeclub/mq-api"
 	"github.com/ecodeclub/mq-api/memory/consumerpartitionassigner/equaldivide"
+	"github.com/ecodeclub/mq-api/memory/consumerpartitionassigner/sticky"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // 测试场景： 不断有 消费者加入 消费组，最后达成的效果，调用consumerGroup的close方法成功之后，consumerGroup里面没有consumer存在且所有的consumer都是关闭的状态
@@ -40,6 +44,7 @@ func TestConsumerGroup_Close(t *testing.T) {
 		},
 		balanceCh: make(chan struct{}, defaultBalanceChLen),
 		status:    StatusStable,
+		metrics:   mq.NopMetrics{},
 	}
 	partitionRecords := syncx.Map[int, PartitionRecord]{}
 	for idx := range cg.partitions {
@@ -82,3 +87,223 @@ func TestConsumerGroup_Close(t *testing.T) {
 		return true
 	})
 }
+
+func TestConsumerGroup_CooperativeReBalance(t *testing.T) {
+	t.Parallel()
+	cg := &ConsumerGroup{
+		name:                      "test_group",
+		consumers:                 syncx.Map[string, *Consumer]{},
+		consumerPartitionAssigner: sticky.NewAssigner(),
+		partitions: []*Partition{
+			NewPartition(),
+			NewPartition(),
+			NewPartition(),
+			NewPartition(),
+		},
+		balanceCh:         make(chan struct{}, defaultBalanceChLen),
+		status:            StatusStable,
+		rebalanceProtocol: RebalanceCooperative,
+		assignments:       map[string][]int{},
+		metrics:           mq.NopMetrics{},
+	}
+	partitionRecords := syncx.Map[int, PartitionRecord]{}
+	for idx := range cg.partitions {
+		partitionRecords.Store(idx, PartitionRecord{
+			Index:  idx,
+			Offset: 0,
+		})
+	}
+	cg.partitionRecords = &partitionRecords
+
+	c1, err := cg.JoinGroup()
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]int{c1.name: {0, 1, 2, 3}}, cg.assignments)
+
+	c2, err := cg.JoinGroup()
+	require.NoError(t, err)
+	// c1 只交出了多余的分区
+	assert.Equal(t, map[string][]int{
+		c1.name: {0, 1},
+		c2.name: {2, 3},
+	}, cg.assignments)
+
+	cg.partitions[3].append(&mq.Message{Value: []byte("3")})
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	msg, err := c2.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("3"), msg.Value)
+
+	require.NoError(t, c1.Close())
+	assert.Equal(t, map[string][]int{c2.name: {0, 1, 2, 3}}, cg.assignments)
+	cg.partitions[0].append(&mq.Message{Value: []byte("0")})
+	msg, err = c2.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("0"), msg.Value)
+}
+
+func TestConsumerGroup_RebalanceCallbacks(t *testing.T) {
+	t.Parallel()
+	cg := &ConsumerGroup{
+		name:                      "test_group",
+		consumers:                 syncx.Map[string, *Consumer]{},
+		consumerPartitionAssigner: equaldivide.NewAssigner(),
+		partitions: []*Partition{
+			NewPartition(),
+			NewPartition(),
+			NewPartition(),
+		},
+		balanceCh:   make(chan struct{}, defaultBalanceChLen),
+		status:      StatusStable,
+		assignments: map[string][]int{},
+		metrics:     mq.NopMetrics{},
+	}
+	partitionRecords := syncx.Map[int, PartitionRecord]{}
+	for idx := range cg.partitions {
+		partitionRecords.Store(idx, PartitionRecord{
+			Index:  idx,
+			Offset: 0,
+		})
+	}
+	cg.partitionRecords = &partitionRecords
+
+	assignedCh := make(chan []int, 10)
+	revokedCh := make(chan []int, 10)
+	c1, err := cg.JoinGroup(
+		mq.WithOnPartitionsAssigned(func(partitions []int) {
+			assignedCh <- partitions
+		}),
+		mq.WithOnPartitionsRevoked(func(partitions []int) {
+			revokedCh <- partitions
+		}))
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, <-assignedCh)
+
+	// 新的消费者加入，c1先交出全部分区再重新分配
+	_, err = cg.JoinGroup()
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, <-revokedCh)
+	// 遍历消费者的顺序不固定，c1可能分到[0, 1]或者[2]
+	assigned := <-assignedCh
+	assert.Contains(t, [][]int{{0, 1}, {2}}, assigned)
+
+	require.NoError(t, c1.Close())
+	assert.Equal(t, assigned, <-revokedCh)
+}
+
+// 测试场景：c1 迟迟不取走已经投递的消息，停止心跳后被移出消费组，它的分区交给c2；c1恢复之后重新加入消费组
+func TestConsumerGroup_Evict(t *testing.T) {
+	t.Parallel()
+	m := NewMQ(WithSessionTimeout(300*time.Millisecond, 50*time.Millisecond),
+		WithMaxPollInterval(200*time.Millisecond))
+	defer func() {
+		_ = m.Close()
+	}()
+	topic, group := "evict_topic", "evict_group"
+	wait := func(ch chan []int) []int {
+		select {
+		case partitions := <-ch:
+			return partitions
+		case <-time.After(10 * time.Second):
+			t.Fatal("等待重平衡超时")
+			return nil
+		}
+	}
+
+	c1Assigned, c1Revoked := make(chan []int, 10), make(chan []int, 10)
+	c1, err := m.Consumer(topic, group,
+		mq.WithOnPartitionsAssigned(func(partitions []int) {
+			c1Assigned <- partitions
+		}),
+		mq.WithOnPartitionsRevoked(func(partitions []int) {
+			c1Revoked <- partitions
+		}))
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, wait(c1Assigned))
+
+	c2Assigned := make(chan []int, 10)
+	c2, err := m.Consumer(topic, group, mq.WithOnPartitionsAssigned(func(partitions []int) {
+		c2Assigned <- partitions
+	}))
+	require.NoError(t, err)
+	wait(c1Revoked)
+	owned := wait(c1Assigned)
+	wait(c2Assigned)
+	go func() {
+		ch, er := c2.ConsumeChan(context.Background())
+		if er != nil {
+			return
+		}
+		for range ch {
+		}
+	}()
+
+	p, err := m.Producer(topic)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = p.ProduceWithPartition(context.Background(), &mq.Message{Value: []byte("msg")}, i)
+		require.NoError(t, err)
+	}
+
+	// c1 不消费，被移出消费组
+	assert.Equal(t, owned, wait(c1Revoked))
+	assert.Equal(t, []int{0, 1, 2}, wait(c2Assigned))
+
+	// c1 恢复消费之后重新加入
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		_, err = c1.Consume(ctx)
+		cancel()
+		if err != nil {
+			break
+		}
+	}
+	assert.NotEmpty(t, wait(c1Assigned))
+}
+
+func TestConsumerGroup_JoinGroup(t *testing.T) {
+	t.Parallel()
+	m := NewMQ(WithSessionTimeout(time.Minute, time.Second))
+	defer func() {
+		_ = m.Close()
+	}()
+	topic, group := "join_topic", "join_group"
+
+	c2Revoked := make(chan []int, 10)
+	c1, err := m.Consumer(topic, group)
+	require.NoError(t, err)
+	c2, err := m.Consumer(topic, group, mq.WithOnPartitionsRevoked(func(partitions []int) {
+		c2Revoked <- partitions
+	}))
+	require.NoError(t, err)
+	require.NoError(t, c1.Close())
+	// c1退出之后新加入的消费者不能与c2重名
+	c3, err := m.Consumer(topic, group)
+	require.NoError(t, err)
+	assert.NotEqual(t, c2.(*Consumer).name, c3.(*Consumer).name)
+	require.NoError(t, c3.Close())
+
+	// 静态成员退出后在会话超时前重新加入，直接拿回原来的分区，c2不受影响
+	instanceAssigned := make(chan []int, 10)
+	instance, err := m.Consumer(topic, group, mq.WithInstanceID("i1"),
+		mq.WithOnPartitionsAssigned(func(partitions []int) {
+			instanceAssigned <- partitions
+		}))
+	require.NoError(t, err)
+	owned := <-instanceAssigned
+
+	_, err = m.Consumer(topic, group, mq.WithInstanceID("i1"))
+	assert.ErrorIs(t, err, ErrInstanceIDInUse)
+
+	for len(c2Revoked) > 0 {
+		<-c2Revoked
+	}
+	require.NoError(t, instance.Close())
+	_, err = m.Consumer(topic, group, mq.WithInstanceID("i1"),
+		mq.WithOnPartitionsAssigned(func(partitions []int) {
+			instanceAssigned <- partitions
+		}))
+	require.NoError(t, err)
+	assert.Equal(t, owned, <-instanceAssigned)
+	assert.Empty(t, c2Revoked)
+}
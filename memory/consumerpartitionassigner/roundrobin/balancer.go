@@ -0,0 +1,39 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package roundrobin
+
+// Assigner 按照轮询的方式分配分区，第i个分区分配给第 i % len(consumers) 个消费者
+type Assigner struct{}
+
+func (b *Assigner) AssignPartition(consumers []string, partitions int) map[string][]int {
+	result := make(map[string][]int, len(consumers))
+	consumerCount := len(consumers)
+	// 初始化每个 consumer 对应的 partitions
+	for _, consumer := range consumers {
+		result[consumer] = make([]int, 0)
+	}
+	if consumerCount == 0 {
+		return result
+	}
+	for partitionIndex := 0; partitionIndex < partitions; partitionIndex++ {
+		consumer := consumers[partitionIndex%consumerCount]
+		result[consumer] = append(result[consumer], partitionIndex)
+	}
+	return result
+}
+
+func NewAssigner() *Assigner {
+	return &Assigner{}
+}
@@ -0,0 +1,25 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sticky
+
+import "github.com/ecodeclub/mq-api/internal/assign/sticky"
+
+// Assigner 粘性分配器，在保证各消费者分区数尽量均衡的前提下，尽可能保留消费者上一轮持有的分区。
+// 分配算法与kafka的 StickyGroupBalancer 共用
+type Assigner = sticky.Assigner
+
+func NewAssigner() *Assigner {
+	return sticky.NewAssigner()
+}
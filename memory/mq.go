@@ -19,29 +19,135 @@ import (
 	"fmt"
 	"log/slog"
 	"sync"
+	"time"
 
 	"github.com/ecodeclub/mq-api/internal/pkg/validator"
 
+	"github.com/ecodeclub/ekit/bean/option"
 	"github.com/ecodeclub/ekit/syncx"
 	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
 	"github.com/ecodeclub/mq-api/internal/errs"
 )
 
 const (
 	defaultBalanceChLen = 10
 	defaultPartitions   = 3
+	// 与kafka的默认值保持一致
+	defaultSessionTimeout    = 45 * time.Second
+	defaultHeartbeatInterval = 3 * time.Second
+	defaultMaxPollInterval   = 5 * time.Minute
 )
 
 type MQ struct {
 	locker sync.RWMutex
 	closed bool
 	topics syncx.Map[string, *Topic]
+	// 新建topic时使用的分区分配器，为nil时使用equaldivide.Assigner
+	consumerPartitionAssigner ConsumerPartitionAssigner
+	rebalanceProtocol         RebalanceProtocol
+	sessionTimeout            time.Duration
+	heartbeatInterval         time.Duration
+	maxPollInterval           time.Duration
+	timestampType             mq.TimestampType
+	clock                     clock.Clock
+	producerInterceptors      []mq.ProducerInterceptor
+	consumerInterceptors      []mq.ConsumerInterceptor
+	metrics                   mq.Metrics
+	// 键为transactionalID，每个transactionalID只保留最新的事务生产者
+	txnProducers map[string]*TxnProducer
 }
 
-func NewMQ() mq.MQ {
-	return &MQ{
-		topics: syncx.Map[string, *Topic]{},
+func NewMQ(opts ...option.Option[MQ]) mq.MQ {
+	m := &MQ{
+		topics:            syncx.Map[string, *Topic]{},
+		rebalanceProtocol: RebalanceEager,
+		sessionTimeout:    defaultSessionTimeout,
+		heartbeatInterval: defaultHeartbeatInterval,
+		maxPollInterval:   defaultMaxPollInterval,
+		clock:             clock.New(),
+		metrics:           mq.NopMetrics{},
+		txnProducers:      map[string]*TxnProducer{},
 	}
+	option.Apply(m, opts...)
+	return m
+}
+
+// WithConsumerPartitionAssigner 指定消费组分配分区的策略
+func WithConsumerPartitionAssigner(assigner ConsumerPartitionAssigner) option.Option[MQ] {
+	return func(m *MQ) {
+		m.consumerPartitionAssigner = assigner
+	}
+}
+
+// WithRebalanceProtocol 指定消费组的重平衡协议，默认为 RebalanceEager
+func WithRebalanceProtocol(protocol RebalanceProtocol) option.Option[MQ] {
+	return func(m *MQ) {
+		m.rebalanceProtocol = protocol
+	}
+}
+
+// WithSessionTimeout 消费者超过timeout没有心跳就会被移出消费组并触发重平衡，
+// heartbeatInterval为消费者发送心跳的间隔，对应kafka的session.timeout.ms与heartbeat.interval.ms
+func WithSessionTimeout(timeout, heartbeatInterval time.Duration) option.Option[MQ] {
+	return func(m *MQ) {
+		m.sessionTimeout = timeout
+		m.heartbeatInterval = heartbeatInterval
+	}
+}
+
+// WithMaxPollInterval 已投递给消费者的消息超过interval没有被取走，消费者停止心跳并最终被移出消费组，
+// 对应kafka的max.poll.interval.ms
+func WithMaxPollInterval(interval time.Duration) option.Option[MQ] {
+	return func(m *MQ) {
+		m.maxPollInterval = interval
+	}
+}
+
+// WithTimestampType 指定消息时间戳的类型，默认为 mq.TimestampCreateTime
+func WithTimestampType(timestampType mq.TimestampType) option.Option[MQ] {
+	return func(m *MQ) {
+		m.timestampType = timestampType
+	}
+}
+
+// WithClock 指定延迟消息与消息时间戳使用的时钟，测试中可以使用 clock.Mock
+func WithClock(clk clock.Clock) option.Option[MQ] {
+	return func(m *MQ) {
+		m.clock = clk
+	}
+}
+
+// WithProducerInterceptors 为 Producer 创建的所有生产者按照顺序套上拦截器，第一个拦截器在最外层
+func WithProducerInterceptors(interceptors ...mq.ProducerInterceptor) option.Option[MQ] {
+	return func(m *MQ) {
+		m.producerInterceptors = interceptors
+	}
+}
+
+// WithConsumerInterceptors 为 Consumer 创建的所有消费者按照顺序套上拦截器，第一个拦截器在最外层
+func WithConsumerInterceptors(interceptors ...mq.ConsumerInterceptor) option.Option[MQ] {
+	return func(m *MQ) {
+		m.consumerInterceptors = interceptors
+	}
+}
+
+// WithMetrics 指定记录生产、消费与重平衡情况的 mq.Metrics
+func WithMetrics(metrics mq.Metrics) option.Option[MQ] {
+	return func(m *MQ) {
+		m.metrics = metrics
+	}
+}
+
+func (m *MQ) newTopic(name string, partitions int) *Topic {
+	t := newTopic(name, partitions)
+	t.timestampType = m.timestampType
+	t.clock = m.clock
+	t.metrics = m.metrics
+	if m.consumerPartitionAssigner != nil {
+		t.consumerPartitionAssigner = m.consumerPartitionAssigner
+	}
+	return t
 }
 
 func (m *MQ) CreateTopic(ctx context.Context, topic string, partitions int) error {
@@ -61,7 +167,7 @@ func (m *MQ) CreateTopic(ctx context.Context, topic string, partitions int) erro
 	}
 	_, ok := m.topics.Load(topic)
 	if !ok {
-		m.topics.Store(topic, newTopic(topic, partitions))
+		m.topics.Store(topic, m.newTopic(topic, partitions))
 	}
 	return nil
 }
@@ -74,7 +180,7 @@ func (m *MQ) Producer(topic string) (mq.Producer, error) {
 	}
 	t, ok := m.topics.Load(topic)
 	if !ok {
-		t = newTopic(topic, defaultPartitions)
+		t = m.newTopic(topic, defaultPartitions)
 		m.topics.Store(topic, t)
 	}
 	p := &Producer{
@@ -84,10 +190,10 @@ func (m *MQ) Producer(topic string) (mq.Producer, error) {
 	if err != nil {
 		return nil, err
 	}
-	return p, nil
+	return mq.InterceptProducer(p, mq.ProducerInfo{Topic: topic}, m.producerInterceptors...), nil
 }
 
-func (m *MQ) Consumer(topic, groupID string) (mq.Consumer, error) {
+func (m *MQ) Consumer(topic, groupID string, opts ...option.Option[mq.ConsumerConfig]) (mq.Consumer, error) {
 	m.locker.Lock()
 	defer m.locker.Unlock()
 	if m.closed {
@@ -95,18 +201,25 @@ func (m *MQ) Consumer(topic, groupID string) (mq.Consumer, error) {
 	}
 	t, ok := m.topics.Load(topic)
 	if !ok {
-		t = newTopic(topic, defaultPartitions)
+		t = m.newTopic(topic, defaultPartitions)
 		m.topics.Store(topic, t)
 	}
 	group, ok := t.consumerGroups.Load(groupID)
 	if !ok {
 		group = &ConsumerGroup{
 			name:                      groupID,
+			topic:                     topic,
+			metrics:                   m.metrics,
 			consumers:                 syncx.Map[string, *Consumer]{},
 			consumerPartitionAssigner: t.consumerPartitionAssigner,
 			partitions:                t.partitions,
 			balanceCh:                 make(chan struct{}, defaultBalanceChLen),
 			status:                    StatusStable,
+			rebalanceProtocol:         m.rebalanceProtocol,
+			assignments:               map[string][]int{},
+			sessionTimeout:            m.sessionTimeout,
+			heartbeatInterval:         m.heartbeatInterval,
+			maxPollInterval:           m.maxPollInterval,
 		}
 		// 初始化分区消费进度
 		partitionRecords := syncx.Map[int, PartitionRecord]{}
@@ -117,13 +230,34 @@ func (m *MQ) Consumer(topic, groupID string) (mq.Consumer, error) {
 			})
 		}
 		group.partitionRecords = &partitionRecords
+		go group.evictLoop()
 	}
-	consumer, err := group.JoinGroup()
+	consumer, err := group.JoinGroup(opts...)
 	if err != nil {
 		return nil, err
 	}
 	t.consumerGroups.Store(groupID, group)
-	return consumer, nil
+	return mq.InterceptConsumer(consumer, mq.ConsumerInfo{Topic: topic, GroupID: groupID}, m.consumerInterceptors...), nil
+}
+
+func (m *MQ) OffsetsForTime(ctx context.Context, topic string, t time.Time) (map[int]int64, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	m.locker.RLock()
+	defer m.locker.RUnlock()
+	if m.closed {
+		return nil, errs.ErrMQIsClosed
+	}
+	tp, ok := m.topics.Load(topic)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errs.ErrUnknownTopic, topic)
+	}
+	res := make(map[int]int64, len(tp.partitions))
+	for idx, p := range tp.partitions {
+		res[idx] = p.offsetForTime(t)
+	}
+	return res, nil
 }
 
 func (m *MQ) Close() error {
@@ -133,7 +267,7 @@ func (m *MQ) Close() error {
 	m.topics.Range(func(key string, value *Topic) bool {
 		err := value.Close()
 		if err != nil {
-			slog.Error("topic关闭失败", slog.String("topic", key), slog.String("error", err.Error()))
+			mq.Logger().Error("topic关闭失败", slog.String("topic", key), slog.String("error", err.Error()))
 		}
 		return true
 	})
@@ -155,7 +289,7 @@ func (m *MQ) DeleteTopics(ctx context.Context, topics ...string) error {
 		if ok {
 			err := topic.Close()
 			if err != nil {
-				slog.Error("topic关闭失败", slog.String("error", err.Error()))
+				mq.Logger().Error("topic关闭失败", slog.String("error", err.Error()))
 				continue
 			}
 			m.topics.Delete(t)
@@ -15,7 +15,13 @@
 package memory
 
 import (
+	"context"
 	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
+	"github.com/ecodeclub/mq-api/internal/errs"
 
 	"github.com/ecodeclub/ekit/syncx"
 	"github.com/stretchr/testify/assert"
@@ -26,7 +32,8 @@ func TestMQ(t *testing.T) {
 	t.Parallel()
 	// 测试调用consumer 和 producer 如果topic不存在就新建
 	testmq := &MQ{
-		topics: syncx.Map[string, *Topic]{},
+		topics:  syncx.Map[string, *Topic]{},
+		metrics: mq.NopMetrics{},
 	}
 	_, err := testmq.Consumer("test_topic", "group1")
 	require.NoError(t, err)
@@ -37,3 +44,198 @@ func TestMQ(t *testing.T) {
 	_, ok = testmq.topics.Load("test_topic1")
 	assert.Equal(t, ok, true)
 }
+
+func TestMQ_OffsetsForTime(t *testing.T) {
+	t.Parallel()
+	testmq := NewMQ()
+	_, err := testmq.OffsetsForTime(context.Background(), "unknown_topic", time.Now())
+	assert.ErrorIs(t, err, errs.ErrUnknownTopic)
+
+	require.NoError(t, testmq.CreateTopic(context.Background(), "offsets_for_time", 2))
+	p, err := testmq.Producer("offsets_for_time")
+	require.NoError(t, err)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err = p.ProduceWithPartition(context.Background(), &mq.Message{
+			Value:     []byte("msg"),
+			Timestamp: now.Add(time.Duration(i) * time.Minute),
+		}, 0)
+		require.NoError(t, err)
+	}
+
+	testCases := []struct {
+		name    string
+		t       time.Time
+		wantRes map[int]int64
+	}{
+		{
+			name:    "早于所有消息",
+			t:       now.Add(-time.Minute),
+			wantRes: map[int]int64{0: 0, 1: 0},
+		},
+		{
+			name:    "与消息时间戳相同",
+			t:       now.Add(time.Minute),
+			wantRes: map[int]int64{0: 1, 1: 0},
+		},
+		{
+			name:    "晚于所有消息",
+			t:       now.Add(time.Hour),
+			wantRes: map[int]int64{0: 3, 1: 0},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := testmq.OffsetsForTime(context.Background(), "offsets_for_time", tc.t)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantRes, res)
+		})
+	}
+}
+
+func TestMQ_TimestampType(t *testing.T) {
+	t.Parallel()
+	createTime := time.Now().Add(-time.Hour)
+	testCases := []struct {
+		name          string
+		timestampType mq.TimestampType
+		timestamp     time.Time
+		assertFunc    func(t *testing.T, ts time.Time)
+	}{
+		{
+			name:          "CreateTime保留生产者设置的时间",
+			timestampType: mq.TimestampCreateTime,
+			timestamp:     createTime,
+			assertFunc: func(t *testing.T, ts time.Time) {
+				assert.True(t, ts.Equal(createTime))
+			},
+		},
+		{
+			name:          "CreateTime未设置时使用发送时间",
+			timestampType: mq.TimestampCreateTime,
+			assertFunc: func(t *testing.T, ts time.Time) {
+				assert.False(t, ts.IsZero())
+			},
+		},
+		{
+			name:          "LogAppendTime覆盖生产者设置的时间",
+			timestampType: mq.TimestampLogAppendTime,
+			timestamp:     createTime,
+			assertFunc: func(t *testing.T, ts time.Time) {
+				assert.True(t, ts.After(createTime.Add(time.Minute)))
+			},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			testmq := NewMQ(WithTimestampType(tc.timestampType))
+			p, err := testmq.Producer("timestamp_type")
+			require.NoError(t, err)
+			c, err := testmq.Consumer("timestamp_type", "c1")
+			require.NoError(t, err)
+			_, err = p.Produce(context.Background(), &mq.Message{Value: []byte("msg"), Timestamp: tc.timestamp})
+			require.NoError(t, err)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			msg, err := c.Consume(ctx)
+			require.NoError(t, err)
+			tc.assertFunc(t, msg.Timestamp)
+		})
+	}
+}
+
+func TestMQ_ProduceAt(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewMock(start)
+	testmq := NewMQ(WithClock(clk))
+	defer func() {
+		_ = testmq.Close()
+	}()
+	require.NoError(t, testmq.CreateTopic(context.Background(), "produce_at", 1))
+	p, err := testmq.Producer("produce_at")
+	require.NoError(t, err)
+	c, err := testmq.Consumer("produce_at", "c1")
+	require.NoError(t, err)
+
+	_, err = p.ProduceAt(context.Background(), &mq.Message{Value: []byte("30m")}, start.Add(30*time.Minute))
+	require.NoError(t, err)
+	delayed := &mq.Message{Value: []byte("10m"), Header: mq.Header{{Key: "k", Value: []byte("v")}}}
+	_, err = p.ProduceAt(context.Background(), delayed, start.Add(10*time.Minute))
+	require.NoError(t, err)
+	// 延迟队列中保存的是副本，调用者之后修改消息不影响投递的内容
+	delayed.Value[0] = '2'
+	delayed.Header[0].Value[0] = 'x'
+	_, err = p.ProduceAt(context.Background(), &mq.Message{Value: []byte("now")}, start)
+	require.NoError(t, err)
+
+	consume := func(timeout time.Duration) (*mq.Message, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return c.Consume(ctx)
+	}
+	msg, err := consume(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "now", string(msg.Value))
+	_, err = consume(2 * time.Second)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	clk.Add(10 * time.Minute)
+	msg, err = consume(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "10m", string(msg.Value))
+	assert.Equal(t, "v", msg.Header.Get("k"))
+	assert.Equal(t, start.Add(10*time.Minute), msg.Timestamp)
+
+	clk.Add(20 * time.Minute)
+	msg, err = consume(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "30m", string(msg.Value))
+
+	// 到期时间使用生产者的时钟计算
+	_, err = mq.ProduceWithDelay(context.Background(), p, &mq.Message{Value: []byte("delay")}, 5*time.Minute)
+	require.NoError(t, err)
+	_, err = consume(2 * time.Second)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	clk.Add(5 * time.Minute)
+	msg, err = consume(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "delay", string(msg.Value))
+}
+
+func TestMQ_Interceptors(t *testing.T) {
+	t.Parallel()
+	testmq := NewMQ(
+		WithProducerInterceptors(mq.ProducerInterceptorFunc(func(info mq.ProducerInfo, next mq.ProduceFunc) mq.ProduceFunc {
+			return func(ctx context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+				m.Header.Set("producer", info.Topic)
+				return next(ctx, m)
+			}
+		})),
+		WithConsumerInterceptors(mq.ConsumerInterceptorFunc(func(info mq.ConsumerInfo, next mq.ConsumeFunc) mq.ConsumeFunc {
+			return func(ctx context.Context) (*mq.Message, error) {
+				m, err := next(ctx)
+				if err != nil {
+					return nil, err
+				}
+				m.Header.Set("consumer", info.GroupID)
+				return m, nil
+			}
+		})),
+	)
+	p, err := testmq.Producer("interceptors")
+	require.NoError(t, err)
+	c, err := testmq.Consumer("interceptors", "c1")
+	require.NoError(t, err)
+	_, err = p.Produce(context.Background(), &mq.Message{Value: []byte("msg")})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	msg, err := c.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "interceptors", msg.Header.Get("producer"))
+	assert.Equal(t, "c1", msg.Header.Get("consumer"))
+}
@@ -15,7 +15,9 @@
 package memory
 
 import (
+	"bytes"
 	"sync"
+	"time"
 
 	"github.com/ecodeclub/ekit/list"
 	"github.com/ecodeclub/mq-api"
@@ -29,11 +31,17 @@ const (
 type Partition struct {
 	locker sync.RWMutex
 	data   *list.ArrayList[*mq.Message]
+	// 进行中的事务在该分区写入的第一条消息的偏移量，键为事务
+	openTxns map[*transaction]int
+	// 已经回滚的事务写入的消息的偏移量
+	aborted map[int]struct{}
 }
 
 func NewPartition() *Partition {
 	return &Partition{
-		data: list.NewArrayList[*mq.Message](defaultPartitionCap),
+		data:     list.NewArrayList[*mq.Message](defaultPartitionCap),
+		openTxns: map[*transaction]int{},
+		aborted:  map[int]struct{}{},
 	}
 }
 
@@ -44,11 +52,79 @@ func (p *Partition) append(msg *mq.Message) {
 	_ = p.data.Append(msg)
 }
 
-func (p *Partition) getBatch(offset, limit int) []*mq.Message {
+// appendTxn 在事务txn中写入消息，事务结束之前 ReadCommitted 的消费者看不到它以及它之后的消息
+func (p *Partition) appendTxn(msg *mq.Message, txn *transaction) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	msg.Offset = int64(p.data.Len())
+	_ = p.data.Append(msg)
+	if _, ok := p.openTxns[txn]; !ok {
+		p.openTxns[txn] = int(msg.Offset)
+	}
+}
+
+// endTxn 结束事务txn，回滚时offsets中的消息不再投递给 ReadCommitted 的消费者
+func (p *Partition) endTxn(txn *transaction, commit bool, offsets []int) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	delete(p.openTxns, txn)
+	if commit {
+		return
+	}
+	for _, offset := range offsets {
+		p.aborted[offset] = struct{}{}
+	}
+}
+
+// getBatch 从offset开始最多读取limit条消息，返回读取的消息与下一次读取的偏移量。
+// ReadCommitted 时只读取到第一个进行中的事务之前，并跳过已经回滚的消息
+func (p *Partition) getBatch(offset, limit int, level mq.IsolationLevel) ([]*mq.Message, int) {
 	p.locker.RLock()
 	defer p.locker.RUnlock()
-	wantLen := offset + limit
-	length := min(wantLen, p.data.Len())
-	res := p.data.AsSlice()[offset:length]
-	return res
+	end := p.data.Len()
+	if level != mq.ReadCommitted {
+		end = min(offset+limit, end)
+		return p.data.AsSlice()[offset:end], end
+	}
+	for _, first := range p.openTxns {
+		end = min(end, first)
+	}
+	res := make([]*mq.Message, 0, min(limit, max(end-offset, 0)))
+	next := offset
+	for ; next < end && len(res) < limit; next++ {
+		if _, ok := p.aborted[next]; ok {
+			continue
+		}
+		// AsSlice 每次都会复制整个列表，逐条读取时使用Get
+		msg, _ := p.data.Get(next)
+		res = append(res, msg)
+	}
+	return res, max(next, offset)
+}
+
+func (p *Partition) len() int {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+	return p.data.Len()
+}
+
+// offsetForTime 返回第一条时间戳不早于t的消息的偏移量，不存在时返回下一条消息的偏移量
+func (p *Partition) offsetForTime(t time.Time) int64 {
+	p.locker.RLock()
+	defer p.locker.RUnlock()
+	for _, msg := range p.data.AsSlice() {
+		if !msg.Timestamp.Before(t) {
+			return msg.Offset
+		}
+	}
+	return int64(p.data.Len())
+}
+
+// cloneMessage 深拷贝消息，分区中保存的消息与投递给消费者的消息互不共享内存
+func cloneMessage(msg *mq.Message) *mq.Message {
+	res := *msg
+	res.Key = bytes.Clone(msg.Key)
+	res.Value = bytes.Clone(msg.Value)
+	res.Header = msg.Header.Clone()
+	return &res
 }
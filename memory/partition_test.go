@@ -30,7 +30,8 @@ func Test_Partition(t *testing.T) {
 		msg := &mq.Message{Value: []byte(strconv.Itoa(i))}
 		p.append(msg)
 	}
-	msgs := p.getBatch(2, 2)
+	msgs, next := p.getBatch(2, 2, mq.ReadUncommitted)
+	assert.Equal(t, 4, next)
 	assert.Equal(t, []*mq.Message{
 		{
 			Value:  []byte(strconv.Itoa(2)),
@@ -41,7 +42,8 @@ func Test_Partition(t *testing.T) {
 			Offset: 3,
 		},
 	}, msgs)
-	msgs = p.getBatch(2, 5)
+	msgs, next = p.getBatch(2, 5, mq.ReadUncommitted)
+	assert.Equal(t, 5, next)
 	assert.Equal(t, []*mq.Message{
 		{
 			Value:  []byte(strconv.Itoa(2)),
@@ -77,7 +79,7 @@ func Test_PartitionConcurrent(t *testing.T) {
 		}()
 	}
 	wg.Wait()
-	msgs := p2.getBatch(0, 16)
+	msgs, _ := p2.getBatch(0, 16, mq.ReadUncommitted)
 	for idx := range msgs {
 		msgs[idx].Partition = 0
 		msgs[idx].Offset = 0
@@ -131,3 +133,44 @@ func Test_PartitionConcurrent(t *testing.T) {
 	}
 	assert.ElementsMatch(t, wantVal, msgs)
 }
+
+func Test_PartitionTxn(t *testing.T) {
+	t.Parallel()
+	p := NewPartition()
+	values := func(msgs []*mq.Message) []string {
+		res := make([]string, 0, len(msgs))
+		for _, msg := range msgs {
+			res = append(res, string(msg.Value))
+		}
+		return res
+	}
+	p.append(&mq.Message{Value: []byte("0")})
+	txn1, txn2 := newTransaction(), newTransaction()
+	p.appendTxn(&mq.Message{Value: []byte("1")}, txn1)
+	p.appendTxn(&mq.Message{Value: []byte("2")}, txn2)
+	p.append(&mq.Message{Value: []byte("3")})
+
+	msgs, next := p.getBatch(0, 10, mq.ReadUncommitted)
+	assert.Equal(t, []string{"0", "1", "2", "3"}, values(msgs))
+	assert.Equal(t, 4, next)
+	// 只能读取到第一个进行中的事务之前
+	msgs, next = p.getBatch(0, 10, mq.ReadCommitted)
+	assert.Equal(t, []string{"0"}, values(msgs))
+	assert.Equal(t, 1, next)
+
+	p.endTxn(txn2, false, []int{2})
+	msgs, next = p.getBatch(1, 10, mq.ReadCommitted)
+	assert.Empty(t, msgs)
+	assert.Equal(t, 1, next)
+	// 跳过已经回滚的消息
+	p.endTxn(txn1, true, []int{1})
+	msgs, next = p.getBatch(1, 10, mq.ReadCommitted)
+	assert.Equal(t, []string{"1", "3"}, values(msgs))
+	assert.Equal(t, 4, next)
+	msgs, next = p.getBatch(1, 1, mq.ReadCommitted)
+	assert.Equal(t, []string{"1"}, values(msgs))
+	assert.Equal(t, 2, next)
+	msgs, next = p.getBatch(2, 1, mq.ReadCommitted)
+	assert.Equal(t, []string{"3"}, values(msgs))
+	assert.Equal(t, 4, next)
+}
@@ -17,7 +17,9 @@ package memory
 import (
 	"context"
 	"sync"
+	"time"
 
+	"github.com/ecodeclub/mq-api/clock"
 	"github.com/ecodeclub/mq-api/internal/errs"
 
 	"github.com/ecodeclub/mq-api"
@@ -38,7 +40,9 @@ func (p *Producer) Produce(ctx context.Context, m *mq.Message) (*mq.ProducerResu
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
+	start := time.Now()
 	err := p.t.addMessage(m)
+	p.t.metrics.ObserveProduce(p.t.name, time.Since(start), err)
 	return &mq.ProducerResult{}, err
 }
 
@@ -51,10 +55,38 @@ func (p *Producer) ProduceWithPartition(ctx context.Context, m *mq.Message, part
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
+	start := time.Now()
 	err := p.t.addMessageWithPartition(m, int64(partition))
+	p.t.metrics.ObserveProduce(p.t.name, time.Since(start), err)
 	return &mq.ProducerResult{}, err
 }
 
+// ProduceAt 消息先保存在topic的延迟队列中，到期后按照 Produce 的方式选择分区
+func (p *Producer) ProduceAt(ctx context.Context, m *mq.Message, at time.Time) (*mq.ProducerResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, errs.ErrProducerIsClosed
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	var err error
+	start := time.Now()
+	if at.After(p.t.clock.Now()) {
+		err = p.t.addDelayedMessage(m, at)
+	} else {
+		err = p.t.addMessage(m)
+	}
+	p.t.metrics.ObserveProduce(p.t.name, time.Since(start), err)
+	return &mq.ProducerResult{}, err
+}
+
+// Clock 返回 WithClock 指定的时钟，mq.ProduceWithDelay 使用它计算到期时间
+func (p *Producer) Clock() clock.Clock {
+	return p.t.clock
+}
+
 func (p *Producer) Close() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -15,10 +15,14 @@
 package memory
 
 import (
+	"log/slog"
 	"sync"
+	"time"
 
 	"github.com/ecodeclub/ekit/syncx"
 	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
+	"github.com/ecodeclub/mq-api/internal/delayqueue"
 	"github.com/ecodeclub/mq-api/internal/errs"
 	"github.com/ecodeclub/mq-api/memory/consumerpartitionassigner/equaldivide"
 	"github.com/ecodeclub/mq-api/memory/produceridgetter/hash"
@@ -35,6 +39,11 @@ type Topic struct {
 	// 生产消息的时候获取分区号
 	producerPartitionIDGetter PartitionIDGetter
 	consumerPartitionAssigner ConsumerPartitionAssigner
+	timestampType             mq.TimestampType
+	clock                     clock.Clock
+	metrics                   mq.Metrics
+	// 延迟消息，第一次发送延迟消息时创建
+	delayed *delayqueue.DelayQueue
 }
 
 func newTopic(name string, partitions int) *Topic {
@@ -43,6 +52,8 @@ func newTopic(name string, partitions int) *Topic {
 		consumerGroups:            syncx.Map[string, *ConsumerGroup]{},
 		consumerPartitionAssigner: equaldivide.NewAssigner(),
 		producerPartitionIDGetter: &hash.Getter{Partitions: partitions},
+		clock:                     clock.New(),
+		metrics:                   mq.NopMetrics{},
 	}
 	partitionList := make([]*Partition, 0, partitions)
 	for i := 0; i < partitions; i++ {
@@ -68,13 +79,48 @@ func (t *Topic) addMessage(msg *mq.Message) error {
 	return t.addMessageWithPartition(msg, partitionID)
 }
 
+// addDelayedMessage 在due时刻再把消息加入分区
+func (t *Topic) addDelayedMessage(msg *mq.Message, due time.Time) error {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+	if t.closed {
+		return errs.ErrMQIsClosed
+	}
+	if t.delayed == nil {
+		t.delayed = delayqueue.New(t.clock, func(msg *mq.Message) {
+			if err := t.addMessage(msg); err != nil {
+				mq.Logger().Error("投递延迟消息失败", slog.String("topic", t.name), slog.String("error", err.Error()))
+			}
+		})
+	}
+	// 与 addTxnMessage 相同保存副本，到期之前调用者修改消息不会影响投递的内容
+	t.delayed.Push(cloneMessage(msg), due)
+	return nil
+}
+
 func (t *Topic) addMessageWithPartition(msg *mq.Message, partitionID int64) error {
+	return t.addTxnMessage(msg, partitionID, nil)
+}
+
+// addTxnMessage 在事务txn中往分区里添加消息，txn为nil时表示不使用事务
+func (t *Topic) addTxnMessage(msg *mq.Message, partitionID int64, txn *transaction) error {
 	if partitionID < 0 || int(partitionID) >= len(t.partitions) {
 		return errs.ErrInvalidPartition
 	}
+	// 保存副本，发送之后调用者继续修改消息不会影响分区中的数据
+	msg = cloneMessage(msg)
 	msg.Topic = t.name
 	msg.Partition = partitionID
-	t.partitions[partitionID].append(msg)
+	if t.timestampType == mq.TimestampLogAppendTime || msg.Timestamp.IsZero() {
+		msg.Timestamp = t.clock.Now()
+	}
+	p := t.partitions[partitionID]
+	if txn == nil {
+		p.append(msg)
+		return nil
+	}
+	p.appendTxn(msg, txn)
+	txn.written[p] = append(txn.written[p], int(msg.Offset))
 	return nil
 }
 
@@ -83,6 +129,9 @@ func (t *Topic) Close() error {
 	defer t.locker.Unlock()
 	if !t.closed {
 		t.closed = true
+		if t.delayed != nil {
+			t.delayed.Close()
+		}
 		t.consumerGroups.Range(func(_ string, value *ConsumerGroup) bool {
 			value.Close()
 			return true
@@ -0,0 +1,249 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
+	"github.com/ecodeclub/mq-api/internal/errs"
+)
+
+var _ mq.TxnMQ = &MQ{}
+
+// transaction 是 TxnProducer 进行中的事务
+type transaction struct {
+	// 事务写入的消息的偏移量，键为分区
+	written map[*Partition][]int
+	// 事务中的消费进度，键为消费组
+	offsets map[string][]*mq.Message
+	// 事务中的延迟消息，提交之后才会加入延迟队列
+	delayed []delayedMessage
+}
+
+type delayedMessage struct {
+	msg *mq.Message
+	at  time.Time
+}
+
+func newTransaction() *transaction {
+	return &transaction{
+		written: map[*Partition][]int{},
+		offsets: map[string][]*mq.Message{},
+	}
+}
+
+// TxnProducer 模拟kafka的事务生产者：事务中的消息立刻写入分区，ReadUncommitted 的消费者可以马上看到；
+// ReadCommitted 的消费者在事务结束之前看不到它们以及之后的消息，事务回滚之后会跳过它们
+type TxnProducer struct {
+	m  *MQ
+	t  *Topic
+	id string
+
+	locker sync.Mutex
+	closed bool
+	fenced bool
+	txn    *transaction
+}
+
+func (m *MQ) TxnProducer(topic, transactionalID string) (mq.TxnProducer, error) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if m.closed {
+		return nil, errs.ErrMQIsClosed
+	}
+	t, ok := m.topics.Load(topic)
+	if !ok {
+		t = m.newTopic(topic, defaultPartitions)
+		m.topics.Store(topic, t)
+	}
+	if old, ok := m.txnProducers[transactionalID]; ok {
+		old.fence()
+	}
+	p := &TxnProducer{m: m, t: t, id: transactionalID}
+	if err := t.addProducer(p); err != nil {
+		return nil, err
+	}
+	m.txnProducers[transactionalID] = p
+	return mq.InterceptTxnProducer(p, mq.ProducerInfo{Topic: topic}, m.producerInterceptors...), nil
+}
+
+// checkProducer 检查生产者是否可用，调用者需要持有锁
+func (p *TxnProducer) checkProducer(ctx context.Context) error {
+	if p.closed {
+		return errs.ErrProducerIsClosed
+	}
+	if p.fenced {
+		return mq.ErrProducerFenced
+	}
+	return ctx.Err()
+}
+
+// check 检查是否可以在事务中操作，调用者需要持有锁
+func (p *TxnProducer) check(ctx context.Context) error {
+	if err := p.checkProducer(ctx); err != nil {
+		return err
+	}
+	if p.txn == nil {
+		return mq.ErrNoTxn
+	}
+	return nil
+}
+
+func (p *TxnProducer) BeginTxn(ctx context.Context) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if err := p.checkProducer(ctx); err != nil {
+		return err
+	}
+	if p.txn != nil {
+		return mq.ErrTxnInProgress
+	}
+	p.txn = newTransaction()
+	return nil
+}
+
+func (p *TxnProducer) Produce(ctx context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+	return p.produce(ctx, m, p.t.producerPartitionIDGetter.PartitionID(string(m.Key)))
+}
+
+func (p *TxnProducer) ProduceWithPartition(ctx context.Context, m *mq.Message, partition int) (*mq.ProducerResult, error) {
+	return p.produce(ctx, m, int64(partition))
+}
+
+// ProduceAt 到期的时间晚于当前时间时，消息在事务提交之后才会加入延迟队列，回滚时直接丢弃
+func (p *TxnProducer) ProduceAt(ctx context.Context, m *mq.Message, at time.Time) (*mq.ProducerResult, error) {
+	if !at.After(p.t.clock.Now()) {
+		return p.Produce(ctx, m)
+	}
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if err := p.check(ctx); err != nil {
+		return nil, err
+	}
+	// 提交之前调用者修改消息不会影响投递的内容
+	p.txn.delayed = append(p.txn.delayed, delayedMessage{msg: cloneMessage(m), at: at})
+	return &mq.ProducerResult{}, nil
+}
+
+func (p *TxnProducer) produce(ctx context.Context, m *mq.Message, partition int64) (*mq.ProducerResult, error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if err := p.check(ctx); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	err := p.t.addTxnMessage(m, partition, p.txn)
+	p.t.metrics.ObserveProduce(p.t.name, time.Since(start), err)
+	return &mq.ProducerResult{}, err
+}
+
+func (p *TxnProducer) SendOffsetsToTxn(ctx context.Context, groupID string, msgs ...*mq.Message) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if err := p.check(ctx); err != nil {
+		return err
+	}
+	p.txn.offsets[groupID] = append(p.txn.offsets[groupID], msgs...)
+	return nil
+}
+
+func (p *TxnProducer) CommitTxn(ctx context.Context) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if err := p.check(ctx); err != nil {
+		return err
+	}
+	txn := p.txn
+	p.txn = nil
+	for groupID, msgs := range txn.offsets {
+		p.commitOffsets(groupID, msgs)
+	}
+	for partition, offsets := range txn.written {
+		partition.endTxn(txn, true, offsets)
+	}
+	for _, d := range txn.delayed {
+		if err := p.t.addDelayedMessage(d.msg, d.at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitOffsets 按照消息所在的topic把消费进度交给groupID消费组
+func (p *TxnProducer) commitOffsets(groupID string, msgs []*mq.Message) {
+	byTopic := map[string][]*mq.Message{}
+	for _, msg := range msgs {
+		byTopic[msg.Topic] = append(byTopic[msg.Topic], msg)
+	}
+	for topic, topicMsgs := range byTopic {
+		t, ok := p.m.topics.Load(topic)
+		if !ok {
+			continue
+		}
+		if group, ok := t.consumerGroups.Load(groupID); ok {
+			group.commitTxnOffsets(topicMsgs)
+		}
+	}
+}
+
+func (p *TxnProducer) AbortTxn(ctx context.Context) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if err := p.check(ctx); err != nil {
+		return err
+	}
+	p.abort()
+	return nil
+}
+
+// abort 回滚进行中的事务，调用者需要持有锁
+func (p *TxnProducer) abort() {
+	if p.txn == nil {
+		return
+	}
+	for partition, offsets := range p.txn.written {
+		partition.endTxn(p.txn, false, offsets)
+	}
+	p.txn = nil
+}
+
+// fence 在使用相同transactionalID的新生产者创建时调用，回滚进行中的事务
+func (p *TxnProducer) fence() {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.abort()
+	p.fenced = true
+}
+
+// Clock 返回 WithClock 指定的时钟，mq.ProduceWithDelay 使用它计算到期时间
+func (p *TxnProducer) Clock() clock.Clock {
+	return p.t.clock
+}
+
+// Close 回滚进行中的事务
+func (p *TxnProducer) Close() error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.abort()
+	p.closed = true
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
+	"github.com/ecodeclub/mq-api/internal/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// consumeValues 在timeout内尽可能多地获取消息
+func consumeValues(t *testing.T, c mq.Consumer, timeout time.Duration) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var res []string
+	for {
+		msg, err := c.Consume(ctx)
+		if err != nil {
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+			return res
+		}
+		res = append(res, string(msg.Value))
+	}
+}
+
+func TestTxnProducer_Isolation(t *testing.T) {
+	t.Parallel()
+	testmq := NewMQ().(mq.TxnMQ)
+	defer func() {
+		_ = testmq.Close()
+	}()
+	const topic = "txn_isolation"
+	require.NoError(t, testmq.CreateTopic(context.Background(), topic, 1))
+	committed, err := testmq.Consumer(topic, "committed", mq.WithIsolationLevel(mq.ReadCommitted))
+	require.NoError(t, err)
+	uncommitted, err := testmq.Consumer(topic, "uncommitted")
+	require.NoError(t, err)
+	p, err := testmq.Producer(topic)
+	require.NoError(t, err)
+	txnProducer, err := testmq.TxnProducer(topic, "tx")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = p.Produce(ctx, &mq.Message{Value: []byte("before")})
+	require.NoError(t, err)
+	require.NoError(t, txnProducer.BeginTxn(ctx))
+	_, err = txnProducer.Produce(ctx, &mq.Message{Value: []byte("t1")})
+	require.NoError(t, err)
+	_, err = p.Produce(ctx, &mq.Message{Value: []byte("after")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"before", "t1", "after"}, consumeValues(t, uncommitted, 1500*time.Millisecond))
+	assert.Equal(t, []string{"before"}, consumeValues(t, committed, 1500*time.Millisecond))
+
+	require.NoError(t, txnProducer.CommitTxn(ctx))
+	assert.Equal(t, []string{"t1", "after"}, consumeValues(t, committed, 1500*time.Millisecond))
+
+	require.NoError(t, txnProducer.BeginTxn(ctx))
+	_, err = txnProducer.ProduceWithPartition(ctx, &mq.Message{Value: []byte("t2")}, 0)
+	require.NoError(t, err)
+	require.NoError(t, txnProducer.AbortTxn(ctx))
+	_, err = p.Produce(ctx, &mq.Message{Value: []byte("last")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"last"}, consumeValues(t, committed, 1500*time.Millisecond))
+	assert.Equal(t, []string{"t2", "last"}, consumeValues(t, uncommitted, 1500*time.Millisecond))
+}
+
+func TestTxnProducer_ReadProcessWrite(t *testing.T) {
+	t.Parallel()
+	testmq := NewMQ().(mq.TxnMQ)
+	defer func() {
+		_ = testmq.Close()
+	}()
+	ctx := context.Background()
+	require.NoError(t, testmq.CreateTopic(ctx, "txn_input", 1))
+	require.NoError(t, testmq.CreateTopic(ctx, "txn_output", 1))
+	p, err := testmq.Producer("txn_input")
+	require.NoError(t, err)
+	for _, value := range []string{"a", "b"} {
+		_, err = p.Produce(ctx, &mq.Message{Value: []byte(value)})
+		require.NoError(t, err)
+	}
+	txnProducer, err := testmq.TxnProducer("txn_output", "processor")
+	require.NoError(t, err)
+	output, err := testmq.Consumer("txn_output", "reader", mq.WithIsolationLevel(mq.ReadCommitted))
+	require.NoError(t, err)
+
+	// process 消费一条消息并在事务中写入结果，commit为false时模拟处理失败
+	process := func(commit bool) string {
+		input, err := testmq.Consumer("txn_input", "processor", mq.WithManualCommit())
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, input.Close())
+		}()
+		consumeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		msg, err := input.Consume(consumeCtx)
+		require.NoError(t, err)
+		require.NoError(t, txnProducer.BeginTxn(ctx))
+		_, err = txnProducer.Produce(ctx, &mq.Message{Value: append([]byte("processed-"), msg.Value...)})
+		require.NoError(t, err)
+		require.NoError(t, txnProducer.SendOffsetsToTxn(ctx, "processor", msg))
+		if commit {
+			require.NoError(t, txnProducer.CommitTxn(ctx))
+		} else {
+			require.NoError(t, txnProducer.AbortTxn(ctx))
+		}
+		return string(msg.Value)
+	}
+	// 回滚之后消费进度没有提交，重新处理同一条消息
+	assert.Equal(t, "a", process(false))
+	assert.Equal(t, "a", process(true))
+	assert.Equal(t, "b", process(true))
+	assert.Equal(t, []string{"processed-a", "processed-b"}, consumeValues(t, output, 1500*time.Millisecond))
+}
+
+func TestTxnProducer(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewMock(time.Now())
+	testmq := NewMQ(WithClock(clk)).(mq.TxnMQ)
+	defer func() {
+		_ = testmq.Close()
+	}()
+	const topic = "txn"
+	ctx := context.Background()
+	require.NoError(t, testmq.CreateTopic(ctx, topic, 1))
+	c, err := testmq.Consumer(topic, "g1", mq.WithIsolationLevel(mq.ReadCommitted))
+	require.NoError(t, err)
+	old, err := testmq.TxnProducer(topic, "tx")
+	require.NoError(t, err)
+
+	_, err = old.Produce(ctx, &mq.Message{Value: []byte("no txn")})
+	assert.ErrorIs(t, err, mq.ErrNoTxn)
+	assert.ErrorIs(t, old.CommitTxn(ctx), mq.ErrNoTxn)
+	assert.ErrorIs(t, old.SendOffsetsToTxn(ctx, "g1"), mq.ErrNoTxn)
+	require.NoError(t, old.BeginTxn(ctx))
+	assert.ErrorIs(t, old.BeginTxn(ctx), mq.ErrTxnInProgress)
+	_, err = old.Produce(ctx, &mq.Message{Value: []byte("fenced")})
+	require.NoError(t, err)
+
+	// 新的生产者回滚旧生产者进行中的事务
+	p, err := testmq.TxnProducer(topic, "tx")
+	require.NoError(t, err)
+	assert.ErrorIs(t, old.CommitTxn(ctx), mq.ErrProducerFenced)
+	assert.ErrorIs(t, old.BeginTxn(ctx), mq.ErrProducerFenced)
+
+	// 延迟消息在提交之后才加入延迟队列
+	require.NoError(t, p.BeginTxn(ctx))
+	_, err = p.ProduceAt(ctx, &mq.Message{Value: []byte("aborted")}, clk.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.NoError(t, p.AbortTxn(ctx))
+	require.NoError(t, p.BeginTxn(ctx))
+	delayed := &mq.Message{Value: []byte("delayed")}
+	_, err = p.ProduceAt(ctx, delayed, clk.Now().Add(time.Minute))
+	require.NoError(t, err)
+	// 保存的是副本，调用者之后修改消息不影响投递的内容
+	delayed.Value[0] = 'D'
+	_, err = p.ProduceAt(ctx, &mq.Message{Value: []byte("now")}, clk.Now())
+	require.NoError(t, err)
+	require.NoError(t, p.CommitTxn(ctx))
+	clk.Add(time.Minute)
+	assert.Equal(t, []string{"now", "delayed"}, consumeValues(t, c, 2500*time.Millisecond))
+
+	// 关闭时回滚进行中的事务
+	require.NoError(t, p.BeginTxn(ctx))
+	_, err = p.Produce(ctx, &mq.Message{Value: []byte("closed")})
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+	assert.ErrorIs(t, p.BeginTxn(ctx), errs.ErrProducerIsClosed)
+	plain, err := testmq.Producer(topic)
+	require.NoError(t, err)
+	_, err = plain.Produce(ctx, &mq.Message{Value: []byte("plain")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"plain"}, consumeValues(t, c, 1500*time.Millisecond))
+}
@@ -25,3 +25,21 @@ type ConsumerPartitionAssigner interface {
 	// AssignPartition partitions表示分区数，返回值为map[消费者名称][]分区索引
 	AssignPartition(consumers []string, partitions int) map[string][]int
 }
+
+// StickyConsumerPartitionAssigner 是可以参考上一轮分配结果的分配器。
+// 消费组重平衡时如果分配器实现了该接口，会把上一轮的分配结果传入，以尽量减少分区的移动
+type StickyConsumerPartitionAssigner interface {
+	ConsumerPartitionAssigner
+	// AssignPartitionWithPrevious previous为上一轮的分配结果，键为消费者名称
+	AssignPartitionWithPrevious(consumers []string, partitions int, previous map[string][]int) map[string][]int
+}
+
+// RebalanceProtocol 消费组重平衡协议
+type RebalanceProtocol string
+
+const (
+	// RebalanceEager 重平衡时所有消费者交出全部分区，再统一重新分配
+	RebalanceEager RebalanceProtocol = "eager"
+	// RebalanceCooperative 重平衡时只收回需要移动的分区，其余分区不受影响继续消费
+	RebalanceCooperative RebalanceProtocol = "cooperative"
+)
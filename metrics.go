@@ -0,0 +1,50 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import "time"
+
+// Metrics 接收MQ实现内部的事件，用于统计吞吐量、延迟与积压。实现需要可以被并发调用
+type Metrics interface {
+	// ObserveProduce 记录一次发送消息的耗时与结果，err不为nil表示发送失败
+	ObserveProduce(topic string, duration time.Duration, err error)
+	// ObserveProduceRetry 记录一次发送失败之后的重试
+	ObserveProduceRetry(topic string)
+	// ObserveConsume 记录一条投递给消费者的消息
+	ObserveConsume(topic, groupID string, partition int)
+	// AddBuffered 记录消费者缓冲区中等待被取走的消息数量的变化
+	AddBuffered(topic, groupID string, delta int)
+	// ObserveRebalance 记录一次重平衡的耗时
+	ObserveRebalance(topic, groupID string, duration time.Duration)
+	// SetLag 记录消费组在分区上的积压，即分区末尾与消费进度之间的消息数量
+	SetLag(topic, groupID string, partition int, lag int64)
+}
+
+// NopMetrics 忽略所有事件，是MQ实现默认使用的 Metrics
+type NopMetrics struct{}
+
+var _ Metrics = NopMetrics{}
+
+func (NopMetrics) ObserveProduce(string, time.Duration, error) {}
+
+func (NopMetrics) ObserveProduceRetry(string) {}
+
+func (NopMetrics) ObserveConsume(string, string, int) {}
+
+func (NopMetrics) AddBuffered(string, string, int) {}
+
+func (NopMetrics) ObserveRebalance(string, string, time.Duration) {}
+
+func (NopMetrics) SetLag(string, string, int, int64) {}
@@ -0,0 +1,172 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics 使用Prometheus实现 mq.Metrics
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultNamespace = "mq"
+
+var (
+	_ mq.Metrics           = &Prometheus{}
+	_ prometheus.Collector = &Prometheus{}
+)
+
+// Prometheus 实现了 mq.Metrics 与 prometheus.Collector，
+// 通过MQ实现的WithMetrics选项传入，并注册到 prometheus.Registerer。
+// 生产相关的指标使用topic标签，消费相关的指标额外使用group标签，与分区相关的指标再加上partition标签
+type Prometheus struct {
+	namespace string
+	// 发送耗时与重平衡耗时使用的桶
+	buckets []float64
+
+	produced          *prometheus.CounterVec
+	produceErrors     *prometheus.CounterVec
+	produceDuration   *prometheus.HistogramVec
+	produceRetries    *prometheus.CounterVec
+	consumed          *prometheus.CounterVec
+	buffered          *prometheus.GaugeVec
+	rebalances        *prometheus.CounterVec
+	rebalanceDuration *prometheus.HistogramVec
+	lag               *prometheus.GaugeVec
+}
+
+func NewPrometheus(opts ...option.Option[Prometheus]) *Prometheus {
+	p := &Prometheus{
+		namespace: defaultNamespace,
+		buckets:   prometheus.DefBuckets,
+	}
+	option.Apply(p, opts...)
+	topic := []string{"topic"}
+	group := []string{"topic", "group"}
+	partition := []string{"topic", "partition", "group"}
+	p.produced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: p.namespace,
+		Name:      "produced_total",
+		Help:      "发送的消息数量，包含发送失败的消息",
+	}, topic)
+	p.produceErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: p.namespace,
+		Name:      "produce_errors_total",
+		Help:      "发送失败的消息数量",
+	}, topic)
+	p.produceDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: p.namespace,
+		Name:      "produce_duration_seconds",
+		Help:      "发送消息的耗时，包含重试的时间",
+		Buckets:   p.buckets,
+	}, topic)
+	p.produceRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: p.namespace,
+		Name:      "produce_retries_total",
+		Help:      "发送失败之后的重试次数",
+	}, topic)
+	p.consumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: p.namespace,
+		Name:      "consumed_total",
+		Help:      "投递给消费者的消息数量",
+	}, partition)
+	p.buffered = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: p.namespace,
+		Name:      "consumer_buffered_messages",
+		Help:      "消费者缓冲区中等待被取走的消息数量",
+	}, group)
+	p.rebalances = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: p.namespace,
+		Name:      "rebalances_total",
+		Help:      "重平衡的次数",
+	}, group)
+	p.rebalanceDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: p.namespace,
+		Name:      "rebalance_duration_seconds",
+		Help:      "重平衡的耗时",
+		Buckets:   p.buckets,
+	}, group)
+	p.lag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: p.namespace,
+		Name:      "consumer_lag",
+		Help:      "消费组在分区上的积压消息数量",
+	}, partition)
+	return p
+}
+
+// WithNamespace 指定指标名称的前缀，默认为mq
+func WithNamespace(namespace string) option.Option[Prometheus] {
+	return func(p *Prometheus) {
+		p.namespace = namespace
+	}
+}
+
+// WithBuckets 指定发送耗时与重平衡耗时的直方图使用的桶，默认为 prometheus.DefBuckets
+func WithBuckets(buckets []float64) option.Option[Prometheus] {
+	return func(p *Prometheus) {
+		p.buckets = buckets
+	}
+}
+
+func (p *Prometheus) ObserveProduce(topic string, duration time.Duration, err error) {
+	p.produced.WithLabelValues(topic).Inc()
+	p.produceDuration.WithLabelValues(topic).Observe(duration.Seconds())
+	if err != nil {
+		p.produceErrors.WithLabelValues(topic).Inc()
+	}
+}
+
+func (p *Prometheus) ObserveProduceRetry(topic string) {
+	p.produceRetries.WithLabelValues(topic).Inc()
+}
+
+func (p *Prometheus) ObserveConsume(topic, groupID string, partition int) {
+	p.consumed.WithLabelValues(topic, strconv.Itoa(partition), groupID).Inc()
+}
+
+func (p *Prometheus) AddBuffered(topic, groupID string, delta int) {
+	p.buffered.WithLabelValues(topic, groupID).Add(float64(delta))
+}
+
+func (p *Prometheus) ObserveRebalance(topic, groupID string, duration time.Duration) {
+	p.rebalances.WithLabelValues(topic, groupID).Inc()
+	p.rebalanceDuration.WithLabelValues(topic, groupID).Observe(duration.Seconds())
+}
+
+func (p *Prometheus) SetLag(topic, groupID string, partition int, lag int64) {
+	p.lag.WithLabelValues(topic, strconv.Itoa(partition), groupID).Set(float64(lag))
+}
+
+func (p *Prometheus) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		p.produced, p.produceErrors, p.produceDuration, p.produceRetries,
+		p.consumed, p.buffered, p.rebalances, p.rebalanceDuration, p.lag,
+	}
+}
+
+func (p *Prometheus) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range p.collectors() {
+		c.Describe(ch)
+	}
+}
+
+func (p *Prometheus) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range p.collectors() {
+		c.Collect(ch)
+	}
+}
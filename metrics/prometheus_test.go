@@ -0,0 +1,108 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheus(t *testing.T) {
+	t.Parallel()
+	p := NewPrometheus(WithNamespace("test"))
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(p))
+
+	p.ObserveProduce("t", time.Millisecond, nil)
+	p.ObserveProduce("t", time.Millisecond, errors.New("发送失败"))
+	p.ObserveProduceRetry("t")
+	p.ObserveConsume("t", "g", 1)
+	p.AddBuffered("t", "g", 3)
+	p.AddBuffered("t", "g", -1)
+	p.ObserveRebalance("t", "g", time.Second)
+	p.SetLag("t", "g", 1, 5)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(p.produced.WithLabelValues("t")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.produceErrors.WithLabelValues("t")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.produceRetries.WithLabelValues("t")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.consumed.WithLabelValues("t", "1", "g")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(p.buffered.WithLabelValues("t", "g")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.rebalances.WithLabelValues("t", "g")))
+	assert.Equal(t, float64(5), testutil.ToFloat64(p.lag.WithLabelValues("t", "1", "g")))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	names := make([]string, 0, len(families))
+	for _, f := range families {
+		names = append(names, f.GetName())
+	}
+	assert.ElementsMatch(t, []string{
+		"test_produced_total", "test_produce_errors_total", "test_produce_duration_seconds",
+		"test_produce_retries_total", "test_consumed_total", "test_consumer_buffered_messages",
+		"test_rebalances_total", "test_rebalance_duration_seconds", "test_consumer_lag",
+	}, names)
+}
+
+func TestPrometheus_Memory(t *testing.T) {
+	t.Parallel()
+	p := NewPrometheus()
+	testmq := memory.NewMQ(memory.WithMetrics(p))
+	defer func() {
+		_ = testmq.Close()
+	}()
+	const topic = "metrics"
+	require.NoError(t, testmq.CreateTopic(context.Background(), topic, 1))
+	c, err := testmq.Consumer(topic, "g1", mq.WithManualCommit())
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.rebalances.WithLabelValues(topic, "g1")))
+
+	producer, err := testmq.Producer(topic)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = producer.Produce(context.Background(), &mq.Message{Value: []byte("msg")})
+		require.NoError(t, err)
+	}
+	_, err = producer.ProduceWithPartition(context.Background(), &mq.Message{}, 5)
+	require.Error(t, err)
+	assert.Equal(t, float64(4), testutil.ToFloat64(p.produced.WithLabelValues(topic)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.produceErrors.WithLabelValues(topic)))
+
+	// 消息已经投递但是没有提交，积压依旧是3
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(p.consumed.WithLabelValues(topic, "0", "g1")) == 3 &&
+			testutil.ToFloat64(p.buffered.WithLabelValues(topic, "g1")) == 3 &&
+			testutil.ToFloat64(p.lag.WithLabelValues(topic, "0", "g1")) == 3
+	}, 5*time.Second, 100*time.Millisecond)
+
+	var last *mq.Message
+	for i := 0; i < 3; i++ {
+		last, err = c.Consume(context.Background())
+		require.NoError(t, err)
+	}
+	require.NoError(t, c.Commit(context.Background(), last))
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(p.buffered.WithLabelValues(topic, "g1")) == 0 &&
+			testutil.ToFloat64(p.lag.WithLabelValues(topic, "0", "g1")) == 0
+	}, 5*time.Second, 100*time.Millisecond)
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import "github.com/ecodeclub/ekit/bean/option"
+
+// ConsumerConfig 是创建消费者时的可选配置
+type ConsumerConfig struct {
+	// OnPartitionsAssigned 在分区分配给消费者之后、开始消费这些分区之前调用，参数为新分配的分区
+	OnPartitionsAssigned func(partitions []int)
+	// OnPartitionsRevoked 在分区被收回、消费进度交还给消费组之前调用，参数为被收回的分区
+	OnPartitionsRevoked func(partitions []int)
+	// InstanceID 静态成员ID，对应kafka的group.instance.id。
+	// 设置之后消费者退出时不会立刻触发重平衡，在会话超时之前以相同的InstanceID重新加入可以拿回原来的分区
+	// kafka实现需要使用 kafka.StickyGroupBalancer，否则创建消费者时返回错误
+	InstanceID string
+	// ManualCommit 为true时，消费进度只会通过 Consumer.Commit 提交，未提交的消息在重平衡或者重启之后会被重新投递
+	ManualCommit bool
+	// IsolationLevel 消费者能够看到的事务消息，默认为 ReadUncommitted
+	IsolationLevel IsolationLevel
+}
+
+// NewConsumerConfig 供MQ的实现使用，未设置的回调会被替换为空实现
+func NewConsumerConfig(opts ...option.Option[ConsumerConfig]) ConsumerConfig {
+	cfg := ConsumerConfig{}
+	option.Apply(&cfg, opts...)
+	if cfg.OnPartitionsAssigned == nil {
+		cfg.OnPartitionsAssigned = func([]int) {}
+	}
+	if cfg.OnPartitionsRevoked == nil {
+		cfg.OnPartitionsRevoked = func([]int) {}
+	}
+	return cfg
+}
+
+// WithOnPartitionsAssigned 设置分区分配回调，例如用于预热与分区相关的缓存
+func WithOnPartitionsAssigned(fn func(partitions []int)) option.Option[ConsumerConfig] {
+	return func(cfg *ConsumerConfig) {
+		cfg.OnPartitionsAssigned = fn
+	}
+}
+
+// WithOnPartitionsRevoked 设置分区收回回调，例如用于刷新与分区相关的缓存
+func WithOnPartitionsRevoked(fn func(partitions []int)) option.Option[ConsumerConfig] {
+	return func(cfg *ConsumerConfig) {
+		cfg.OnPartitionsRevoked = fn
+	}
+}
+
+// WithInstanceID 以静态成员的身份加入消费组，同一个消费组内InstanceID不能重复
+func WithInstanceID(id string) option.Option[ConsumerConfig] {
+	return func(cfg *ConsumerConfig) {
+		cfg.InstanceID = id
+	}
+}
+
+// WithManualCommit 关闭自动提交，由使用者在处理完消息之后调用 Consumer.Commit 提交消费进度
+func WithManualCommit() option.Option[ConsumerConfig] {
+	return func(cfg *ConsumerConfig) {
+		cfg.ManualCommit = true
+	}
+}
+
+// WithIsolationLevel 指定消费者能够看到的事务消息，消费 TxnProducer 发送的消息时一般使用 ReadCommitted
+func WithIsolationLevel(level IsolationLevel) option.Option[ConsumerConfig] {
+	return func(cfg *ConsumerConfig) {
+		cfg.IsolationLevel = level
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+)
+
+const (
+	defaultTable      = "mq_outbox"
+	defaultLeaseTable = "mq_outbox_lease"
+)
+
+// Outbox 在业务事务中写入待发送的消息，由 Relay 发送到消息队列，表结构为：
+//
+//	CREATE TABLE mq_outbox (
+//	    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+//	    topic VARCHAR(255) NOT NULL,
+//	    msg_key BLOB,
+//	    msg_value BLOB,
+//	    headers TEXT,
+//	    created_at BIGINT NOT NULL,
+//	    attempts INT NOT NULL DEFAULT 0,
+//	    last_error TEXT,
+//	    sent_at BIGINT
+//	);
+//	CREATE INDEX idx_mq_outbox_sent_at ON mq_outbox (sent_at, id);
+//	CREATE TABLE mq_outbox_lease (
+//	    name VARCHAR(255) PRIMARY KEY,
+//	    owner VARCHAR(255) NOT NULL,
+//	    expires_at BIGINT NOT NULL
+//	);
+//
+// 时间均为毫秒时间戳。消息按照id的顺序发送，因此同一个Key的消息保持写入的顺序
+type Outbox struct {
+	table      string
+	leaseTable string
+	// 生成第i个参数的占位符，从1开始
+	placeholder func(i int) string
+}
+
+func New(opts ...option.Option[Outbox]) *Outbox {
+	o := &Outbox{
+		table:      defaultTable,
+		leaseTable: defaultLeaseTable,
+		placeholder: func(_ int) string {
+			return "?"
+		},
+	}
+	option.Apply(o, opts...)
+	return o
+}
+
+// WithTables 指定保存消息与 Relay 选主使用的表名，默认为mq_outbox与mq_outbox_lease
+func WithTables(table, leaseTable string) option.Option[Outbox] {
+	return func(o *Outbox) {
+		o.table = table
+		o.leaseTable = leaseTable
+	}
+}
+
+// WithDollarPlaceholder 使用$1形式的占位符，用于PostgreSQL
+func WithDollarPlaceholder() option.Option[Outbox] {
+	return func(o *Outbox) {
+		o.placeholder = func(i int) string {
+			return fmt.Sprintf("$%d", i)
+		}
+	}
+}
+
+// placeholders 返回从start开始的n个占位符
+func (o *Outbox) placeholders(start, n int) string {
+	res := make([]string, 0, n)
+	for i := start; i < start+n; i++ {
+		res = append(res, o.placeholder(i))
+	}
+	return strings.Join(res, ", ")
+}
+
+// Insert 在tx中写入发送到topic的消息，tx提交之后消息才会被 Relay 发送。
+// 只会使用消息的 Key、Value 与 Header
+func (o *Outbox) Insert(ctx context.Context, tx *sql.Tx, topic string, msgs ...*mq.Message) error {
+	query := fmt.Sprintf("INSERT INTO %s (topic, msg_key, msg_value, headers, created_at, attempts) VALUES (%s)",
+		o.table, o.placeholders(1, 6))
+	now := time.Now().UnixMilli()
+	for _, msg := range msgs {
+		var headers []byte
+		if len(msg.Header) > 0 {
+			var err error
+			headers, err = json.Marshal(msg.Header)
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, query, topic, msg.Key, msg.Value, string(headers), now, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
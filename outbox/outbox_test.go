@@ -0,0 +1,220 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func newDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "outbox.db")+"?_pragma=busy_timeout(5000)")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	_, err = db.Exec(`
+CREATE TABLE mq_outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    topic TEXT NOT NULL,
+    msg_key BLOB,
+    msg_value BLOB,
+    headers TEXT,
+    created_at INTEGER NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    sent_at INTEGER
+);
+CREATE INDEX idx_mq_outbox_sent_at ON mq_outbox (sent_at, id);
+CREATE TABLE mq_outbox_lease (
+    name TEXT PRIMARY KEY,
+    owner TEXT NOT NULL,
+    expires_at INTEGER NOT NULL
+);`)
+	require.NoError(t, err)
+	return db
+}
+
+func insert(t *testing.T, db *sql.DB, o *Outbox, topic string, msgs ...*mq.Message) {
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, o.Insert(context.Background(), tx, topic, msgs...))
+	require.NoError(t, tx.Commit())
+}
+
+func TestOutbox(t *testing.T) {
+	t.Parallel()
+	db := newDB(t)
+	o := New()
+	testmq := memory.NewMQ()
+	defer func() {
+		_ = testmq.Close()
+	}()
+	const topic = "outbox"
+	require.NoError(t, testmq.CreateTopic(context.Background(), topic, 1))
+	c, err := testmq.Consumer(topic, "g1")
+	require.NoError(t, err)
+
+	// 业务事务回滚时消息也不会发送
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, o.Insert(context.Background(), tx, topic, &mq.Message{Value: []byte("rollback")}))
+	require.NoError(t, tx.Rollback())
+	insert(t, db, o, topic,
+		&mq.Message{Key: []byte("order-1"), Value: []byte("created"), Header: mq.Header{{Key: "k", Value: []byte("v")}}},
+		&mq.Message{Key: []byte("order-1"), Value: []byte("paid")})
+	insert(t, db, o, topic, &mq.Message{Key: []byte("order-2"), Value: []byte("created")})
+
+	relay := NewRelay(db, o, testmq.Producer, WithBatchSize(2))
+	sent, err := relay.Publish(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, sent)
+	sent, err = relay.Publish(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var got []*mq.Message
+	for i := 0; i < 3; i++ {
+		msg, err := c.Consume(ctx)
+		require.NoError(t, err)
+		got = append(got, msg)
+	}
+	assert.Equal(t, []byte("created"), got[0].Value)
+	assert.Equal(t, mq.Header{{Key: "k", Value: []byte("v")}}, got[0].Header)
+	assert.Equal(t, []byte("paid"), got[1].Value)
+	assert.Empty(t, got[1].Header)
+	assert.Equal(t, []byte("order-2"), got[2].Key)
+
+	n, err := relay.DeleteSent(context.Background(), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+}
+
+// failingProducer 对value在failures中的消息返回error，每返回一次计数减一
+type failingProducer struct {
+	mq.Producer
+	locker   sync.Mutex
+	failures map[string]int
+	sent     []string
+}
+
+func (p *failingProducer) Produce(_ context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if p.failures[string(m.Value)] > 0 {
+		p.failures[string(m.Value)]--
+		return nil, errors.New("发送失败")
+	}
+	p.sent = append(p.sent, string(m.Value))
+	return &mq.ProducerResult{}, nil
+}
+
+func TestRelay_Retry(t *testing.T) {
+	t.Parallel()
+	db := newDB(t)
+	o := New()
+	p := &failingProducer{failures: map[string]int{"a1": 1, "c1": 100}}
+	insert(t, db, o, "t",
+		&mq.Message{Key: []byte("a"), Value: []byte("a1")},
+		&mq.Message{Key: []byte("a"), Value: []byte("a2")},
+		&mq.Message{Key: []byte("b"), Value: []byte("b1")},
+		&mq.Message{Key: []byte("c"), Value: []byte("c1")},
+		&mq.Message{Key: []byte("c"), Value: []byte("c2")})
+	relay := NewRelay(db, o, func(string) (mq.Producer, error) {
+		return p, nil
+	}, WithMaxAttempts(2))
+
+	// a1发送失败之后同一个Key的a2不会发送
+	sent, err := relay.Publish(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []string{"b1"}, p.sent)
+	sent, err = relay.Publish(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, []string{"b1", "a1", "a2"}, p.sent)
+	// c1达到发送次数上限之后不再阻塞c2
+	sent, err = relay.Publish(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []string{"b1", "a1", "a2", "c2"}, p.sent)
+
+	var attempts int
+	var lastError string
+	require.NoError(t, db.QueryRow("SELECT attempts, last_error FROM mq_outbox WHERE msg_value = ?", []byte("c1")).
+		Scan(&attempts, &lastError))
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, "发送失败", lastError)
+}
+
+func TestRelay_Lease(t *testing.T) {
+	t.Parallel()
+	db := newDB(t)
+	o := New()
+	clk := clock.NewMock(time.Now())
+	p := &failingProducer{}
+	producer := func(string) (mq.Producer, error) {
+		return p, nil
+	}
+	relay1 := NewRelay(db, o, producer, WithOwner("r1"), WithClock(clk), WithPollInterval(time.Hour))
+	relay2 := NewRelay(db, o, producer, WithOwner("r2"), WithClock(clk), WithLeaseDuration(time.Minute))
+
+	insert(t, db, o, "t", &mq.Message{Value: []byte("1")})
+	sent, err := relay1.Publish(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	// relay1持有租约
+	insert(t, db, o, "t", &mq.Message{Value: []byte("2")})
+	sent, err = relay2.Publish(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	// 租约过期之后relay2接手
+	clk.Add(defaultLeaseDuration + time.Second)
+	sent, err = relay2.Publish(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	sent, err = relay1.Publish(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	// relay2停止时释放租约，relay1立刻接手
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- relay2.Run(ctx)
+	}()
+	cancel()
+	require.NoError(t, <-done)
+	insert(t, db, o, "t", &mq.Message{Value: []byte("3")})
+	sent, err = relay1.Publish(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []string{"1", "2", "3"}, p.sent)
+}
@@ -0,0 +1,320 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
+)
+
+const (
+	defaultLeaseName     = "default"
+	defaultBatchSize     = 100
+	defaultPollInterval  = time.Second
+	defaultLeaseDuration = 30 * time.Second
+	defaultMaxAttempts   = 10
+)
+
+// Relay 轮询 Outbox 的表，按照id的顺序把还没有发送的消息交给生产者发送，发送成功之后标记为已发送。
+// 多个实例通过租约表选出一个实例发送，其他实例等待租约过期，因此同一个Key的消息保持写入的顺序。
+// 某条消息发送失败时，同一个topic与Key的后续消息在这一轮中不会发送，下一轮重试；
+// 失败次数达到上限的消息不再重试，保留在表中等待人工处理，它之后的消息会继续发送。
+//
+// 发送成功但是标记失败，或者发送时间超过租约导致其他实例接手时，消息会被重复发送，消费者需要去重
+type Relay struct {
+	db       *sql.DB
+	outbox   *Outbox
+	producer func(topic string) (mq.Producer, error)
+
+	name          string
+	owner         string
+	batchSize     int
+	pollInterval  time.Duration
+	leaseDuration time.Duration
+	maxAttempts   int
+	clock         clock.Clock
+
+	locker sync.Mutex
+	// 按照topic缓存的生产者，Relay 不负责关闭
+	producers map[string]mq.Producer
+}
+
+// NewRelay 创建发送o中消息的 Relay，producer用于创建每个topic的生产者，例如 mq.MQ 的 Producer 方法
+func NewRelay(db *sql.DB, o *Outbox, producer func(topic string) (mq.Producer, error), opts ...option.Option[Relay]) *Relay {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	r := &Relay{
+		db:            db,
+		outbox:        o,
+		producer:      producer,
+		name:          defaultLeaseName,
+		owner:         hex.EncodeToString(b),
+		batchSize:     defaultBatchSize,
+		pollInterval:  defaultPollInterval,
+		leaseDuration: defaultLeaseDuration,
+		maxAttempts:   defaultMaxAttempts,
+		clock:         clock.New(),
+		producers:     map[string]mq.Producer{},
+	}
+	option.Apply(r, opts...)
+	return r
+}
+
+// WithLeaseName 指定租约的名称，默认为default。使用同一个表的多组 Relay 需要使用不同的名称
+func WithLeaseName(name string) option.Option[Relay] {
+	return func(r *Relay) {
+		r.name = name
+	}
+}
+
+// WithOwner 指定实例在租约表中的标识，默认随机生成
+func WithOwner(owner string) option.Option[Relay] {
+	return func(r *Relay) {
+		r.owner = owner
+	}
+}
+
+// WithBatchSize 指定每次从表中读取的消息数量，默认为100
+func WithBatchSize(size int) option.Option[Relay] {
+	return func(r *Relay) {
+		r.batchSize = size
+	}
+}
+
+// WithPollInterval 指定轮询的间隔，默认为1秒
+func WithPollInterval(interval time.Duration) option.Option[Relay] {
+	return func(r *Relay) {
+		r.pollInterval = interval
+	}
+}
+
+// WithLeaseDuration 指定租约的时长，默认为30秒，需要长于发送一批消息的时间
+func WithLeaseDuration(d time.Duration) option.Option[Relay] {
+	return func(r *Relay) {
+		r.leaseDuration = d
+	}
+}
+
+// WithMaxAttempts 指定一条消息最多发送的次数，默认为10
+func WithMaxAttempts(n int) option.Option[Relay] {
+	return func(r *Relay) {
+		r.maxAttempts = n
+	}
+}
+
+// WithClock 指定计算租约与时间戳使用的时钟，测试中可以使用 clock.Mock
+func WithClock(clk clock.Clock) option.Option[Relay] {
+	return func(r *Relay) {
+		r.clock = clk
+	}
+}
+
+// Run 按照轮询间隔发送消息，阻塞到ctx结束，结束时释放租约让其他实例尽快接手
+func (r *Relay) Run(ctx context.Context) error {
+	defer r.release()
+	for {
+		if _, err := r.Publish(ctx); err != nil && ctx.Err() == nil {
+			mq.Logger().Error("发送outbox中的消息失败", slog.String("error", err.Error()))
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-r.clock.After(r.pollInterval):
+		}
+	}
+}
+
+type record struct {
+	id       int64
+	topic    string
+	key      []byte
+	value    []byte
+	headers  sql.NullString
+	attempts int
+}
+
+// Publish 在持有租约时发送所有待发送的消息，返回发送成功的数量。没有获得租约时返回0
+func (r *Relay) Publish(ctx context.Context) (int, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	sent := 0
+	var lastID int64
+	// 发送失败的topic与Key，这一轮中不再发送它们之后的消息
+	blocked := map[string]struct{}{}
+	for {
+		leader, err := r.acquire(ctx)
+		if err != nil || !leader {
+			return sent, err
+		}
+		records, err := r.fetch(ctx, lastID)
+		if err != nil {
+			return sent, err
+		}
+		for _, rec := range records {
+			lastID = rec.id
+			orderKey := rec.topic + "/" + string(rec.key)
+			if _, ok := blocked[orderKey]; ok {
+				continue
+			}
+			if err = r.publish(ctx, rec); err != nil {
+				if len(rec.key) > 0 {
+					blocked[orderKey] = struct{}{}
+				}
+				mq.Logger().Warn("发送outbox中的消息失败", slog.Int64("id", rec.id),
+					slog.String("topic", rec.topic), slog.Int("attempts", rec.attempts+1),
+					slog.String("error", err.Error()))
+				if err = r.markFailed(ctx, rec.id, err); err != nil {
+					return sent, err
+				}
+				continue
+			}
+			if err = r.markSent(ctx, rec.id); err != nil {
+				return sent, err
+			}
+			sent++
+		}
+		if len(records) < r.batchSize {
+			return sent, nil
+		}
+	}
+}
+
+func (r *Relay) publish(ctx context.Context, rec record) error {
+	p, ok := r.producers[rec.topic]
+	if !ok {
+		var err error
+		p, err = r.producer(rec.topic)
+		if err != nil {
+			return err
+		}
+		r.producers[rec.topic] = p
+	}
+	msg := &mq.Message{Key: rec.key, Value: rec.value}
+	if rec.headers.String != "" {
+		if err := json.Unmarshal([]byte(rec.headers.String), &msg.Header); err != nil {
+			return err
+		}
+	}
+	_, err := p.Produce(ctx, msg)
+	return err
+}
+
+func (r *Relay) fetch(ctx context.Context, afterID int64) ([]record, error) {
+	o := r.outbox
+	rows, err := r.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT id, topic, msg_key, msg_value, headers, attempts FROM %s "+
+			"WHERE sent_at IS NULL AND attempts < %s AND id > %s ORDER BY id LIMIT %s",
+			o.table, o.placeholder(1), o.placeholder(2), o.placeholder(3)),
+		r.maxAttempts, afterID, r.batchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var records []record
+	for rows.Next() {
+		var rec record
+		if err = rows.Scan(&rec.id, &rec.topic, &rec.key, &rec.value, &rec.headers, &rec.attempts); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+func (r *Relay) markSent(ctx context.Context, id int64) error {
+	o := r.outbox
+	_, err := r.db.ExecContext(ctx,
+		fmt.Sprintf("UPDATE %s SET sent_at = %s WHERE id = %s", o.table, o.placeholder(1), o.placeholder(2)),
+		r.clock.Now().UnixMilli(), id)
+	return err
+}
+
+func (r *Relay) markFailed(ctx context.Context, id int64, cause error) error {
+	o := r.outbox
+	_, err := r.db.ExecContext(ctx,
+		fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, last_error = %s WHERE id = %s",
+			o.table, o.placeholder(1), o.placeholder(2)),
+		cause.Error(), id)
+	return err
+}
+
+// acquire 获取或者续期租约，返回当前实例是否持有租约
+func (r *Relay) acquire(ctx context.Context) (bool, error) {
+	o := r.outbox
+	now := r.clock.Now().UnixMilli()
+	expiresAt := now + r.leaseDuration.Milliseconds()
+	res, err := r.db.ExecContext(ctx,
+		fmt.Sprintf("UPDATE %s SET owner = %s, expires_at = %s WHERE name = %s AND (owner = %s OR expires_at < %s)",
+			o.leaseTable, o.placeholder(1), o.placeholder(2), o.placeholder(3), o.placeholder(4), o.placeholder(5)),
+		r.owner, expiresAt, r.name, r.owner, now)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return true, nil
+	}
+	_, err = r.db.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (name, owner, expires_at) VALUES (%s)", o.leaseTable, o.placeholders(1, 3)),
+		r.name, r.owner, expiresAt)
+	if err == nil {
+		return true, nil
+	}
+	// 插入失败说明租约已经存在。续期时间没有变化时，部分数据库的影响行数为0，因此再比较一次持有者
+	var owner string
+	err = r.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT owner FROM %s WHERE name = %s", o.leaseTable, o.placeholder(1)), r.name).Scan(&owner)
+	if err != nil {
+		return false, err
+	}
+	return owner == r.owner, nil
+}
+
+// release 释放当前实例持有的租约
+func (r *Relay) release() {
+	o := r.outbox
+	_, err := r.db.ExecContext(context.Background(),
+		fmt.Sprintf("UPDATE %s SET expires_at = 0 WHERE name = %s AND owner = %s",
+			o.leaseTable, o.placeholder(1), o.placeholder(2)),
+		r.name, r.owner)
+	if err != nil {
+		mq.Logger().Warn("释放outbox租约失败", slog.String("name", r.name), slog.String("error", err.Error()))
+	}
+}
+
+// DeleteSent 删除早于before发送的消息，返回删除的数量，需要定期调用清理表
+func (r *Relay) DeleteSent(ctx context.Context, before time.Time) (int64, error) {
+	o := r.outbox
+	res, err := r.db.ExecContext(ctx,
+		fmt.Sprintf("DELETE FROM %s WHERE sent_at IS NOT NULL AND sent_at < %s", o.table, o.placeholder(1)),
+		before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
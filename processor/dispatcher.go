@@ -0,0 +1,162 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/internal/inflight"
+)
+
+// 按照Key分发时每个worker的队列长度，避免一个Key的慢消息立刻阻塞其他Key的分发
+const workerQueueSize = 64
+
+// Dispatcher 从一个 mq.Consumer 拉取消息并分发给多个worker处理。
+// 消息可以乱序完成，但是每个分区只会提交连续处理完成的前缀，因此重启之后不会丢失消息
+type Dispatcher struct {
+	consumer     mq.Consumer
+	s            *Subscription
+	drainTimeout time.Duration
+}
+
+// NewDispatcher 在c上按照Key并发处理消息，相当于使用了 WithKeyOrdering 的订阅。
+// c需要使用 mq.WithManualCommit 创建，Dispatcher 不会关闭c
+func NewDispatcher(c mq.Consumer, handler Handler, opts ...option.Option[Subscription]) *Dispatcher {
+	opts = append([]option.Option[Subscription]{WithKeyOrdering()}, opts...)
+	return &Dispatcher{
+		consumer: c,
+		s:        newSubscription("", "", handler, opts...),
+	}
+}
+
+// Run 阻塞到ctx结束或者处理出错，返回之前等待正在处理的消息完成并提交消费进度
+func (d *Dispatcher) Run(ctx context.Context) error {
+	// 出错时停止拉取新消息
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	// 不随ctx取消，保证停止时正在处理的消息可以完成
+	handlerCtx, cancelHandlers := context.WithCancel(context.Background())
+	defer cancelHandlers()
+	tracker := inflight.NewTracker()
+	queues := d.newQueues()
+	var wg sync.WaitGroup
+	for i := 0; i < d.s.concurrency; i++ {
+		wg.Add(1)
+		go func(entries <-chan *inflight.Entry) {
+			defer wg.Done()
+			for e := range entries {
+				// 停止之后不再处理还在队列中的消息，它们没有提交，重启之后会被重新投递
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := d.s.process(handlerCtx, e.Message); err != nil {
+					// 不标记完成，这条消息及其之后的消息都不会被提交
+					fail(err)
+					continue
+				}
+				if msg := tracker.Done(e); msg != nil {
+					if err := d.consumer.Commit(handlerCtx, msg); err != nil {
+						fail(err)
+					}
+				}
+			}
+		}(queues[i%len(queues)])
+	}
+
+	d.dispatch(ctx, tracker, queues, fail)
+	for _, q := range queues {
+		close(q)
+	}
+	d.drain(&wg, cancelHandlers)
+	return firstErr
+}
+
+// newQueues 按照Key分发时每个worker一个队列，否则所有worker共用一个队列
+func (d *Dispatcher) newQueues() []chan *inflight.Entry {
+	if !d.s.keyOrdered {
+		return []chan *inflight.Entry{make(chan *inflight.Entry)}
+	}
+	queues := make([]chan *inflight.Entry, 0, d.s.concurrency)
+	for i := 0; i < d.s.concurrency; i++ {
+		queues = append(queues, make(chan *inflight.Entry, workerQueueSize))
+	}
+	return queues
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, tracker *inflight.Tracker, queues []chan *inflight.Entry, fail func(err error)) {
+	for {
+		msg, err := d.consumer.Consume(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				fail(err)
+			}
+			return
+		}
+		e := tracker.Start(msg)
+		select {
+		case queues[route(msg, len(queues))] <- e:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drain 等待正在处理的消息完成，超过drainTimeout之后取消传给Handler的ctx
+func (d *Dispatcher) drain(wg *sync.WaitGroup, cancelHandlers context.CancelFunc) {
+	if d.drainTimeout <= 0 {
+		wg.Wait()
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(d.drainTimeout):
+		cancelHandlers()
+		<-done
+	}
+}
+
+// route 返回消息所属的队列，相同Key的消息总是进入同一个队列，没有Key时按照分区
+func route(msg *mq.Message, n int) int {
+	if n == 1 {
+		return 0
+	}
+	if len(msg.Key) == 0 {
+		return int(msg.Partition % int64(n))
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(msg.Key)
+	return int(h.Sum32() % uint32(n))
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatcher_KeyOrdering(t *testing.T) {
+	t.Parallel()
+	m := memory.NewMQ()
+	const topic, keys, perKey = "dispatcher_key_ordering", 4, 10
+	require.NoError(t, m.CreateTopic(context.Background(), topic, 1))
+	p, err := m.Producer(topic)
+	require.NoError(t, err)
+	for i := 0; i < perKey; i++ {
+		for k := 0; k < keys; k++ {
+			_, err = p.Produce(context.Background(), &mq.Message{
+				Key:   []byte(fmt.Sprintf("key%d", k)),
+				Value: []byte(strconv.Itoa(i)),
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	c, err := m.Consumer(topic, "g1", mq.WithManualCommit())
+	require.NoError(t, err)
+	var mu sync.Mutex
+	handled := map[string][]int{}
+	total := 0
+	d := NewDispatcher(c, func(ctx context.Context, msg *mq.Message) error {
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		v, _ := strconv.Atoi(string(msg.Value))
+		mu.Lock()
+		defer mu.Unlock()
+		handled[string(msg.Key)] = append(handled[string(msg.Key)], v)
+		total++
+		return nil
+	}, WithConcurrency(keys))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- d.Run(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return total == keys*perKey
+	}, 10*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-runErr)
+	require.NoError(t, c.Close())
+
+	want := make([]int, 0, perKey)
+	for i := 0; i < perKey; i++ {
+		want = append(want, i)
+	}
+	for k := 0; k < keys; k++ {
+		assert.Equal(t, want, handled[fmt.Sprintf("key%d", k)])
+	}
+	assert.Equal(t, int64(-1), nextOffset(t, m, topic, "g1"))
+}
+
+func TestRoute(t *testing.T) {
+	t.Parallel()
+	msg := &mq.Message{Key: []byte("order-1"), Partition: 3}
+	assert.Equal(t, route(msg, 8), route(&mq.Message{Key: []byte("order-1"), Partition: 5}, 8))
+	assert.Equal(t, 0, route(msg, 1))
+	// 没有Key时按照分区分发
+	assert.Equal(t, 3, route(&mq.Message{Partition: 3}, 8))
+}
+
+func TestDispatcher_InvalidConcurrency(t *testing.T) {
+	t.Parallel()
+	for _, n := range []int{0, -1} {
+		d := NewDispatcher(nil, func(ctx context.Context, msg *mq.Message) error {
+			return nil
+		}, WithConcurrency(n))
+		assert.Equal(t, 1, d.s.concurrency)
+	}
+
+	m := memory.NewMQ()
+	const topic = "dispatcher_invalid_concurrency"
+	require.NoError(t, m.CreateTopic(context.Background(), topic, 2))
+	p, err := m.Producer(topic)
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		_, err = p.ProduceWithPartition(context.Background(), &mq.Message{Value: []byte(strconv.Itoa(i))}, i%2)
+		require.NoError(t, err)
+	}
+	c, err := m.Consumer(topic, "g1", mq.WithManualCommit())
+	require.NoError(t, err)
+	var mu sync.Mutex
+	total := 0
+	d := NewDispatcher(c, func(ctx context.Context, msg *mq.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		total++
+		return nil
+	}, WithConcurrency(0))
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- d.Run(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return total == 4
+	}, 10*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-runErr)
+	require.NoError(t, c.Close())
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+	"golang.org/x/sync/errgroup"
+)
+
+const defaultConcurrency = 1
+
+var (
+	ErrHandlerPanic = errors.New("处理消息时发生panic")
+	ErrNoHandlers   = errors.New("没有注册任何handler")
+)
+
+// Handler 处理一条消息，返回nil之后才会提交这条消息的消费进度
+type Handler func(ctx context.Context, msg *mq.Message) error
+
+// ErrorHandler 在 Handler 返回error或者panic时调用。
+// 返回nil表示消息已经被妥善处理，例如投递到了死信topic，可以提交消费进度；
+// 返回error时对应的订阅停止消费，Run 返回该error，未提交的消息在重启之后会被重新投递
+type ErrorHandler func(ctx context.Context, msg *mq.Message, err error) error
+
+// Processor 在 mq.MQ 的基础上运行注册的 Handler，负责拉取消息、并发处理与提交消费进度，
+// 可以使用任意的 mq.MQ 实现
+type Processor struct {
+	mq            mq.MQ
+	subscriptions []*Subscription
+	// 停止之后等待正在处理的消息的最长时间，超过之后取消传给Handler的ctx，为0时一直等待
+	drainTimeout time.Duration
+}
+
+func NewProcessor(m mq.MQ, opts ...option.Option[Processor]) *Processor {
+	p := &Processor{mq: m}
+	option.Apply(p, opts...)
+	return p
+}
+
+// WithDrainTimeout 指定停止时等待正在处理的消息的最长时间，超过之后传给 Handler 的ctx会被取消
+func WithDrainTimeout(timeout time.Duration) option.Option[Processor] {
+	return func(p *Processor) {
+		p.drainTimeout = timeout
+	}
+}
+
+// Subscription 是一个消费组对一个topic的订阅
+type Subscription struct {
+	topic        string
+	groupID      string
+	handler      Handler
+	concurrency  int
+	errorHandler ErrorHandler
+	consumerOpts []option.Option[mq.ConsumerConfig]
+	// 是否按照Key把消息分发给固定的worker
+	keyOrdered bool
+}
+
+func newSubscription(topic, groupID string, handler Handler, opts ...option.Option[Subscription]) *Subscription {
+	s := &Subscription{
+		topic:       topic,
+		groupID:     groupID,
+		handler:     handler,
+		concurrency: defaultConcurrency,
+		errorHandler: func(_ context.Context, _ *mq.Message, err error) error {
+			return err
+		},
+	}
+	option.Apply(s, opts...)
+	if s.concurrency < 1 {
+		s.concurrency = defaultConcurrency
+	}
+	return s
+}
+
+// WithConcurrency 指定同时处理消息的协程数量，默认为1，n小于1时按照1处理。
+// 同一个分区的消息可能会被并发处理，但是消费进度只会提交到连续处理完成的位置
+func WithConcurrency(n int) option.Option[Subscription] {
+	return func(s *Subscription) {
+		s.concurrency = n
+	}
+}
+
+// WithKeyOrdering 按照 Message.Key 的哈希值把消息分发给固定的worker，同一个Key的消息按照顺序处理，
+// 不同Key的消息并发处理。没有Key的消息按照分区分发，保持分区内的顺序
+func WithKeyOrdering() option.Option[Subscription] {
+	return func(s *Subscription) {
+		s.keyOrdered = true
+	}
+}
+
+// WithErrorHandler 指定 Handler 失败时的处理方式，默认直接返回error，停止这个订阅
+func WithErrorHandler(h ErrorHandler) option.Option[Subscription] {
+	return func(s *Subscription) {
+		s.errorHandler = h
+	}
+}
+
+// WithConsumerOptions 指定创建消费者时的可选配置，Processor 总是会开启手动提交
+func WithConsumerOptions(opts ...option.Option[mq.ConsumerConfig]) option.Option[Subscription] {
+	return func(s *Subscription) {
+		s.consumerOpts = opts
+	}
+}
+
+// Handle 注册handler处理groupID消费组在topic上的消息，需要在 Run 之前调用
+func (p *Processor) Handle(topic, groupID string, handler Handler, opts ...option.Option[Subscription]) {
+	p.subscriptions = append(p.subscriptions, newSubscription(topic, groupID, handler, opts...))
+}
+
+// Run 启动所有订阅并阻塞到ctx结束或者某个订阅出错。
+// 停止时不再拉取新消息，等待正在处理的消息完成并提交消费进度之后关闭消费者
+func (p *Processor) Run(ctx context.Context) error {
+	if len(p.subscriptions) == 0 {
+		return ErrNoHandlers
+	}
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, s := range p.subscriptions {
+		s := s
+		eg.Go(func() error {
+			opts := make([]option.Option[mq.ConsumerConfig], 0, len(s.consumerOpts)+1)
+			opts = append(opts, s.consumerOpts...)
+			c, err := p.mq.Consumer(s.topic, s.groupID, append(opts, mq.WithManualCommit())...)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = c.Close()
+			}()
+			d := &Dispatcher{consumer: c, s: s, drainTimeout: p.drainTimeout}
+			return d.Run(ctx)
+		})
+	}
+	return eg.Wait()
+}
+
+func (s *Subscription) process(ctx context.Context, msg *mq.Message) error {
+	err := s.invoke(ctx, msg)
+	if err == nil {
+		return nil
+	}
+	return s.errorHandler(ctx, msg, err)
+}
+
+func (s *Subscription) invoke(ctx context.Context, msg *mq.Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+		}
+	}()
+	return s.handler(ctx, msg)
+}
@@ -0,0 +1,218 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func produceMessages(t *testing.T, m mq.MQ, topic string, n int) {
+	t.Helper()
+	require.NoError(t, m.CreateTopic(context.Background(), topic, 1))
+	p, err := m.Producer(topic)
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		_, err = p.Produce(context.Background(), &mq.Message{Value: []byte(fmt.Sprintf("%d", i))})
+		require.NoError(t, err)
+	}
+}
+
+// nextOffset 用同一个消费组的新消费者读取一条消息，返回它的偏移量，没有消息时返回-1
+func nextOffset(t *testing.T, m mq.MQ, topic, groupID string) int64 {
+	t.Helper()
+	c, err := m.Consumer(topic, groupID)
+	require.NoError(t, err)
+	defer func() {
+		_ = c.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	msg, err := c.Consume(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return -1
+	}
+	require.NoError(t, err)
+	return msg.Offset
+}
+
+func TestProcessor_Run(t *testing.T) {
+	t.Parallel()
+	m := memory.NewMQ()
+	produceMessages(t, m, "processor_run", 20)
+
+	var mu sync.Mutex
+	handled := map[string]struct{}{}
+	var running, maxRunning atomic.Int64
+	p := NewProcessor(m)
+	p.Handle("processor_run", "g1", func(ctx context.Context, msg *mq.Message) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			cur := maxRunning.Load()
+			if n <= cur || maxRunning.CompareAndSwap(cur, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		handled[string(msg.Value)] = struct{}{}
+		return nil
+	}, WithConcurrency(4))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- p.Run(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(handled) == 20
+	}, 10*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-runErr)
+	assert.Greater(t, maxRunning.Load(), int64(1))
+	// 所有消息都已经提交
+	assert.Equal(t, int64(-1), nextOffset(t, m, "processor_run", "g1"))
+}
+
+func TestProcessor_HandlerError(t *testing.T) {
+	t.Parallel()
+	errHandle := errors.New("处理失败")
+	testCases := []struct {
+		name         string
+		topic        string
+		handler      Handler
+		errorHandler ErrorHandler
+		wantErr      error
+		wantOffset   int64
+	}{
+		{
+			name:  "默认停止订阅",
+			topic: "processor_error",
+			handler: func(ctx context.Context, msg *mq.Message) error {
+				if msg.Offset == 3 {
+					return errHandle
+				}
+				return nil
+			},
+			wantErr: errHandle,
+			// 失败的消息没有提交，会被重新投递
+			wantOffset: 3,
+		},
+		{
+			name:  "panic",
+			topic: "processor_panic",
+			handler: func(ctx context.Context, msg *mq.Message) error {
+				if msg.Offset == 2 {
+					panic("boom")
+				}
+				return nil
+			},
+			wantErr:    ErrHandlerPanic,
+			wantOffset: 2,
+		},
+		{
+			name:  "ErrorHandler处理之后继续消费",
+			topic: "processor_error_handler",
+			handler: func(ctx context.Context, msg *mq.Message) error {
+				if msg.Offset == 3 {
+					panic("boom")
+				}
+				return nil
+			},
+			errorHandler: func(ctx context.Context, msg *mq.Message, err error) error {
+				return nil
+			},
+			wantOffset: -1,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			m := memory.NewMQ()
+			produceMessages(t, m, tc.topic, 5)
+			var handled atomic.Int64
+			var opts []option.Option[Subscription]
+			if tc.errorHandler != nil {
+				opts = append(opts, WithErrorHandler(tc.errorHandler))
+			}
+			p := NewProcessor(m)
+			p.Handle(tc.topic, "g1", func(ctx context.Context, msg *mq.Message) error {
+				defer handled.Add(1)
+				return tc.handler(ctx, msg)
+			}, opts...)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := p.Run(ctx)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, int64(5), handled.Load())
+			}
+			assert.Equal(t, tc.wantOffset, nextOffset(t, m, tc.topic, "g1"))
+		})
+	}
+}
+
+func TestProcessor_Drain(t *testing.T) {
+	t.Parallel()
+	m := memory.NewMQ()
+	produceMessages(t, m, "processor_drain", 1)
+
+	started := make(chan struct{})
+	var finished atomic.Bool
+	p := NewProcessor(m)
+	p.Handle("processor_drain", "g1", func(ctx context.Context, msg *mq.Message) error {
+		close(started)
+		time.Sleep(500 * time.Millisecond)
+		// 停止时正在处理的消息不会被取消
+		if ctx.Err() == nil {
+			finished.Store(true)
+		}
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- p.Run(ctx)
+	}()
+	<-started
+	cancel()
+	require.NoError(t, <-runErr)
+	assert.True(t, finished.Load())
+	assert.Equal(t, int64(-1), nextOffset(t, m, "processor_drain", "g1"))
+}
+
+func TestProcessor_NoHandlers(t *testing.T) {
+	t.Parallel()
+	assert.ErrorIs(t, NewProcessor(memory.NewMQ()).Run(context.Background()), ErrNoHandlers)
+}
@@ -0,0 +1,267 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrytopic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
+	"github.com/ecodeclub/mq-api/dlq"
+	"go.uber.org/multierr"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// HeaderTier 记录消息所在的重试层级，从0开始
+	HeaderTier = "x-mq-retry-tier"
+	// HeaderDueTime 记录消息可以被再次处理的时间，为毫秒级的unix时间戳
+	HeaderDueTime = "x-mq-retry-due"
+)
+
+var ErrNoDelays = errors.New("重试层级不能为空")
+
+// Topic 返回topic在延迟为delay的重试层级使用的topic，例如 orders.retry.5s
+func Topic(topic string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", topic, formatDelay(delay))
+}
+
+func formatDelay(delay time.Duration) string {
+	switch {
+	case delay%time.Hour == 0:
+		return fmt.Sprintf("%dh", delay/time.Hour)
+	case delay%time.Minute == 0:
+		return fmt.Sprintf("%dm", delay/time.Minute)
+	case delay%time.Second == 0:
+		return fmt.Sprintf("%ds", delay/time.Second)
+	default:
+		return fmt.Sprintf("%dms", delay/time.Millisecond)
+	}
+}
+
+// Tier 返回消息所在的重试层级，不在重试topic中的消息返回-1
+func Tier(msg *mq.Message) int {
+	tier, err := strconv.Atoi(msg.Header.Get(HeaderTier))
+	if err != nil {
+		return -1
+	}
+	return tier
+}
+
+// DueTime 返回消息可以被再次处理的时间，不在重试topic中的消息返回零值
+func DueTime(msg *mq.Message) time.Time {
+	due, err := strconv.ParseInt(msg.Header.Get(HeaderDueTime), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(due)
+}
+
+// RetryTopics 实现非阻塞重试。
+// 处理失败的消息依次投递到 <topic>.retry.<delay> 中，由重试消费者在到期后再次处理，
+// 所有层级都失败后投递到死信topic，这样失败的消息不会阻塞原分区中后续的消息
+type RetryTopics struct {
+	mq     mq.MQ
+	topic  string
+	delays []time.Duration
+	dlq    *dlq.DeadLetterQueue
+	// dlq是否由 RetryTopics 创建，只有这种情况下 Close 才关闭它
+	ownDLQ bool
+	clock  clock.Clock
+
+	locker    sync.Mutex
+	producers map[string]mq.Producer
+}
+
+// NewRetryTopics 创建topic的重试层级，delays为每个层级的延迟，例如 5s、1m、10m
+func NewRetryTopics(m mq.MQ, topic string, delays []time.Duration, opts ...option.Option[RetryTopics]) (*RetryTopics, error) {
+	if len(delays) == 0 {
+		return nil, ErrNoDelays
+	}
+	r := &RetryTopics{
+		mq:        m,
+		topic:     topic,
+		delays:    delays,
+		clock:     clock.New(),
+		producers: make(map[string]mq.Producer),
+	}
+	option.Apply(r, opts...)
+	if r.dlq == nil {
+		r.dlq = dlq.NewDeadLetterQueue(m)
+		r.ownDLQ = true
+	}
+	return r, nil
+}
+
+// WithDeadLetterQueue 指定所有重试层级都失败后使用的死信队列
+func WithDeadLetterQueue(d *dlq.DeadLetterQueue) option.Option[RetryTopics] {
+	return func(r *RetryTopics) {
+		r.dlq = d
+	}
+}
+
+// WithClock 指定计算到期时间与等待使用的时钟，默认使用系统时间
+func WithClock(clk clock.Clock) option.Option[RetryTopics] {
+	return func(r *RetryTopics) {
+		r.clock = clk
+	}
+}
+
+// Topics 返回所有重试层级使用的topic
+func (r *RetryTopics) Topics() []string {
+	res := make([]string, 0, len(r.delays))
+	for _, delay := range r.delays {
+		res = append(res, Topic(r.topic, delay))
+	}
+	return res
+}
+
+// Handle 调用handler处理消息一次，失败时投递到下一个重试层级，没有下一个层级时投递到死信topic。
+// 返回nil表示消息已经处理成功或者已经转发，调用者可以提交偏移量
+func (r *RetryTopics) Handle(ctx context.Context, msg *mq.Message, handler dlq.HandleFunc) error {
+	err := handler(ctx, msg)
+	if err == nil {
+		return nil
+	}
+	next := Tier(msg) + 1
+	if next >= len(r.delays) {
+		return r.dlq.Send(ctx, msg, err)
+	}
+
+	header := msg.Header.Clone()
+	header.Set(dlq.HeaderAttempts, strconv.Itoa(dlq.Attempts(msg)+1))
+	header.Set(dlq.HeaderError, err.Error())
+	if header.Get(dlq.HeaderOriginTopic) == "" {
+		header.Set(dlq.HeaderOriginTopic, msg.Topic)
+		header.Set(dlq.HeaderOriginPartition, strconv.FormatInt(msg.Partition, 10))
+		header.Set(dlq.HeaderOriginOffset, strconv.FormatInt(msg.Offset, 10))
+	}
+	header.Set(HeaderTier, strconv.Itoa(next))
+	header.Set(HeaderDueTime, strconv.FormatInt(r.clock.Now().Add(r.delays[next]).UnixMilli(), 10))
+
+	p, err := r.producer(ctx, Topic(r.topic, r.delays[next]))
+	if err != nil {
+		return err
+	}
+	_, err = p.Produce(ctx, &mq.Message{
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Header:    header,
+		Timestamp: msg.Timestamp,
+	})
+	return err
+}
+
+// Run 为每个重试层级启动一个消费者，等到消息到期后再调用handler处理，失败的消息继续交给 Handle。
+// 原topic的消息需要调用者自己消费并调用 Handle。Run 会一直阻塞到ctx结束或者出现错误。
+// 消息在处理成功或者转发之后才会提交，等待或者处理期间重启不会丢失重试
+func (r *RetryTopics) Run(ctx context.Context, groupID string, handler dlq.HandleFunc) error {
+	// 先创建所有的消费者，出错时关闭已经创建的，不会留下没有人等待的协程
+	topics := r.Topics()
+	consumers := make([]mq.Consumer, 0, len(topics))
+	for _, topic := range topics {
+		c, err := r.newConsumer(ctx, topic, groupID)
+		if err != nil {
+			for _, created := range consumers {
+				_ = created.Close()
+			}
+			return err
+		}
+		consumers = append(consumers, c)
+	}
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, c := range consumers {
+		eg.Go(func() error {
+			defer func() {
+				_ = c.Close()
+			}()
+			return r.consume(ctx, c, handler)
+		})
+	}
+	err := eg.Wait()
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	return err
+}
+
+func (r *RetryTopics) newConsumer(ctx context.Context, topic, groupID string) (mq.Consumer, error) {
+	// topic已经存在时两种实现的CreateTopic都不会返回错误
+	if err := r.mq.CreateTopic(ctx, topic, 1); err != nil {
+		return nil, err
+	}
+	return r.mq.Consumer(topic, groupID, mq.WithManualCommit())
+}
+
+func (r *RetryTopics) consume(ctx context.Context, c mq.Consumer, handler dlq.HandleFunc) error {
+	for {
+		msg, err := c.Consume(ctx)
+		if err != nil {
+			return err
+		}
+		// 同一个层级的消息延迟相同，按照顺序到期，等待队头的消息不会推迟后面的消息
+		if wait := DueTime(msg).Sub(r.clock.Now()); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-r.clock.After(wait):
+			}
+		}
+		if err = r.Handle(ctx, msg, handler); err != nil {
+			return err
+		}
+		if err = c.Commit(ctx, msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *RetryTopics) producer(ctx context.Context, topic string) (mq.Producer, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if p, ok := r.producers[topic]; ok {
+		return p, nil
+	}
+	if err := r.mq.CreateTopic(ctx, topic, 1); err != nil {
+		return nil, err
+	}
+	p, err := r.mq.Producer(topic)
+	if err != nil {
+		return nil, err
+	}
+	r.producers[topic] = p
+	return p, nil
+}
+
+// Close 关闭 RetryTopics 创建的生产者和死信队列，不会关闭 mq.MQ 和通过 WithDeadLetterQueue 传入的死信队列
+func (r *RetryTopics) Close() error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	var err error
+	for topic, p := range r.producers {
+		err = multierr.Append(err, p.Close())
+		delete(r.producers, topic)
+	}
+	if r.ownDLQ {
+		err = multierr.Append(err, r.dlq.Close())
+	}
+	return err
+}
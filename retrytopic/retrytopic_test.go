@@ -0,0 +1,221 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retrytopic
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/clock"
+	"github.com/ecodeclub/mq-api/dlq"
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopic(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "orders.retry.5s", Topic("orders", 5*time.Second))
+	assert.Equal(t, "orders.retry.1m", Topic("orders", time.Minute))
+	assert.Equal(t, "orders.retry.2h", Topic("orders", 2*time.Hour))
+	assert.Equal(t, "orders.retry.1500ms", Topic("orders", 1500*time.Millisecond))
+}
+
+func TestNewRetryTopics(t *testing.T) {
+	t.Parallel()
+	_, err := NewRetryTopics(memory.NewMQ(), "orders", nil)
+	assert.ErrorIs(t, err, ErrNoDelays)
+}
+
+func TestRetryTopics_Run(t *testing.T) {
+	t.Parallel()
+	errHandle := errors.New("处理失败")
+	testCases := []struct {
+		name        string
+		topic       string
+		failures    int64
+		wantCalls   int64
+		wantDLQ     bool
+		wantHeaders map[string]string
+	}{
+		{
+			name:      "在第二个层级成功",
+			topic:     "retry_success",
+			failures:  2,
+			wantCalls: 3,
+		},
+		{
+			name:      "所有层级都失败",
+			topic:     "retry_exhausted",
+			failures:  10,
+			wantCalls: 3,
+			wantDLQ:   true,
+			wantHeaders: map[string]string{
+				dlq.HeaderAttempts:        "3",
+				dlq.HeaderError:           errHandle.Error(),
+				dlq.HeaderOriginTopic:     "retry_exhausted",
+				dlq.HeaderOriginPartition: "0",
+				dlq.HeaderOriginOffset:    "0",
+				HeaderTier:                "1",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			m := memory.NewMQ()
+			delays := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}
+			r, err := NewRetryTopics(m, tc.topic, delays)
+			require.NoError(t, err)
+			defer func() {
+				_ = r.Close()
+			}()
+
+			var calls atomic.Int64
+			var lastDue, lastCall atomic.Int64
+			handler := func(ctx context.Context, msg *mq.Message) error {
+				if Tier(msg) >= 0 {
+					lastDue.Store(DueTime(msg).UnixMilli())
+					lastCall.Store(time.Now().UnixMilli())
+				}
+				if calls.Add(1) <= tc.failures {
+					return errHandle
+				}
+				return nil
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			runErr := make(chan error, 1)
+			go func() {
+				runErr <- r.Run(ctx, "retry", handler)
+			}()
+
+			err = r.Handle(context.Background(), &mq.Message{Topic: tc.topic, Value: []byte("msg")}, handler)
+			require.NoError(t, err)
+			require.Eventually(t, func() bool {
+				return calls.Load() == tc.wantCalls
+			}, 15*time.Second, 50*time.Millisecond)
+			// 重试消费者不会在到期前处理消息
+			assert.GreaterOrEqual(t, lastCall.Load(), lastDue.Load())
+			cancel()
+			require.NoError(t, <-runErr)
+
+			c, err := m.Consumer(dlq.Topic(tc.topic), "c1")
+			require.NoError(t, err)
+			consumeCtx, consumeCancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer consumeCancel()
+			msg, err := c.Consume(consumeCtx)
+			if !tc.wantDLQ {
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+				return
+			}
+			require.NoError(t, err)
+			header := msg.Header.Map()
+			delete(header, HeaderDueTime)
+			assert.Equal(t, tc.wantHeaders, header)
+			assert.Equal(t, tc.wantCalls, calls.Load())
+		})
+	}
+}
+
+func TestRetryTopics_RunRestart(t *testing.T) {
+	t.Parallel()
+	clk := clock.NewMock(time.Now())
+	m := memory.NewMQ()
+	r, err := NewRetryTopics(m, "retry_restart", []time.Duration{time.Minute}, WithClock(clk))
+	require.NoError(t, err)
+	defer func() {
+		_ = r.Close()
+	}()
+
+	var calls atomic.Int64
+	handler := func(ctx context.Context, msg *mq.Message) error {
+		if calls.Add(1) == 1 {
+			return errors.New("处理失败")
+		}
+		return nil
+	}
+	err = r.Handle(context.Background(), &mq.Message{Topic: "retry_restart", Value: []byte("msg")}, handler)
+	require.NoError(t, err)
+	c, err := m.Consumer(Topic("retry_restart", time.Minute), "c1")
+	require.NoError(t, err)
+	msg, err := c.Consume(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, clk.Now().Add(time.Minute).UnixMilli(), DueTime(msg).UnixMilli())
+
+	// 在消息到期之前停止，消息没有被提交
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- r.Run(ctx, "retry", handler)
+	}()
+	time.Sleep(2 * time.Second)
+	cancel()
+	require.NoError(t, <-runErr)
+	assert.Equal(t, int64(1), calls.Load())
+
+	// 重启之后依旧可以处理到期的消息
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		runErr <- r.Run(ctx, "retry", handler)
+	}()
+	clk.Add(time.Minute)
+	require.Eventually(t, func() bool {
+		return calls.Load() == 2
+	}, 10*time.Second, 50*time.Millisecond)
+}
+
+// failingMQ 在为第failAt个topic创建消费者时返回错误
+type failingMQ struct {
+	mq.MQ
+	failAt    int
+	consumers []mq.Consumer
+}
+
+func (m *failingMQ) Consumer(topic, groupID string, opts ...option.Option[mq.ConsumerConfig]) (mq.Consumer, error) {
+	if len(m.consumers) == m.failAt {
+		return nil, errors.New("mock error")
+	}
+	c, err := m.MQ.Consumer(topic, groupID, opts...)
+	if err != nil {
+		return nil, err
+	}
+	m.consumers = append(m.consumers, c)
+	return c, nil
+}
+
+func TestRetryTopics_RunSetupError(t *testing.T) {
+	t.Parallel()
+	testmq := &failingMQ{MQ: memory.NewMQ(), failAt: 1}
+	defer func() {
+		_ = testmq.Close()
+	}()
+	r, err := NewRetryTopics(testmq, "orders_setup", []time.Duration{time.Second, time.Minute})
+	require.NoError(t, err)
+	err = r.Run(context.Background(), "g1", func(ctx context.Context, msg *mq.Message) error {
+		return nil
+	})
+	assert.EqualError(t, err, "mock error")
+	// 已经创建的消费者都被关闭了
+	require.Len(t, testmq.consumers, 1)
+	_, err = testmq.consumers[0].Consume(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,231 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+	"go.uber.org/multierr"
+)
+
+const (
+	defaultReplyTopicPrefix = "reply-"
+	defaultTimeout          = 30 * time.Second
+)
+
+// Requester 发送请求并等待应答，可以被多个协程并发访问。
+// 默认每个 Requester 创建一个只有一个分区的应答topic，关闭时删除；
+// 使用 WithSharedReplyTopic 时多个实例共用同一个应答topic，每个实例使用自己的消费组读取所有应答，
+// 只保留自己发出的请求的应答，适用于无法频繁创建topic的场景
+type Requester struct {
+	mq         mq.MQ
+	replyTopic string
+	// 应答topic是否由多个实例共用，共用时不会创建与删除
+	shared  bool
+	timeout time.Duration
+
+	consumer mq.Consumer
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+
+	locker    sync.Mutex
+	closed    bool
+	producers map[string]mq.Producer
+	// 键为 HeaderCorrelationID，等待应答的请求
+	pending map[string]chan *mq.Message
+}
+
+// NewRequester 创建 Requester 并开始接收应答，使用完之后需要调用 Close
+func NewRequester(ctx context.Context, m mq.MQ, opts ...option.Option[Requester]) (*Requester, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	r := &Requester{
+		mq:         m,
+		replyTopic: defaultReplyTopicPrefix + id,
+		timeout:    defaultTimeout,
+		producers:  map[string]mq.Producer{},
+		pending:    map[string]chan *mq.Message{},
+		done:       make(chan struct{}),
+	}
+	option.Apply(r, opts...)
+	if !r.shared {
+		if err = m.CreateTopic(ctx, r.replyTopic, 1); err != nil {
+			return nil, err
+		}
+	}
+	// 每个实例都需要收到所有应答，因此使用自己的消费组
+	r.consumer, err = m.Consumer(r.replyTopic, defaultReplyTopicPrefix+id)
+	if err != nil {
+		return nil, multierr.Combine(err, r.deleteReplyTopic())
+	}
+	loopCtx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go r.receive(loopCtx)
+	return r, nil
+}
+
+// WithSharedReplyTopic 使用多个实例共用的应答topic，topic需要提前创建
+func WithSharedReplyTopic(topic string) option.Option[Requester] {
+	return func(r *Requester) {
+		r.replyTopic = topic
+		r.shared = true
+	}
+}
+
+// WithTimeout 指定等待应答的最长时间，默认为30秒。ctx的截止时间更早时以ctx为准
+func WithTimeout(timeout time.Duration) option.Option[Requester] {
+	return func(r *Requester) {
+		r.timeout = timeout
+	}
+}
+
+// ReplyTopic 返回接收应答的topic
+func (r *Requester) ReplyTopic() string {
+	return r.replyTopic
+}
+
+// Request 向topic发送请求并等待应答。发送的是msg的副本，不会修改调用者传入的消息。
+// 应答方处理失败时返回 *RemoteError，超时时返回 context.DeadlineExceeded
+func (r *Requester) Request(ctx context.Context, topic string, msg *mq.Message) (*mq.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	replies := make(chan *mq.Message, 1)
+	p, err := r.register(topic, id, replies)
+	if err != nil {
+		return nil, err
+	}
+	defer r.unregister(id)
+
+	req := *msg
+	req.Header = msg.Header.Clone()
+	req.Header.Set(HeaderReplyTo, r.replyTopic)
+	req.Header.Set(HeaderCorrelationID, id)
+	if d, ok := ctx.Deadline(); ok {
+		req.Header.Set(HeaderDeadline, strconv.FormatInt(d.UnixMilli(), 10))
+	}
+	if _, err = p.Produce(ctx, &req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replies:
+		if reason := reply.Header.Get(HeaderError); reason != "" {
+			return nil, &RemoteError{Reply: reply, Message: reason}
+		}
+		return reply, nil
+	case <-r.done:
+		return nil, ErrRequesterClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// register 登记等待应答的请求并返回topic的生产者
+func (r *Requester) register(topic, id string, replies chan *mq.Message) (mq.Producer, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.closed {
+		return nil, ErrRequesterClosed
+	}
+	p, ok := r.producers[topic]
+	if !ok {
+		var err error
+		p, err = r.mq.Producer(topic)
+		if err != nil {
+			return nil, err
+		}
+		r.producers[topic] = p
+	}
+	r.pending[id] = replies
+	return p, nil
+}
+
+func (r *Requester) unregister(id string) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	delete(r.pending, id)
+}
+
+// receive 把应答交给等待的请求，没有请求在等待的应答直接丢弃，例如已经超时或者属于共用topic的其他实例
+func (r *Requester) receive(ctx context.Context) {
+	defer close(r.done)
+	for {
+		reply, err := r.consumer.Consume(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			mq.Logger().Error("接收应答失败", slog.String("topic", r.replyTopic), slog.String("error", err.Error()))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		r.locker.Lock()
+		replies, ok := r.pending[reply.Header.Get(HeaderCorrelationID)]
+		r.locker.Unlock()
+		if ok {
+			select {
+			case replies <- reply:
+			default:
+				// 重复的应答
+			}
+		}
+	}
+}
+
+// Close 停止接收应答并关闭创建的生产者与消费者，没有使用共用topic时删除应答topic。
+// 正在等待的请求返回 ErrRequesterClosed。多次调用返回的error与第一次调用返回的error相同
+func (r *Requester) Close() error {
+	r.closeOnce.Do(func() {
+		r.locker.Lock()
+		r.closed = true
+		r.locker.Unlock()
+
+		r.cancel()
+		<-r.done
+		errorList := make([]error, 0, len(r.producers)+2)
+		for _, p := range r.producers {
+			errorList = append(errorList, p.Close())
+		}
+		errorList = append(errorList, r.consumer.Close(), r.deleteReplyTopic())
+		r.closeErr = multierr.Combine(errorList...)
+	})
+	return r.closeErr
+}
+
+func (r *Requester) deleteReplyTopic() error {
+	if r.shared {
+		return nil
+	}
+	return r.mq.DeleteTopics(context.Background(), r.replyTopic)
+}
@@ -0,0 +1,160 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/processor"
+	"go.uber.org/multierr"
+)
+
+// 缓存的发送应答的生产者数量上限
+const maxReplyProducers = 256
+
+// Handler 处理一个请求并返回应答，返回error时应答方会收到 *RemoteError
+type Handler func(ctx context.Context, req *mq.Message) (*mq.Message, error)
+
+// Responder 处理请求并把应答发送到请求的 HeaderReplyTo，可以被多个协程并发访问
+type Responder struct {
+	mq      mq.MQ
+	handler Handler
+
+	locker sync.Mutex
+	// 键为应答topic
+	producers map[string]*replyProducer
+}
+
+func NewResponder(m mq.MQ, handler Handler) *Responder {
+	return &Responder{
+		mq:        m,
+		handler:   handler,
+		producers: map[string]*replyProducer{},
+	}
+}
+
+// Run 使用 processor.Processor 处理groupID消费组在topic上收到的请求，阻塞到ctx结束或者出错。
+// 需要自定义消费方式时，可以直接把 Handle 注册到 processor.Processor
+func (r *Responder) Run(ctx context.Context, topic, groupID string, opts ...option.Option[processor.Subscription]) error {
+	p := processor.NewProcessor(r.mq)
+	p.Handle(topic, groupID, r.Handle, opts...)
+	return p.Run(ctx)
+}
+
+// Handle 处理一个请求并发送应答。没有 HeaderReplyTo 的请求以及已经超过 HeaderDeadline 的请求会被丢弃，
+// 只有发送应答失败时才返回error
+func (r *Responder) Handle(ctx context.Context, req *mq.Message) error {
+	replyTo := req.Header.Get(HeaderReplyTo)
+	if replyTo == "" {
+		mq.Logger().Warn("丢弃请求", slog.String("topic", req.Topic), slog.Int64("partition", req.Partition),
+			slog.Int64("offset", req.Offset), slog.String("error", ErrNoReplyTo.Error()))
+		return nil
+	}
+	handleCtx := ctx
+	if d, ok := deadline(req); ok {
+		var cancel context.CancelFunc
+		handleCtx, cancel = context.WithDeadline(ctx, d)
+		defer cancel()
+		if handleCtx.Err() != nil {
+			// 请求方已经不再等待
+			return nil
+		}
+	}
+
+	reply, err := r.handler(handleCtx, req)
+	if reply == nil {
+		reply = &mq.Message{}
+	} else {
+		msg := *reply
+		msg.Header = reply.Header.Clone()
+		reply = &msg
+	}
+	reply.Header.Set(HeaderCorrelationID, req.Header.Get(HeaderCorrelationID))
+	if err != nil {
+		reply.Header.Set(HeaderError, err.Error())
+	}
+	p, err := r.acquire(replyTo)
+	if err != nil {
+		return err
+	}
+	defer r.release(p)
+	_, err = p.Produce(ctx, reply)
+	return err
+}
+
+// acquire 返回发送到topic的生产者，使用完之后需要调用 release
+func (r *Responder) acquire(topic string) (*replyProducer, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	rp, ok := r.producers[topic]
+	if !ok {
+		p, err := r.mq.Producer(topic)
+		if err != nil {
+			return nil, err
+		}
+		// 请求方各自的应答topic会不断变化，超过上限时淘汰其他的生产者
+		for t, old := range r.producers {
+			if len(r.producers) < maxReplyProducers {
+				break
+			}
+			delete(r.producers, t)
+			old.evicted = true
+			old.closeIfIdle()
+		}
+		rp = &replyProducer{Producer: p}
+		r.producers[topic] = rp
+	}
+	rp.refs++
+	return rp, nil
+}
+
+func (r *Responder) release(rp *replyProducer) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	rp.refs--
+	rp.closeIfIdle()
+}
+
+// replyProducer 记录正在使用生产者的协程数量，被淘汰的生产者在没有协程使用时关闭
+type replyProducer struct {
+	mq.Producer
+	refs    int
+	evicted bool
+}
+
+func (p *replyProducer) closeIfIdle() {
+	if !p.evicted || p.refs > 0 {
+		return
+	}
+	if err := p.Close(); err != nil {
+		mq.Logger().Warn("关闭发送应答的生产者失败", slog.String("error", err.Error()))
+	}
+}
+
+// Close 关闭发送应答的生产者
+func (r *Responder) Close() error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	errorList := make([]error, 0, len(r.producers))
+	for topic, p := range r.producers {
+		errorList = append(errorList, p.Close())
+		delete(r.producers, topic)
+	}
+	return multierr.Combine(errorList...)
+}
@@ -0,0 +1,70 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+)
+
+const (
+	// HeaderReplyTo 请求的应答需要发送到的topic
+	HeaderReplyTo = "reply-to"
+	// HeaderCorrelationID 请求的ID，应答中带有相同的ID
+	HeaderCorrelationID = "correlation-id"
+	// HeaderDeadline 请求方等待应答的截止时间，为毫秒时间戳，超过之后不再需要处理
+	HeaderDeadline = "x-mq-deadline"
+	// HeaderError 应答方处理请求失败时的错误信息
+	HeaderError = "x-mq-error"
+)
+
+var (
+	ErrRequesterClosed = errors.New("请求方已经关闭")
+	ErrNoReplyTo       = errors.New("请求没有指定应答的topic")
+)
+
+// RemoteError 是应答方处理请求时返回的error
+type RemoteError struct {
+	// 应答消息
+	Reply   *mq.Message
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "应答方处理请求失败: " + e.Message
+}
+
+// deadline 返回请求的截止时间，没有截止时间时返回false
+func deadline(msg *mq.Message) (time.Time, bool) {
+	ms, err := strconv.ParseInt(msg.Header.Get(HeaderDeadline), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms), true
+}
+
+// newID 生成随机的十六进制ID
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/ecodeclub/mq-api/processor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startResponder(t *testing.T, m mq.MQ, topic string) {
+	require.NoError(t, m.CreateTopic(context.Background(), topic, 2))
+	responder := NewResponder(m, func(_ context.Context, req *mq.Message) (*mq.Message, error) {
+		if string(req.Value) == "fail" {
+			return nil, errors.New("余额不足")
+		}
+		return &mq.Message{Value: bytes.ToUpper(req.Value), Header: mq.Header{{Key: "k", Value: []byte("v")}}}, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = responder.Run(ctx, topic, "responder", processor.WithConcurrency(4))
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		_ = responder.Close()
+	})
+}
+
+func TestRequester(t *testing.T) {
+	t.Parallel()
+	testmq := memory.NewMQ()
+	defer func() {
+		_ = testmq.Close()
+	}()
+	startResponder(t, testmq, "rpc")
+	requester, err := NewRequester(context.Background(), testmq)
+	require.NoError(t, err)
+
+	req := &mq.Message{Value: []byte("hello")}
+	reply, err := requester.Request(context.Background(), "rpc", req)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("HELLO"), reply.Value)
+	assert.Equal(t, "v", reply.Header.Get("k"))
+	assert.Len(t, reply.Header.Get(HeaderCorrelationID), 32)
+	// 不修改调用者的消息
+	assert.Empty(t, req.Header)
+
+	_, err = requester.Request(context.Background(), "rpc", &mq.Message{Value: []byte("fail")})
+	var remoteErr *RemoteError
+	require.ErrorAs(t, err, &remoteErr)
+	assert.Equal(t, "余额不足", remoteErr.Message)
+
+	// 并发的请求收到各自的应答
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := "req-" + strconv.Itoa(i)
+			reply, err := requester.Request(context.Background(), "rpc", &mq.Message{Value: []byte(value)})
+			if assert.NoError(t, err) {
+				assert.Equal(t, "REQ-"+strconv.Itoa(i), string(reply.Value))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.NoError(t, requester.Close())
+	require.NoError(t, requester.Close())
+	_, err = requester.Request(context.Background(), "rpc", req)
+	assert.ErrorIs(t, err, ErrRequesterClosed)
+	// 应答topic已经被删除，可以重新创建
+	assert.NoError(t, testmq.CreateTopic(context.Background(), requester.ReplyTopic(), 1))
+}
+
+func TestRequester_SharedReplyTopic(t *testing.T) {
+	t.Parallel()
+	testmq := memory.NewMQ()
+	defer func() {
+		_ = testmq.Close()
+	}()
+	startResponder(t, testmq, "rpc")
+	require.NoError(t, testmq.CreateTopic(context.Background(), "replies", 1))
+	var requesters []*Requester
+	for i := 0; i < 2; i++ {
+		requester, err := NewRequester(context.Background(), testmq, WithSharedReplyTopic("replies"))
+		require.NoError(t, err)
+		requesters = append(requesters, requester)
+	}
+	for i, requester := range requesters {
+		value := fmt.Sprintf("from-%d", i)
+		reply, err := requester.Request(context.Background(), "rpc", &mq.Message{Value: []byte(value)})
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("FROM-%d", i), string(reply.Value))
+		require.NoError(t, requester.Close())
+	}
+	// 共用的topic不会被删除，其中依旧保留着应答
+	c, err := testmq.Consumer("replies", "check")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reply, err := c.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("FROM-0"), reply.Value)
+}
+
+func TestRequester_Timeout(t *testing.T) {
+	t.Parallel()
+	testmq := memory.NewMQ()
+	defer func() {
+		_ = testmq.Close()
+	}()
+	require.NoError(t, testmq.CreateTopic(context.Background(), "nobody", 1))
+	c, err := testmq.Consumer("nobody", "g1")
+	require.NoError(t, err)
+	requester, err := NewRequester(context.Background(), testmq, WithTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	defer func() {
+		_ = requester.Close()
+	}()
+	_, err = requester.Request(context.Background(), "nobody", &mq.Message{Value: []byte("hello")})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// 请求带有应答的topic与截止时间，超时之后应答方不再处理
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := c.Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, requester.ReplyTopic(), req.Header.Get(HeaderReplyTo))
+	_, ok := deadline(req)
+	assert.True(t, ok)
+	called := false
+	responder := NewResponder(testmq, func(context.Context, *mq.Message) (*mq.Message, error) {
+		called = true
+		return nil, nil
+	})
+	require.NoError(t, responder.Handle(context.Background(), req))
+	require.NoError(t, responder.Handle(context.Background(), &mq.Message{Value: []byte("no reply-to")}))
+	assert.False(t, called)
+}
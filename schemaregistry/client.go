@@ -0,0 +1,198 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schemaregistry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ecodeclub/ekit/bean/option"
+)
+
+const contentType = "application/vnd.schemaregistry.v1+json"
+
+var _ Registry = &Client{}
+
+// Client 通过REST API访问Schema Registry，并缓存schema与ID的对应关系。
+// schema注册之后不会改变，因此缓存不会过期
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	username   string
+	password   string
+
+	mu sync.RWMutex
+	// 键为 cacheKey 的返回值
+	ids     map[string]int
+	schemas map[int]Schema
+}
+
+func NewClient(baseURL string, opts ...option.Option[Client]) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		ids:        map[string]int{},
+		schemas:    map[int]Schema{},
+	}
+	option.Apply(c, opts...)
+	return c
+}
+
+// WithHTTPClient 指定发送请求使用的 http.Client，默认为 http.DefaultClient
+func WithHTTPClient(client *http.Client) option.Option[Client] {
+	return func(c *Client) {
+		c.httpClient = client
+	}
+}
+
+// WithBasicAuth 指定访问Schema Registry使用的用户名与密码
+func WithBasicAuth(username, password string) option.Option[Client] {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+	}
+}
+
+type idResponse struct {
+	ID int `json:"id"`
+}
+
+func (c *Client) Register(ctx context.Context, subject string, schema Schema) (int, error) {
+	if id, ok := c.cachedID(subject, schema); ok {
+		return id, nil
+	}
+	var resp idResponse
+	err := c.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", schema, &resp)
+	if err != nil {
+		return 0, err
+	}
+	c.cache(subject, schema, resp.ID)
+	return resp.ID, nil
+}
+
+func (c *Client) Lookup(ctx context.Context, subject string, schema Schema) (int, error) {
+	if id, ok := c.cachedID(subject, schema); ok {
+		return id, nil
+	}
+	var resp idResponse
+	err := c.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject), schema, &resp)
+	if err != nil {
+		return 0, err
+	}
+	c.cache(subject, schema, resp.ID)
+	return resp.ID, nil
+}
+
+func (c *Client) GetByID(ctx context.Context, id int) (Schema, error) {
+	c.mu.RLock()
+	schema, ok := c.schemas[id]
+	c.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+	err := c.do(ctx, http.MethodGet, "/schemas/ids/"+strconv.Itoa(id), nil, &schema)
+	if err != nil {
+		return Schema{}, err
+	}
+	c.mu.Lock()
+	c.schemas[id] = schema
+	c.mu.Unlock()
+	return schema, nil
+}
+
+func (c *Client) CheckCompatibility(ctx context.Context, subject string, schema Schema) (bool, error) {
+	var resp struct {
+		IsCompatible bool `json:"is_compatible"`
+	}
+	err := c.do(ctx, http.MethodPost, "/compatibility/subjects/"+url.PathEscape(subject)+"/versions/latest", schema, &resp)
+	var regErr *Error
+	if errors.As(err, &regErr) && regErr.StatusCode == http.StatusNotFound {
+		// subject还没有任何版本
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return resp.IsCompatible, nil
+}
+
+func (c *Client) cachedID(subject string, schema Schema) (int, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	id, ok := c.ids[cacheKey(subject, schema)]
+	return id, ok
+}
+
+func (c *Client) cache(subject string, schema Schema, id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ids[cacheKey(subject, schema)] = id
+	c.schemas[id] = schema
+}
+
+func cacheKey(subject string, schema Schema) string {
+	return subject + "\x00" + schema.schemaType() + "\x00" + schema.Schema
+}
+
+// do 发送请求，body不为nil时编码为JSON，状态码不是2xx时返回 *Error
+func (c *Client) do(ctx context.Context, method, path string, body any, res any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", contentType)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		regErr := &Error{StatusCode: resp.StatusCode}
+		if json.Unmarshal(data, regErr) != nil || regErr.Message == "" {
+			regErr.Message = fmt.Sprintf("%s %s: %s", method, path, strings.TrimSpace(string(data)))
+		}
+		return regErr
+	}
+	return json.Unmarshal(data, res)
+}
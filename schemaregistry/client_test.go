@@ -0,0 +1,88 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schemaregistry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+	fake := NewFake(WithCompatibilityChecker(func(_ []Schema, candidate Schema) bool {
+		return candidate.Schema != `"int"`
+	}))
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, contentType, r.Header.Get("Accept"))
+		fake.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	client := NewClient(server.URL+"/", WithBasicAuth("user", "secret"))
+	ctx := context.Background()
+	schema := Schema{Schema: `"string"`}
+
+	ok, err := client.CheckCompatibility(ctx, "topic-value", schema)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	_, err = client.Lookup(ctx, "topic-value", schema)
+	assert.ErrorIs(t, err, ErrSchemaNotFound)
+
+	id, err := client.Register(ctx, "topic-value", schema)
+	require.NoError(t, err)
+	assert.Equal(t, 1, id)
+	// schema与ID都已经缓存
+	before := requests.Load()
+	id, err = client.Register(ctx, "topic-value", schema)
+	require.NoError(t, err)
+	assert.Equal(t, 1, id)
+	id, err = client.Lookup(ctx, "topic-value", schema)
+	require.NoError(t, err)
+	assert.Equal(t, 1, id)
+	got, err := client.GetByID(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, schema, got)
+	assert.Equal(t, before, requests.Load())
+
+	ok, err = client.CheckCompatibility(ctx, "topic-value", Schema{Schema: `"int"`})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, err = client.Register(ctx, "topic-value", Schema{Schema: `"int"`})
+	assert.ErrorIs(t, err, ErrIncompatibleSchema)
+	_, err = client.GetByID(ctx, 10)
+	assert.ErrorIs(t, err, ErrSchemaNotFound)
+
+	// 相同的schema在另一个subject下使用相同的ID
+	id, err = client.Register(ctx, "other-value", schema)
+	require.NoError(t, err)
+	assert.Equal(t, 1, id)
+
+	_, err = NewClient(server.URL).GetByID(ctx, 1)
+	var regErr *Error
+	require.ErrorAs(t, err, &regErr)
+	assert.Equal(t, http.StatusUnauthorized, regErr.StatusCode)
+}
@@ -0,0 +1,212 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schemaregistry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/ecodeclub/ekit/bean/option"
+)
+
+// CompatibilityChecker 判断candidate是否兼容subject已有的版本，previous按照版本从旧到新排列且不为空
+type CompatibilityChecker func(previous []Schema, candidate Schema) bool
+
+var (
+	_ Registry     = &Fake{}
+	_ http.Handler = &Fake{}
+)
+
+// Fake 是保存在内存中的Schema Registry，用于测试。
+// 它实现了 Registry，也可以作为 http.Handler 配合 httptest.Server 提供REST API，供 Client 访问
+type Fake struct {
+	mu      sync.Mutex
+	schemas []Schema
+	// 每个subject按照版本顺序保存的schema ID
+	subjects map[string][]int
+	checker  CompatibilityChecker
+
+	mux *http.ServeMux
+}
+
+// NewFake 创建 Fake，默认所有schema都互相兼容
+func NewFake(opts ...option.Option[Fake]) *Fake {
+	f := &Fake{
+		subjects: map[string][]int{},
+		checker: func(_ []Schema, _ Schema) bool {
+			return true
+		},
+	}
+	option.Apply(f, opts...)
+	f.mux = http.NewServeMux()
+	f.mux.HandleFunc("POST /subjects/{subject}/versions", f.handleRegister)
+	f.mux.HandleFunc("POST /subjects/{subject}", f.handleLookup)
+	f.mux.HandleFunc("GET /schemas/ids/{id}", f.handleGetByID)
+	f.mux.HandleFunc("POST /compatibility/subjects/{subject}/versions/latest", f.handleCheckCompatibility)
+	return f
+}
+
+// WithCompatibilityChecker 指定判断兼容性的方式，注册不兼容的schema会返回 ErrIncompatibleSchema
+func WithCompatibilityChecker(checker CompatibilityChecker) option.Option[Fake] {
+	return func(f *Fake) {
+		f.checker = checker
+	}
+}
+
+func (f *Fake) Register(_ context.Context, subject string, schema Schema) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, ok := f.lookup(subject, schema); ok {
+		return id, nil
+	}
+	if !f.compatible(subject, schema) {
+		return 0, &Error{StatusCode: http.StatusConflict, Code: http.StatusConflict,
+			Message: fmt.Sprintf("schema与subject %s 的已有版本不兼容", subject)}
+	}
+	// 与Schema Registry一样，相同的schema在不同subject下使用相同的ID
+	id := f.idOf(schema)
+	if id == 0 {
+		f.schemas = append(f.schemas, schema)
+		id = len(f.schemas)
+	}
+	f.subjects[subject] = append(f.subjects[subject], id)
+	return id, nil
+}
+
+func (f *Fake) Lookup(_ context.Context, subject string, schema Schema) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, ok := f.lookup(subject, schema); ok {
+		return id, nil
+	}
+	return 0, &Error{StatusCode: http.StatusNotFound, Code: 40403,
+		Message: fmt.Sprintf("schema没有在subject %s 下注册", subject)}
+}
+
+func (f *Fake) GetByID(_ context.Context, id int) (Schema, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id <= 0 || id > len(f.schemas) {
+		return Schema{}, &Error{StatusCode: http.StatusNotFound, Code: 40403,
+			Message: fmt.Sprintf("schema %d 不存在", id)}
+	}
+	return f.schemas[id-1], nil
+}
+
+func (f *Fake) CheckCompatibility(_ context.Context, subject string, schema Schema) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.compatible(subject, schema), nil
+}
+
+func (f *Fake) lookup(subject string, schema Schema) (int, bool) {
+	for _, id := range f.subjects[subject] {
+		if sameSchema(f.schemas[id-1], schema) {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func (f *Fake) idOf(schema Schema) int {
+	for i, s := range f.schemas {
+		if sameSchema(s, schema) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (f *Fake) compatible(subject string, schema Schema) bool {
+	ids := f.subjects[subject]
+	if len(ids) == 0 {
+		return true
+	}
+	previous := make([]Schema, 0, len(ids))
+	for _, id := range ids {
+		previous = append(previous, f.schemas[id-1])
+	}
+	return f.checker(previous, schema)
+}
+
+func sameSchema(a, b Schema) bool {
+	return a.Schema == b.Schema && a.schemaType() == b.schemaType()
+}
+
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.ServeHTTP(w, r)
+}
+
+func (f *Fake) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var schema Schema
+	if !decodeRequest(w, r, &schema) {
+		return
+	}
+	id, err := f.Register(r.Context(), r.PathValue("subject"), schema)
+	writeResponse(w, idResponse{ID: id}, err)
+}
+
+func (f *Fake) handleLookup(w http.ResponseWriter, r *http.Request) {
+	var schema Schema
+	if !decodeRequest(w, r, &schema) {
+		return
+	}
+	id, err := f.Lookup(r.Context(), r.PathValue("subject"), schema)
+	writeResponse(w, idResponse{ID: id}, err)
+}
+
+func (f *Fake) handleGetByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeResponse(w, nil, &Error{StatusCode: http.StatusNotFound, Code: 40403, Message: "schema ID非法"})
+		return
+	}
+	schema, err := f.GetByID(r.Context(), id)
+	writeResponse(w, schema, err)
+}
+
+func (f *Fake) handleCheckCompatibility(w http.ResponseWriter, r *http.Request) {
+	var schema Schema
+	if !decodeRequest(w, r, &schema) {
+		return
+	}
+	ok, err := f.CheckCompatibility(r.Context(), r.PathValue("subject"), schema)
+	writeResponse(w, map[string]bool{"is_compatible": ok}, err)
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeResponse(w, nil, &Error{StatusCode: http.StatusUnprocessableEntity, Code: 42201, Message: err.Error()})
+		return false
+	}
+	return true
+}
+
+func writeResponse(w http.ResponseWriter, v any, err error) {
+	w.Header().Set("Content-Type", contentType)
+	if err != nil {
+		regErr, ok := err.(*Error)
+		if !ok {
+			regErr = &Error{StatusCode: http.StatusInternalServerError, Code: 50001, Message: err.Error()}
+		}
+		w.WriteHeader(regErr.StatusCode)
+		v = regErr
+	}
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schemaregistry 对接Confluent Schema Registry，
+// 使用magic byte与schema ID前缀(Confluent wire format)编解码消息
+package schemaregistry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	SchemaTypeAvro     = "AVRO"
+	SchemaTypeProtobuf = "PROTOBUF"
+	SchemaTypeJSON     = "JSON"
+)
+
+var (
+	ErrSchemaNotFound     = errors.New("schema不存在")
+	ErrIncompatibleSchema = errors.New("schema与subject已有的版本不兼容")
+	ErrInvalidWireFormat  = errors.New("消息不是合法的Confluent wire format")
+)
+
+// Schema 是注册到Schema Registry的schema
+type Schema struct {
+	// schema的定义，例如Avro的JSON定义或者.proto文件的内容
+	Schema string `json:"schema"`
+	// SchemaTypeAvro、SchemaTypeProtobuf 或者 SchemaTypeJSON，为空时表示Avro
+	Type string `json:"schemaType,omitempty"`
+}
+
+func (s Schema) schemaType() string {
+	if s.Type == "" {
+		return SchemaTypeAvro
+	}
+	return s.Type
+}
+
+// Registry 是Schema Registry的抽象，需要可以被并发调用
+type Registry interface {
+	// Register 在subject下注册schema并返回它的ID，schema已经注册过时返回已有的ID
+	Register(ctx context.Context, subject string, schema Schema) (int, error)
+	// Lookup 查询schema在subject下的ID，没有注册过时返回 ErrSchemaNotFound
+	Lookup(ctx context.Context, subject string, schema Schema) (int, error)
+	// GetByID 根据ID获取schema，不存在时返回 ErrSchemaNotFound
+	GetByID(ctx context.Context, id int) (Schema, error)
+	// CheckCompatibility 检查schema是否兼容subject的最新版本，subject不存在时视为兼容
+	CheckCompatibility(ctx context.Context, subject string, schema Schema) (bool, error)
+}
+
+// Error 是Schema Registry返回的错误
+type Error struct {
+	StatusCode int    `json:"-"`
+	Code       int    `json:"error_code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("schema registry返回错误，状态码: %d, 错误码: %d: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is 让subject、版本或者schema不存在的错误匹配 ErrSchemaNotFound，不兼容的错误匹配 ErrIncompatibleSchema
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrSchemaNotFound:
+		return e.StatusCode == 404
+	case ErrIncompatibleSchema:
+		return e.StatusCode == 409
+	default:
+		return false
+	}
+}
+
+// ValueSubject 返回topic中消息Value使用的subject，对应Confluent的TopicNameStrategy
+func ValueSubject(topic string) string {
+	return topic + "-value"
+}
+
+// KeySubject 返回topic中消息Key使用的subject，对应Confluent的TopicNameStrategy
+func KeySubject(topic string) string {
+	return topic + "-key"
+}
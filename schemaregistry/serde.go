@@ -0,0 +1,193 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schemaregistry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+)
+
+// SerializerConfig 是 Serializer 的可选配置
+type SerializerConfig struct {
+	// 是否在第一次序列化时注册schema，为false时只查询已经注册的ID
+	autoRegister bool
+	// Protobuf消息在.proto文件中的位置，默认为第一个消息
+	messageIndexes []int
+}
+
+// WithAutoRegister 指定是否自动注册schema，默认开启。关闭之后schema需要预先注册，否则序列化返回 ErrSchemaNotFound
+func WithAutoRegister(autoRegister bool) option.Option[SerializerConfig] {
+	return func(c *SerializerConfig) {
+		c.autoRegister = autoRegister
+	}
+}
+
+// WithMessageIndexes 指定Protobuf消息在.proto文件中的位置，例如[1, 0]表示第二个消息中的第一个嵌套消息
+func WithMessageIndexes(indexes ...int) option.Option[SerializerConfig] {
+	return func(c *SerializerConfig) {
+		c.messageIndexes = indexes
+	}
+}
+
+// Serializer 使用codec编码T，并加上schema ID前缀。
+// 第一次序列化时获取schema ID，自动注册之前会检查schema是否兼容subject已有的版本
+type Serializer[T any] struct {
+	registry Registry
+	subject  string
+	schema   Schema
+	codec    mq.Codec[T]
+	cfg      SerializerConfig
+
+	mu sync.Mutex
+	// 为0表示还没有获取到schema ID
+	id int
+}
+
+func NewSerializer[T any](registry Registry, subject string, schema Schema, codec mq.Codec[T],
+	opts ...option.Option[SerializerConfig]) *Serializer[T] {
+	cfg := SerializerConfig{autoRegister: true}
+	option.Apply(&cfg, opts...)
+	return &Serializer[T]{registry: registry, subject: subject, schema: schema, codec: codec, cfg: cfg}
+}
+
+func (s *Serializer[T]) Serialize(ctx context.Context, v T) ([]byte, error) {
+	id, err := s.schemaID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := s.codec.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := AppendWireFormat(make([]byte, 0, headerSize+1+len(payload)), id)
+	if s.schema.schemaType() == SchemaTypeProtobuf {
+		buf = AppendMessageIndexes(buf, s.cfg.messageIndexes)
+	}
+	return append(buf, payload...), nil
+}
+
+// schemaID 获取失败时不缓存，下一次序列化会重新获取
+func (s *Serializer[T]) schemaID(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.id != 0 {
+		return s.id, nil
+	}
+	var (
+		id  int
+		err error
+	)
+	if s.cfg.autoRegister {
+		id, err = s.register(ctx)
+	} else {
+		id, err = s.registry.Lookup(ctx, s.subject, s.schema)
+	}
+	if err != nil {
+		return 0, err
+	}
+	s.id = id
+	return id, nil
+}
+
+func (s *Serializer[T]) register(ctx context.Context) (int, error) {
+	// 已经注册过的schema不需要检查兼容性
+	if id, err := s.registry.Lookup(ctx, s.subject, s.schema); err == nil {
+		return id, nil
+	}
+	ok, err := s.registry.CheckCompatibility(ctx, s.subject, s.schema)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrIncompatibleSchema, s.subject)
+	}
+	return s.registry.Register(ctx, s.subject, s.schema)
+}
+
+// SchemaDecoder 由解码时需要写入者schema的codec实现，例如Avro需要按照写入者schema读取数据，
+// 再转换为读取者schema
+type SchemaDecoder[T any] interface {
+	// DecodeWithSchema 使用写入者schema writer 解码去掉前缀之后的数据
+	DecodeWithSchema(writer Schema, data []byte) (T, error)
+}
+
+// Deserializer 根据消息中的schema ID获取写入者schema，去掉前缀之后解码。
+// codec实现了 SchemaDecoder 时调用 SchemaDecoder.DecodeWithSchema 并传入写入者schema，
+// 否则调用 mq.Codec.Decode，此时codec需要能够直接解码兼容的schema写入的数据，例如JSON与Protobuf
+type Deserializer[T any] struct {
+	registry Registry
+	codec    mq.Codec[T]
+}
+
+func NewDeserializer[T any](registry Registry, codec mq.Codec[T]) *Deserializer[T] {
+	return &Deserializer[T]{registry: registry, codec: codec}
+}
+
+func (d *Deserializer[T]) Deserialize(ctx context.Context, data []byte) (T, error) {
+	var zero T
+	id, payload, err := ParseWireFormat(data)
+	if err != nil {
+		return zero, err
+	}
+	schema, err := d.registry.GetByID(ctx, id)
+	if err != nil {
+		return zero, err
+	}
+	if schema.schemaType() == SchemaTypeProtobuf {
+		_, payload, err = ParseMessageIndexes(payload)
+		if err != nil {
+			return zero, err
+		}
+	}
+	if sd, ok := d.codec.(SchemaDecoder[T]); ok {
+		return sd.DecodeWithSchema(schema, payload)
+	}
+	return d.codec.Decode(payload)
+}
+
+var _ mq.Codec[any] = &Codec[any]{}
+
+// Codec 把 Serializer 与 Deserializer 组合为 mq.Codec，可以直接用于 mq.TypedProducer 与 mq.TypedConsumer。
+// mq.Codec 不接收ctx，访问Schema Registry时使用 context.Background
+type Codec[T any] struct {
+	serializer   *Serializer[T]
+	deserializer *Deserializer[T]
+}
+
+func NewCodec[T any](registry Registry, subject string, schema Schema, codec mq.Codec[T],
+	opts ...option.Option[SerializerConfig]) *Codec[T] {
+	return &Codec[T]{
+		serializer:   NewSerializer[T](registry, subject, schema, codec, opts...),
+		deserializer: NewDeserializer[T](registry, codec),
+	}
+}
+
+// ContentType 返回 application/vnd.schemaregistry.v1+avro 这样的格式，后缀为schema的类型
+func (c *Codec[T]) ContentType() string {
+	return "application/vnd.schemaregistry.v1+" + strings.ToLower(c.serializer.schema.schemaType())
+}
+
+func (c *Codec[T]) Encode(v T) ([]byte, error) {
+	return c.serializer.Serialize(context.Background(), v)
+}
+
+func (c *Codec[T]) Decode(data []byte) (T, error) {
+	return c.deserializer.Deserialize(context.Background(), data)
+}
@@ -0,0 +1,178 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schemaregistry
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type user struct {
+	Name string `json:"name"`
+}
+
+type userCodec struct{}
+
+func (userCodec) ContentType() string {
+	return "application/json"
+}
+
+func (userCodec) Encode(v user) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (userCodec) Decode(data []byte) (user, error) {
+	var v user
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+const userSchema = `{"type":"record","name":"User","fields":[{"name":"name","type":"string"}]}`
+
+func TestSerializer(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	registry := NewFake(WithCompatibilityChecker(func(_ []Schema, candidate Schema) bool {
+		return candidate.Schema != "incompatible"
+	}))
+	_, err := registry.Register(ctx, "other", Schema{Schema: "other"})
+	require.NoError(t, err)
+
+	s := NewSerializer[user](registry, ValueSubject("users"), Schema{Schema: userSchema}, userCodec{})
+	data, err := s.Serialize(ctx, user{Name: "Tom"})
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0, 0, 0, 0, 2}, `{"name":"Tom"}`...), data)
+
+	d := NewDeserializer[user](registry, userCodec{})
+	v, err := d.Deserialize(ctx, data)
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Tom"}, v)
+	_, err = d.Deserialize(ctx, []byte(`{"name":"Tom"}`))
+	assert.ErrorIs(t, err, ErrInvalidWireFormat)
+	_, err = d.Deserialize(ctx, append([]byte{0, 0, 0, 0, 9}, `{}`...))
+	assert.ErrorIs(t, err, ErrSchemaNotFound)
+
+	s = NewSerializer[user](registry, ValueSubject("users"), Schema{Schema: "incompatible"}, userCodec{})
+	_, err = s.Serialize(ctx, user{})
+	assert.ErrorIs(t, err, ErrIncompatibleSchema)
+
+	s = NewSerializer[user](registry, ValueSubject("orders"), Schema{Schema: userSchema}, userCodec{},
+		WithAutoRegister(false))
+	_, err = s.Serialize(ctx, user{})
+	assert.ErrorIs(t, err, ErrSchemaNotFound)
+	_, err = registry.Register(ctx, ValueSubject("orders"), Schema{Schema: userSchema})
+	require.NoError(t, err)
+	data, err = s.Serialize(ctx, user{})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 2}, data[:5])
+}
+
+// writerUserCodec 记录解码时收到的写入者schema
+type writerUserCodec struct {
+	userCodec
+	writer *Schema
+}
+
+func (c writerUserCodec) DecodeWithSchema(writer Schema, data []byte) (user, error) {
+	*c.writer = writer
+	return c.Decode(data)
+}
+
+func TestDeserializer_WriterSchema(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	registry := NewFake()
+	writer := Schema{Schema: userSchema}
+	data, err := NewSerializer[user](registry, ValueSubject("writer"), writer, userCodec{}).Serialize(ctx, user{Name: "Tom"})
+	require.NoError(t, err)
+
+	var got Schema
+	v, err := NewDeserializer[user](registry, writerUserCodec{writer: &got}).Deserialize(ctx, data)
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Tom"}, v)
+	assert.Equal(t, writer, got)
+}
+
+func TestSerde_Protobuf(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	registry := NewFake()
+	schema := Schema{
+		Schema: `syntax = "proto3"; message User { string name = 1; }`,
+		Type:   SchemaTypeProtobuf,
+	}
+	testCases := []struct {
+		name       string
+		indexes    []int
+		wantPrefix []byte
+	}{
+		{
+			name:       "第一个消息",
+			wantPrefix: []byte{0, 0, 0, 0, 1, 0},
+		},
+		{
+			name:       "嵌套消息",
+			indexes:    []int{1, 0},
+			wantPrefix: []byte{0, 0, 0, 0, 1, 4, 2, 0},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			s := NewSerializer[user](registry, ValueSubject("proto"), schema, userCodec{}, WithMessageIndexes(tc.indexes...))
+			data, err := s.Serialize(ctx, user{Name: "Tom"})
+			require.NoError(t, err)
+			assert.Equal(t, append(tc.wantPrefix, `{"name":"Tom"}`...), data)
+
+			// 解码之前去掉message indexes
+			v, err := NewDeserializer[user](registry, userCodec{}).Deserialize(ctx, data)
+			require.NoError(t, err)
+			assert.Equal(t, user{Name: "Tom"}, v)
+		})
+	}
+}
+
+func TestCodec(t *testing.T) {
+	t.Parallel()
+	registry := NewFake()
+	c := NewCodec[user](registry, ValueSubject("registry"), Schema{Schema: userSchema}, userCodec{})
+	assert.Equal(t, "application/vnd.schemaregistry.v1+avro", c.ContentType())
+
+	testmq := memory.NewMQ()
+	defer func() {
+		_ = testmq.Close()
+	}()
+	p, err := testmq.Producer("registry")
+	require.NoError(t, err)
+	consumer, err := testmq.Consumer("registry", "g1")
+	require.NoError(t, err)
+	_, err = mq.NewTypedProducer[user](p, c).Produce(context.Background(), &mq.TypedMessage[user]{Value: user{Name: "Tom"}})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	msg, err := mq.NewTypedConsumer[user](consumer, c).Consume(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Tom"}, msg.Value)
+	assert.Equal(t, []byte{0, 0, 0, 0, 1}, msg.Raw.Value[:5])
+}
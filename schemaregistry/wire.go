@@ -0,0 +1,79 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schemaregistry
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	magicByte = 0
+	// magic byte加上4字节的schema ID
+	headerSize = 5
+)
+
+// AppendWireFormat 在buf后追加magic byte与大端序的schema ID，之后应当追加消息内容
+func AppendWireFormat(buf []byte, id int) []byte {
+	buf = append(buf, magicByte)
+	return binary.BigEndian.AppendUint32(buf, uint32(id))
+}
+
+// ParseWireFormat 解析magic byte与schema ID，返回schema ID与剩余的内容
+func ParseWireFormat(data []byte) (int, []byte, error) {
+	if len(data) < headerSize || data[0] != magicByte {
+		return 0, nil, ErrInvalidWireFormat
+	}
+	return int(binary.BigEndian.Uint32(data[1:headerSize])), data[headerSize:], nil
+}
+
+// AppendMessageIndexes 追加Protobuf消息在.proto文件中的位置，使用zigzag编码的变长整数。
+// 第一个消息[0]按照约定编码为单个0
+func AppendMessageIndexes(buf []byte, indexes []int) []byte {
+	if len(indexes) == 0 || (len(indexes) == 1 && indexes[0] == 0) {
+		return binary.AppendVarint(buf, 0)
+	}
+	buf = binary.AppendVarint(buf, int64(len(indexes)))
+	for _, idx := range indexes {
+		buf = binary.AppendVarint(buf, int64(idx))
+	}
+	return buf
+}
+
+// ParseMessageIndexes 解析 AppendMessageIndexes 写入的内容，返回消息的位置与剩余的内容
+func ParseMessageIndexes(data []byte) ([]int, []byte, error) {
+	count, n := binary.Varint(data)
+	if n <= 0 || count < 0 {
+		return nil, nil, fmt.Errorf("%w: 消息位置非法", ErrInvalidWireFormat)
+	}
+	data = data[n:]
+	if count == 0 {
+		return []int{0}, data, nil
+	}
+	// 每个位置至少占用一个字节
+	if count > int64(len(data)) {
+		return nil, nil, fmt.Errorf("%w: 消息位置非法", ErrInvalidWireFormat)
+	}
+	indexes := make([]int, 0, count)
+	for i := int64(0); i < count; i++ {
+		idx, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, nil, fmt.Errorf("%w: 消息位置非法", ErrInvalidWireFormat)
+		}
+		indexes = append(indexes, int(idx))
+		data = data[n:]
+	}
+	return indexes, data, nil
+}
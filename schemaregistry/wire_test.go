@@ -0,0 +1,68 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schemaregistry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWireFormat(t *testing.T) {
+	t.Parallel()
+	data := append(AppendWireFormat(nil, 258), "payload"...)
+	assert.Equal(t, []byte{0, 0, 0, 1, 2}, data[:5])
+	id, payload, err := ParseWireFormat(data)
+	require.NoError(t, err)
+	assert.Equal(t, 258, id)
+	assert.Equal(t, []byte("payload"), payload)
+
+	_, _, err = ParseWireFormat([]byte{0, 0, 1})
+	assert.ErrorIs(t, err, ErrInvalidWireFormat)
+	_, _, err = ParseWireFormat([]byte{1, 0, 0, 0, 1})
+	assert.ErrorIs(t, err, ErrInvalidWireFormat)
+}
+
+func TestMessageIndexes(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name    string
+		indexes []int
+		encoded []byte
+		want    []int
+	}{
+		{name: "默认", indexes: nil, encoded: []byte{0}, want: []int{0}},
+		{name: "第一个消息", indexes: []int{0}, encoded: []byte{0}, want: []int{0}},
+		{name: "嵌套消息", indexes: []int{1, 0}, encoded: []byte{4, 2, 0}, want: []int{1, 0}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			data := append(AppendMessageIndexes(nil, tc.indexes), 'x')
+			assert.Equal(t, tc.encoded, data[:len(data)-1])
+			indexes, rest, err := ParseMessageIndexes(data)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, indexes)
+			assert.Equal(t, []byte("x"), rest)
+		})
+	}
+
+	_, _, err := ParseMessageIndexes(nil)
+	assert.ErrorIs(t, err, ErrInvalidWireFormat)
+	_, _, err = ParseMessageIndexes([]byte{100, 2})
+	assert.ErrorIs(t, err, ErrInvalidWireFormat)
+}
@@ -0,0 +1,171 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracing 为 mq.Producer 与 mq.Consumer 提供OpenTelemetry链路追踪，
+// 通过W3C traceparent/tracestate header在服务之间传递链路上下文
+package tracing
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/ecodeclub/mq-api/tracing"
+
+var (
+	_ mq.ProducerInterceptor = &Interceptor{}
+	_ mq.ConsumerInterceptor = &Interceptor{}
+)
+
+// Interceptor 同时实现了 mq.ProducerInterceptor 与 mq.ConsumerInterceptor。
+// 发送消息时创建producer span并把链路上下文注入 mq.Header，
+// 获取消息时从header中提取链路上下文，创建链接到producer span的consumer span
+type Interceptor struct {
+	tracerProvider trace.TracerProvider
+	propagator     propagation.TextMapPropagator
+	// 对应 messaging.system 属性
+	system string
+
+	tracer trace.Tracer
+}
+
+// NewInterceptor 创建 Interceptor，默认使用全局的 TracerProvider 与W3C Trace Context格式
+func NewInterceptor(opts ...option.Option[Interceptor]) *Interceptor {
+	i := &Interceptor{
+		tracerProvider: otel.GetTracerProvider(),
+		propagator:     propagation.TraceContext{},
+		system:         semconv.MessagingSystemKafka.Value.AsString(),
+	}
+	option.Apply(i, opts...)
+	i.tracer = i.tracerProvider.Tracer(instrumentationName)
+	return i
+}
+
+// WithTracerProvider 指定创建span使用的 TracerProvider
+func WithTracerProvider(tp trace.TracerProvider) option.Option[Interceptor] {
+	return func(i *Interceptor) {
+		i.tracerProvider = tp
+	}
+}
+
+// WithPropagator 指定在header中注入与提取链路上下文的格式，默认为W3C Trace Context
+func WithPropagator(p propagation.TextMapPropagator) option.Option[Interceptor] {
+	return func(i *Interceptor) {
+		i.propagator = p
+	}
+}
+
+// WithSystem 指定span的 messaging.system 属性，默认为kafka
+func WithSystem(system string) option.Option[Interceptor] {
+	return func(i *Interceptor) {
+		i.system = system
+	}
+}
+
+func (i *Interceptor) InterceptProduce(info mq.ProducerInfo, next mq.ProduceFunc) mq.ProduceFunc {
+	return func(ctx context.Context, m *mq.Message) (*mq.ProducerResult, error) {
+		attrs := []attribute.KeyValue{
+			semconv.MessagingSystemKey.String(i.system),
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingOperationName("publish"),
+			semconv.MessagingDestinationName(info.Topic),
+			semconv.MessagingMessageBodySize(len(m.Value)),
+		}
+		if len(m.Key) > 0 {
+			attrs = append(attrs, semconv.MessagingKafkaMessageKey(string(m.Key)))
+		}
+		ctx, span := i.tracer.Start(ctx, info.Topic+" publish",
+			trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(attrs...))
+		defer span.End()
+
+		// 注入到副本中，调用者重用或者重试这条消息时不会带上过期的链路上下文
+		msg := *m
+		msg.Header = m.Header.Clone()
+		i.propagator.Inject(ctx, HeaderCarrier{Header: &msg.Header})
+		res, err := next(ctx, &msg)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return res, err
+	}
+}
+
+func (i *Interceptor) InterceptConsume(info mq.ConsumerInfo, next mq.ConsumeFunc) mq.ConsumeFunc {
+	return func(ctx context.Context) (*mq.Message, error) {
+		m, err := next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		opts := []trace.SpanStartOption{
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				semconv.MessagingSystemKey.String(i.system),
+				semconv.MessagingOperationTypeReceive,
+				semconv.MessagingOperationName("receive"),
+				semconv.MessagingDestinationName(info.Topic),
+				semconv.MessagingDestinationPartitionID(strconv.FormatInt(m.Partition, 10)),
+				semconv.MessagingKafkaMessageOffset(int(m.Offset)),
+				semconv.MessagingKafkaConsumerGroup(info.GroupID),
+				semconv.MessagingMessageBodySize(len(m.Value)),
+			),
+		}
+		// 生产者与消费者属于不同的请求，使用链接而不是父子关系关联两个span
+		if sc := trace.SpanContextFromContext(i.Extract(ctx, m)); sc.IsValid() {
+			opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+		}
+		_, span := i.tracer.Start(ctx, info.Topic+" receive", opts...)
+		span.End()
+		return m, nil
+	}
+}
+
+// Extract 返回带有msg中链路上下文的ctx，处理消息时可以用它创建子span，把处理过程接入生产者所在的链路
+func (i *Interceptor) Extract(ctx context.Context, msg *mq.Message) context.Context {
+	return i.propagator.Extract(ctx, HeaderCarrier{Header: &msg.Header})
+}
+
+// HeaderCarrier 让 mq.Header 实现 propagation.TextMapCarrier
+type HeaderCarrier struct {
+	Header *mq.Header
+}
+
+var _ propagation.TextMapCarrier = HeaderCarrier{}
+
+func (c HeaderCarrier) Get(key string) string {
+	return c.Header.Get(key)
+}
+
+// Set 覆盖key已有的值，避免转发消费到的消息时header中出现多个traceparent
+func (c HeaderCarrier) Set(key, value string) {
+	c.Header.Set(key, value)
+}
+
+func (c HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c.Header))
+	for _, f := range *c.Header {
+		keys = append(keys, f.Key)
+	}
+	return keys
+}
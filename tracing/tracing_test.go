@@ -0,0 +1,107 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ecodeclub/mq-api"
+	"github.com/ecodeclub/mq-api/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestInterceptor(t *testing.T) {
+	t.Parallel()
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	interceptor := NewInterceptor(WithTracerProvider(tp))
+	testmq := memory.NewMQ(
+		memory.WithProducerInterceptors(interceptor),
+		memory.WithConsumerInterceptors(interceptor))
+	defer func() {
+		_ = testmq.Close()
+	}()
+	p, err := testmq.Producer("tracing")
+	require.NoError(t, err)
+	c, err := testmq.Consumer("tracing", "g1")
+	require.NoError(t, err)
+
+	state, err := trace.ParseTraceState("vendor=value")
+	require.NoError(t, err)
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	ctx = trace.ContextWithSpanContext(ctx, parent.SpanContext().WithTraceState(state))
+	produced := &mq.Message{Key: []byte("k"), Value: []byte("msg")}
+	_, err = p.Produce(ctx, produced)
+	require.NoError(t, err)
+	parent.End()
+	// 调用者的消息没有被修改
+	assert.Empty(t, produced.Header)
+
+	consumeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	msg, err := c.Consume(consumeCtx)
+	require.NoError(t, err)
+	assert.Equal(t, "vendor=value", msg.Header.Get("tracestate"))
+	assert.NotEmpty(t, msg.Header.Get("traceparent"))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 3)
+	producerSpan, consumerSpan := spans[0], spans[2]
+
+	assert.Equal(t, "tracing publish", producerSpan.Name)
+	assert.Equal(t, trace.SpanKindProducer, producerSpan.SpanKind)
+	assert.Equal(t, parent.SpanContext().SpanID(), producerSpan.Parent.SpanID())
+	assert.Contains(t, producerSpan.Attributes, semconv.MessagingDestinationName("tracing"))
+	assert.Contains(t, producerSpan.Attributes, semconv.MessagingKafkaMessageKey("k"))
+
+	assert.Equal(t, "tracing receive", consumerSpan.Name)
+	assert.Equal(t, trace.SpanKindConsumer, consumerSpan.SpanKind)
+	assert.False(t, consumerSpan.Parent.IsValid())
+	require.Len(t, consumerSpan.Links, 1)
+	assert.Equal(t, producerSpan.SpanContext.TraceID(), consumerSpan.Links[0].SpanContext.TraceID())
+	assert.Equal(t, producerSpan.SpanContext.SpanID(), consumerSpan.Links[0].SpanContext.SpanID())
+	for _, attr := range []attribute.KeyValue{
+		semconv.MessagingDestinationName("tracing"),
+		semconv.MessagingDestinationPartitionID("0"),
+		semconv.MessagingKafkaMessageOffset(0),
+		semconv.MessagingKafkaConsumerGroup("g1"),
+	} {
+		assert.Contains(t, consumerSpan.Attributes, attr)
+	}
+
+	// 处理消息时可以接入生产者所在的链路
+	sc := trace.SpanContextFromContext(interceptor.Extract(context.Background(), msg))
+	assert.Equal(t, producerSpan.SpanContext.TraceID(), sc.TraceID())
+	assert.True(t, sc.IsRemote())
+}
+
+func TestHeaderCarrier(t *testing.T) {
+	t.Parallel()
+	h := mq.Header{{Key: "traceparent", Value: []byte("old")}, {Key: "a", Value: []byte("1")}}
+	c := HeaderCarrier{Header: &h}
+	c.Set("traceparent", "new")
+	c.Set("tracestate", "k=v")
+	assert.Equal(t, "new", c.Get("traceparent"))
+	assert.Equal(t, []string{"new"}, h.Values("traceparent"))
+	assert.Equal(t, []string{"traceparent", "a", "tracestate"}, c.Keys())
+}
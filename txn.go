@@ -0,0 +1,60 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrNoTxn          = errors.New("没有进行中的事务")
+	ErrTxnInProgress  = errors.New("已经有进行中的事务")
+	ErrProducerFenced = errors.New("生产者已经被使用相同transactionalID的新生产者取代")
+)
+
+// IsolationLevel 表示消费者能够看到的事务消息，对应kafka的isolation.level
+type IsolationLevel int
+
+const (
+	// ReadUncommitted 能够看到所有消息，包括还没有提交以及已经回滚的事务中的消息
+	ReadUncommitted IsolationLevel = iota
+	// ReadCommitted 只能看到已经提交的事务中的消息，进行中的事务之后的消息在事务结束之前都不会投递
+	ReadCommitted
+)
+
+// TxnProducer 是事务生产者，同一个事务中发送的消息与提交的消费进度要么同时生效，要么同时回滚，
+// 用于实现consume-transform-produce的精确一次语义。同一时刻只能有一个进行中的事务，可以被多个协程并发访问。
+// Produce、ProduceWithPartition 与 ProduceAt 只能在事务中调用，否则返回 ErrNoTxn
+type TxnProducer interface {
+	Producer
+	// BeginTxn 开始一个事务，已经有进行中的事务时返回 ErrTxnInProgress
+	BeginTxn(ctx context.Context) error
+	// SendOffsetsToTxn 把groupID消费组对msgs的消费进度加入事务，事务提交时一起提交，
+	// 语义与 Consumer.Commit 相同。消费者需要使用 WithManualCommit 创建
+	SendOffsetsToTxn(ctx context.Context, groupID string, msgs ...*Message) error
+	// CommitTxn 提交事务，事务中的消息对 ReadCommitted 的消费者可见，消费进度生效
+	CommitTxn(ctx context.Context) error
+	// AbortTxn 回滚事务，事务中的消息不会投递给 ReadCommitted 的消费者，消费进度不会提交
+	AbortTxn(ctx context.Context) error
+}
+
+// TxnMQ 是支持事务的 MQ
+type TxnMQ interface {
+	MQ
+	// TxnProducer 创建某个topic的事务生产者。transactionalID用于在重启之后识别同一个生产者，
+	// 创建时会回滚使用相同transactionalID的旧生产者进行中的事务，旧生产者之后的调用都会返回 ErrProducerFenced
+	TxnProducer(topic string, transactionalID string) (TxnProducer, error)
+}
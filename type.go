@@ -14,7 +14,13 @@
 
 package mq
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"github.com/ecodeclub/ekit/bean/option"
+	"github.com/ecodeclub/mq-api/clock"
+)
 
 // MQ 是消息队列的抽象用于创建Topic、生产者及消费者,MQ可以被多个协程并发访问
 type MQ interface {
@@ -24,22 +30,23 @@ type MQ interface {
 	DeleteTopics(ctx context.Context, topics ...string) error
 	// Producer 用于创建某个topic的生产者
 	Producer(topic string) (Producer, error)
-	// Consumer 用于创建某个topic的消费者并使用groupID指定消费者所属消费组
-	Consumer(topic string, groupID string) (Consumer, error)
+	// Consumer 用于创建某个topic的消费者并使用groupID指定消费者所属消费组，opts为消费者的可选配置
+	Consumer(topic string, groupID string, opts ...option.Option[ConsumerConfig]) (Consumer, error)
+	// OffsetsForTime 查询topic每个分区中第一条时间戳不早于t的消息的偏移量，返回值为map[分区号]偏移量。
+	// 如果分区中不存在这样的消息，返回该分区下一条消息将会使用的偏移量
+	OffsetsForTime(ctx context.Context, topic string, t time.Time) (map[int]int64, error)
 	// Close 用于关闭消息队列,释放所有建立的Producer和Consumer资源，多次调用返回的error与第一次调用返回的error相同
 	// 返回的error为由MQ抽象创建的Consumer和Producer的Close方法返回的error拼接而成
 	Close() error
 }
 
-type Header map[string]string
-
 // Message 是消息队列中传递的消息封装
 type Message struct {
 	// 消息本体，存储业务消息
 	Value []byte
 	// 对标kafka中的key，用于分区的。可以省略
 	Key []byte
-	// 对标kafka的header，用于传递一些自定义的元数据。
+	// 对标kafka的header，用于传递一些自定义的元数据。保留写入顺序和重复的键
 	Header Header
 	// 消息主题
 	Topic string
@@ -47,8 +54,20 @@ type Message struct {
 	Partition int64
 	// 偏移量
 	Offset int64
+	// 时间戳，由 TimestampType 决定是创建时间还是写入分区的时间
+	Timestamp time.Time
 }
 
+// TimestampType 表示消息时间戳的含义，对应kafka的message.timestamp.type
+type TimestampType int
+
+const (
+	// TimestampCreateTime 使用生产者设置的 Message.Timestamp，未设置时使用发送消息的时间
+	TimestampCreateTime TimestampType = iota
+	// TimestampLogAppendTime 使用消息写入分区的时间，忽略生产者设置的时间
+	TimestampLogAppendTime
+)
+
 type ProducerResult struct{}
 
 // Producer 是生产者抽象，用于向指定Topic发送/生产消息,可以被多个协程并发访问
@@ -57,16 +76,45 @@ type Producer interface {
 	Produce(ctx context.Context, m *Message) (*ProducerResult, error)
 	// ProduceWithPartition 指定分区发送消息
 	ProduceWithPartition(ctx context.Context, m *Message, partition int) (*ProducerResult, error)
+	// ProduceAt 在at时刻才将消息投递给消费者，at不晚于当前时间时等同于 Produce
+	ProduceAt(ctx context.Context, m *Message, at time.Time) (*ProducerResult, error)
 	// Close 用于释放资源，多次调用返回的error与第一次调用返回的error相同
 	Close() error
 }
 
+// ClockProducer 由使用可注入时钟判断延迟消息是否到期的生产者实现
+type ClockProducer interface {
+	// Clock 返回生产者判断延迟消息是否到期使用的时钟
+	Clock() clock.Clock
+}
+
+// ProduceWithDelay 在delay之后才将消息投递给消费者，等同于 p.ProduceAt(ctx, m, now.Add(delay))。
+// p实现了 ClockProducer 时now取自它的时钟，否则为系统时间
+func ProduceWithDelay(ctx context.Context, p Producer, m *Message, delay time.Duration) (*ProducerResult, error) {
+	return p.ProduceAt(ctx, m, producerClock(p).Now().Add(delay))
+}
+
+func producerClock(p Producer) clock.Clock {
+	if cp, ok := p.(ClockProducer); ok {
+		return cp.Clock()
+	}
+	return clock.New()
+}
+
 // Consumer 是消费者的抽象，用于从指定Topic接收/消费消息,可以被多个协程并发访问
 type Consumer interface {
 	// Consume 获取单条信息
 	Consume(ctx context.Context) (*Message, error)
 	// ConsumeChan  从返回的channel中获取mq中的消息
 	ConsumeChan(ctx context.Context) (<-chan *Message, error)
+	// Pause 暂停拉取指定分区的消息，消费者不会离开消费组，也不会触发重平衡。已经拉取到的消息依旧可以被消费
+	Pause(partitions ...int) error
+	// Resume 恢复拉取被 Pause 暂停的分区
+	Resume(partitions ...int) error
+	// Commit 提交消费进度，msgs所在分区中它及之前的消息都被视为已经处理完成。
+	// 只有使用 WithManualCommit 创建的消费者需要调用，否则消息投递之后就会自动提交，调用 Commit 不会有任何效果。
+	// 已经不属于该消费者的分区会被忽略
+	Commit(ctx context.Context, msgs ...*Message) error
 	// Close 用于释放资源，多次调用返回的error与第一次调用返回的error相同
 	Close() error
 }
@@ -0,0 +1,148 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// HeaderContentType 记录消息内容编码格式的header
+const HeaderContentType = "content-type"
+
+// ErrContentTypeMismatch 消息header中的content-type与 Codec 不一致
+var ErrContentTypeMismatch = errors.New("消息的content-type与codec不一致")
+
+// Codec 负责T与消息内容之间的转换，实现需要可以被并发调用
+type Codec[T any] interface {
+	// ContentType 返回编码格式，发送时写入 HeaderContentType
+	ContentType() string
+	Encode(v T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// DecodeError 表示消费到的消息无法解码，Msg为原始消息，可以用于投递到死信队列
+type DecodeError struct {
+	Msg         *Message
+	ContentType string
+	Err         error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("解码消息失败，topic: %s, partition: %d, offset: %d, content-type: %s: %s",
+		e.Msg.Topic, e.Msg.Partition, e.Msg.Offset, e.ContentType, e.Err.Error())
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// TypedMessage 是内容为T的消息
+type TypedMessage[T any] struct {
+	Value  T
+	Key    []byte
+	Header Header
+	// 消费到的原始消息，发送时忽略，提交消费进度时使用
+	Raw *Message
+}
+
+// TypedProducer 使用 Codec 编码之后再发送消息
+type TypedProducer[T any] struct {
+	producer Producer
+	codec    Codec[T]
+}
+
+func NewTypedProducer[T any](p Producer, codec Codec[T]) *TypedProducer[T] {
+	return &TypedProducer[T]{producer: p, codec: codec}
+}
+
+func (p *TypedProducer[T]) Produce(ctx context.Context, m *TypedMessage[T]) (*ProducerResult, error) {
+	msg, err := p.encode(m)
+	if err != nil {
+		return nil, err
+	}
+	return p.producer.Produce(ctx, msg)
+}
+
+func (p *TypedProducer[T]) ProduceWithPartition(ctx context.Context, m *TypedMessage[T], partition int) (*ProducerResult, error) {
+	msg, err := p.encode(m)
+	if err != nil {
+		return nil, err
+	}
+	return p.producer.ProduceWithPartition(ctx, msg, partition)
+}
+
+func (p *TypedProducer[T]) ProduceAt(ctx context.Context, m *TypedMessage[T], at time.Time) (*ProducerResult, error) {
+	msg, err := p.encode(m)
+	if err != nil {
+		return nil, err
+	}
+	return p.producer.ProduceAt(ctx, msg, at)
+}
+
+// Close 关闭底层的生产者
+func (p *TypedProducer[T]) Close() error {
+	return p.producer.Close()
+}
+
+func (p *TypedProducer[T]) encode(m *TypedMessage[T]) (*Message, error) {
+	data, err := p.codec.Encode(m.Value)
+	if err != nil {
+		return nil, err
+	}
+	header := m.Header.Clone()
+	header.Set(HeaderContentType, p.codec.ContentType())
+	return &Message{Value: data, Key: m.Key, Header: header}, nil
+}
+
+// TypedConsumer 使用 Codec 解码消费到的消息
+type TypedConsumer[T any] struct {
+	consumer Consumer
+	codec    Codec[T]
+}
+
+func NewTypedConsumer[T any](c Consumer, codec Codec[T]) *TypedConsumer[T] {
+	return &TypedConsumer[T]{consumer: c, codec: codec}
+}
+
+// Consume 获取并解码一条消息。消息无法解码时返回 *DecodeError，
+// header中的content-type与 Codec 不一致时，DecodeError.Err 为 ErrContentTypeMismatch，没有content-type时直接尝试解码
+func (c *TypedConsumer[T]) Consume(ctx context.Context) (*TypedMessage[T], error) {
+	msg, err := c.consumer.Consume(ctx)
+	if err != nil {
+		return nil, err
+	}
+	contentType := msg.Header.Get(HeaderContentType)
+	if contentType != "" && contentType != c.codec.ContentType() {
+		return nil, &DecodeError{Msg: msg, ContentType: contentType, Err: ErrContentTypeMismatch}
+	}
+	v, err := c.codec.Decode(msg.Value)
+	if err != nil {
+		return nil, &DecodeError{Msg: msg, ContentType: contentType, Err: err}
+	}
+	return &TypedMessage[T]{Value: v, Key: msg.Key, Header: msg.Header, Raw: msg}, nil
+}
+
+// Commit 提交消费进度，见 Consumer.Commit
+func (c *TypedConsumer[T]) Commit(ctx context.Context, msgs ...*Message) error {
+	return c.consumer.Commit(ctx, msgs...)
+}
+
+// Close 关闭底层的消费者
+func (c *TypedConsumer[T]) Close() error {
+	return c.consumer.Close()
+}
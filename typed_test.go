@@ -0,0 +1,94 @@
+// Copyright 2021 ecodeclub
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type intCodec struct{}
+
+func (intCodec) ContentType() string {
+	return "text/plain"
+}
+
+func (intCodec) Encode(v int) ([]byte, error) {
+	if v < 0 {
+		return nil, errors.New("不支持负数")
+	}
+	return []byte(strconv.Itoa(v)), nil
+}
+
+func (intCodec) Decode(data []byte) (int, error) {
+	return strconv.Atoi(string(data))
+}
+
+type recordProducer struct {
+	Producer
+	msgs []*Message
+}
+
+func (p *recordProducer) Produce(_ context.Context, m *Message) (*ProducerResult, error) {
+	p.msgs = append(p.msgs, m)
+	return &ProducerResult{}, nil
+}
+
+func TestTypedProducer(t *testing.T) {
+	t.Parallel()
+	p := &recordProducer{}
+	producer := NewTypedProducer[int](p, intCodec{})
+	header := Header{{Key: HeaderContentType, Value: []byte("application/json")}}
+	_, err := producer.Produce(context.Background(), &TypedMessage[int]{Value: 12, Key: []byte("k"), Header: header})
+	require.NoError(t, err)
+	_, err = producer.Produce(context.Background(), &TypedMessage[int]{Value: -1})
+	assert.Error(t, err)
+
+	require.Len(t, p.msgs, 1)
+	assert.Equal(t, []byte("12"), p.msgs[0].Value)
+	assert.Equal(t, []byte("k"), p.msgs[0].Key)
+	assert.Equal(t, "text/plain", p.msgs[0].Header.Get(HeaderContentType))
+	// 不修改调用者的header
+	assert.Equal(t, "application/json", header.Get(HeaderContentType))
+}
+
+func TestTypedConsumer(t *testing.T) {
+	t.Parallel()
+	fake := &fakeConsumer{msgCh: make(chan *Message, 10)}
+	consumer := NewTypedConsumer[int](fake, intCodec{})
+
+	fake.msgCh <- &Message{Value: []byte("12")}
+	fake.msgCh <- &Message{Value: []byte("a"), Header: Header{{Key: HeaderContentType, Value: []byte("text/plain")}}}
+	fake.msgCh <- &Message{Value: []byte("1"), Header: Header{{Key: HeaderContentType, Value: []byte("application/json")}}}
+
+	m, err := consumer.Consume(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 12, m.Value)
+	assert.Equal(t, []byte("12"), m.Raw.Value)
+
+	_, err = consumer.Consume(context.Background())
+	var decodeErr *DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	assert.Equal(t, []byte("a"), decodeErr.Msg.Value)
+	assert.ErrorIs(t, err, strconv.ErrSyntax)
+
+	_, err = consumer.Consume(context.Background())
+	assert.ErrorIs(t, err, ErrContentTypeMismatch)
+}